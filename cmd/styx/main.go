@@ -1,28 +1,95 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
+	"github.com/deviceix/styx/internal/bugreport"
 	"github.com/deviceix/styx/internal/builder"
 	"github.com/deviceix/styx/internal/compiler"
 	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/crash"
+	"github.com/deviceix/styx/internal/dependency"
+	"github.com/deviceix/styx/internal/deps"
+	"github.com/deviceix/styx/internal/i18n"
 	"github.com/deviceix/styx/internal/logger"
 	"github.com/deviceix/styx/internal/platform"
+	"github.com/deviceix/styx/internal/report"
+	"github.com/deviceix/styx/internal/scaffold"
+	"github.com/deviceix/styx/internal/selftest"
+	"github.com/deviceix/styx/internal/telemetry"
 )
 
 var (
 	configPath string
+	envName    string
 	target     string
 	outputDir  string
+	outLayout  string
 	verbose    bool
 	jobs       int
+	jobsSet    bool
+	maxLoad    float64
+	dryRun     bool
+	profile    bool
+	tracePath  string
+	shardSpec  string
+	watchRun   bool
+	reloadCmd  string
+	allTargets bool
+	testCover  bool
+	reportSpec []string
+	watchTest  bool
+	runBin     string
+	runExample string
+	runDir     string
+	runEnv     []string
+	probeLink  bool
+	probeLang  string
+	graphFmt   string
+	coverFmt   string
+	graphTypes []string
+	graphDirty bool
+	since      string
+	cacheOnly  bool
+	keepCache  bool
+	benchFiles int
+	benchDepth int
+	benchRun   string
+	golden     string
+	update     bool
+	prefix     string
+	destDir    string
+	backend    string
+	logFormat  string
+	ciMode     bool
+	reportOut  string
+	assumeYes  bool
+	endpoint   string
+	jsonOut    bool
+	htmlOut    string
+	badgeOut   string
+	initOpts   scaffold.Options
+	interact   bool
+	addTo      string
+	addFlags   string
+	addLinks   string
+	dumpFmt    string
+	pruneDays  int
+	pruneSize  string
 	log        *logger.Logger
 
 	version = "0.1.0"
@@ -37,6 +104,11 @@ func setupLogging(verbose bool) {
 func main() {
 	// Initialize logger early to prevent nil pointer errors
 	log = logger.New(false)
+	defer recoverCrash()
+	// [ui] language replaces the language of the environment once the
+	// configuration is loaded
+	i18n.SetLanguage(i18n.Detect())
+	telemetry.Version = version
 
 	rootCmd := &cobra.Command{
 		Use:   "styx",
@@ -46,302 +118,2376 @@ it provides simple configuration, fast incremental builds, and
 supports specialized environments like OSDev and embedded systems.`,
 		Version: version,
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			format, err := logger.ParseFormat(logFormat)
+			if err != nil {
+				log.Error("%v", err)
+				os.Exit(1)
+			}
+			logger.SetFormat(format)
+			// the theme of the configuration is only known once it is loaded
+			if name := os.Getenv("STYX_THEME"); name != "" {
+				theme, err := logger.NewTheme(name, nil, nil, "")
+				if err != nil {
+					log.Error("STYX_THEME: %v", err)
+					os.Exit(1)
+				}
+				logger.SetTheme(theme)
+			}
+			if ciMode {
+				logger.SetCI(10 * time.Second)
+			}
 			setupLogging(verbose)
+			// without -j, the jobs of the configuration apply
+			jobsSet = cmd.Flags().Changed("jobs")
+			recordCommand(cmd)
 		},
 	}
 
 	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "path to configuration file (default: styx.toml in current directory)")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose output")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "log format: text, or json for one event per line on stderr")
+	rootCmd.PersistentFlags().BoolVar(&ciMode, "ci", os.Getenv("CI") == "true", "print plain, timestamped lines for CI logs (default: true when $CI is true)")
+	rootCmd.PersistentFlags().StringVarP(&envName, "env", "e", os.Getenv("STYX_ENV"), "environment to build in (default: $STYX_ENV)")
 	buildCmd := &cobra.Command{
 		Use:   "build",
 		Short: "build the project",
-		Long:  `build the project according to the configuration file.`,
+		Long: `build the project according to the configuration file. several targets, given
+separated by commas or with --all-targets, are built together, each in its own output
+directory, sharing the scanned dependencies and the jobs.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			runBuild()
 		},
 	}
 
-	buildCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release)")
+	buildCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release), or several separated by commas")
+	buildCmd.Flags().BoolVar(&allTargets, "all-targets", false, "build every configured target")
 	buildCmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "output directory")
-	buildCmd.Flags().IntVarP(&jobs, "jobs", "j", runtime.NumCPU(), "number of parallel jobs")
+	buildCmd.Flags().StringVar(&outLayout, "out-layout", "", "output layout (target, platform or flat)")
+	buildCmd.Flags().IntVarP(&jobs, "jobs", "j", 0, "number of parallel jobs, 0 for one per CPU (default: [build].jobs, or one per CPU)")
+	buildCmd.Flags().Float64VarP(&maxLoad, "load-average", "l", 0, "do not start jobs while the load average is at or above this")
+	buildCmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "print the steps of the build and why they run without running them")
+	buildCmd.Flags().BoolVar(&profile, "profile", false, "write a timing report of the build to .styx/reports")
+	buildCmd.Flags().StringVar(&tracePath, "trace", "", "write a Chrome trace of the commands of the build to this file")
+	buildCmd.Flags().StringVar(&shardSpec, "shard", "", "compile only this part of the translation units, as index/count like 2/5, without linking")
 	cleanCmd := &cobra.Command{
 		Use:   "clean",
 		Short: "clean build artifacts",
-		Long:  `remove build artifacts and clear build cache.`,
+		Long: `remove the build artifacts of a target, or of all targets, and forget them in the
+build cache. dependency builds and downloads in the state directory are kept.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			runClean()
 		},
 	}
 
 	cleanCmd.Flags().StringVarP(&target, "target", "t", "", "Clean specific target (default: all)")
+	cleanCmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "output directory")
+	cleanCmd.Flags().StringVar(&outLayout, "out-layout", "", "output layout (target, platform or flat)")
+	cleanCmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "list what would be removed without removing it")
+	cleanCmd.Flags().BoolVar(&cacheOnly, "cache-only", false, "only forget the outputs in the build cache")
+	cleanCmd.Flags().BoolVar(&keepCache, "artifacts-only", false, "only remove the outputs, keeping the build cache")
 	runCmd := &cobra.Command{
-		Use:   "run",
+		Use:   "run [-- args...]",
 		Short: "build and run the project",
-		Long:  `build and then execute the resulting binary.`,
+		Long: `build and then execute the resulting binary, passing it the arguments after --.
+the exit status of the binary is the one of styx run. with --example, an example
+of [[examples]] is built against the libraries of the project and run instead.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			runBuildAndExecute(args)
 		},
 	}
 
 	runCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release)")
+	runCmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "output directory")
+	runCmd.Flags().StringVar(&outLayout, "out-layout", "", "output layout (target, platform or flat)")
+	runCmd.Flags().StringVarP(&runBin, "bin", "b", "", "binary to run when the project defines several")
+	runCmd.Flags().StringVar(&runExample, "example", "", "build and run this example of [[examples]] instead")
+	runCmd.Flags().StringVar(&runDir, "cwd", "", "working directory of the binary (default: the current directory)")
+	runCmd.Flags().StringArrayVar(&runEnv, "env-var", nil, "set a variable in the environment of the binary, as NAME=VALUE")
+	runCmd.Flags().IntVarP(&jobs, "jobs", "j", 0, "number of parallel jobs, 0 for one per CPU (default: [build].jobs, or one per CPU)")
+	runCmd.Flags().Float64VarP(&maxLoad, "load-average", "l", 0, "do not start jobs while the load average is at or above this")
+	runCmd.Flags().BoolVar(&profile, "profile", false, "write a timing report of the build to .styx/reports")
+	runCmd.Flags().StringVar(&tracePath, "trace", "", "write a Chrome trace of the commands of the build to this file")
+	watchCmd := &cobra.Command{
+		Use:   "watch [-- args...]",
+		Short: "rebuild the project on every change",
+		Long: `watch the source and include directories and rebuild incrementally whenever
+a file changes. with --run, the executable is restarted after every successful build.
+with --reload-cmd, the command runs after every successful rebuild instead, for a program
+loading the shared libraries of the project to load them again; ${output} in it stands
+for their paths.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runWatch(args)
+		},
+	}
+
+	watchCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release)")
+	watchCmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "output directory")
+	watchCmd.Flags().StringVar(&outLayout, "out-layout", "", "output layout (target, platform or flat)")
+	watchCmd.Flags().BoolVarP(&watchRun, "run", "r", false, "restart the executable after every successful build")
+	watchCmd.Flags().StringVar(&reloadCmd, "reload-cmd", "", "run this shell command after every successful rebuild of a shared library project")
+	watchCmd.Flags().IntVarP(&jobs, "jobs", "j", 0, "number of parallel jobs, 0 for one per CPU (default: [build].jobs, or one per CPU)")
+	watchCmd.Flags().Float64VarP(&maxLoad, "load-average", "l", 0, "do not start jobs while the load average is at or above this")
+	testCmd := &cobra.Command{
+		Use:   "test [name...]",
+		Short: "build and run the tests",
+		Long: `build every test executable declared in the [test] section and run them.
+exits with a non-zero status if any test fails. with --watch, the tests are
+rebuilt on every change and those depending on the changed files run again.
+with --coverage, their coverage is measured as with styx coverage, and the
+command fails as well when it is below min_coverage in [test]. --report
+junit=results.xml writes the results as a JUnit XML report, and tap=results.tap
+in the Test Anything Protocol, with the duration of every test and the message
+and output of failed ones; - writes to the standard output.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runTest(args)
+		},
+	}
+
+	testCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release)")
+	testCmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "output directory")
+	testCmd.Flags().StringVar(&outLayout, "out-layout", "", "output layout (target, platform or flat)")
+	testCmd.Flags().BoolVarP(&watchTest, "watch", "w", false, "rerun the tests affected by every change")
+	testCmd.Flags().BoolVar(&testCover, "coverage", false, "measure the coverage of the tests like styx coverage, failing below [test] min_coverage")
+	testCmd.Flags().StringVar(&coverFmt, "format", "html", "format of the coverage report with --coverage: html or lcov")
+	testCmd.Flags().StringArrayVar(&reportSpec, "report", nil, "write the results as format=path, junit or tap, - for the standard output (may be repeated)")
+	testCmd.Flags().StringVar(&shardSpec, "shard", "", "run only this part of the tests, as index/count like 2/5")
+	testCmd.Flags().IntVarP(&jobs, "jobs", "j", 0, "number of parallel jobs, 0 for one per CPU (default: [build].jobs, or one per CPU)")
+	testCmd.Flags().Float64VarP(&maxLoad, "load-average", "l", 0, "do not start jobs while the load average is at or above this")
+	coverageCmd := &cobra.Command{
+		Use:   "coverage [name...]",
+		Short: "measure the coverage of the tests",
+		Long: `build the tests instrumented for coverage into the coverage directory of the
+output directory, run them and report the lines of the project they ran, as an
+HTML page (index.html) or, with --format lcov, an lcov tracefile (coverage.info)
+in the same directory. GCC coverage is read with gcov, Clang coverage with
+llvm-profdata and llvm-cov. exits with a non-zero status if any test fails.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runCoverage(args, nil)
+		},
+	}
+
+	coverageCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release)")
+	coverageCmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "output directory")
+	coverageCmd.Flags().StringVar(&outLayout, "out-layout", "", "output layout (target, platform or flat)")
+	coverageCmd.Flags().StringVar(&coverFmt, "format", "html", "report format: html or lcov")
+	coverageCmd.Flags().IntVarP(&jobs, "jobs", "j", 0, "number of parallel jobs, 0 for one per CPU (default: [build].jobs, or one per CPU)")
+	coverageCmd.Flags().Float64VarP(&maxLoad, "load-average", "l", 0, "do not start jobs while the load average is at or above this")
 	initCmd := &cobra.Command{
 		Use:   "init",
 		Short: "initialize a new project",
-		Long:  `create a new Styx project in the current directory.`,
+		Long: `create a new Styx project in the current directory from a template:
+
+  executable      a program (the default)
+  static-lib      a static library
+  shared-lib      a shared library
+  kernel          a multiboot kernel for x86, built with i686-elf-gcc
+  embedded-arm    bare metal firmware for an ARM Cortex-M4
+  gtest-project   a library and a program, tested with GoogleTest
+
+the project is named after the directory unless --name is given. with
+--interactive, the template, name, language, standard and compiler are asked
+for, with the values of the flags as defaults.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			runInit()
 		},
 	}
+	initCmd.Flags().StringVarP(&initOpts.Template, "template", "t", scaffold.DefaultTemplate, "project template")
+	initCmd.Flags().StringVar(&initOpts.Name, "name", "", "project name (default: the directory name)")
+	initCmd.Flags().StringVar(&initOpts.Language, "lang", "", "language, c or c++ (default: that of the template)")
+	initCmd.Flags().StringVar(&initOpts.Standard, "std", "", "language standard (default: c17 or c++23)")
+	initCmd.Flags().StringVar(&initOpts.Compiler, "compiler", "", "compiler (default: that of the template)")
+	initCmd.Flags().BoolVarP(&interact, "interactive", "i", false, "ask for the project settings")
+
+	compilerCmd := &cobra.Command{
+		Use:   "compiler",
+		Short: "show compiler information",
+		Long:  `display information about available compilers.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			showCompilerInfo()
+		},
+	}
+
+	compdbCmd := &cobra.Command{
+		Use:   "compdb",
+		Short: "generate compile_commands.json",
+		Long: `write the compilation database used by clangd, clang-tidy and IDEs
+without compiling anything.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runCompdb()
+		},
+	}
+
+	compdbCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release)")
+	compdbCmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "output directory")
+	compdbCmd.Flags().StringVar(&outLayout, "out-layout", "", "output layout (target, platform or flat)")
+	generateCmd := &cobra.Command{
+		Use:   "generate",
+		Short: "generate build files for another build tool",
+		Long: `write the commands styx would run for a target as build files of another
+build tool, so that tool can run the build. ninja is the only backend.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runGenerate()
+		},
+	}
+
+	generateCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release)")
+	generateCmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "output directory")
+	generateCmd.Flags().StringVar(&outLayout, "out-layout", "", "output layout (target, platform or flat)")
+	generateCmd.Flags().StringVarP(&backend, "backend", "b", "ninja", "build tool to generate files for (ninja)")
+	tryCompileCmd := &cobra.Command{
+		Use:   "try-compile <file|-> [-- flags...]",
+		Short: "check whether a snippet compiles",
+		Long: `compile a snippet, read from a file or from stdin with -, with the flags of the
+current target followed by the given flags. results are cached with the feature checks.
+exits with 0 when the snippet compiles, 1 when it does not and 2 on errors, so pre-build
+scripts can probe for intrinsics, builtins or ABI quirks.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var flags []string
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				flags = args[dash:]
+				args = args[:dash]
+			}
+			if len(args) != 1 {
+				log.Error("expected exactly one snippet")
+				os.Exit(2)
+			}
+			runTryCompile(args[0], flags)
+		},
+	}
+
+	tryCompileCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release)")
+	tryCompileCmd.Flags().BoolVarP(&probeLink, "link", "l", false, "link the snippet as well")
+	tryCompileCmd.Flags().StringVar(&probeLang, "lang", "", "language of the snippet (c or c++); defaults to the file extension or the project language")
+	graphCmd := &cobra.Command{
+		Use:   "graph",
+		Short: "print the dependency graph",
+		Long: `print the build graph of the current target as DOT, JSON or Mermaid without
+compiling anything. edges point from inputs to what is built from them.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runGraph()
+		},
+	}
+
+	graphCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release)")
+	graphCmd.Flags().StringVarP(&graphFmt, "format", "f", "dot", "output format (dot, json, or mermaid)")
+	graphCmd.Flags().StringSliceVar(&graphTypes, "type", nil, "only show nodes of these types (source, header, object, library, executable, generated)")
+	graphCmd.Flags().BoolVarP(&graphDirty, "dirty", "d", false, "highlight the nodes the next build would rebuild")
+	affectedCmd := &cobra.Command{
+		Use:   "affected",
+		Short: "list what changes since a git revision affect",
+		Long: `map the files changed since a git revision, committed or not, through the
+dependency graph to the objects to compile, the outputs to link and the tests
+to run again, without compiling anything. CI pipelines can shard their work
+from the list. every line names a kind and a path or test name.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runAffected()
+		},
+	}
+
+	affectedCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release)")
+	affectedCmd.Flags().StringVar(&since, "since", "HEAD", "git revision to compare with, from where the current branch forked")
+	affectedCmd.Flags().BoolVar(&jsonOut, "json", false, "print the list as JSON")
+	planCmd := &cobra.Command{
+		Use:   "plan",
+		Short: "print the build plan as canonical JSON",
+		Long: `print the commands a build of the current target runs, in order, as JSON that is
+the same on every machine: paths are relative to the project and the state of the cache
+is left out. with --golden, the plan is compared with a golden file instead, so changes
+to flag handling or scheduling show up as a failure.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runPlan()
+		},
+	}
+
+	planCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release)")
+	planCmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "output directory")
+	planCmd.Flags().StringVar(&outLayout, "out-layout", "", "output layout (target, platform or flat)")
+	planCmd.Flags().StringVarP(&golden, "golden", "g", "", "golden plan file to compare the plan with")
+	planCmd.Flags().BoolVarP(&update, "update", "u", false, "write the plan to the golden file instead of comparing")
+	vendorCmd := &cobra.Command{
+		Use:   "vendor",
+		Short: "copy remote dependencies into vendor/",
+		Long: `fetch every remote dependency and copy it into the vendor/ directory.
+vendored copies are preferred over the network, so builds work offline.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runVendor()
+		},
+	}
+
+	installCmd := &cobra.Command{
+		Use:   "install",
+		Short: "build and install the project",
+		Long: `build the project and copy its outputs, public headers and the files named by
+the [install] rules below the prefix. with --destdir (or $DESTDIR) the files are staged
+under that directory instead. the installed files are listed in build/install_manifest.txt.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runInstall()
+		},
+	}
+	installCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release)")
+	installCmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "output directory")
+	installCmd.Flags().StringVar(&outLayout, "out-layout", "", "output layout (target, platform or flat)")
+	installCmd.Flags().StringVar(&prefix, "prefix", "", "install prefix (default: [install].prefix or /usr/local)")
+	installCmd.Flags().StringVar(&destDir, "destdir", os.Getenv("DESTDIR"), "staging directory prepended to every installed path (default: $DESTDIR)")
+
+	uninstallCmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "remove the files of the last install",
+		Long:  `remove every file listed in the install manifest written by styx install.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runUninstall()
+		},
+	}
+	uninstallCmd.Flags().StringVarP(&outputDir, "output-dir", "o", "", "output directory")
+	uninstallCmd.Flags().StringVar(&outLayout, "out-layout", "", "output layout (target, platform or flat)")
+
+	depsCmd := &cobra.Command{
+		Use:   "deps",
+		Short: "manage project dependencies",
+		Long:  `inspect and update the dependencies declared in the configuration file.`,
+	}
+
+	depsOutdatedCmd := &cobra.Command{
+		Use:   "outdated",
+		Short: "list dependencies with newer upstream revisions",
+		Long:  `check git dependencies for newer release tags or commits than the ones in styx.lock.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runDepsOutdated()
+		},
+	}
+
+	depsUpdateCmd := &cobra.Command{
+		Use:   "update [name...]",
+		Short: "update locked dependencies",
+		Long:  `move git dependencies to the latest commit of the ref they track and update styx.lock.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runDepsUpdate(args)
+		},
+	}
+
+	depsCmd.AddCommand(depsOutdatedCmd)
+	depsCmd.AddCommand(depsUpdateCmd)
+
+	addCmd := &cobra.Command{
+		Use:   "add",
+		Short: "add sources, include directories, libraries or targets to styx.toml",
+		Long: `edit styx.toml for the common changes, keeping its comments and layout. the
+configuration is checked before it is replaced, and values already there are left alone.`,
+	}
+
+	addSourceCmd := &cobra.Command{
+		Use:   "source <path...>",
+		Short: "add sources or source patterns to the build",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runAdd("sources", addTo, args)
+		},
+	}
+	addSourceCmd.Flags().StringVar(&addTo, "to", "", "binary or library to add them to instead of [build]")
+
+	addIncludeCmd := &cobra.Command{
+		Use:   "include <dir...>",
+		Short: "add include directories to the build",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runAdd("include_dirs", addTo, args)
+		},
+	}
+	addIncludeCmd.Flags().StringVar(&addTo, "to", "", "binary or library to add them to instead of [build]")
+
+	addLibCmd := &cobra.Command{
+		Use:   "lib <name...>",
+		Short: "link system libraries",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runAdd("libs", "", args)
+		},
+	}
+
+	addTargetCmd := &cobra.Command{
+		Use:   "target <name>",
+		Short: "add a target, or flags to one",
+		Long: `add a target like profiling, built with styx build -t profiling. the compile flags
+go to c_flags or cxx_flags, by the language of the project.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runAddTarget(args[0], strings.Fields(addFlags), strings.Fields(addLinks))
+		},
+	}
+	addTargetCmd.Flags().StringVar(&addFlags, "flags", "", "compile flags of the target, like \"-pg\"")
+	addTargetCmd.Flags().StringVar(&addLinks, "linker-flags", "", "linker flags of the target")
+
+	addCmd.AddCommand(addSourceCmd)
+	addCmd.AddCommand(addIncludeCmd)
+	addCmd.AddCommand(addLibCmd)
+	addCmd.AddCommand(addTargetCmd)
+
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "inspect the configuration",
+	}
+
+	configDumpCmd := &cobra.Command{
+		Use:   "dump",
+		Short: "print the configuration a build uses",
+		Long: `print the configuration the current target is built with, as TOML or JSON: the
+sections of the platform, the compiler and the environment merged in, the compiler
+found for the toolchain and the output names expanded. settings left empty are left out.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runConfigDump()
+		},
+	}
+	configDumpCmd.Flags().StringVarP(&target, "target", "t", "", "build target (e.g., debug, release)")
+	configDumpCmd.Flags().StringVarP(&dumpFmt, "format", "f", "toml", "output format (toml or json)")
+
+	configCmd.AddCommand(configDumpCmd)
+
+	toolchainCmd := &cobra.Command{
+		Use:   "toolchain",
+		Short: "manage downloaded toolchains",
+		Long:  `install the pinned toolchains declared under [toolchains] into ~/.styx/toolchains.`,
+	}
+
+	toolchainInstallCmd := &cobra.Command{
+		Use:   "install [name]",
+		Short: "download and install a toolchain",
+		Long: `download the archive of a toolchain declared under [toolchains] for this platform,
+verify its sha256 and unpack it into ~/.styx/toolchains. without a name, the toolchain
+selected with [toolchain].use is installed.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := ""
+			if len(args) > 0 {
+				name = args[0]
+			}
+			runToolchainInstall(name)
+		},
+	}
+
+	toolchainCmd.AddCommand(toolchainInstallCmd)
+
+	selftestCmd := &cobra.Command{
+		Use:   "selftest",
+		Short: "check styx itself",
+		Long:  `commands checking styx itself rather than a project.`,
+	}
+
+	selftestBenchCmd := &cobra.Command{
+		Use:   "bench",
+		Short: "benchmark the scanner, the build cache and the build graph",
+		Long: `generate a synthetic tree of sources including a deep chain of headers in a temporary
+directory and measure dependency scanning, a build with nothing to do and the construction
+of the build graph on it. results are printed in the format of go test -bench, so they
+can be compared with benchstat.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runSelftestBench()
+		},
+	}
+
+	selftestBenchCmd.Flags().IntVar(&benchFiles, "sources", 10000, "number of sources in the generated tree")
+	selftestBenchCmd.Flags().IntVar(&benchDepth, "depth", 32, "length of the chain of headers the sources include")
+	selftestBenchCmd.Flags().StringVar(&benchRun, "run", ".", "only run the benchmarks matching this regular expression")
+	selftestCmd.AddCommand(selftestBenchCmd)
+
+	bugreportCmd := &cobra.Command{
+		Use:   "bugreport",
+		Short: "collect a diagnostic bundle to attach to an issue",
+		Long: `collect the platform, the configuration, the compiler versions, the state of the build
+cache and the log of the last build into a tarball to attach to a bug report. the paths
+identifying this machine are listed one by one and replaced with placeholders unless
+refused; with --yes, or when stdin is not a terminal, all of them are replaced.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runBugreport()
+		},
+	}
+
+	bugreportCmd.Flags().StringVarP(&reportOut, "output", "o", "", "path of the report (default styx-bugreport-<time>.tar.gz)")
+	bugreportCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "replace every path without asking")
+
+	reportCmd := &cobra.Command{
+		Use:   "report",
+		Short: "summarize the last build, or render it as an HTML report or a badge",
+		Long: `summarize the last build of the project. with --html, render it as a static HTML page
+with its timings, warnings, cache statistics and dependency graph, and with --badge, as an
+SVG badge telling whether it passed; both suit publishing from CI as build artifacts.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runReport()
+		},
+	}
+
+	reportCmd.Flags().StringVar(&htmlOut, "html", "", "write an HTML report to this file (default build-report.html)")
+	reportCmd.Flags().Lookup("html").NoOptDefVal = "build-report.html"
+	reportCmd.Flags().StringVar(&badgeOut, "badge", "", "write an SVG badge to this file (default build-badge.svg)")
+	reportCmd.Flags().Lookup("badge").NoOptDefVal = "build-badge.svg"
+
+	telemetryCmd := &cobra.Command{
+		Use:   "telemetry",
+		Short: "manage the opt-in usage telemetry",
+		Long: `styx records which commands and features are used and how long builds take only
+after telemetry enable. events are kept in ~/.styx/telemetry and hold no paths, names or
+flag values; they leave this machine only with telemetry upload. STYX_TELEMETRY=0 or
+DO_NOT_TRACK=1 turn recording off.`,
+	}
+
+	telemetryEnableCmd := &cobra.Command{
+		Use:   "enable",
+		Short: "start recording usage on this machine",
+		Run: func(cmd *cobra.Command, args []string) {
+			runTelemetryEnable(true)
+		},
+	}
+	telemetryEnableCmd.Flags().StringVar(&endpoint, "endpoint", "", "URL telemetry upload sends the events to")
+
+	telemetryDisableCmd := &cobra.Command{
+		Use:   "disable",
+		Short: "stop recording usage, keeping the events recorded so far",
+		Run: func(cmd *cobra.Command, args []string) {
+			runTelemetryEnable(false)
+		},
+	}
+
+	telemetryReportCmd := &cobra.Command{
+		Use:   "report",
+		Short: "summarize the recorded usage",
+		Run: func(cmd *cobra.Command, args []string) {
+			runTelemetryReport()
+		},
+	}
+	telemetryReportCmd.Flags().BoolVar(&jsonOut, "json", false, "print the summary as JSON")
+
+	telemetryUploadCmd := &cobra.Command{
+		Use:   "upload",
+		Short: "send the events not sent yet to the configured endpoint",
+		Run: func(cmd *cobra.Command, args []string) {
+			runTelemetryUpload()
+		},
+	}
+
+	telemetryClearCmd := &cobra.Command{
+		Use:   "clear",
+		Short: "delete the recorded events",
+		Run: func(cmd *cobra.Command, args []string) {
+			runTelemetryClear()
+		},
+	}
+
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "inspect and trim the build cache",
+		Long: `the build cache in the state directory records every object and rule output built,
+with the stamps of its inputs, so that builds only redo what changed. entries of files
+long gone or no longer built are kept until styx cache prune drops them.`,
+	}
+
+	cacheStatsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "show the entries and size of the build cache, and how much of the last build it saved",
+		Run: func(cmd *cobra.Command, args []string) {
+			runCacheStats()
+		},
+	}
+	cacheStatsCmd.Flags().BoolVar(&jsonOut, "json", false, "print the statistics as JSON")
+
+	cachePruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "drop the entries of deleted files, old entries, and the oldest ones beyond a size",
+		Run: func(cmd *cobra.Command, args []string) {
+			runCachePrune()
+		},
+	}
+	cachePruneCmd.Flags().IntVar(&pruneDays, "older-than", 0, "also drop the entries recorded more than this many days ago")
+	cachePruneCmd.Flags().StringVar(&pruneSize, "max-size", "", "also drop the oldest entries until the cache file fits this size, like 512K or 20M")
+
+	cacheClearCmd := &cobra.Command{
+		Use:   "clear",
+		Short: "delete the build cache, so that the next build compiles everything",
+		Run: func(cmd *cobra.Command, args []string) {
+			runCacheClear()
+		},
+	}
+
+	cacheCmd.AddCommand(cacheStatsCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+
+	telemetryCmd.AddCommand(telemetryEnableCmd)
+	telemetryCmd.AddCommand(telemetryDisableCmd)
+	telemetryCmd.AddCommand(telemetryReportCmd)
+	telemetryCmd.AddCommand(telemetryUploadCmd)
+	telemetryCmd.AddCommand(telemetryClearCmd)
+
+	rootCmd.AddCommand(buildCmd)
+	rootCmd.AddCommand(cleanCmd)
+	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(testCmd)
+	rootCmd.AddCommand(coverageCmd)
+	rootCmd.AddCommand(initCmd)
+	rootCmd.AddCommand(compilerCmd)
+	rootCmd.AddCommand(compdbCmd)
+	rootCmd.AddCommand(generateCmd)
+	rootCmd.AddCommand(tryCompileCmd)
+	rootCmd.AddCommand(graphCmd)
+	rootCmd.AddCommand(affectedCmd)
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(vendorCmd)
+	rootCmd.AddCommand(installCmd)
+	rootCmd.AddCommand(uninstallCmd)
+	rootCmd.AddCommand(depsCmd)
+	rootCmd.AddCommand(addCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(toolchainCmd)
+	rootCmd.AddCommand(selftestCmd)
+	rootCmd.AddCommand(bugreportCmd)
+	rootCmd.AddCommand(reportCmd)
+	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(telemetryCmd)
+	rootCmd.SilenceErrors = true
+	localize(rootCmd)
+	if err := rootCmd.Execute(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// localize translates the help of cmd and of its subcommands: the
+// descriptions of the commands and flags and the headings of the usage
+func localize(cmd *cobra.Command) {
+	if cmd.Parent() == nil && i18n.T("Usage:") != "Usage:" {
+		headings := []string{"Usage:", "Aliases:", "Examples:", "Available Commands:", "Additional Commands:",
+			"Global Flags:", "Flags:", "Additional help topics:",
+			`Use "{{.CommandPath}} [command] --help" for more information about a command.`}
+		var pairs []string
+		for _, heading := range headings {
+			pairs = append(pairs, heading, i18n.T(heading))
+		}
+		cmd.SetUsageTemplate(strings.NewReplacer(pairs...).Replace(cmd.UsageTemplate()))
+	}
+
+	cmd.Short = i18n.T(cmd.Short)
+	cmd.Long = i18n.T(cmd.Long)
+	translate := func(f *pflag.Flag) {
+		f.Usage = i18n.T(f.Usage)
+	}
+	cmd.Flags().VisitAll(translate)
+	cmd.PersistentFlags().VisitAll(translate)
+	for _, sub := range cmd.Commands() {
+		localize(sub)
+	}
+}
+
+// recoverCrash turns a panic of the command into a crash report under
+// .styx/crash, and tells the user where to file it
+func recoverCrash() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	path := crash.FindConfig(configPath)
+	report := crash.Report{
+		Version:    version,
+		Args:       os.Args,
+		ConfigPath: path,
+		Panic:      r,
+		Stack:      debug.Stack(),
+		Log:        logger.History(),
+		Time:       time.Now(),
+	}
+
+	log.Error("styx crashed: %v", r)
+	file, err := crash.Write(crash.Dir(path), report)
+	if err != nil {
+		// the report is still worth having on the terminal
+		log.Error("%v", err)
+		_, _ = fmt.Fprint(os.Stderr, report.String())
+		file = "the report above"
+	} else {
+		log.Error("crash report written to %s", file)
+	}
+	log.Error("please file a bug at %s and attach %s", crash.IssueURL, file)
+	os.Exit(2)
+}
+
+// builderOptions returns the builder options set by the command line flags
+func builderOptions() []builder.Option {
+	opts := []builder.Option{builder.WithMaxLoad(maxLoad), builder.WithVerbose(verbose)}
+	if jobsSet {
+		opts = append(opts, builder.WithJobs(jobs))
+	}
+	if outputDir != "" {
+		opts = append(opts, builder.WithOutputDir(outputDir))
+	}
+	if outLayout != "" {
+		opts = append(opts, builder.WithOutputLayout(outLayout))
+	}
+	return opts
+}
+
+// loadConfig loads the configuration file and selects the environment given
+// with --env
+func loadConfig() (*config.Config, error) {
+	cfg, err := findConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.IsWorkspace() {
+		return nil, fmt.Errorf("this is a workspace root; run the command in one of its members")
+	}
+	if err := cfg.SelectEnvironment(envName); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadProject loads the configuration like loadConfig, but also accepts a
+// workspace root, which is returned as a workspace instead
+func loadProject() (*config.Config, *builder.Workspace, error) {
+	cfg, err := findConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !cfg.IsWorkspace() {
+		if err := cfg.SelectEnvironment(envName); err != nil {
+			return nil, nil, err
+		}
+		return cfg, nil, nil
+	}
+
+	root := "."
+	if configPath != "" {
+		root = filepath.Dir(configPath)
+	}
+	ws, err := builder.NewWorkspace(cfg, root, envName, builderOptions()...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Info("workspace with %d projects", len(ws.Members))
+	ws.SetTarget(target)
+	return cfg, ws, nil
+}
+
+// findConfig loads the configuration file given with --config, or the one
+// found in the current directory
+func findConfig() (*config.Config, error) {
+	// use if provided
+	if configPath != "" {
+		log.Info("using configuration file: %s", configPath)
+		parse := config.ParseFile
+		if filepath.Ext(configPath) == ".script" {
+			parse = config.ParseScript
+		}
+		cfg, err := parse(configPath)
+		if err != nil {
+			return nil, err
+		}
+		applyOutput(cfg)
+		return cfg, nil
+	}
+
+	// otherwise try to find configuration file
+	log.Info("searching for configuration file...")
+	cfg, err := config.LoadConfig("")
+	if errors.Is(err, config.ErrConfigNotFound) {
+		log.Info("no TOML configuration found, trying script configuration...")
+		cfg, err = config.LoadScriptConfig("")
+		if errors.Is(err, config.ErrConfigNotFound) {
+			return nil, fmt.Errorf("no configuration file found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		log.Success("found script configuration")
+	} else if err != nil {
+		return nil, err
+	} else {
+		log.Success("found TOML configuration")
+	}
+
+	applyOutput(cfg)
+	return cfg, nil
+}
+
+// applyOutput routes the messages to the streams chosen by [output], paces
+// the progress lines of CI mode and applies the theme and language of [ui]
+func applyOutput(cfg *config.Config) {
+	if language, err := i18n.Parse(cfg.UI.Language); err == nil && cfg.UI.Language != "" {
+		i18n.SetLanguage(language)
+	}
+	if theme, err := cfg.UI.NewTheme(); err == nil {
+		logger.SetTheme(theme)
+	}
+
+	progress, err := logger.ParseStream(cfg.Output.Progress)
+	if err != nil {
+		log.Warning("%v", err)
+		return
+	}
+	diagnostics, err := logger.ParseStream(cfg.Output.Diagnostics)
+	if err != nil {
+		log.Warning("%v", err)
+		return
+	}
+	logger.SetStreams(progress, diagnostics)
+
+	if ciMode {
+		logger.SetCI(time.Duration(cfg.Output.ProgressInterval) * time.Second)
+	}
+}
+
+// runBuild executes the build process
+func runBuild() {
+	log.Info("loading project configuration...")
+	cfg, ws, err := loadProject()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	shard := parseShard()
+	if ws != nil {
+		if dryRun {
+			log.Error("--dry-run is not supported for workspaces")
+			os.Exit(1)
+		}
+		if shardSpec != "" {
+			log.Error("--shard is not supported for workspaces")
+			os.Exit(1)
+		}
+
+		ws.SetProfile(profile)
+		ws.SetTrace(tracePath)
+		start := time.Now()
+		if err := ws.Build(); err != nil {
+			log.Error("build failed: %v", err)
+			os.Exit(1)
+		}
+		log.Success("built %d projects in %.2f seconds", len(ws.Members), time.Since(start).Seconds())
+		return
+	}
+
+	log.Info("creating builder...")
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("failed to create builder: %v", err)
+		os.Exit(1)
+	}
+
+	targets := buildTargets(b)
+	if len(targets) == 1 && targets[0] != "" {
+		log.Info("setting target: %s", targets[0])
+		if err := b.SetTarget(targets[0]); err != nil {
+			log.Error("invalid target: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	b.SetProfile(profile)
+	b.SetTrace(tracePath)
+	b.SetShard(shard)
+	if len(targets) > 1 && shardSpec != "" {
+		log.Error("--shard builds a single target")
+		os.Exit(1)
+	}
+	if dryRun {
+		for _, name := range targets {
+			if len(targets) > 1 {
+				if err := b.SetTarget(name); err != nil {
+					log.Error("invalid target: %v", err)
+					os.Exit(1)
+				}
+			}
+			printPlan(b)
+		}
+		return
+	}
+
+	start := time.Now()
+	if len(targets) > 1 {
+		if _, err := b.BuildTargets(targets); err != nil {
+			log.Error("build failed: %v", err)
+			os.Exit(1)
+		}
+		log.Success("built %d targets in %.2f seconds", len(targets), time.Since(start).Seconds())
+		return
+	}
+	if _, err := b.Build(); err != nil {
+		log.Error("build failed: %v", err)
+		os.Exit(1)
+	}
+
+	duration := time.Since(start)
+	log.Success("build completed in %.2f seconds", duration.Seconds())
+}
+
+// buildTargets returns the targets given with --target, separated by
+// commas, or all of them with --all-targets; a single empty name stands
+// for the default target
+func buildTargets(b *builder.Builder) []string {
+	if allTargets {
+		if target != "" {
+			log.Error("--target and --all-targets cannot be used together")
+			os.Exit(1)
+		}
+		targets := b.AllTargets()
+		if len(targets) == 0 {
+			log.Error("no targets are configured")
+			os.Exit(1)
+		}
+		return targets
+	}
+
+	var targets []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(target, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			targets = append(targets, name)
+		}
+	}
+	if len(targets) == 0 {
+		return []string{""}
+	}
+	return targets
+}
+
+// parseShard returns the shard given with --shard, the whole work without it
+func parseShard() builder.Shard {
+	if shardSpec == "" {
+		return builder.Shard{}
+	}
+	shard, err := builder.ParseShard(shardSpec)
+	if err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+	return shard
+}
+
+// printPlan prints the steps a build would run, and why, without running
+// them
+func printPlan(b *builder.Builder) {
+	plan, err := b.Plan()
+	if err != nil {
+		log.Error("failed to plan build: %v", err)
+		os.Exit(1)
+	}
+
+	pending := 0
+	for _, step := range plan.Steps {
+		if step.UpToDate {
+			log.Note("%s %s (up to date)", step.Kind, step.Task.OutputFile)
+			continue
+		}
+		pending++
+		log.Info("%s %s (%s)", step.Kind, step.Task.OutputFile, step.Reason)
+	}
+	log.Success("%d of %d steps would run for target %s", pending, len(plan.Steps), plan.Target)
+}
+
+// runClean cleans build artifacts
+func runClean() {
+	log.Info("loading project configuration...")
+	cfg, ws, err := loadProject()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	opts := builder.CleanOptions{
+		AllTargets:    target == "",
+		DryRun:        dryRun,
+		CacheOnly:     cacheOnly,
+		ArtifactsOnly: keepCache,
+	}
+
+	if ws != nil {
+		ws.Target = target
+		if err := ws.Clean(opts); err != nil {
+			log.Error("clean failed: %v", err)
+			os.Exit(1)
+		}
+		if !dryRun {
+			log.Success("clean completed successfully")
+		}
+		return
+	}
+
+	log.Info("creating builder...")
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("Failed to create builder: %v", err)
+		os.Exit(1)
+	}
+
+	if target != "" {
+		log.Info("setting target: %s", target)
+		if err := b.SetTarget(target); err != nil {
+			log.Error("invalid target: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := b.Clean(opts); err != nil {
+		log.Error("clean failed: %v", err)
+		os.Exit(1)
+	}
+
+	if !dryRun {
+		log.Success("clean completed successfully")
+	}
+}
+
+// runBuildAndExecute builds and then runs the executable with args
+func runBuildAndExecute(args []string) {
+	log.Info("loading project configuration...")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("failed to create builder: %v", err)
+		os.Exit(1)
+	}
+
+	if target != "" {
+		if err := b.SetTarget(target); err != nil {
+			log.Error("invalid target: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	b.SetProfile(profile)
+	b.SetTrace(tracePath)
+	if runExample != "" && runBin != "" {
+		log.Error("--example and --bin cannot be used together")
+		os.Exit(1)
+	}
+
+	var exePath string
+	if runExample != "" {
+		exePath, err = b.BuildExample(runExample)
+		if err != nil {
+			log.Error("build failed: %v", err)
+			os.Exit(1)
+		}
+	} else {
+		result, err := b.Build()
+		if err != nil {
+			log.Error("build failed: %v", err)
+			os.Exit(1)
+		}
+
+		exePath, err = result.Executable(runBin)
+		if err != nil {
+			log.Error("%v", err)
+			if len(result.Executables) == 0 && len(cfg.Examples) > 0 {
+				names := make([]string, len(cfg.Examples))
+				for i, example := range cfg.Examples {
+					names[i] = example.Name
+				}
+				log.Info("run an example with --example: %s", strings.Join(names, ", "))
+			}
+			os.Exit(1)
+		}
+	}
+	// the working directory of the executable may be another one
+	if exePath, err = filepath.Abs(exePath); err != nil {
+		log.Error("failed to resolve %s: %v", exePath, err)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(exePath); os.IsNotExist(err) {
+		log.Error("executable not found: %s", exePath)
+		os.Exit(1)
+	}
+
+	cmd := exec.Command(exePath, args...)
+	cmd.Dir = runDir
+	cmd.Env = os.Environ()
+	if env := cfg.ActiveEnvironment(); env != nil {
+		for name, value := range env.Env {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+	}
+	for _, variable := range runEnv {
+		if !strings.Contains(variable, "=") {
+			log.Error("invalid environment variable: %s (must be NAME=VALUE)", variable)
+			os.Exit(1)
+		}
+		cmd.Env = append(cmd.Env, variable)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		// the exit status of the executable is passed on
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		log.Error("execution failed: %v", err)
+		os.Exit(1)
+	}
+}
+
+// runWatch rebuilds the project whenever its sources change
+func runWatch(args []string) {
+	log.Info("loading project configuration...")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("failed to create builder: %v", err)
+		os.Exit(1)
+	}
+
+	if target != "" {
+		if err := b.SetTarget(target); err != nil {
+			log.Error("invalid target: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if watchRun && reloadCmd != "" {
+		log.Error("--run and --reload-cmd cannot be used together")
+		os.Exit(1)
+	}
+
+	if err := b.Watch(watchRun, reloadCmd, args); err != nil {
+		log.Error("watch failed: %v", err)
+		os.Exit(1)
+	}
+}
+
+// runTest builds and runs the tests, exiting with a failure status if any fail
+func runTest(names []string) {
+	log.Info("loading project configuration...")
+	cfg, ws, err := loadProject()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	shard := parseShard()
+	if ws != nil && shardSpec != "" {
+		log.Error("--shard is not supported for workspaces")
+		os.Exit(1)
+	}
+
+	reports := parseTestReports()
+
+	if testCover {
+		if ws != nil || watchTest || shardSpec != "" {
+			log.Error("--coverage cannot be used in workspaces or with --watch or --shard")
+			os.Exit(1)
+		}
+		runCoverage(names, reports)
+		return
+	}
+
+	if watchTest {
+		if ws != nil {
+			log.Error("--watch is not supported in workspaces; run it in a member")
+			os.Exit(1)
+		}
+		if len(reports) > 0 {
+			log.Error("--watch and --report cannot be used together")
+			os.Exit(1)
+		}
+		watchTests(cfg, names)
+		return
+	}
+
+	var results []builder.TestResult
+	if ws != nil {
+		results, err = ws.Test(names)
+	} else {
+		results, err = buildTests(cfg, names, shard)
+	}
+	if err != nil {
+		log.Error("failed to build tests: %v", err)
+		os.Exit(1)
+	}
+
+	passed := printTestResults(results)
+	writeTestReports(reports, cfg.Project.Name, results)
+	if !passed {
+		os.Exit(1)
+	}
+}
+
+// testReport is a report of the test results requested with --report
+type testReport struct {
+	format string
+	path   string
+}
+
+// parseTestReports parses the --report flags, exiting on invalid ones
+func parseTestReports() []testReport {
+	var reports []testReport
+	for _, spec := range reportSpec {
+		format, path, ok := strings.Cut(spec, "=")
+		if !ok || path == "" {
+			log.Error("invalid --report: %s (must be format=path, like junit=results.xml)", spec)
+			os.Exit(1)
+		}
+		if err := builder.CheckTestReport(format); err != nil {
+			log.Error("%v", err)
+			os.Exit(1)
+		}
+		reports = append(reports, testReport{format: format, path: path})
+	}
+	return reports
+}
+
+// writeTestReports writes results in every report, naming their suite
+// after the project
+func writeTestReports(reports []testReport, project string, results []builder.TestResult) {
+	if project == "" {
+		project = "styx"
+	}
+	for _, report := range reports {
+		if err := builder.WriteTestReport(report.format, report.path, project, results); err != nil {
+			log.Error("%v", err)
+			os.Exit(1)
+		}
+		if report.path != "-" {
+			log.Success("%s test report written to %s", report.format, report.path)
+		}
+	}
+}
+
+// printTestResults prints the result of every test and a summary, and
+// reports whether they all passed
+func printTestResults(results []builder.TestResult) bool {
+	failed := 0
+	for _, result := range results {
+		if result.Passed {
+			log.Success("PASS %s (%.2fs)", result.Name, result.Duration.Seconds())
+			if verbose && result.Output != "" {
+				log.Note("%s", result.Output)
+			}
+			continue
+		}
+
+		failed++
+		log.Error("FAIL %s (exit code %d, %.2fs)", result.Name, result.ExitCode, result.Duration.Seconds())
+		if result.Output != "" {
+			log.Note("%s", result.Output)
+		}
+	}
+
+	if failed > 0 {
+		log.Error("%d of %d tests failed", failed, len(results))
+		return false
+	}
+
+	log.Success("all %d tests passed", len(results))
+	return true
+}
+
+// runCoverage builds and runs the tests instrumented for coverage and
+// writes the coverage report
+func runCoverage(names []string, reports []testReport) {
+	log.Info("loading project configuration...")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("failed to create builder: %v", err)
+		os.Exit(1)
+	}
+
+	if target != "" {
+		if err := b.SetTarget(target); err != nil {
+			log.Error("invalid target: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	results, path, err := b.Coverage(names, coverFmt)
+	passed := results == nil || printTestResults(results)
+	if results != nil {
+		writeTestReports(reports, cfg.Project.Name, results)
+	}
+	if err != nil && !errors.Is(err, builder.ErrCoverageTooLow) {
+		log.Error("coverage failed: %v", err)
+		os.Exit(1)
+	}
+	log.Success("coverage report written to %s", path)
+	if err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+	if !passed {
+		os.Exit(1)
+	}
+}
+
+// buildTests builds and runs the tests of a single project, those of shard
+// only
+func buildTests(cfg *config.Config, names []string, shard builder.Shard) ([]builder.TestResult, error) {
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("failed to create builder: %v", err)
+		os.Exit(1)
+	}
+
+	if target != "" {
+		if err := b.SetTarget(target); err != nil {
+			log.Error("invalid target: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	b.SetShard(shard)
+	return b.Test(names)
+}
+
+// watchTests runs the tests again whenever the files they depend on change
+func watchTests(cfg *config.Config, names []string) {
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("failed to create builder: %v", err)
+		os.Exit(1)
+	}
+
+	if target != "" {
+		if err := b.SetTarget(target); err != nil {
+			log.Error("invalid target: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := b.WatchTests(names); err != nil {
+		log.Error("watch failed: %v", err)
+		os.Exit(1)
+	}
+}
+
+// runInit initializes a new Styx project
+func runInit() {
+	if _, err := os.Stat("styx.toml"); err == nil {
+		log.Error("project already initialized; styx.toml exists")
+		os.Exit(1)
+	}
+
+	if initOpts.Name == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			log.Error("failed to get current directory: %v", err)
+			os.Exit(1)
+		}
+		initOpts.Name = filepath.Base(wd)
+	}
+
+	if interact {
+		if err := scaffold.Prompt(os.Stdin, os.Stdout, &initOpts); err != nil {
+			log.Error("%v", err)
+			os.Exit(1)
+		}
+	}
+
+	tmpl, err := scaffold.Lookup(initOpts.Template)
+	if err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+
+	log.Info("creating %s project %s...", tmpl.Name, initOpts.Name)
+	files, err := scaffold.Generate(".", initOpts)
+	if err != nil {
+		log.Error("failed to create project: %v", err)
+		os.Exit(1)
+	}
+	for _, file := range files {
+		log.Success("created %s", file)
+	}
+
+	log.Success("project %s initialized successfully", initOpts.Name)
+	for _, note := range tmpl.Notes {
+		log.Note("%s", note)
+	}
+}
+
+// runCompdb writes the compilation database without building
+func runCompdb() {
+	log.Info("loading project configuration...")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("failed to create builder: %v", err)
+		os.Exit(1)
+	}
+
+	if target != "" {
+		if err := b.SetTarget(target); err != nil {
+			log.Error("invalid target: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	path, err := b.GenerateCompileCommands()
+	if err != nil {
+		log.Error("failed to generate compilation database: %v", err)
+		os.Exit(1)
+	}
+
+	log.Success("wrote %s", path)
+}
+
+// runGenerate writes the build files of the selected backend
+func runGenerate() {
+	if backend != "ninja" {
+		log.Error("unsupported backend: %s (expected ninja)", backend)
+		os.Exit(1)
+	}
+
+	log.Info("loading project configuration...")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("failed to create builder: %v", err)
+		os.Exit(1)
+	}
+
+	if target != "" {
+		if err := b.SetTarget(target); err != nil {
+			log.Error("invalid target: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	path, err := b.GenerateNinja()
+	if err != nil {
+		log.Error("failed to generate ninja file: %v", err)
+		os.Exit(1)
+	}
+
+	log.Success("wrote %s (run ninja -f %s)", path, path)
+}
+
+// runConfigDump prints the configuration the current target is built with
+func runConfigDump() {
+	log.Info("loading project configuration...")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("failed to create builder: %v", err)
+		os.Exit(1)
+	}
+
+	if target != "" {
+		if err := b.SetTarget(target); err != nil {
+			log.Error("invalid target: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := b.EffectiveConfig().Dump(os.Stdout, dumpFmt); err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+}
+
+// runGraph prints the dependency graph of the current target
+func runGraph() {
+	var types []dependency.NodeType
+	for _, name := range graphTypes {
+		t, err := dependency.ParseNodeType(name)
+		if err != nil {
+			log.Error("%v", err)
+			os.Exit(1)
+		}
+		types = append(types, t)
+	}
+
+	log.Info("loading project configuration...")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("failed to create builder: %v", err)
+		os.Exit(1)
+	}
+
+	if target != "" {
+		if err := b.SetTarget(target); err != nil {
+			log.Error("invalid target: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	dirty, err := b.LoadGraph()
+	if err != nil {
+		log.Error("failed to load dependency graph: %v", err)
+		os.Exit(1)
+	}
+
+	opts := dependency.ExportOptions{Format: graphFmt, Types: types}
+	if graphDirty {
+		opts.Dirty = dirty
+	}
+
+	if err := b.Graph.Export(os.Stdout, opts); err != nil {
+		log.Error("failed to export dependency graph: %v", err)
+		os.Exit(1)
+	}
+}
+
+// runAffected prints the objects, outputs and tests affected by the files
+// changed since a git revision
+func runAffected() {
+	log.Info("loading project configuration...")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	changed, err := builder.ChangedSince(since)
+	if err != nil {
+		log.Error("failed to list changed files: %v", err)
+		os.Exit(1)
+	}
+
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("failed to create builder: %v", err)
+		os.Exit(1)
+	}
+
+	if target != "" {
+		if err := b.SetTarget(target); err != nil {
+			log.Error("invalid target: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	affected, err := b.Affected(changed)
+	if err != nil {
+		log.Error("failed to load dependency graph: %v", err)
+		os.Exit(1)
+	}
+	affected.Since = since
+
+	if jsonOut {
+		data, err := json.MarshalIndent(affected, "", "  ")
+		if err != nil {
+			log.Error("failed to encode affected targets: %v", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	for _, path := range affected.Changed {
+		fmt.Printf("changed %s\n", path)
+	}
+	for _, path := range affected.Objects {
+		fmt.Printf("object %s\n", path)
+	}
+	for _, path := range affected.Outputs {
+		fmt.Printf("output %s\n", path)
+	}
+	for _, name := range affected.Tests {
+		fmt.Printf("test %s\n", name)
+	}
+}
+
+// runPlan prints the canonical plan of the current target, or compares it
+// with a golden file
+func runPlan() {
+	if update && golden == "" {
+		log.Error("--update needs a golden file")
+		os.Exit(1)
+	}
+
+	log.Info("loading project configuration...")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("failed to create builder: %v", err)
+		os.Exit(1)
+	}
+
+	if target != "" {
+		if err := b.SetTarget(target); err != nil {
+			log.Error("invalid target: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	plan, err := b.Plan()
+	if err != nil {
+		log.Error("failed to plan build: %v", err)
+		os.Exit(1)
+	}
+
+	data, err := plan.CanonicalJSON(".", cfg.StateDir())
+	if err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+
+	if golden == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := builder.CheckGolden(golden, data, update); err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+	if update {
+		log.Success("updated %s", golden)
+	} else {
+		log.Success("plan matches %s", golden)
+	}
+}
+
+// runSelftestBench runs the benchmarks of styx on a generated tree
+func runSelftestBench() {
+	log.Info("generating %d sources with an include depth of %d...", benchFiles, benchDepth)
+	if err := selftest.Run(os.Stdout, benchFiles, benchDepth, benchRun); err != nil {
+		log.Error("benchmark failed: %v", err)
+		os.Exit(1)
+	}
+}
+
+// runBugreport writes a diagnostic bundle of the project for a bug report;
+// it works with configurations that fail to load, which are often the bug
+func runBugreport() {
+	report := bugreport.Collect(crash.FindConfig(configPath), version)
+
+	redactions := report.Redactions()
+	if !assumeYes && isatty.IsTerminal(os.Stdin.Fd()) {
+		redactions = bugreport.Confirm(os.Stdin, os.Stdout, redactions)
+	}
+	report.Redact(redactions)
+
+	path := reportOut
+	if path == "" {
+		path = fmt.Sprintf("styx-bugreport-%s.tar.gz", time.Now().Format("20060102-150405"))
+	}
+	if err := report.Write(path); err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+
+	log.Success("bug report written to %s", path)
+	log.Info("review it, then attach it to an issue at %s", crash.IssueURL)
+}
+
+// runReport summarizes the last build, or writes it as an HTML report or
+// a badge
+func runReport() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	build, err := report.Load(builder.LastBuildPath(cfg))
+	if err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+
+	if htmlOut == "" && badgeOut == "" {
+		printReport(build)
+		return
+	}
+	for _, out := range []struct {
+		path  string
+		write func(io.Writer, *report.Build) error
+	}{{htmlOut, report.WriteHTML}, {badgeOut, report.WriteBadge}} {
+		if out.path == "" {
+			continue
+		}
+		var data strings.Builder
+		if err := out.write(&data, build); err != nil {
+			log.Error("failed to render report: %v", err)
+			os.Exit(1)
+		}
+		if err := platform.WriteFileAtomic(out.path, []byte(data.String()), 0644); err != nil {
+			log.Error("failed to write %s: %v", out.path, err)
+			os.Exit(1)
+		}
+		log.Success("wrote %s", out.path)
+	}
+}
+
+// printReport logs the summary of a recorded build
+func printReport(build *report.Build) {
+	log.Info("last build: %s (%s), started %s", build.Project, build.Target, build.Started.Local().Format("2006-01-02 15:04:05"))
+	if build.Success {
+		log.Success("succeeded in %.2f seconds", build.Duration().Seconds())
+	} else {
+		log.Error("failed after %.2f seconds: %s", build.Duration().Seconds(), build.Error)
+	}
+	log.Info("%d of %d translation units up to date", build.Cache.Hits, build.Cache.Units)
+	log.Info("%d warnings, %d errors", build.Count("warning"), build.Count("error"))
+	for _, output := range build.Outputs {
+		log.Info("output: %s", output)
+	}
+}
+
+// loadCache loads the build cache of the project in the current directory
+func loadCache() (*config.Config, *builder.Cache) {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
 
-	compilerCmd := &cobra.Command{
-		Use:   "compiler",
-		Short: "show compiler information",
-		Long:  `display information about available compilers.`,
-		Run: func(cmd *cobra.Command, args []string) {
-			showCompilerInfo()
-		},
+	cache := builder.NewCache(filepath.Join(builder.CacheDir(cfg), "build.json"))
+	if err := cache.Load(); err != nil {
+		log.Error("failed to load cache: %v", err)
+		os.Exit(1)
 	}
+	return cfg, cache
+}
 
-	rootCmd.AddCommand(buildCmd)
-	rootCmd.AddCommand(cleanCmd)
-	rootCmd.AddCommand(runCmd)
-	rootCmd.AddCommand(initCmd)
-	rootCmd.AddCommand(compilerCmd)
-	rootCmd.SilenceErrors = true
-	if err := rootCmd.Execute(); err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+// runCacheStats prints the statistics of the build cache and of the last
+// build
+func runCacheStats() {
+	cfg, cache := loadCache()
+	stats := cache.Stats()
+	build, err := report.Load(builder.LastBuildPath(cfg))
+	if err != nil {
+		build = nil
+	}
+
+	if jsonOut {
+		out := map[string]interface{}{
+			"entries":   stats.Entries,
+			"missing":   stats.Missing,
+			"size":      stats.Size,
+			"file_size": stats.FileSize,
+		}
+		if stats.Entries > 0 {
+			out["oldest"] = stats.Oldest
+			out["newest"] = stats.Newest
+		}
+		if build != nil {
+			out["last_build"] = build.Cache
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			log.Error("failed to encode statistics: %v", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	log.Info("cache: %s (%s)", cache.Path, builder.FormatSize(stats.FileSize))
+	log.Info("%d entries for %s of outputs", stats.Entries, builder.FormatSize(stats.Size))
+	if stats.Missing > 0 {
+		log.Info("%d entries of files that no longer exist; styx cache prune drops them", stats.Missing)
+	}
+	if stats.Entries > 0 {
+		log.Info("recorded between %s and %s", stats.Oldest.Local().Format("2006-01-02 15:04"), stats.Newest.Local().Format("2006-01-02 15:04"))
+	}
+	if build == nil || build.Cache.Units == 0 {
+		return
+	}
+	hits, units := build.Cache.Hits, build.Cache.Units
+	log.Info("last build: %d hits, %d misses (%.0f%% hit rate), %.2f seconds saved",
+		hits, units-hits, 100*float64(hits)/float64(units), build.Cache.SavedMS/1000)
+}
+
+// runCachePrune drops the entries of the build cache of deleted files, and
+// those older or beyond the size given
+func runCachePrune() {
+	if pruneDays < 0 {
+		log.Error("invalid --older-than: %d (must not be negative)", pruneDays)
+		os.Exit(1)
+	}
+	maxSize, err := parseSize(pruneSize)
+	if err != nil {
+		log.Error("invalid --max-size: %v", err)
+		os.Exit(1)
+	}
+
+	_, cache := loadCache()
+	var before time.Time
+	if pruneDays > 0 {
+		before = time.Now().AddDate(0, 0, -pruneDays)
+	}
+	removed, err := cache.Prune(before, maxSize)
+	if err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+	if err := cache.Save(); err != nil {
+		log.Error("failed to save build cache: %v", err)
 		os.Exit(1)
 	}
+
+	stats := cache.Stats()
+	log.Success("pruned %d entries; %d left in %s", removed, stats.Entries, builder.FormatSize(stats.FileSize))
 }
 
-// loadConfig loads the configuration file
-func loadConfig() (*config.Config, error) {
-	// use if provided
-	if configPath != "" {
-		log.Info("using configuration file: %s", configPath)
-		return config.ParseFile(configPath)
+// runCacheClear deletes the build cache
+func runCacheClear() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
 	}
 
-	// otherwise try to find configuration file
-	log.Info("searching for configuration file...")
-	cfg, err := config.LoadConfig("")
+	if err := builder.ClearCache(cfg); err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+	log.Success("build cache deleted")
+}
+
+// parseSize parses a size in bytes, or in kibibytes, mebibytes or gibibytes
+// with a K, M or G suffix; empty is 0
+func parseSize(size string) (int64, error) {
+	text := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B"), "I")
+	if text == "" {
+		return 0, nil
+	}
+
+	unit := int64(1)
+	switch text[len(text)-1] {
+	case 'K':
+		unit = 1 << 10
+	case 'M':
+		unit = 1 << 20
+	case 'G':
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		text = text[:len(text)-1]
+	}
+
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%s (must be a size like 512K or 20M)", size)
+	}
+	return value * unit, nil
+}
+
+// recordCommand records the command and the names of the flags given to it
+// in the telemetry; the telemetry commands themselves are left out
+func recordCommand(cmd *cobra.Command) {
+	command := strings.TrimPrefix(cmd.CommandPath(), "styx ")
+	if command == "styx" || strings.HasPrefix(command, "telemetry") {
+		return
+	}
+
+	var flags []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		flags = append(flags, f.Name)
+	})
+	telemetry.Record(telemetry.Event{Kind: "command", Command: command, Flags: flags})
+}
+
+// runTelemetryEnable turns the recording of usage on or off
+func runTelemetryEnable(enabled bool) {
+	settings, err := telemetry.LoadSettings()
 	if err != nil {
-		log.Info("no TOML configuration found, trying script configuration...")
-		cfg, err = config.LoadScriptConfig("")
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+
+	settings.Enabled = enabled
+	if endpoint != "" {
+		settings.Endpoint = endpoint
+	}
+	if err := settings.Save(); err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+
+	if !enabled {
+		log.Success("telemetry disabled; styx telemetry clear deletes the events recorded so far")
+		return
+	}
+	dir, _ := telemetry.Dir()
+	log.Success("telemetry enabled; events are kept in %s", dir)
+	if settings.Endpoint != "" {
+		log.Info("styx telemetry upload sends them to %s", settings.Endpoint)
+	}
+	if variable := telemetry.Disabled(); variable != "" {
+		log.Warning("nothing is recorded while %s is set", variable)
+	}
+}
+
+// runTelemetryReport prints the summary of the recorded events
+func runTelemetryReport() {
+	settings, err := telemetry.LoadSettings()
+	if err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+	events, err := telemetry.ReadEvents()
+	if err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+
+	report := telemetry.Summarize(events)
+	if jsonOut {
+		data, err := json.MarshalIndent(report, "", "  ")
 		if err != nil {
-			return nil, fmt.Errorf("no configuration file found")
+			log.Error("failed to encode report: %v", err)
+			os.Exit(1)
 		}
-		log.Success("found script configuration")
-	} else {
-		log.Success("found TOML configuration")
+		fmt.Println(string(data))
+		return
 	}
 
-	return cfg, nil
+	switch {
+	case telemetry.Disabled() != "":
+		log.Info("telemetry is turned off by %s", telemetry.Disabled())
+	case settings.Enabled:
+		log.Info("telemetry is enabled")
+	default:
+		log.Info("telemetry is disabled; styx telemetry enable starts recording")
+	}
+	fmt.Print(report.String())
 }
 
-// runBuild executes the build process
-func runBuild() {
-	log.Info("loading project configuration...")
+// runTelemetryUpload sends the events not sent yet to the endpoint
+func runTelemetryUpload() {
+	settings, err := telemetry.LoadSettings()
+	if err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+
+	sent, err := telemetry.Upload(settings)
+	if err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+	log.Success("uploaded %d events to %s", sent, settings.Endpoint)
+}
+
+// runTelemetryClear deletes the recorded events
+func runTelemetryClear() {
+	if err := telemetry.Clear(); err != nil {
+		log.Error("%v", err)
+		os.Exit(1)
+	}
+	log.Success("telemetry events deleted")
+}
+
+// runTryCompile compiles a snippet with the project flags and exits with its outcome
+func runTryCompile(path string, flags []string) {
+	var source []byte
+	var err error
+	if path == "-" {
+		source, err = io.ReadAll(os.Stdin)
+	} else {
+		source, err = os.ReadFile(path)
+	}
+	if err != nil {
+		log.Error("failed to read snippet: %v", err)
+		os.Exit(2)
+	}
+
+	language := probeLang
+	if language == "" {
+		switch filepath.Ext(path) {
+		case ".c":
+			language = "c"
+		case ".cpp", ".cc", ".cxx":
+			language = "c++"
+		}
+	}
+
 	cfg, err := loadConfig()
 	if err != nil {
 		log.Error("failed to load configuration: %v", err)
-		os.Exit(1)
+		os.Exit(2)
 	}
 
-	log.Info("creating builder...")
-	b, err := builder.NewBuilder(cfg)
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
 	if err != nil {
 		log.Error("failed to create builder: %v", err)
-		os.Exit(1)
+		os.Exit(2)
 	}
 
 	if target != "" {
-		log.Info("setting target: %s", target)
 		if err := b.SetTarget(target); err != nil {
 			log.Error("invalid target: %v", err)
-			os.Exit(1)
+			os.Exit(2)
 		}
 	}
 
-	if outputDir != "" {
-		log.Info("setting output directory: %s", outputDir)
-		if err := b.SetOutputDir(outputDir); err != nil {
-			log.Error("invalid output directory: %v", err)
-			os.Exit(1)
-		}
+	ok, err := b.TryCompile(builder.Probe{
+		Source:   string(source),
+		Language: language,
+		Link:     probeLink,
+		Flags:    flags,
+	})
+	if err != nil {
+		log.Error("try-compile failed: %v", err)
+		os.Exit(2)
 	}
 
-	b.SetVerbose(verbose)
-	start := time.Now()
-	if err := b.Build(); err != nil {
-		log.Error("build failed: %v", err)
+	if !ok {
+		log.Warning("%s does not compile", path)
 		os.Exit(1)
 	}
 
-	duration := time.Since(start)
-	log.Success("build completed in %.2f seconds", duration.Seconds())
+	log.Success("%s compiles", path)
 }
 
-// runClean cleans build artifacts
-func runClean() {
-	log.Info("loading project configuration...")
+// runInstall builds the project and installs it
+func runInstall() {
+	runBuild()
+
 	cfg, err := loadConfig()
 	if err != nil {
 		log.Error("failed to load configuration: %v", err)
 		os.Exit(1)
 	}
 
-	log.Info("creating builder...")
-	b, err := builder.NewBuilder(cfg)
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
 	if err != nil {
-		log.Error("Failed to create builder: %v", err)
+		log.Error("failed to create builder: %v", err)
 		os.Exit(1)
 	}
 
 	if target != "" {
-		log.Info("setting target: %s", target)
 		if err := b.SetTarget(target); err != nil {
 			log.Error("invalid target: %v", err)
 			os.Exit(1)
 		}
 	}
 
-	if err := b.Clean(); err != nil {
-		log.Error("clean failed: %v", err)
+	installed, err := b.Install(prefix, destDir)
+	for _, path := range installed {
+		log.Note("installed %s", path)
+	}
+	if err != nil {
+		log.Error("install failed: %v", err)
 		os.Exit(1)
 	}
 
-	log.Success("clean completed successfully")
+	log.Success("installed %d files", len(installed))
 }
 
-// runBuildAndExecute builds and then runs the executable
-func runBuildAndExecute(args []string) {
-	runBuild()
-
-	log.Info("loading project configuration...")
+// runUninstall removes the files of the last install
+func runUninstall() {
 	cfg, err := loadConfig()
 	if err != nil {
 		log.Error("failed to load configuration: %v", err)
 		os.Exit(1)
 	}
 
-	if cfg.Build.OutputType != "executable" {
-		log.Error("cannot run non-executable output")
+	b, err := builder.NewBuilder(cfg, builderOptions()...)
+	if err != nil {
+		log.Error("failed to create builder: %v", err)
 		os.Exit(1)
 	}
 
-	targetDir := "build"
-	if outputDir != "" {
-		targetDir = outputDir
+	removed, err := b.Uninstall()
+	for _, path := range removed {
+		log.Note("removed %s", path)
 	}
-
-	if target == "" {
-		target = "debug" // default target build type
+	if err != nil {
+		log.Error("uninstall failed: %v", err)
+		os.Exit(1)
 	}
 
-	targetDir = filepath.Join(targetDir, target)
+	log.Success("removed %d files", len(removed))
+}
 
-	outputName := cfg.Build.OutputName
-	if outputName == "" {
-		outputName = cfg.Project.Name
+// runVendor copies remote dependencies into the vendor directory
+func runVendor() {
+	log.Info("loading project configuration...")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
 	}
 
-	platformInfo := platform.GetPlatformInfo()
-	exePath := filepath.Join(targetDir, outputName+platformInfo.ExeExtension)
-	if _, err := os.Stat(exePath); os.IsNotExist(err) {
-		log.Error("executable not found: %s", exePath)
+	manager := deps.NewProjectManager(cfg, log)
+	vendored, err := manager.Vendor()
+	if err != nil {
+		log.Error("vendoring failed: %v", err)
 		os.Exit(1)
 	}
 
-	cmd := exec.Command(exePath, args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.Error("execution failed: %v", err)
-		os.Exit(1)
+	if len(vendored) == 0 {
+		log.Note("no remote dependencies to vendor")
+		return
 	}
+
+	log.Success("vendored %d dependencies into %s", len(vendored), manager.VendorDir)
 }
 
-// runInit initializes a new Styx project
-func runInit() {
-	if _, err := os.Stat("styx.toml"); err == nil {
-		log.Error("project already initialized; styx.toml exists")
+// runDepsOutdated reports dependencies with newer upstream revisions
+func runDepsOutdated() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
 		os.Exit(1)
 	}
 
-	log.Info("creating project directories...")
-	dirs := []string{"src", "include", "build"}
-	for _, dir := range dirs {
-		log.Info("creating directory: %s", dir)
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			log.Error("failed to create directory %s: %v", dir, err)
-			os.Exit(1)
-		}
+	log.Info("checking dependencies for updates...")
+	manager := deps.NewProjectManager(cfg, log)
+	outdated, err := manager.Outdated()
+	if err != nil {
+		log.Error("failed to check dependencies: %v", err)
+		os.Exit(1)
+	}
+
+	if len(outdated) == 0 {
+		log.Success("all dependencies are up to date")
+		return
+	}
+
+	for _, pkg := range outdated {
+		log.Warning("%s: %s -> %s", pkg.Name, pkg.Current, pkg.Latest)
+	}
+	log.Note("run 'styx deps update' to update the lockfile")
+}
+
+// runDepsUpdate updates locked dependencies and prints what changed
+func runDepsUpdate(names []string) {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
 	}
 
-	wd, err := os.Getwd()
+	log.Info("updating dependencies...")
+	manager := deps.NewProjectManager(cfg, log)
+	changes, err := manager.Update(names)
 	if err != nil {
-		log.Error("failed to get current directory: %v", err)
+		log.Error("update failed: %v", err)
 		os.Exit(1)
 	}
 
-	projectName := filepath.Base(wd)
-	log.Info("project name: %s", projectName)
+	if len(changes) == 0 {
+		log.Success("dependencies are already at their latest revisions")
+		return
+	}
 
-	log.Info("creating configuration file...")
-	configContent := fmt.Sprintf(`[project]
-name = "%s"
-version = "0.1.0"
-language = "c++"
-standard = "c++23"
+	for _, change := range changes {
+		log.Info("%s: %s -> %s", change.Name, change.From, change.To)
+	}
+	log.Success("updated %d dependencies in %s", len(changes), manager.LockPath)
+}
 
-[build]
-output_type = "executable"
-output_name = "%s"
-sources = [ "src/*.cpp", "src/**/*.cpp" ]
-include_dirs = [ "include" ]
+// addition is a value styx add puts in an array of the configuration: of
+// a table, or of the binary or library artifact when table is empty
+type addition struct {
+	table    string
+	artifact string
+	key      string
+	value    string
+}
 
-[toolchain]
-compiler = "auto"
-c_flags = [ "-Wall", "-Wextra" ]
-cxx_flags = [ "-Wall", "-Wextra" ]
-linker_flags = []
+// runAdd adds values to the array key of [build], or of the binary or
+// library called to
+func runAdd(key, to string, values []string) {
+	var additions []addition
+	for _, value := range values {
+		if to != "" {
+			additions = append(additions, addition{artifact: to, key: key, value: value})
+		} else {
+			additions = append(additions, addition{table: "build", key: key, value: value})
+		}
+	}
+	editConfig(nil, additions)
+}
 
-[targets.debug]
-c_flags = ["-g", "-O0"]
-cxx_flags = ["-g", "-O0"]
+// runAddTarget adds the target called name, with the compile flags and
+// linker flags given
+func runAddTarget(name string, flags, linkerFlags []string) {
+	table := "targets." + name
+	editConfig(func(cfg *config.Config) []addition {
+		var additions []addition
+		keys := []string{"c_flags", "cxx_flags"}
+		switch cfg.Project.Language {
+		case "c":
+			keys = keys[:1]
+		case "c++":
+			keys = keys[1:]
+		case "objective-c":
+			keys = []string{"objc_flags"}
+		case "objective-c++":
+			keys = []string{"objcxx_flags"}
+		}
+		for _, key := range keys {
+			for _, flag := range flags {
+				additions = append(additions, addition{table: table, key: key, value: flag})
+			}
+		}
+		for _, flag := range linkerFlags {
+			additions = append(additions, addition{table: table, key: "linker_flags", value: flag})
+		}
+		return additions
+	}, nil, table)
+}
 
-[targets.release]
-c_flags = ["-O2", "-DNDEBUG"]
-cxx_flags = ["-O2", "-DNDEBUG"]
-`, projectName, projectName)
+// editConfig makes the additions to styx.toml, and those plan returns for
+// its current configuration, adding the tables first. The edited file is
+// replaced only once it parses and holds every addition.
+func editConfig(plan func(cfg *config.Config) []addition, additions []addition, tables ...string) {
+	path := configPath
+	if path == "" {
+		path = "styx.toml"
+		if _, err := os.Stat(path); err != nil {
+			if _, err := os.Stat("Styx.toml"); err == nil {
+				path = "Styx.toml"
+			}
+		}
+	}
+	if filepath.Ext(path) == ".script" {
+		log.Error("styx add edits TOML configurations; change %s by hand", path)
+		os.Exit(1)
+	}
 
-	if err := os.WriteFile("styx.toml", []byte(configContent), 0644); err != nil {
-		log.Error("failed to write configuration file: %v", err)
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Error("failed to load configuration: %v", fmt.Errorf("%w: %s", config.ErrConfigNotFound, path))
+		os.Exit(1)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Error("failed to read configuration: %v", err)
+		os.Exit(1)
+	}
+	cfg, err := config.Parse(data)
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
 		os.Exit(1)
 	}
-	log.Success("created styx.toml")
+	if plan != nil {
+		additions = append(additions, plan(cfg)...)
+	}
+
+	editor := config.NewEditor(data)
+	var changes []string
+	for _, table := range tables {
+		if editor.AddTable(table) {
+			changes = append(changes, fmt.Sprintf("added [%s]", table))
+		}
+	}
+	for _, a := range additions {
+		where := "[" + a.table + "]"
+		add := func() (bool, error) { return editor.AddToArray(a.table, a.key, a.value) }
+		if a.table == "" {
+			where = a.artifact
+			add = func() (bool, error) { return editor.AddToArtifactArray(a.artifact, a.key, a.value) }
+		}
 
-	log.Info("creating main.cpp...")
-	mainContent := `#include <iostream>
+		added, err := add()
+		if err != nil {
+			log.Error("failed to edit %s: %v", path, err)
+			os.Exit(1)
+		}
+		if !added {
+			log.Info("%s is already in %s of %s", a.value, a.key, where)
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("added %s to %s of %s", a.value, a.key, where))
+	}
+	if len(changes) == 0 {
+		return
+	}
 
-int main(int argc, char* argv[]) 
-{
-    std::cout << "Hello from " << argv[0] << "!" << std::endl;
-    return 0;
+	edited := editor.Bytes()
+	if _, err := config.Parse(edited); err != nil {
+		log.Error("%s was left unchanged, since the edit would break it: %v", path, err)
+		os.Exit(1)
+	}
+	for _, a := range additions {
+		if !editor.Contains(a.table, a.artifact, a.key, a.value) {
+			log.Error("%s was left unchanged, since it could not be edited safely; add %s to %s by hand", path, a.value, a.key)
+			os.Exit(1)
+		}
+	}
+	if err := platform.WriteFileAtomic(path, edited, info.Mode().Perm()); err != nil {
+		log.Error("failed to write %s: %v", path, err)
+		os.Exit(1)
+	}
+	for _, change := range changes {
+		log.Success("%s", change)
+	}
 }
-`
 
-	if err := os.WriteFile("src/main.cpp", []byte(mainContent), 0644); err != nil {
-		log.Error("Failed to write main.cpp: %v", err)
+// runToolchainInstall installs a toolchain declared in the configuration
+func runToolchainInstall(name string) {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Error("failed to load configuration: %v", err)
+		os.Exit(1)
+	}
+
+	if name == "" {
+		name = cfg.Toolchain.Use
+	}
+	if name == "" {
+		log.Error("no toolchain given and none selected with [toolchain].use")
+		os.Exit(1)
+	}
+
+	release, exists := cfg.Toolchains[name]
+	if !exists {
+		log.Error("toolchain %s is not declared under [toolchains]", name)
+		os.Exit(1)
+	}
+
+	manager := deps.NewProjectManager(cfg, log)
+	dir, _, err := manager.InstallToolchain(name, release)
+	if err != nil {
+		log.Error("failed to install toolchain: %v", err)
 		os.Exit(1)
 	}
-	log.Success("created src/main.cpp")
 
-	log.Success("project %s initialized successfully", projectName)
-	log.Note("run 'styx build' to build the project")
-	log.Note("run 'styx run' to build and run the project")
+	log.Success("toolchain %s is installed in %s", name, dir)
 }
 
 // showCompilerInfo displays information about available compilers
@@ -359,6 +2505,9 @@ func showCompilerInfo() {
 	for i, comp := range compilers {
 		log.Info("compiler #%d: %s", i+1, comp.GetName())
 		log.Note("  version: %s", comp.GetVersion())
+		if info := comp.GetVersionInfo(); info.Target != "" {
+			log.Note("  target: %s", info.Target)
+		}
 		log.Note("  object extension: %s", comp.GetObjectExtension())
 		log.Note("  executable extension: %s", comp.GetExecutableExtension())
 		log.Note("  static library extension: %s", comp.GetStaticLibraryExtension())
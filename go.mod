@@ -6,15 +6,18 @@ toolchain go1.23.7
 
 require (
 	github.com/BurntSushi/toml v1.5.0
+	github.com/fatih/color v1.18.0
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/mattn/go-isatty v0.0.20
 	github.com/rs/zerolog v1.34.0
 	github.com/spf13/cobra v1.9.1
+	github.com/spf13/pflag v1.0.6
+	github.com/ulikunitz/xz v0.5.17
+	go.starlark.net v0.0.0-20231121155337-90ade8b19d09
 )
 
 require (
-	github.com/fatih/color v1.18.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/spf13/pflag v1.0.6 // indirect
 	golang.org/x/sys v0.32.0 // indirect
 )
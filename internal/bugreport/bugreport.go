@@ -0,0 +1,312 @@
+// Package bugreport collects what maintainers need to reproduce a problem
+// into a single archive: the platform, the configuration, the compilers,
+// the state of the build cache and the log of the last build. Secrets are
+// left out, and the paths identifying the user's machine can be redacted.
+package bugreport
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deviceix/styx/internal/builder"
+	"github.com/deviceix/styx/internal/compiler"
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// reportedVars are the environment variables included in reports; anything
+// else, such as access tokens, is left out
+var reportedVars = []string{
+	"PATH", "CC", "CXX", "CFLAGS", "CXXFLAGS", "LDFLAGS", "CPATH", "LIBRARY_PATH",
+	"STYX_ENV", "STYX_CACHE_DIR", "IN_NIX_SHELL", "SHELL", "LANG", "LC_ALL",
+}
+
+// urlCredentials matches the user and password of a URL
+var urlCredentials = regexp.MustCompile(`://[^/@\s"']+@`)
+
+// File is one file of a report
+type File struct {
+	Name    string
+	Content string
+}
+
+// Report is the set of files of a bug report
+type Report struct {
+	Files []File
+}
+
+// Collect gathers a report about the project configured by the file at
+// configPath, which may be empty when there is none. A configuration that
+// fails to parse is reported along with the error.
+func Collect(configPath, version string) *Report {
+	r := &Report{}
+	r.add("system.txt", system(version))
+
+	var cfg *config.Config
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			r.add("config.txt", fmt.Sprintf("failed to read %s: %v\n", configPath, err))
+		} else {
+			r.add(filepath.Base(configPath), urlCredentials.ReplaceAllString(string(data), "://<redacted>@"))
+		}
+		if filepath.Ext(configPath) == ".script" {
+			cfg, err = config.ParseScript(configPath)
+		} else {
+			cfg, err = config.ParseFile(configPath)
+		}
+		if err != nil {
+			cfg = nil
+			r.add("config-error.txt", err.Error()+"\n")
+		}
+	}
+
+	r.add("compilers.txt", compilers(cfg))
+	if cfg != nil && !cfg.IsWorkspace() {
+		r.add("cache.txt", cacheStats(cfg))
+		if data, err := os.ReadFile(builder.BuildLogPath(cfg)); err == nil {
+			r.add("last-build.log", string(data))
+		}
+	}
+	return r
+}
+
+// add appends a file to the report
+func (r *Report) add(name, content string) {
+	r.Files = append(r.Files, File{Name: name, Content: content})
+}
+
+// system describes styx, the platform and the reported environment
+func system(version string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "styx %s\n", version)
+	fmt.Fprintf(&b, "platform: %s/%s, %d CPUs, %s\n", runtime.GOOS, runtime.GOARCH, runtime.NumCPU(), runtime.Version())
+	fmt.Fprintf(&b, "date: %s\n\nenvironment:\n", time.Now().Format(time.RFC3339))
+	for _, name := range reportedVars {
+		if value, ok := os.LookupEnv(name); ok {
+			fmt.Fprintf(&b, "  %s=%s\n", name, value)
+		}
+	}
+	return b.String()
+}
+
+// compilers describes the configured compiler and the ones found on the
+// system
+func compilers(cfg *config.Config) string {
+	var b strings.Builder
+	if cfg != nil && cfg.Toolchain.Compiler != "" {
+		fmt.Fprintf(&b, "configured: %s\n", cfg.Toolchain.Compiler)
+		if cfg.Toolchain.Use != "" {
+			fmt.Fprintf(&b, "toolchain: %s\n", cfg.Toolchain.Use)
+		}
+		if comp, err := compiler.GetCompiler(cfg.Toolchain.Compiler); err == nil {
+			describeCompiler(&b, comp)
+		} else if cfg.Toolchain.Compiler != "auto" {
+			fmt.Fprintf(&b, "  %v\n", err)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("detected:\n")
+	for _, comp := range compiler.DetectCompilers() {
+		describeCompiler(&b, comp)
+	}
+	return b.String()
+}
+
+// describeCompiler writes the name, path, version and target of comp
+func describeCompiler(b *strings.Builder, comp compiler.Compiler) {
+	fmt.Fprintf(b, "  %s at %s\n    %s\n", comp.GetName(), comp.GetPath(), comp.GetVersion())
+	if target := comp.GetVersionInfo().Target; target != "" {
+		fmt.Fprintf(b, "    target: %s\n", target)
+	}
+}
+
+// cacheStats describes the build cache and the state directory of cfg
+func cacheStats(cfg *config.Config) string {
+	var b strings.Builder
+	stateDir := cfg.StateDir()
+	fmt.Fprintf(&b, "state directory: %s (%d files, %d bytes)\n", stateDir, countFiles(stateDir), dirSize(stateDir))
+
+	path := filepath.Join(stateDir, "cache", "build.json")
+	if _, err := os.Stat(path); err != nil {
+		b.WriteString("build cache: none\n")
+		return b.String()
+	}
+
+	cache := builder.NewCache(path)
+	if err := cache.Load(); err != nil {
+		fmt.Fprintf(&b, "build cache: %v\n", err)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "build cache: version %s, %d entries, last build %s\n",
+		cache.BuildCache.Version, len(cache.BuildCache.Entries), cache.BuildCache.LastBuildTime.Format(time.RFC3339))
+	fmt.Fprintf(&b, "rebuild strategy: %s\n", cfg.Cache.RebuildStrategy)
+	return b.String()
+}
+
+// countFiles returns the number of files under dir
+func countFiles(dir string) int {
+	count := 0
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+// dirSize returns the size of the files under dir
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// Redaction replaces every occurrence of Path in a report with Placeholder
+type Redaction struct {
+	Path        string
+	Placeholder string
+}
+
+// absolutePath matches the absolute paths in configuration files
+var absolutePath = regexp.MustCompile(`(?:[A-Za-z]:)?[/\\][^\s"',\]]+`)
+
+// systemDirs hold the paths that are the same on every machine, which are
+// worth keeping in reports
+var systemDirs = []string{"/usr/", "/bin/", "/lib", "/etc/", "/opt/homebrew/"}
+
+// isSystemPath reports whether path is inside one of the systemDirs
+func isSystemPath(path string) bool {
+	for _, dir := range systemDirs {
+		if strings.HasPrefix(path+"/", dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redactions returns the paths of the report that identify the machine it
+// was collected on: the home, project and temporary directories, and the
+// absolute paths the configuration refers to, longest first
+func (r *Report) Redactions() []Redaction {
+	var candidates []Redaction
+	if cwd, err := os.Getwd(); err == nil {
+		candidates = append(candidates, Redaction{cwd, "<project>"})
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, Redaction{home, "~"})
+	}
+	candidates = append(candidates, Redaction{filepath.Clean(os.TempDir()), "<tmp>"})
+
+	for _, file := range r.Files {
+		if !strings.HasSuffix(file.Name, ".toml") && !strings.HasSuffix(file.Name, ".script") {
+			continue
+		}
+		for _, path := range absolutePath.FindAllString(file.Content, -1) {
+			if dir := filepath.Dir(path); !isSystemPath(dir) {
+				candidates = append(candidates, Redaction{dir, "<path>"})
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	var redactions []Redaction
+	for _, c := range candidates {
+		if len(c.Path) <= 1 || seen[c.Path] || !r.contains(c.Path) {
+			continue
+		}
+		seen[c.Path] = true
+		redactions = append(redactions, c)
+	}
+	sort.SliceStable(redactions, func(i, j int) bool {
+		return len(redactions[i].Path) > len(redactions[j].Path)
+	})
+	return redactions
+}
+
+// contains reports whether text occurs in one of the files of the report
+func (r *Report) contains(text string) bool {
+	for _, file := range r.Files {
+		if strings.Contains(file.Content, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Confirm asks on out which of the redactions to apply, reading the
+// answers from in; redactions are applied unless refused
+func Confirm(in io.Reader, out io.Writer, redactions []Redaction) []Redaction {
+	reader := bufio.NewReader(in)
+	var confirmed []Redaction
+	for _, redaction := range redactions {
+		fmt.Fprintf(out, "replace %s with %s? [Y/n] ", redaction.Path, redaction.Placeholder)
+		answer, _ := reader.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "n", "no":
+		default:
+			confirmed = append(confirmed, redaction)
+		}
+	}
+	return confirmed
+}
+
+// Redact applies redactions, in order, to every file of the report
+func (r *Report) Redact(redactions []Redaction) {
+	for i := range r.Files {
+		for _, redaction := range redactions {
+			r.Files[i].Content = strings.ReplaceAll(r.Files[i].Content, redaction.Path, redaction.Placeholder)
+		}
+	}
+}
+
+// Write writes the report to path as a gzip compressed tarball
+func (r *Report) Write(path string) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, file := range r.Files {
+		header := &tar.Header{
+			Name:    "styx-bugreport/" + file.Name,
+			Mode:    0644,
+			Size:    int64(len(file.Content)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		if _, err := tw.Write([]byte(file.Content)); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	if err := platform.WriteFileAtomic(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/deviceix/styx/internal/dependency"
+)
+
+// Affected is what changes to a set of files make the build do again: the
+// objects to compile, the outputs to link and the tests to run
+type Affected struct {
+	Since   string   `json:"since,omitempty"`
+	Changed []string `json:"changed"`
+	Objects []string `json:"objects"`
+	Outputs []string `json:"outputs"`
+	Tests   []string `json:"tests"`
+}
+
+// projectFiles are the files whose changes affect the whole project
+var projectFiles = map[string]bool{
+	"styx.toml":   true,
+	"styx.script": true,
+	"styx.lock":   true,
+}
+
+// ChangedSince returns the files that changed since the commit where HEAD
+// and since diverged, committed or not, along with the untracked files,
+// relative to the current directory and below it
+func ChangedSince(since string) ([]string, error) {
+	base, err := gitOutput("merge-base", since, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	diff, err := gitOutput("diff", "--name-only", "--relative", strings.TrimSpace(base))
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := gitOutput("ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var changed []string
+	for _, path := range strings.Fields(diff + "\n" + untracked) {
+		path = filepath.Clean(filepath.FromSlash(path))
+		if !seen[path] {
+			seen[path] = true
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// gitOutput runs git in the current directory and returns its output
+func gitOutput(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
+
+// Affected maps changed files through the dependency graph of the current
+// target, without compiling anything, to the objects and outputs depending
+// on them and the tests whose dependency closure holds them. A change to
+// the configuration or the lock file affects everything.
+func (b *Builder) Affected(changed []string) (*Affected, error) {
+	if _, err := b.LoadGraph(); err != nil {
+		return nil, err
+	}
+
+	var tests []testBinary
+	if len(b.Config.Test.Sources) > 0 {
+		var err error
+		tests, err = b.discoverTests(nil, filepath.Join(b.targetOutputDir(), "tests"))
+		if err != nil {
+			return nil, err
+		}
+		var testSources []string
+		for _, test := range tests {
+			testSources = append(testSources, test.source)
+		}
+		if err := b.buildDependencyGraph(testSources); err != nil {
+			return nil, fmt.Errorf("failed to build dependency graph: %w", err)
+		}
+	}
+
+	all := false
+	hit := make(map[string]bool)
+	for _, path := range changed {
+		if projectFiles[path] {
+			all = true
+		}
+		if _, exists := b.Graph.GetNode(path); exists {
+			hit[path] = true
+			for _, node := range b.Graph.GetDependentsRecursive(path) {
+				hit[node.ID] = true
+			}
+		}
+	}
+
+	affected := &Affected{Changed: changed, Objects: []string{}, Outputs: []string{}, Tests: []string{}}
+	if affected.Changed == nil {
+		affected.Changed = []string{}
+	}
+	for id, node := range b.Graph.Nodes {
+		if !all && !hit[id] {
+			continue
+		}
+		switch node.Type {
+		case dependency.NodeTypeObject:
+			affected.Objects = append(affected.Objects, id)
+		case dependency.NodeTypeLibrary, dependency.NodeTypeExecutable:
+			affected.Outputs = append(affected.Outputs, id)
+		}
+	}
+
+	if !all {
+		tests = b.affectedTests(tests, changed, nil)
+	}
+	for _, test := range tests {
+		affected.Tests = append(affected.Tests, test.name)
+	}
+
+	sort.Strings(affected.Objects)
+	sort.Strings(affected.Outputs)
+	return affected, nil
+}
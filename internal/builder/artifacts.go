@@ -0,0 +1,213 @@
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/dependency"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// artifact is one of the [[binaries]] or [[libraries]] of a project
+type artifact struct {
+	config.ArtifactConfig
+	output string
+	links  []*artifact
+}
+
+// hasArtifacts reports whether the project declares [[binaries]] or
+// [[libraries]] instead of a single output
+func (b *Builder) hasArtifacts() bool {
+	return len(b.Config.Binaries)+len(b.Config.Libraries) > 0
+}
+
+// artifactConfigs returns the configured libraries followed by the binaries
+func artifactConfigs(cfg *config.Config) []config.ArtifactConfig {
+	return append(append([]config.ArtifactConfig{}, cfg.Libraries...), cfg.Binaries...)
+}
+
+// artifacts returns the configured binaries and libraries ordered so that
+// every library comes before the artifacts linking it. Their outputs and
+// links are added to the dependency graph, which rejects circular links.
+func (b *Builder) artifacts(outputDir string) ([]*artifact, error) {
+	var all []*artifact
+	byName := make(map[string]*artifact)
+	for _, cfg := range artifactConfigs(b.Config) {
+		a := &artifact{
+			ArtifactConfig: cfg,
+			output:         b.artifactPath(outputDir, cfg),
+		}
+		all = append(all, a)
+		byName[cfg.Name] = a
+
+		nodeType := dependency.NodeTypeLibrary
+		if cfg.Type == "executable" {
+			nodeType = dependency.NodeTypeExecutable
+		}
+		if _, exists := b.Graph.GetNode(a.output); !exists {
+			if err := b.Graph.AddNode(&dependency.Node{ID: a.output, Type: nodeType, Path: a.output}); err != nil {
+				return nil, fmt.Errorf("failed to add output node for %s: %w", cfg.Name, err)
+			}
+		}
+	}
+
+	for _, a := range all {
+		for _, name := range a.Links {
+			lib := byName[name]
+			if err := b.Graph.AddDependency(a.output, lib.output); err != nil {
+				return nil, fmt.Errorf("%s cannot link %s: %w", a.Name, name, err)
+			}
+			a.links = append(a.links, lib)
+		}
+	}
+
+	var order []*artifact
+	visited := make(map[*artifact]bool)
+	var visit func(a *artifact)
+	visit = func(a *artifact) {
+		if visited[a] {
+			return
+		}
+		visited[a] = true
+		for _, lib := range a.links {
+			visit(lib)
+		}
+		order = append(order, a)
+	}
+	for _, a := range all {
+		visit(a)
+	}
+
+	return order, nil
+}
+
+// buildArtifacts builds the configured binaries and libraries in link order,
+// or only the libraries when librariesOnly is set, and returns their outputs
+// and whether any of them holds C++ objects
+func (b *Builder) buildArtifacts(outputDir string, librariesOnly bool) ([]string, bool, error) {
+	plan := &Plan{Target: b.Target}
+	if err := b.planArtifacts(plan, outputDir, librariesOnly); err != nil {
+		return nil, false, err
+	}
+
+	if err := b.execute(plan); err != nil {
+		return nil, false, err
+	}
+	return plan.Outputs, slices.ContainsFunc(plan.Steps, func(step *Step) bool { return step.cpp }), nil
+}
+
+// configuredLibraries returns the libraries an artifact links by its own
+// configuration: the ones in its links, then depLibs. Libraries it only
+// gets through those are left out.
+func (a *artifact) configuredLibraries(depLibs []string) []string {
+	var libs []string
+	for _, lib := range a.links {
+		libs = append(libs, lib.output)
+	}
+	return append(libs, depLibs...)
+}
+
+// artifactSources returns the source files of an artifact
+func (b *Builder) artifactSources(a *artifact) ([]string, error) {
+	exclude := append(append([]string{}, b.Config.Build.Exclude...), a.Exclude...)
+	sourceFiles, err := b.findSources(a.Sources, exclude)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find source files: %w", err)
+	}
+	return sourceFiles, nil
+}
+
+// artifactCompilationFlags returns the flags for compiling the sources of
+// an artifact; shared library code must be position independent
+func (b *Builder) artifactCompilationFlags(a *artifact) []string {
+	flags := append(b.getCompilationFlags(), artifactIncludeFlags(a)...)
+	if a.Type == "shared_lib" && platform.IsUnixLike(b.platformInfo.Platform) {
+		flags = append(flags, "-fPIC")
+	}
+	return b.mergeCompileFlags(flags)
+}
+
+// artifactLinkFlags returns the flags, besides the ones of the target,
+// linking the output of an artifact against libs
+func (b *Builder) artifactLinkFlags(a *artifact, libs []string) []string {
+	flags := append(append([]string{}, a.LinkerFlags...), b.runtimePathFlags(libs)...)
+	flags = append(flags, b.linkMapFlags(a.output)...)
+	if a.Type == "executable" {
+		flags = append(flags, b.linkerScriptFlags()...)
+	}
+	return flags
+}
+
+// artifactObjectDir returns the directory holding the objects of an artifact,
+// kept apart since the same source may be compiled with different flags
+func artifactObjectDir(a *artifact, outputDir string) string {
+	return filepath.Join(outputDir, "obj", a.Name)
+}
+
+// linkedLibraries returns the outputs of every library an artifact links,
+// directly or through other libraries, each before the libraries it needs
+func linkedLibraries(a *artifact) []string {
+	var postorder []string
+	visited := make(map[*artifact]bool)
+	var visit func(lib *artifact)
+	visit = func(lib *artifact) {
+		if visited[lib] {
+			return
+		}
+		visited[lib] = true
+		for _, dep := range lib.links {
+			visit(dep)
+		}
+		postorder = append(postorder, lib.output)
+	}
+	for _, lib := range a.links {
+		visit(lib)
+	}
+
+	libs := make([]string, len(postorder))
+	for i, lib := range postorder {
+		libs[len(postorder)-1-i] = lib
+	}
+	return libs
+}
+
+// artifactIncludeFlags returns the include flags of an artifact together
+// with those of every library it links
+func artifactIncludeFlags(a *artifact) []string {
+	var flags []string
+	seen := make(map[string]bool)
+	var visit func(a *artifact)
+	visit = func(a *artifact) {
+		for _, dir := range a.IncludeDirs {
+			if !seen[dir] {
+				seen[dir] = true
+				flags = append(flags, "-I"+dir)
+			}
+		}
+		for _, lib := range a.links {
+			visit(lib)
+		}
+	}
+	visit(a)
+	return flags
+}
+
+// primaryArtifactPath returns the output of the first binary, or of the first
+// library when the project has no binaries
+func (b *Builder) primaryArtifactPath(outputDir string) string {
+	if len(b.Config.Binaries) > 0 {
+		return b.artifactPath(outputDir, b.Config.Binaries[0])
+	}
+	return b.artifactPath(outputDir, b.Config.Libraries[0])
+}
+
+// artifactIncludeDirs returns the include directories of every binary and library
+func artifactIncludeDirs(cfg *config.Config) []string {
+	var dirs []string
+	for _, a := range artifactConfigs(cfg) {
+		dirs = append(dirs, a.IncludeDirs...)
+	}
+	return dirs
+}
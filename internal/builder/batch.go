@@ -0,0 +1,235 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// batchedSourceSize and batchedCompileTime bound the sources compiled in
+// batches: larger ones, or ones that took longer to compile last time, gain
+// nothing from sharing the start of a compiler
+const (
+	batchedSourceSize  = 16 << 10
+	batchedCompileTime = 250 * time.Millisecond
+)
+
+// batch is a compiler invocation compiling several sources, into the
+// directory of their objects
+type batch struct {
+	task    *Task
+	members []*Task
+	stems   map[string]bool
+}
+
+// batchTasks returns tasks with the compilations of small sources replaced
+// by batches of up to batch_size of them, one per object directory and
+// command line. The compiler names the objects after the sources, so it runs
+// in their directory, given absolute paths, and sources of the same name go
+// to different batches. Batches are not used in a build container, whose
+// paths differ from those of the host.
+func (b *Builder) batchTasks(tasks []*Task) []*Task {
+	size := b.Config.Build.BatchSize
+	if size < 2 || b.container != nil {
+		return tasks
+	}
+
+	var submitted []*Task
+	open := make(map[string]*batch)
+	var batches []*batch
+	for _, task := range tasks {
+		if !b.batchable(task) {
+			submitted = append(submitted, task)
+			continue
+		}
+
+		key := strings.Join(append([]string{task.Command, filepath.Dir(task.OutputFile)}, task.Args[4:]...), "\x00")
+		stem := sourceStem(task.SourceFile)
+		current := open[key]
+		if current == nil || current.stems[stem] {
+			current = &batch{stems: make(map[string]bool)}
+			batches = append(batches, current)
+			submitted = append(submitted, nil) // the place of the batch
+			open[key] = current
+		}
+		current.members = append(current.members, task)
+		current.stems[stem] = true
+		if len(current.members) == size {
+			delete(open, key)
+		}
+	}
+
+	next := 0
+	for i, task := range submitted {
+		if task != nil {
+			continue
+		}
+		submitted[i] = b.startBatch(batches[next])
+		next++
+	}
+	return submitted
+}
+
+// batchable reports whether task compiles a small source with a plain
+// command line the compiler can be given several sources on, into the
+// object the compiler names after the source
+func (b *Builder) batchable(task *Task) bool {
+	if language := languageOf(task.SourceFile); len(task.Args) < 4 || task.Args[0] != "-c" || language == "asm" || language == "cuda" {
+		return false
+	}
+	if filepath.Base(task.OutputFile) != sourceStem(task.SourceFile)+".o" {
+		// the driver writes <source>.o files, whatever the platform, which
+		// the objects of the hashed layout are not
+		return false
+	}
+	info, err := os.Stat(task.SourceFile)
+	if err != nil || info.Size() > batchedSourceSize {
+		return false
+	}
+	if entry, exists := b.Cache.GetEntry(task.OutputFile); exists && entry.CompilationTime > batchedCompileTime {
+		return false
+	}
+	return true
+}
+
+// sourceStem returns the name of sourceFile without its extension
+func sourceStem(sourceFile string) string {
+	name := filepath.Base(sourceFile)
+	return name[:len(name)-len(filepath.Ext(name))]
+}
+
+// startBatch creates the task of a batch, or returns the task of its only
+// member, and completes the members once the batch is done
+func (b *Builder) startBatch(batch *batch) *Task {
+	if len(batch.members) == 1 {
+		return batch.members[0]
+	}
+
+	first := batch.members[0]
+	args := []string{"-c"}
+	for _, member := range batch.members {
+		source, _ := filepath.Abs(member.SourceFile)
+		args = append(args, source)
+		member.CompleteCh = make(chan struct{})
+		// a stale object would pass for the one of a failed compilation
+		_ = os.Remove(member.OutputFile)
+	}
+	batch.task = &Task{
+		ID:         fmt.Sprintf("batch of %d in %s", len(batch.members), filepath.Dir(first.OutputFile)),
+		Command:    first.Command,
+		Args:       append(args, absoluteFlags(first.Args[4:])...),
+		Dir:        filepath.Dir(first.OutputFile),
+		CompleteCh: make(chan struct{}),
+	}
+
+	go b.completeBatch(batch)
+	return batch.task
+}
+
+// completeBatch waits for a batch and completes every member with its share
+// of the time and the diagnostics about its source. A member whose object
+// was not written failed.
+func (b *Builder) completeBatch(batch *batch) {
+	result := b.Executor.WaitForTask(batch.task)
+	output := result.Stderr
+	if wd, err := os.Getwd(); err == nil {
+		output = strings.ReplaceAll(output, wd+string(filepath.Separator), "")
+	}
+
+	var sources []string
+	for _, member := range batch.members {
+		sources = append(sources, member.SourceFile)
+	}
+	diagnostics := splitDiagnostics(output, sources)
+
+	n := time.Duration(len(batch.members))
+	share := (batch.task.EndTime.Sub(batch.task.StartTime)) / n
+	for i, member := range batch.members {
+		member.Output = &bytes.Buffer{}
+		member.Stderr = diagnostics[member.SourceFile]
+		member.Worker = batch.task.Worker
+		member.StartTime = batch.task.StartTime.Add(time.Duration(i) * share)
+		member.EndTime = member.StartTime.Add(share)
+		member.CPUTime = batch.task.CPUTime / n
+
+		if _, err := os.Stat(member.OutputFile); err != nil {
+			switch {
+			case member.Stderr != "":
+				member.Error = fmt.Errorf("compilation failed: %s", member.Stderr)
+			case result.Error != nil:
+				member.Error = result.Error
+			default:
+				member.Error = fmt.Errorf("compiler did not write %s", member.OutputFile)
+			}
+		}
+		close(member.CompleteCh)
+	}
+}
+
+// splitDiagnostics attributes the lines of the output of a batch to the
+// sources they are about. Diagnostics about a source start with its path,
+// and the ones about its headers with "In file included from" and its path;
+// every line belongs to the source named last, and the lines before the
+// first one to every source.
+func splitDiagnostics(output string, sources []string) map[string]string {
+	parts := make(map[string]*strings.Builder)
+	for _, source := range sources {
+		parts[source] = &strings.Builder{}
+	}
+
+	var shared strings.Builder
+	current := ""
+	for _, line := range strings.SplitAfter(output, "\n") {
+		for _, source := range sources {
+			if strings.HasPrefix(line, source+":") || strings.HasPrefix(line, "In file included from "+source+":") {
+				current = source
+				break
+			}
+		}
+		if current == "" {
+			shared.WriteString(line)
+			continue
+		}
+		parts[current].WriteString(line)
+	}
+
+	diagnostics := make(map[string]string)
+	for source, part := range parts {
+		if part.Len() > 0 {
+			diagnostics[source] = shared.String() + part.String()
+		} else if shared.Len() > 0 {
+			diagnostics[source] = shared.String()
+		}
+	}
+	return diagnostics
+}
+
+// absoluteFlags returns flags with the paths of include directories and
+// forced includes made absolute, for a compiler run in another directory
+func absoluteFlags(flags []string) []string {
+	absolute := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			return abs
+		}
+		return path
+	}
+
+	var result []string
+	for _, f := range parseFlags(flags) {
+		switch {
+		case f.class == flagInclude && len(f.args) == 1:
+			result = append(result, "-I"+absolute(strings.TrimPrefix(f.args[0], "-I")))
+		case f.class == flagInclude, len(f.args) == 2 && (f.args[0] == "-include" || f.args[0] == "-imacros"):
+			result = append(result, f.args[0], absolute(f.args[1]))
+		default:
+			result = append(result, f.args...)
+		}
+	}
+	return result
+}
@@ -1,19 +1,29 @@
 package builder
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/deviceix/styx/internal/compiler"
 	"github.com/deviceix/styx/internal/config"
 	"github.com/deviceix/styx/internal/dependency"
+	"github.com/deviceix/styx/internal/deps"
+	"github.com/deviceix/styx/internal/i18n"
 	"github.com/deviceix/styx/internal/logger"
 	"github.com/deviceix/styx/internal/platform"
+	"github.com/deviceix/styx/internal/telemetry"
 )
 
+// ErrTargetNotFound is returned when selecting a target that is not configured
+var ErrTargetNotFound = errors.New("target not found")
+
 // Builder is responsible for the build process
 type Builder struct {
 	Config       *config.Config
@@ -24,54 +34,148 @@ type Builder struct {
 	Executor     *Executor
 	Target       string
 	OutputDir    string
+	OutputLayout string // how outputs are nested in OutputDir, one of the Layout constants
 	Verbose      bool
-	HasCppFiles  bool
+	Profile      bool   // write a timing report of every build
+	TracePath    string // write a Chrome trace of every build there
+	Packages     []*deps.Package
 	platformInfo *platform.PlatformInfo
 	logger       *logger.Logger
+
+	compileCommands []CompileCommand
+	scanned         map[string]bool
+	probes          *probeCache
+	checkDefines    []Define
+	pchFlags        map[string][]string
+	flagConflicts   map[string]bool // the conflicting flags warned about
+	container       *container
+	envDigest       string
+	generated       *generatedSources     // set by Plan
+	rulesRan        bool                  // a rule created files in this build
+	timings         []stepTime            // the commands run by this build
+	diagnostics     []logger.BuilderEvent // reported by the compiler in this build
+	watchingTests   bool                  // the test sources are watched as well
+	shard           Shard                 // the part of the work to do, all of it when zero
+	ltoUnsupported  bool                  // the compiler cannot do the LTO of the target
+	coverage        bool                  // the build is instrumented for coverage
+	graphPath       string                // where the scanner saves the files it read
+	plugins         []*plugin             // the plugins notified of the events of builds
+	configChecked   bool                  // the ineffective configuration was warned about
+	hasObjCFiles    bool                  // Objective-C or Objective-C++ sources are linked
+	hasCUDAFiles    bool                  // CUDA sources are linked
+	targetLock      *sync.Mutex           // held while building together with other targets
+
+	// set when the builder is one of the projects of a workspace
+	memberPackages map[string]*deps.Package
+	sharedExecutor bool
 }
 
-// NewBuilder creates a new builder for the given configuration
-func NewBuilder(cfg *config.Config) (*Builder, error) {
-	outputDir := "build"
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
+// NewBuilder creates a new builder for the given configuration, set up by
+// the given options
+func NewBuilder(cfg *config.Config, opts ...Option) (*Builder, error) {
+	options := BuilderOptions{
+		OutputDir: "build",
+		CacheDir:  CacheDir(cfg),
+	}
+	if env := cfg.ActiveEnvironment(); env != nil && env.OutputDir != "" {
+		options.OutputDir = env.OutputDir
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	layout := cfg.Build.OutputLayout
+	if options.OutputLayout != "" {
+		layout = options.OutputLayout
+	}
+	if err := checkLayout(layout); err != nil {
+		return nil, err
+	}
+	if layout == "" {
+		layout = LayoutTarget
+	}
+	log := options.newLogger()
+
+	if err := os.MkdirAll(options.OutputDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create build directory: %w", err)
 	}
 
-	cacheDir := filepath.Join(".styx", "cache")
-	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+	if err := os.MkdirAll(options.CacheDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
 	platformInfo := platform.GetPlatformInfo()
-	compilerName := cfg.Toolchain.Compiler
-	if compilerName == "" || compilerName == "auto" {
-		comp, err := compiler.GetDefaultCompiler("")
+	ctr, envDigest, err := toolchainEnvironment(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// the variables of the environment can change what the compiler does
+	if env := cfg.ActiveEnvironment(); env != nil && len(env.Env) > 0 {
+		envDigest = strings.TrimSpace(envDigest + " env:" + variablesDigest(env.Env))
+	}
+
+	// the toolchain of a container comes with its image
+	if ctr == nil && cfg.Toolchain.Use != "" {
+		digest, err := useToolchain(cfg, log)
 		if err != nil {
-			return nil, fmt.Errorf("failed to find a suitable compiler: %w", err)
+			return nil, err
 		}
-		compilerName = comp.GetName()
+		envDigest = strings.TrimSpace(envDigest + " " + digest)
 	}
 
-	comp, err := compiler.GetCompiler(compilerName)
-	if err != nil {
-		// try again, one more time
-		compiler.DetectCompilers()
-		comp, err = compiler.GetCompiler(compilerName)
-		if err != nil {
-			return nil, fmt.Errorf("compiler not found: %s", compilerName)
+	comp := options.Compiler
+	if ctr != nil {
+		// the toolchain lives in the image, which is always Linux
+		if comp == nil {
+			comp, err = ctr.detectCompiler(cfg.Toolchain.Compiler)
 		}
+		platformInfo = platform.GetPlatformInfoFor(platform.PlatformLinux)
+	} else if comp == nil {
+		comp, err = hostCompiler(cfg.Toolchain.Compiler)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := comp.GetVersionInfo().StandardName(cfg.Project.Standard); err != nil {
+		return nil, err
+	}
+
+	// the sections of the platform and the compiler apply from here on
+	if err := cfg.SelectConditions(platformInfo.Name, strings.ToLower(comp.GetName())); err != nil {
+		return nil, err
+	}
+	if options.Jobs == 0 {
+		options.Jobs = cfg.Build.Jobs
 	}
+	dependency.SetMaxDepth(cfg.Build.MaxScanDepth)
 
-	scanner := dependency.NewDependencyScanner(cfg.Build.IncludeDirs)
-	log := logger.New(false)
-	cache := NewCache(filepath.Join(cacheDir, "build.json"))
+	includeDirs := append(append([]string{}, cfg.Build.IncludeDirs...), artifactIncludeDirs(cfg)...)
+	scanner := dependency.NewDependencyScanner(includeDirs)
+	cache := NewCache(filepath.Join(options.CacheDir, "build.json"))
+	cache.Strategy = cfg.Cache.RebuildStrategy
 	if err := cache.Load(); err != nil {
 		return nil, fmt.Errorf("failed to load cache: %w", err)
 	}
+	scanner.SetFS(cache.FS)
+	graphPath := filepath.Join(options.CacheDir, "graph.bin")
+	if err := scanner.Load(graphPath); err != nil {
+		log.Warning("%v", err)
+	}
+
+	plugins, err := loadPlugins(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	// `workerCount` 0 means use all available
-	executor := NewExecutor(0)
+	executor := NewExecutor(options.Jobs)
 	executor.SetLogger(log)
+	executor.maxLoad = options.MaxLoad
+	executor.container = ctr
+	if env := cfg.ActiveEnvironment(); env != nil {
+		executor.env = env.Env
+	}
 	return &Builder{
 		Config:       cfg,
 		Compiler:     comp,
@@ -80,28 +184,56 @@ func NewBuilder(cfg *config.Config) (*Builder, error) {
 		Cache:        cache,
 		Executor:     executor,
 		Target:       "debug", // default to debug
-		OutputDir:    outputDir,
+		OutputDir:    options.OutputDir,
+		OutputLayout: layout,
+		Verbose:      options.Verbose,
 		platformInfo: platformInfo,
 		logger:       log, // Set the logger
+		container:    ctr,
+		envDigest:    envDigest,
+		graphPath:    graphPath,
+		plugins:      plugins,
 	}, nil
 }
 
-// SetVerbose sets verbose output mode
+// hostCompiler finds the named compiler on the host, or the default one
+// when the name is empty or "auto"
+func hostCompiler(compilerName string) (compiler.Compiler, error) {
+	if compilerName == "" || compilerName == "auto" {
+		comp, err := compiler.GetDefaultCompiler("")
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a suitable compiler: %w", err)
+		}
+		return comp, nil
+	}
+
+	return compiler.GetCompiler(compilerName)
+}
+
+// SetVerbose sets verbose output mode, of the logger of the builder as well
 func (b *Builder) SetVerbose(verbose bool) {
 	b.Verbose = verbose
-	// Update the logger with the new verbosity setting
-	b.logger = logger.New(verbose)
+	b.logger.SetVerbose(verbose)
+}
+
+// SetProfile makes every build write a timing report to the reports
+// directory
+func (b *Builder) SetProfile(profile bool) {
+	b.Profile = profile
+}
+
+// SetTrace makes every build write a Chrome trace of the commands it runs
+// to path; an empty path writes none
+func (b *Builder) SetTrace(path string) {
+	b.TracePath = path
 }
 
 // SetTarget sets the build target
 func (b *Builder) SetTarget(target string) error {
 	if target == "" {
-		b.Target = "debug"
-		return nil
-	}
-
-	if _, exists := b.Config.Targets[target]; !exists {
-		return fmt.Errorf("target not found: %s", target)
+		target = "debug"
+	} else if _, exists := b.Config.Targets[target]; !exists {
+		return fmt.Errorf("%w: %s", ErrTargetNotFound, target)
 	}
 
 	b.Target = target
@@ -122,80 +254,225 @@ func (b *Builder) SetOutputDir(dir string) error {
 	return nil
 }
 
-// Build performs the build process
-func (b *Builder) Build() error {
+// BuildResult is what a build produced
+type BuildResult struct {
+	Target      string
+	Outputs     []string
+	Executables []Executable // in the order of the configuration
+}
+
+// Executable is an executable a build produced
+type Executable struct {
+	Name string
+	Path string
+}
+
+// Executable returns the path of the executable called name, or of the
+// first one when name is empty
+func (r *BuildResult) Executable(name string) (string, error) {
+	if len(r.Executables) == 0 {
+		return "", errors.New("cannot run non-executable output")
+	}
+	if name == "" {
+		return r.Executables[0].Path, nil
+	}
+	for _, exe := range r.Executables {
+		if exe.Name == name {
+			return exe.Path, nil
+		}
+	}
+	return "", fmt.Errorf("binary not found: %s", name)
+}
+
+// buildResult returns the result of a build of plan
+func (b *Builder) buildResult(plan *Plan) *BuildResult {
+	result := &BuildResult{Target: b.Target, Outputs: plan.Outputs}
+	for _, a := range b.ResolveArtifacts() {
+		if a.Type == "executable" {
+			result.Executables = append(result.Executables, Executable{Name: a.Name, Path: a.Path})
+		}
+	}
+	return result
+}
+
+// Build performs the build process and returns what it produced
+func (b *Builder) Build() (result *BuildResult, err error) {
+	startTime := time.Now()
+	var outputs []string
+	var plan *Plan
+	defer func() {
+		if pluginErr := b.notifyPlugins(eventPostBuild, buildSummary(startTime, outputs, err)); pluginErr != nil {
+			if err != nil {
+				b.logger.Warning("%v", pluginErr)
+			} else {
+				result, err = nil, pluginErr
+			}
+		}
+		b.reportSummary(startTime, outputs, err)
+		b.recordBuild(startTime, plan, err)
+		b.saveTrace(startTime)
+		b.saveRecord(startTime, plan, outputs, err)
+		b.saveBuildLog()
+	}()
+	if b.TracePath != "" && !b.sharedExecutor {
+		b.Executor.StartTrace()
+	}
+
 	b.logger.Info("starting build for target: %s", b.Target)
 	b.logger.Info("project: %s (version %s)", b.Config.Project.Name, b.Config.Project.Version)
 	b.logger.Info("compiler: %s", b.Compiler.GetName())
 
-	startTime := time.Now()
-	targetOutputDir := filepath.Join(b.OutputDir, b.Target)
+	if err := b.checkSanitizers(); err != nil {
+		return nil, err
+	}
+	b.checkLTO()
+	b.warnIneffectiveConfig()
+
+	targetOutputDir := b.targetOutputDir()
 	if err := os.MkdirAll(targetOutputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create target output directory: %w", err)
+		return nil, fmt.Errorf("failed to create target output directory: %w", err)
+	}
+	if err := b.recordLayout(); err != nil {
+		return nil, err
 	}
+	previousSizes := b.outputSizes(targetOutputDir)
 
-	if err := b.executePreBuildCommands(); err != nil {
-		return fmt.Errorf("pre-build commands failed: %w", err)
+	if err := b.startContainer(); err != nil {
+		return nil, err
 	}
-	b.logger.Info("finding source files...")
-	sourceFiles, err := dependency.FindSourceFiles(b.Config.Build.Sources, b.Config.Build.Exclude)
-	if err != nil {
-		return fmt.Errorf("failed to find source files: %w", err)
+	defer b.stopContainer()
+
+	if !b.sharedExecutor {
+		b.Executor.Start()
+		defer b.Executor.Shutdown()
 	}
 
-	if len(sourceFiles) == 0 {
-		b.logger.Warning("no source files found. Check your sources configuration.")
-		return fmt.Errorf("no source files found")
+	if err := b.executePreBuildCommands(); err != nil {
+		return nil, fmt.Errorf("pre-build commands failed: %w", err)
+	}
+	if err := b.notifyPlugins(eventPreBuild, nil); err != nil {
+		return nil, err
 	}
 
-	b.logger.Info("found %d source files", len(sourceFiles))
-	if err := b.buildDependencyGraph(sourceFiles); err != nil {
-		return fmt.Errorf("failed to build dependency graph: %w", err)
+	b.compileCommands = nil
+	b.timings = nil
+	b.diagnostics = nil
+	plan, err = b.Plan()
+	if err == nil && b.sharded() {
+		plan = b.shardPlan(plan)
+	}
+	if err == nil {
+		err = b.execute(plan)
 	}
 
-	b.Executor.Start()
-	defer b.Executor.Shutdown()
+	if len(b.compileCommands) > 0 {
+		if _, err := b.writeCompileCommands(b.compileCommandsDir()); err != nil {
+			b.logger.Warning("%v", err)
+		}
+	}
 
-	b.logger.Info("compiling source files...")
-	objectFiles, err := b.scheduleCompilationTasks(sourceFiles, targetOutputDir)
 	if err != nil {
-		return fmt.Errorf("failed to compile source files: %w", err)
+		return nil, err
 	}
+	outputs = plan.Outputs
 
-	outputPath := b.getOutputPath(targetOutputDir)
-	switch b.Config.Build.OutputType {
-	case "executable":
-		b.logger.Info("linking executable: %s", filepath.Base(outputPath))
-		if err := b.scheduleLinkingTask(objectFiles, outputPath); err != nil {
-			return fmt.Errorf("failed to link object files: %w", err)
-		}
-	case "static_lib":
-		b.logger.Info("creating static library: %s", filepath.Base(outputPath))
-		if err := b.scheduleArchiveTask(objectFiles, outputPath); err != nil {
-			return fmt.Errorf("failed to create static library: %w", err)
-		}
-	case "shared_lib":
-		b.logger.Info("creating shared library: %s", filepath.Base(outputPath))
-		if err := b.scheduleSharedLibTask(objectFiles, outputPath); err != nil {
-			return fmt.Errorf("failed to create shared library: %w", err)
+	// the post-build commands need the outputs, which shards do not link
+	if !b.sharded() {
+		if err := b.executePostBuildCommands(); err != nil {
+			return nil, fmt.Errorf("post-build commands failed: %w", err)
 		}
-	default:
-		return fmt.Errorf("unsupported output type: %s", b.Config.Build.OutputType)
 	}
 
-	if err := b.executePostBuildCommands(); err != nil {
-		return fmt.Errorf("post-build commands failed: %w", err)
+	for path, skew := range b.Cache.ClockSkew() {
+		b.logger.Warning("clock skew detected: %s was modified %s in the future", path, skew.Round(time.Second))
 	}
 
 	if err := b.Cache.Save(); err != nil {
 		b.logger.Warning("failed to save build cache: %v", err)
 	}
+	b.saveScanGraph()
 
 	buildTime := time.Since(startTime)
+	b.reportTimings(plan, buildTime)
+	if b.Profile {
+		if path, err := b.writeProfile(plan, startTime, buildTime); err != nil {
+			b.logger.Warning("%v", err)
+		} else {
+			b.logger.Info("timing report written to %s", path)
+		}
+	}
 	b.logger.Success("build completed in %.2f seconds", buildTime.Seconds())
-	b.logger.Success("output: %s", outputPath)
+	for _, output := range outputs {
+		if b.Config.Build.StripDeadCode {
+			b.logger.Success("output: %s (%s)", output, describeSize(output, previousSizes))
+			continue
+		}
+		b.logger.Success("output: %s", output)
+	}
 
-	return nil
+	return b.buildResult(plan), nil
+}
+
+// reportSummary writes the build_summary event of a build that started at
+// startTime and ended with outputs or err
+func (b *Builder) reportSummary(startTime time.Time, outputs []string, err error) {
+	summary := buildSummary(startTime, outputs, err)
+	summary["project"] = b.Config.Project.Name
+	summary["target"] = b.Target
+	b.logger.Event("build_summary", summary)
+}
+
+// buildSummary returns how a build that started at startTime and ended
+// with outputs or err went, for the build_summary and post-build events
+func buildSummary(startTime time.Time, outputs []string, err error) map[string]interface{} {
+	if err != nil || outputs == nil {
+		outputs = []string{}
+	}
+	summary := map[string]interface{}{
+		"success":     err == nil,
+		"duration_ms": time.Since(startTime).Milliseconds(),
+		"outputs":     outputs,
+	}
+	if err != nil {
+		summary["error"] = err.Error()
+	}
+	return summary
+}
+
+// recordBuild records the features, compiler and duration of a build in
+// the telemetry, for users who opted in
+func (b *Builder) recordBuild(startTime time.Time, plan *Plan, err error) {
+	event := telemetry.Event{
+		Kind:       "build",
+		Features:   telemetry.ConfigFeatures(b.Config),
+		Compiler:   b.Compiler.GetVersionInfo().Vendor,
+		Success:    err == nil,
+		DurationMS: time.Since(startTime).Milliseconds(),
+	}
+	if plan != nil {
+		for _, step := range plan.Steps {
+			event.Steps++
+			if !step.UpToDate {
+				event.Ran++
+			}
+		}
+	}
+	telemetry.Record(event)
+}
+
+// BuildLogPath returns the file keeping the log of the last build of the
+// project configured by cfg
+func BuildLogPath(cfg *config.Config) string {
+	return filepath.Join(cfg.StateDir(), "last-build.log")
+}
+
+// saveBuildLog writes the messages logged up to the end of the build to
+// the build log, for bug reports
+func (b *Builder) saveBuildLog() {
+	data := strings.Join(logger.History(), "\n") + "\n"
+	if err := platform.WriteFileAtomic(BuildLogPath(b.Config), []byte(data), 0644); err != nil {
+		b.logger.Warning("failed to save build log: %v", err)
+	}
 }
 
 // executePreBuildCommands executes pre-build commands
@@ -225,7 +502,7 @@ func (b *Builder) executePreBuildCommands() error {
 		}
 
 		b.Executor.Submit(task)
-		result := b.Executor.WaitForTask(task)
+		result := b.waitForTask(task)
 		if result == nil || !result.Success {
 			b.logger.StopProgress()
 			if result != nil {
@@ -264,7 +541,7 @@ func (b *Builder) executePostBuildCommands() error {
 		// Replace variables in arguments
 		for j, arg := range args {
 			// Replace ${output} with the actual output path
-			args[j] = strings.ReplaceAll(arg, "${output}", b.getOutputPath(filepath.Join(b.OutputDir, b.Target)))
+			args[j] = strings.ReplaceAll(arg, "${output}", b.GetOutputPath())
 		}
 
 		b.logger.UpdateProgress(i+1, fmt.Sprintf("Running: %s", cmd))
@@ -277,7 +554,7 @@ func (b *Builder) executePostBuildCommands() error {
 
 		// Execute synchronously
 		b.Executor.Submit(task)
-		result := b.Executor.WaitForTask(task)
+		result := b.waitForTask(task)
 
 		if result == nil || !result.Success {
 			b.logger.StopProgress()
@@ -301,51 +578,10 @@ func (b *Builder) buildDependencyGraph(sourceFiles []string) error {
 	b.logger.StartProgress(len(sourceFiles), "scanning dependencies")
 
 	for i, sourceFile := range sourceFiles {
-		b.logger.UpdateProgress(i+1, fmt.Sprintf("scanning %s", filepath.Base(sourceFile)))
-
-		// source node
-		sourceNode := &dependency.Node{
-			ID:   sourceFile,
-			Type: dependency.NodeTypeSource,
-			Path: sourceFile,
-		}
-
-		if err := b.Graph.AddNode(sourceNode); err != nil {
-			if !strings.Contains(err.Error(), "already exists") {
-				b.logger.StopProgress()
-				return fmt.Errorf("failed to add source node: %w", err)
-			}
-		}
-
-		// find deps
-		deps, err := b.Scanner.Scan(sourceFile)
-		if err != nil {
+		b.logger.UpdateProgress(i+1, fmt.Sprintf(i18n.T("scanning %s"), filepath.Base(sourceFile)))
+		if err := b.scanSource(sourceFile); err != nil {
 			b.logger.StopProgress()
-			return fmt.Errorf("failed to scan dependencies for %s: %w", sourceFile, err)
-		}
-
-		if b.Verbose {
-			b.logger.Note("found %d dependencies for %s", len(deps), sourceFile)
-		}
-
-		for _, depPath := range deps {
-			headerNode := &dependency.Node{
-				ID:   depPath,
-				Type: dependency.NodeTypeHeader,
-				Path: depPath,
-			}
-
-			if err := b.Graph.AddNode(headerNode); err != nil {
-				if !strings.Contains(err.Error(), "already exists") {
-					b.logger.StopProgress()
-					return fmt.Errorf("failed to add header node: %w", err)
-				}
-			}
-
-			if err := b.Graph.AddDependency(sourceFile, depPath); err != nil {
-				b.logger.StopProgress()
-				return fmt.Errorf("failed to add dependency: %w", err)
-			}
+			return err
 		}
 	}
 
@@ -354,245 +590,264 @@ func (b *Builder) buildDependencyGraph(sourceFiles []string) error {
 	return nil
 }
 
-// scheduleCompilationTasks schedules source file compilations
-func (b *Builder) scheduleCompilationTasks(sourceFiles []string, outputDir string) ([]string, error) {
-	var objectFiles []string
-	var filesToCompile []*Task
+// findSources returns the sources matching patterns but not exclude. The
+// files matched that cannot be read, or are binary, are skipped with a
+// warning naming the pattern matching them, or fail with strict_sources.
+func (b *Builder) findSources(patterns, exclude []string) ([]string, error) {
+	files, err := dependency.FindSourceFiles(patterns, exclude)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, sourceFile := range sourceFiles {
-		ext := filepath.Ext(sourceFile)
-		if ext == ".cpp" || ext == ".cc" || ext == ".cxx" || ext == ".C" {
-			b.HasCppFiles = true
-			break
+	sources := files[:0]
+	for _, file := range files {
+		if err := b.Scanner.Check(file); err != nil {
+			err = fmt.Errorf("%s, matched by %s: %w", file, sourcePattern(patterns, file), err)
+			if b.Config.Build.StrictSources {
+				return nil, err
+			}
+			b.logger.Warning("skipping %v", err)
+			continue
 		}
+		sources = append(sources, file)
 	}
+	return sources, nil
+}
 
-	cFlags := b.getCompilationFlags()
-
-	totalFiles := len(sourceFiles)
-	compiledCount := 0
-	b.logger.StartProgress(totalFiles, "compiling")
+// sourcePattern returns the first of patterns matching file
+func sourcePattern(patterns []string, file string) string {
+	for _, pattern := range patterns {
+		matches, _ := dependency.FindSourceFiles([]string{pattern}, nil)
+		if slices.Contains(matches, file) {
+			return pattern
+		}
+	}
+	return strings.Join(patterns, ", ")
+}
 
-	for _, sourceFile := range sourceFiles {
-		ext := filepath.Ext(sourceFile)
-		isCpp := ext == ".cpp" || ext == ".cc" || ext == ".cxx" || ext == ".C"
+// scanSource adds a source and its includes to the dependency graph
+func (b *Builder) scanSource(sourceFile string) error {
+	if b.scanned == nil {
+		b.scanned = make(map[string]bool)
+	}
 
-		objectFile := b.getObjectFilePath(sourceFile, outputDir)
-		objectFiles = append(objectFiles, objectFile)
+	// sources already in the graph keep their includes until invalidated
+	if b.scanned[sourceFile] {
+		return nil
+	}
 
-		objectNode := &dependency.Node{
-			ID:   objectFile,
-			Type: dependency.NodeTypeObject,
-			Path: objectFile,
+	// generated sources are scanned once the rule creating them ran
+	if node, exists := b.Graph.GetNode(sourceFile); exists && node.Type == dependency.NodeTypeGenerated {
+		if _, err := b.Cache.FS.Stat(sourceFile); err != nil {
+			return nil
 		}
+	}
 
-		if err := b.Graph.AddNode(objectNode); err != nil {
-			if !strings.Contains(err.Error(), "already exists") {
-				b.logger.StopProgress()
-				return nil, fmt.Errorf("failed to add object node: %w", err)
-			}
-		}
+	// source node
+	sourceNode := &dependency.Node{
+		ID:   sourceFile,
+		Type: dependency.NodeTypeSource,
+		Path: sourceFile,
+	}
 
-		if err := b.Graph.AddDependency(objectFile, sourceFile); err != nil {
-			b.logger.StopProgress()
-			return nil, fmt.Errorf("failed to add dependency: %w", err)
+	if err := b.Graph.AddNode(sourceNode); err != nil {
+		if !errors.Is(err, dependency.ErrNodeExists) {
+			return fmt.Errorf("failed to add source node: %w", err)
 		}
+	}
 
-		sourceNode, _ := b.Graph.GetNode(sourceFile)
-		for _, dep := range sourceNode.Dependencies {
-			if err := b.Graph.AddDependency(objectFile, dep.ID); err != nil {
-				b.logger.StopProgress()
-				return nil, fmt.Errorf("failed to add dependency: %w", err)
-			}
-		}
+	// find deps
+	deps, err := b.Scanner.Scan(sourceFile)
+	if err != nil {
+		return fmt.Errorf("failed to scan dependencies for %s: %w", sourceFile, err)
+	}
 
-		commandHash := b.Cache.CalculateCommandHash(b.Compiler.GetName(), cFlags)
-		var dependencies []string
-		dependencies = append(dependencies, sourceFile)
-		for _, dep := range sourceNode.Dependencies {
-			dependencies = append(dependencies, dep.Path)
-		}
+	if b.Verbose {
+		b.logger.Note("found %d dependencies for %s", len(deps), sourceFile)
+	}
 
-		needsRebuild, reason := b.needsRebuild(objectFile, dependencies, commandHash)
-		if reason == "" {
-			b.logger.Warning("error checking if %s needs rebuild: %v", sourceFile, reason)
-			needsRebuild = true
-			reason = "error occurred"
+	for _, depPath := range deps {
+		headerNode := &dependency.Node{
+			ID:   depPath,
+			Type: dependency.NodeTypeHeader,
+			Path: depPath,
 		}
 
-		if !needsRebuild {
-			compiledCount++
-			b.logger.UpdateProgress(compiledCount, fmt.Sprintf("Skipping %s (up to date)", filepath.Base(sourceFile)))
-			if b.Verbose {
-				b.logger.Note("Skipping up-to-date file: %s", sourceFile)
+		if err := b.Graph.AddNode(headerNode); err != nil {
+			if !errors.Is(err, dependency.ErrNodeExists) {
+				return fmt.Errorf("failed to add header node: %w", err)
 			}
-			continue
-		}
-
-		objDir := filepath.Dir(objectFile)
-		if err := os.MkdirAll(objDir, 0755); err != nil {
-			b.logger.StopProgress()
-			return nil, fmt.Errorf("failed to create output directory: %w", err)
 		}
 
-		compilerCmd := b.Compiler.GetName()
-		if isCpp {
-			if strings.Contains(compilerCmd, "clang") {
-				compilerCmd = "clang++"
-			} else if strings.Contains(compilerCmd, "gcc") {
-				compilerCmd = "g++"
-			}
+		if err := b.Graph.AddDependency(sourceFile, depPath); err != nil {
+			return fmt.Errorf("failed to add dependency: %w", err)
 		}
+	}
 
-		task := &Task{
-			ID:           sourceFile,
-			Command:      compilerCmd,
-			Args:         append([]string{"-c", sourceFile, "-o", objectFile}, cFlags...),
-			Dir:          "",
-			Env:          nil,
-			Output:       nil,
-			SourceFile:   sourceFile,
-			OutputFile:   objectFile,
-			Dependencies: nil,
-		}
+	b.scanned[sourceFile] = true
+	return nil
+}
 
-		filesToCompile = append(filesToCompile, task)
+// compileCommandHash identifies everything besides the inputs that an
+// object compiled with cFlags depends on, including the compiler itself: its
+// path, version and target, as compilers of the same family may differ in
+// all three
+func (b *Builder) compileCommandHash(cFlags []string) string {
+	hashInputs := append(append([]string{}, cFlags...), b.dependencyFingerprint()...)
+	if b.Config.Build.StdlibPCH {
+		hashInputs = append(hashInputs, "stdlib_pch")
 	}
-
-	for _, task := range filesToCompile {
-		b.Executor.Submit(task)
+	if b.envDigest != "" {
+		hashInputs = append(hashInputs, b.envDigest)
 	}
+	compiler := b.Compiler.GetPath() + " " + b.Compiler.GetVersionInfo().String()
+	return b.Cache.CalculateCommandHash(compiler, hashInputs)
+}
 
-	var compilationErrors []string
-	for _, task := range filesToCompile {
-		result := b.Executor.WaitForTask(task)
-		compiledCount++
-		b.logger.UpdateProgress(compiledCount, fmt.Sprintf("Compiled %s", filepath.Base(task.SourceFile)))
+// addObjectNode adds the object compiled from sourceFile to the dependency
+// graph, depending on the source and its includes, and returns those inputs
+// with the source first
+func (b *Builder) addObjectNode(sourceFile, objectFile string) ([]string, error) {
+	objectNode := &dependency.Node{
+		ID:   objectFile,
+		Type: dependency.NodeTypeObject,
+		Path: objectFile,
+	}
 
-		if result == nil || !result.Success {
-			if result != nil {
-				errorMsg := fmt.Sprintf("Compilation of %s failed: %v", task.SourceFile, result.Error)
-				compilationErrors = append(compilationErrors, errorMsg)
-				b.parseCompilerOutput(result.Error.Error(), task.SourceFile)
-			} else {
-				errorMsg := fmt.Sprintf("Compilation of %s failed: unknown error", task.SourceFile)
-				compilationErrors = append(compilationErrors, errorMsg)
-				b.logger.Error(errorMsg)
-			}
-			continue
+	if err := b.Graph.AddNode(objectNode); err != nil {
+		if !errors.Is(err, dependency.ErrNodeExists) {
+			return nil, fmt.Errorf("failed to add object node: %w", err)
 		}
+	}
 
-		if b.Verbose {
-			b.logger.Note("Compiled %s in %.2f seconds", filepath.Base(task.SourceFile), result.Duration.Seconds())
-		}
+	if err := b.Graph.AddDependency(objectFile, sourceFile); err != nil {
+		return nil, fmt.Errorf("failed to add dependency: %w", err)
+	}
 
-		sourceNode, _ := b.Graph.GetNode(task.SourceFile)
-		var dependencies []string
-		dependencies = append(dependencies, task.SourceFile)
-		for _, dep := range sourceNode.Dependencies {
-			dependencies = append(dependencies, dep.Path)
+	sourceNode, _ := b.Graph.GetNode(sourceFile)
+	for _, dep := range sourceNode.Dependencies {
+		if err := b.Graph.AddDependency(objectFile, dep.ID); err != nil {
+			return nil, fmt.Errorf("failed to add dependency: %w", err)
 		}
+	}
 
-		commandHash := b.Cache.CalculateCommandHash(b.Compiler.GetName(), cFlags)
-		compilationTime := result.Duration
+	dependencies := []string{sourceFile}
+	for _, dep := range sourceNode.Dependencies {
+		dependencies = append(dependencies, dep.Path)
+	}
+	return dependencies, nil
+}
 
-		if err := b.Cache.UpdateEntry(task.OutputFile, dependencies, commandHash, task.OutputFile, compilationTime); err != nil {
-			b.logger.Warning("Failed to update cache entry for %s: %v", task.SourceFile, err)
-		}
+// newCompileTask creates the task compiling sourceFile into objectFile
+func (b *Builder) newCompileTask(sourceFile, objectFile string, cFlags []string) *Task {
+	command := b.compilerCommand(isCppSource(sourceFile))
+	args := append([]string{"-c", sourceFile, "-o", objectFile}, cFlags...)
+	if assembler := b.assemblerFor(sourceFile); assembler != "" {
+		command = assembler
+		args = append([]string{sourceFile, "-o", objectFile}, cFlags...)
+	} else if languageOf(sourceFile) == "cuda" {
+		command = b.cudaCompiler()
+	} else if filepath.Ext(sourceFile) == ".asm" {
+		// the driver only knows .s and .S as assembly
+		args = append([]string{"-x", "assembler-with-cpp"}, args...)
 	}
 
-	b.logger.StopProgress()
-	if len(compilationErrors) > 0 {
-		for _, err := range compilationErrors {
-			b.logger.Error("%s", err)
-		}
-		return nil, fmt.Errorf("compilation failed with %d errors", len(compilationErrors))
+	return &Task{
+		ID:           sourceFile,
+		Command:      command,
+		Args:         args,
+		Dir:          "",
+		Env:          nil,
+		Output:       nil,
+		SourceFile:   sourceFile,
+		OutputFile:   objectFile,
+		Dependencies: nil,
 	}
+}
 
-	b.logger.Success("Compilation complete")
-	return objectFiles, nil
+// isCppSource reports whether sourceFile is compiled as C++, which
+// Objective-C++ is as well
+func isCppSource(sourceFile string) bool {
+	return baseLanguage(languageOf(sourceFile)) == "c++"
 }
 
-// needsRebuild determines if a file needs to be rebuilt
-func (b *Builder) needsRebuild(objectFile string, dependencies []string, commandHash string) (bool, string) {
-	if needsRebuild, err := b.Cache.NeedsRebuild(objectFile, dependencies, commandHash); err == nil && needsRebuild {
-		return true, "cache indicates rebuild needed"
-	}
+// hasCppSources reports whether any of sourceFiles is C++, in which case
+// their objects are linked with the C++ driver
+func hasCppSources(sourceFiles []string) bool {
+	return slices.ContainsFunc(sourceFiles, isCppSource)
+}
 
-	objInfo, err := os.Stat(objectFile)
-	if os.IsNotExist(err) {
-		return true, "object file doesn't exist"
+// assemblerFor returns the assembler of sourceFile when it is not the
+// compiler driver, or ""
+func (b *Builder) assemblerFor(sourceFile string) string {
+	if filepath.Ext(sourceFile) != ".asm" {
+		return ""
 	}
+	return b.Config.Toolchain.Assembler
+}
 
-	sourceFile := dependencies[0] // first dependency is the source file
-	srcInfo, err := os.Stat(sourceFile)
-	if err != nil {
-		return true, "cannot stat source file"
+// compilerCommand returns the compiler driver to invoke, using the C++
+// driver when cpp is set
+func (b *Builder) compilerCommand(cpp bool) string {
+	if cpp {
+		return b.Compiler.GetCXXCompilerName()
 	}
+	return b.Compiler.GetPath()
+}
 
-	if srcInfo.ModTime().After(objInfo.ModTime()) {
-		return true, "source file modified"
+// compilerTool returns the tool of the toolchain of the compiler called
+// name, like gcc-ar or llvm-cov: the one named after the compiler, like
+// gcc-ar-13 for gcc-13 or llvm-cov-17 for clang-17, the one called name, or
+// fallback when neither is found. In a build container, tools are run by
+// name.
+func (b *Builder) compilerTool(name, fallback string) string {
+	if b.container != nil {
+		return name
 	}
 
-	for _, dep := range dependencies[1:] {
-		depInfo, err := os.Stat(dep)
-		if err != nil {
-			return true, "cannot stat dependency"
-		}
-
-		if depInfo.ModTime().After(objInfo.ModTime()) {
-			return true, fmt.Sprintf("dependency %s modified", filepath.Base(dep))
+	driver := "gcc"
+	if strings.Contains(b.Compiler.GetVersionInfo().Vendor, "clang") {
+		driver = "clang"
+	}
+	dir, base := filepath.Split(b.Compiler.GetPath())
+	var candidates []string
+	if i := strings.LastIndex(base, driver); i >= 0 {
+		named := base[:i] + name + base[i+len(driver):]
+		candidates = append(candidates, filepath.Join(dir, named), named)
+	}
+	for _, candidate := range append(candidates, name) {
+		if path, err := exec.LookPath(candidate); err == nil {
+			return path
 		}
 	}
-
-	return false, "up to date"
+	return fallback
 }
 
-// scheduleLinkingTask schedules the final linking task for an executable
-func (b *Builder) scheduleLinkingTask(objectFiles []string, outputPath string) error {
-	linkFlags := b.getLinkingFlags() // get flags
-	outDir := filepath.Dir(outputPath)
-	if err := os.MkdirAll(outDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+// saveScanGraph saves the files the scanner read for the next invocation,
+// which reads again only those that changed
+func (b *Builder) saveScanGraph() {
+	if err := b.Scanner.Save(b.graphPath); err != nil {
+		b.logger.Warning("%v", err)
 	}
+}
 
-	compilerCmd := b.Compiler.GetName()
-	if b.HasCppFiles {
-		if strings.Contains(compilerCmd, "clang") {
-			compilerCmd = "clang++"
-		} else if strings.Contains(compilerCmd, "gcc") {
-			compilerCmd = "g++"
-		}
-	}
+// needsRebuild determines if a file needs to be rebuilt
+func (b *Builder) needsRebuild(objectFile string, dependencies []string, commandHash string) (bool, string) {
+	return b.Cache.NeedsRebuild(objectFile, dependencies, commandHash)
+}
 
-	task := &Task{
+// newLinkTask returns the task linking objectFiles, together with the
+// libraries of the dependencies, into the executable at outputPath, with
+// the C++ driver when cpp is set
+func (b *Builder) newLinkTask(objectFiles []string, outputPath string, extraFlags []string, cpp bool) *Task {
+	linkFlags := append(b.getLinkingFlags(cpp), extraFlags...)
+	inputs := append(append([]string{}, objectFiles...), b.dependencyLibraries()...)
+	return &Task{
 		ID:         "link",
-		Command:    compilerCmd,
-		Args:       append(append(objectFiles, "-o", outputPath), linkFlags...),
-		Dir:        "",
-		Env:        nil,
-		Output:     nil,
+		Command:    b.compilerCommand(cpp),
+		Args:       append(append(inputs, "-o", outputPath), linkFlags...),
 		OutputFile: outputPath,
 	}
-
-	b.logger.StartProgress(1, "linking executable")
-
-	b.Executor.Submit(task)
-	result := b.Executor.WaitForTask(task)
-
-	b.logger.StopProgress()
-
-	if result == nil || !result.Success {
-		if result != nil {
-			b.logger.Error("linking failed: %v", result.Error)
-			return fmt.Errorf("linking failed: %v", result.Error)
-		}
-		b.logger.Error("linking failed: unknown error")
-		return fmt.Errorf("linking failed: unknown error")
-	}
-
-	b.logger.Success("linking complete")
-	return nil
 }
 
 // scheduleArchiveTask schedules the creation of a static library
@@ -605,20 +860,46 @@ func (b *Builder) scheduleArchiveTask(objectFiles []string, outputPath string) e
 
 	b.logger.StartProgress(1, "creating static library")
 
-	if err := b.Compiler.Archive(objectFiles, outputPath, archiverFlags); err != nil {
+	// the archiver runs outside the executor, on the thread of the build
+	task := &Task{ID: outputPath, Command: b.archiverCommand(), OutputFile: outputPath, Worker: -1, StartTime: time.Now()}
+	err := b.archive(objectFiles, outputPath, archiverFlags)
+	task.EndTime = time.Now()
+	task.Error = err
+	b.Executor.trace(task)
+	if err != nil {
 		b.logger.StopProgress()
 		b.logger.Error("archiving failed: %v", err)
 		return fmt.Errorf("archiving failed: %w", err)
 	}
+	b.recordStep(StepArchive, outputPath, &Result{Task: task, Success: true, Duration: task.EndTime.Sub(task.StartTime)})
 
 	b.logger.StopProgress()
 	b.logger.Success("static library created")
 	return nil
 }
 
-// scheduleSharedLibTask schedules the creation of a shared library
-func (b *Builder) scheduleSharedLibTask(objectFiles []string, outputPath string) error {
-	linkFlags := append(b.getLinkingFlags(), "-shared")
+// archive creates a static library with the archiver of the compiler, or
+// with the one in the build container if there is one, or the one for
+// link-time optimization
+func (b *Builder) archive(objectFiles []string, outputPath string, archiverFlags []string) error {
+	archiver := b.archiverCommand()
+	if b.container == nil && archiver == "ar" {
+		return b.Compiler.Archive(objectFiles, outputPath, archiverFlags)
+	}
+
+	args := append(append(append([]string{}, archiverFlags...), "rcs", outputPath), objectFiles...)
+	output, err := b.command(archiver, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// newSharedLibTask returns the task linking objectFiles, together with the
+// libraries of the dependencies, into the shared library at outputPath,
+// with the C++ driver when cpp is set
+func (b *Builder) newSharedLibTask(objectFiles []string, outputPath string, extraFlags []string, cpp bool) *Task {
+	linkFlags := append(append(b.getLinkingFlags(cpp), extraFlags...), "-shared")
 
 	switch b.platformInfo.Platform {
 	case platform.PlatformLinux:
@@ -630,73 +911,41 @@ func (b *Builder) scheduleSharedLibTask(objectFiles []string, outputPath string)
 		// nothing as of rn.
 	}
 
-	outDir := filepath.Dir(outputPath)
-	if err := os.MkdirAll(outDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
-	}
-
-	compilerCmd := b.Compiler.GetName()
-	if b.HasCppFiles {
-		if strings.Contains(compilerCmd, "clang") {
-			compilerCmd = "clang++"
-		} else if strings.Contains(compilerCmd, "gcc") {
-			compilerCmd = "g++"
-		}
-	}
-
-	task := &Task{
+	inputs := append(append([]string{}, objectFiles...), b.dependencyLibraries()...)
+	return &Task{
 		ID:         "shared_lib",
-		Command:    compilerCmd,
-		Args:       append(append(objectFiles, "-o", outputPath), linkFlags...),
-		Dir:        "",
-		Env:        nil,
-		Output:     nil,
+		Command:    b.compilerCommand(cpp),
+		Args:       append(append(inputs, "-o", outputPath), linkFlags...),
 		OutputFile: outputPath,
 	}
-
-	b.logger.StartProgress(1, "Creating shared library")
-
-	// submit and wait
-	b.Executor.Submit(task)
-	result := b.Executor.WaitForTask(task)
-
-	b.logger.StopProgress()
-
-	if result == nil || !result.Success {
-		if result != nil {
-			b.logger.Error("shared library creation failed: %v", result.Error)
-			return fmt.Errorf("shared library creation failed: %v", result.Error)
-		}
-		b.logger.Error("shared library creation failed: unknown error")
-		return fmt.Errorf("shared library creation failed: unknown error")
-	}
-
-	b.logger.Success("Shared library created")
-	return nil
 }
 
-// parseCompilerOutput parses compiler error output for better formatting
-func (b *Builder) parseCompilerOutput(output, sourceFile string) {
+// parseCompilerOutput parses compiler error output for better formatting,
+// keeping the diagnostics for the record of the build, and returns them
+func (b *Builder) parseCompilerOutput(output, sourceFile string) []logger.BuilderEvent {
 	parser := compiler.NewErrorParser(b.logger)
-	parser.Report(output, sourceFile)
+	events := parser.Parse(output, sourceFile)
+	for _, event := range events {
+		b.logger.ReportBuildEvent(event)
+		b.diagnostics = append(b.diagnostics, event)
+	}
+	return events
 }
 
 // getCompilationFlags gets the compilation flags for the current target
 func (b *Builder) getCompilationFlags() []string {
-	var flags []string
+	flags := b.warningFlags()
 
-	if b.Config.Project.Language == "c" {
-		flags = append(flags, b.Config.Toolchain.CFlags...)
-	} else if b.Config.Project.Language == "c++" {
-		flags = append(flags, b.Config.Toolchain.CXXFlags...)
-	}
+	language := b.Config.Project.Language
+	toolchainFlags, targetFlags := b.languageFlagLists(language)
+	flags = append(flags, toolchainFlags...)
+	flags = append(flags, b.objcFlags(language)...)
 
-	// c/c++ std
+	// c/c++ std, spelled the way the compiler version knows it
 	if b.Config.Project.Standard != "" {
-		if b.Config.Project.Language == "c" {
-			flags = append(flags, "-std="+b.Config.Project.Standard)
-		} else if b.Config.Project.Language == "c++" {
-			flags = append(flags, "-std="+b.Config.Project.Standard)
+		standard, _ := b.Compiler.GetVersionInfo().StandardName(b.Config.Project.Standard)
+		if base := baseLanguage(language); base == "c" || base == "c++" {
+			flags = append(flags, "-std="+standard)
 		}
 	}
 
@@ -704,24 +953,29 @@ func (b *Builder) getCompilationFlags() []string {
 	for _, dir := range b.Config.Build.IncludeDirs {
 		flags = append(flags, "-I"+dir)
 	}
-
-	if target, ok := b.Config.Targets[b.Target]; ok {
-		if b.Config.Project.Language == "c" {
-			flags = append(flags, target.CFlags...)
-		} else if b.Config.Project.Language == "c++" {
-			flags = append(flags, target.CXXFlags...)
-		}
-	}
-
-	return flags
+	flags = append(flags, b.dependencyIncludeFlags()...)
+	flags = append(flags, b.checkFlags()...)
+	flags = append(flags, b.deadCodeCompileFlags()...)
+	flags = append(flags, b.sanitizerFlags()...)
+	flags = append(flags, b.ltoFlags()...)
+	flags = append(flags, b.coverageFlags()...)
+	flags = append(flags, targetFlags...)
+
+	return b.mergeCompileFlags(flags)
 }
 
-// getLinkingFlags gets the linking flags for the current target
-func (b *Builder) getLinkingFlags() []string {
+// getLinkingFlags gets the linking flags for the current target, of C++
+// objects when cpp is set
+func (b *Builder) getLinkingFlags(cpp bool) []string {
 	var flags []string
 
 	// global flags
 	flags = append(flags, b.Config.Toolchain.LinkerFlags...)
+	flags = append(flags, b.dependencyLinkFlags()...)
+	flags = append(flags, b.deadCodeLinkFlags()...)
+	flags = append(flags, b.sanitizerFlags()...)
+	flags = append(flags, b.ltoLinkFlags()...)
+	flags = append(flags, b.coverageFlags()...)
 
 	// target flags
 	if target, ok := b.Config.Targets[b.Target]; ok {
@@ -729,11 +983,16 @@ func (b *Builder) getLinkingFlags() []string {
 	}
 
 	// Add C++ standard library if needed
-	if b.HasCppFiles {
+	if cpp {
 		flags = append(flags, "-lstdc++")
 	}
+	// and the runtime of Objective-C
+	if b.hasObjCFiles {
+		flags = append(flags, "-lobjc")
+	}
+	flags = append(flags, b.cudaLinkingFlags()...)
 
-	return flags
+	return append(flags, b.libraryFlags()...)
 }
 
 // getArchiverFlags gets the archiver flags
@@ -741,26 +1000,18 @@ func (b *Builder) getArchiverFlags() []string {
 	return b.Config.Toolchain.ArchiverFlags
 }
 
-// getObjectFilePath calculates the path for an object file
-func (b *Builder) getObjectFilePath(sourceFile, outputDir string) string {
-	baseName := filepath.Base(sourceFile)
-	ext := filepath.Ext(baseName)
-	baseName = baseName[:len(baseName)-len(ext)]
-
-	relPath, err := filepath.Rel(".", filepath.Dir(sourceFile))
-	if err != nil {
-		// flat directory as fallback
-		return filepath.Join(outputDir, baseName+b.Compiler.GetObjectExtension())
-	}
-
-	return filepath.Join(outputDir, relPath, baseName+b.Compiler.GetObjectExtension())
-}
-
 // getOutputPath calculates the path for the final output
 func (b *Builder) getOutputPath(outputDir string) string {
-	outputName := b.Config.Build.OutputName
+	if b.hasArtifacts() {
+		return b.primaryArtifactPath(outputDir)
+	}
+	name := b.outputFileName(b.Config.Build.OutputName, b.Config.Project.Name)
+	return b.outputPath(outputDir, name, b.Config.Build.OutputType)
+}
 
-	switch b.Config.Build.OutputType {
+// outputPath calculates the path of an output with the given name and type
+func (b *Builder) outputPath(outputDir, outputName, outputType string) string {
+	switch outputType {
 	case "executable":
 		return filepath.Join(outputDir, outputName+b.Compiler.GetExecutableExtension())
 	case "static_lib":
@@ -772,34 +1023,77 @@ func (b *Builder) getOutputPath(outputDir string) string {
 	}
 }
 
-// Clean removes build artifacts
-func (b *Builder) Clean() error {
-	if b.Target == "" {
-		b.logger.Info("cleaning all build artifacts")
+// CleanOptions selects what Clean removes. By default, the outputs of the
+// current target are removed and forgotten by the build cache; dependency
+// builds and downloads in the state directory are always kept.
+type CleanOptions struct {
+	AllTargets    bool // clean every target instead of the current one
+	DryRun        bool // only report what would be removed
+	CacheOnly     bool // keep the outputs
+	ArtifactsOnly bool // keep the build cache
+}
+
+// Clean removes build artifacts and the cache entries of the outputs they
+// held. Cleaning all targets also removes the results of the feature checks
+// and the scratch files of the state directory.
+func (b *Builder) Clean(opts CleanOptions) error {
+	if opts.CacheOnly && opts.ArtifactsOnly {
+		return fmt.Errorf("cache-only and artifacts-only cleaning cannot be combined")
+	}
+
+	// the outputs are where the last build put them
+	targetOutputDir := b.targetOutputDir()
+	if layout := b.recordedLayout(); layout != "" && layout != b.OutputLayout {
+		b.logger.Info("outputs were built with the %s output layout", layout)
+		targetOutputDir = b.layoutDir(layout)
+	}
+	if opts.AllTargets {
+		targetOutputDir = b.OutputDir
+		b.logger.Info("cleaning all targets")
 	} else {
-		b.logger.Info("cleaning build artifacts for target: %s", b.Target)
+		b.logger.Info("cleaning target: %s", b.Target)
 	}
 
-	targetOutputDir := filepath.Join(b.OutputDir, b.Target)
-	if err := os.RemoveAll(targetOutputDir); err != nil {
-		b.logger.Error("failed to clean target directory: %v", err)
-		return fmt.Errorf("failed to clean target directory: %w", err)
+	var paths, entries []string
+	if !opts.CacheOnly {
+		paths = append(paths, targetOutputDir)
+	}
+	if !opts.ArtifactsOnly {
+		entries = b.Cache.EntriesUnder(targetOutputDir)
+		if opts.AllTargets {
+			stateDir := b.Config.StateDir()
+			paths = append(paths, filepath.Join(stateDir, "cache", "probes.json"), filepath.Join(stateDir, "tmp"))
+		}
 	}
 
-	// also clean cache directory
-	cacheDir := ".styx"
-	if _, err := os.Stat(cacheDir); err == nil {
-		b.logger.Info("removing cache: %s", cacheDir)
-		if err := os.RemoveAll(cacheDir); err != nil {
-			b.logger.Warning("failed to remove cache: %v", err)
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if opts.DryRun {
+			b.logger.Info("would remove %s", path)
+			continue
+		}
+		b.logger.Info("removing %s", path)
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
 		}
 	}
 
-	b.Cache.Clean()
-	if err := b.Cache.Save(); err != nil {
-		b.logger.Warning("failed to save cleaned cache: %v", err)
+	if opts.DryRun {
+		b.logger.Info("would forget %d build cache entries", len(entries))
+		return nil
+	}
+	if len(entries) == 0 {
+		return nil
 	}
 
-	b.logger.Success("clean completed successfully")
+	for _, entry := range entries {
+		b.Cache.RemoveEntry(entry)
+	}
+	b.logger.Info("forgot %d build cache entries", len(entries))
+	if err := b.Cache.Save(); err != nil {
+		return fmt.Errorf("failed to save cleaned cache: %w", err)
+	}
 	return nil
 }
@@ -8,20 +8,45 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/fsmeta"
+	"github.com/deviceix/styx/internal/platform"
 )
 
 // CacheEntry represents a cached build artifact
 type CacheEntry struct {
-	Path            string        `json:"path"`
-	Hash            string        `json:"hash"`
-	Timestamp       int64         `json:"timestamp"`
-	Dependencies    []string      `json:"dependencies"`
-	CommandHash     string        `json:"command_hash"`
-	ObjectFile      string        `json:"object_file"`
-	CompilationTime time.Duration `json:"compilation_time"`
+	Path            string               `json:"path"`
+	Hash            string               `json:"hash"`
+	Timestamp       int64                `json:"timestamp"`
+	Dependencies    []string             `json:"dependencies"`
+	CommandHash     string               `json:"command_hash"`
+	ObjectFile      string               `json:"object_file"`
+	CompilationTime time.Duration        `json:"compilation_time"`
+	Output          FileStamp            `json:"output"`
+	Inputs          map[string]FileStamp `json:"inputs"`
+	RecordedAt      int64                `json:"recorded_at"`
+}
+
+// FileStamp records the state of a file when an entry was cached; ModTime
+// is in nanoseconds
+type FileStamp struct {
+	ModTime int64  `json:"mtime"`
+	Size    int64  `json:"size"`
+	Hash    string `json:"hash"`
 }
 
+// cacheVersion is the version of the cache format; caches written by other
+// versions are discarded
+const cacheVersion = "1.1"
+
+// timestampSlack is how far apart timestamps may be without being treated
+// as different moments, covering coarse filesystem resolution
+const timestampSlack = 2 * time.Second
+
 // BuildCache represents the cache of build artifacts
 type BuildCache struct {
 	Version       string                 `json:"version"`
@@ -29,11 +54,25 @@ type BuildCache struct {
 	LastBuildTime time.Time              `json:"last_build_time"`
 }
 
-// Cache provides methods to manage the build cache
+// Cache provides methods to manage the build cache. Dir is the directory
+// the paths given to the cache are relative to, as seen from the directory
+// the cached paths are relative to; it is set when projects of a workspace
+// share a cache, so their paths do not collide. Strategy is the rebuild
+// strategy of [cache], hybrid when empty. Shard names the shard of a
+// sharded build, whose cache is saved apart for an unsharded build to merge.
+// FS remembers the metadata and hashes of the files checked, and is shared
+// with the scanner of the builder.
 type Cache struct {
 	Path          string
 	BuildCache    *BuildCache
 	HashAlgorithm string
+	Dir           string
+	Strategy      string
+	Shard         string
+	FS            *fsmeta.FS
+
+	skewed map[string]time.Duration
+	merged []string // the caches of shards merged by Load
 }
 
 // NewCache creates a new Cache instance
@@ -41,6 +80,7 @@ func NewCache(cachePath string) *Cache {
 	return &Cache{
 		Path:          cachePath,
 		HashAlgorithm: "sha256",
+		FS:            fsmeta.New(),
 	}
 }
 
@@ -56,11 +96,11 @@ func (c *Cache) Load() error {
 		// new cache
 		if os.IsNotExist(err) {
 			c.BuildCache = &BuildCache{
-				Version:       "1.0",
+				Version:       cacheVersion,
 				Entries:       make(map[string]*CacheEntry),
 				LastBuildTime: time.Now(),
 			}
-			return nil
+			return c.mergeShards()
 		}
 		return fmt.Errorf("failed to read cache file: %w", err)
 	}
@@ -71,6 +111,42 @@ func (c *Cache) Load() error {
 		return fmt.Errorf("failed to parse cache file: %w", err)
 	}
 
+	if c.BuildCache.Version != cacheVersion || c.BuildCache.Entries == nil {
+		c.BuildCache.Version = cacheVersion
+		c.BuildCache.Entries = make(map[string]*CacheEntry)
+	}
+
+	return c.mergeShards()
+}
+
+// shardsDir returns the directory holding the caches of sharded builds
+func (c *Cache) shardsDir() string {
+	return filepath.Join(filepath.Dir(c.Path), "shards")
+}
+
+// mergeShards adds the entries the caches of sharded builds recorded last
+func (c *Cache) mergeShards() error {
+	paths, _ := filepath.Glob(filepath.Join(c.shardsDir(), "*.json"))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read cache file: %w", err)
+		}
+		var shard BuildCache
+		if err := json.Unmarshal(data, &shard); err != nil {
+			return fmt.Errorf("failed to parse cache file %s: %w", path, err)
+		}
+		if shard.Version != cacheVersion {
+			continue
+		}
+
+		for key, entry := range shard.Entries {
+			if existing, ok := c.BuildCache.Entries[key]; !ok || entry.RecordedAt > existing.RecordedAt {
+				c.BuildCache.Entries[key] = entry
+			}
+		}
+		c.merged = append(c.merged, path)
+	}
 	return nil
 }
 
@@ -88,10 +164,25 @@ func (c *Cache) Save() error {
 		return fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
-	if err := os.WriteFile(c.Path, data, 0644); err != nil {
+	if c.Shard != "" {
+		if err := os.MkdirAll(c.shardsDir(), 0755); err != nil {
+			return fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		if err := platform.WriteFileAtomic(filepath.Join(c.shardsDir(), c.Shard+".json"), data, 0644); err != nil {
+			return fmt.Errorf("failed to write cache file: %w", err)
+		}
+		return nil
+	}
+
+	if err := platform.WriteFileAtomic(c.Path, data, 0644); err != nil {
 		return fmt.Errorf("failed to write cache file: %w", err)
 	}
 
+	// the entries of the shards are in the cache now
+	for _, path := range c.merged {
+		_ = os.Remove(path)
+	}
+	c.merged = nil
 	return nil
 }
 
@@ -101,7 +192,7 @@ func (c *Cache) GetEntry(path string) (*CacheEntry, bool) {
 		return nil, false
 	}
 
-	entry, exists := c.BuildCache.Entries[path]
+	entry, exists := c.BuildCache.Entries[c.key(path)]
 	return entry, exists
 }
 
@@ -109,7 +200,7 @@ func (c *Cache) GetEntry(path string) (*CacheEntry, bool) {
 func (c *Cache) PutEntry(entry *CacheEntry) {
 	if c.BuildCache == nil {
 		c.BuildCache = &BuildCache{
-			Version:       "1.0",
+			Version:       cacheVersion,
 			Entries:       make(map[string]*CacheEntry),
 			LastBuildTime: time.Now(),
 		}
@@ -124,10 +215,30 @@ func (c *Cache) RemoveEntry(path string) {
 		return
 	}
 
-	delete(c.BuildCache.Entries, path)
+	delete(c.BuildCache.Entries, c.key(path))
+}
+
+// key returns the path an entry or stamp of path is recorded under
+func (c *Cache) key(path string) string {
+	if c.Dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.Dir, path)
+}
+
+// localPath turns a recorded path back into one relative to Dir
+func (c *Cache) localPath(key string) string {
+	if c.Dir == "" || filepath.IsAbs(key) {
+		return key
+	}
+	if rel, err := filepath.Rel(c.Dir, key); err == nil {
+		return rel
+	}
+	return key
 }
 
-// CalculateFileHash computes a hash of the file content
+// CalculateFileHash computes a hash of the file content. Files of at least
+// mappedHashSize are mapped into memory rather than read.
 func (c *Cache) CalculateFileHash(path string) (string, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -139,6 +250,15 @@ func (c *Cache) CalculateFileHash(path string) (string, error) {
 		}
 	}(file)
 
+	if info, err := file.Stat(); err == nil && info.Size() >= mappedHashSize {
+		data, unmap, err := platform.MapFile(path)
+		if err == nil {
+			sum := sha256.Sum256(data)
+			_ = unmap()
+			return hex.EncodeToString(sum[:]), nil
+		}
+	}
+
 	hasher := sha256.New()
 	if _, err := io.Copy(hasher, file); err != nil {
 		return "", fmt.Errorf("failed to hash file: %w", err)
@@ -159,98 +279,319 @@ func (c *Cache) CalculateCommandHash(command string, args []string) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
-// NeedsRebuild checks if a file needs to be rebuilt
-func (c *Cache) NeedsRebuild(path string, dependencies []string, commandHash string) (bool, error) {
-	// Get cache entry
+// NeedsRebuild checks if a file needs to be rebuilt and returns why.
+// With the hybrid strategy, timestamps only decide whether a file has to be
+// hashed: a file whose modification time and size match those recorded is
+// unchanged, any other file is unchanged only if its content hash still
+// matches. Timestamps are never compared with each other, so coarse
+// resolution and clocks skewed between machines cause neither missed nor
+// spurious rebuilds. The hash strategy compares the content of every file,
+// catching changes that keep the timestamp and size, such as files copied
+// or extracted with their original times; the timestamp strategy compares
+// modification times and sizes only.
+func (c *Cache) NeedsRebuild(path string, dependencies []string, commandHash string) (bool, string) {
 	entry, exists := c.GetEntry(path)
 	if !exists {
-		return true, nil
+		return true, "not in cache"
 	}
 
-	// Check if file exists
-	fileInfo, err := os.Stat(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return true, nil
+	if commandHash != entry.CommandHash {
+		return true, "command changed"
+	}
+
+	// outputs are stamped after being written, so their stamps are never racy
+	if changed, err := c.changedSince(path, entry.Output, false); err != nil {
+		return true, "output missing"
+	} else if changed {
+		return true, "output modified"
+	}
+
+	if len(dependencies) != len(entry.Inputs) {
+		return true, "dependencies changed"
+	}
+
+	for _, dep := range dependencies {
+		stamp, ok := entry.Inputs[c.key(dep)]
+		if !ok {
+			return true, "dependencies changed"
+		}
+
+		racy := stamp.ModTime >= entry.RecordedAt-int64(timestampSlack)
+		changed, err := c.changedSince(dep, stamp, racy)
+		if err != nil {
+			return true, fmt.Sprintf("cannot read %s", filepath.Base(dep))
 		}
-		return true, fmt.Errorf("failed to stat file: %w", err)
+		if changed {
+			return true, fmt.Sprintf("%s modified", filepath.Base(dep))
+		}
+	}
+
+	return false, "up to date"
+}
+
+// changedSince reports whether the file at path differs from its stamp.
+// Racy stamps, of files modified shortly before they were recorded, are
+// always checked by hash: a later change within the resolution of the
+// filesystem timestamps would leave the modification time unchanged.
+func (c *Cache) changedSince(path string, stamp FileStamp, racy bool) (bool, error) {
+	info, err := c.FS.Stat(path)
+	if err != nil {
+		return true, err
 	}
+	c.checkSkew(path, info.ModTime())
 
-	// time-based check
-	if fileInfo.ModTime().Unix() != entry.Timestamp {
-		return true, nil
+	if !c.comparesByHash(info, stamp, racy) {
+		return !sameStamp(info, stamp), nil
 	}
 
-	// file hash
-	currentHash, err := c.CalculateFileHash(path)
+	current, err := c.fileStamp(path, info)
 	if err != nil {
-		return true, fmt.Errorf("failed to calculate hash: %w", err)
+		return true, err
 	}
+	return current.Hash != stamp.Hash, nil
+}
 
-	if currentHash != entry.Hash {
-		return true, nil
+// comparesByHash reports whether telling if a file, with info, differs from
+// stamp takes hashing it, or only its modification time and size
+func (c *Cache) comparesByHash(info os.FileInfo, stamp FileStamp, racy bool) bool {
+	switch c.Strategy {
+	case "timestamp":
+		return false
+	case "hash":
+		return true
+	default:
+		return racy || !sameStamp(info, stamp)
 	}
+}
 
-	if commandHash != entry.CommandHash {
-		return true, nil
+// sameStamp reports whether a file, with info, has the modification time and
+// size of stamp
+func sameStamp(info os.FileInfo, stamp FileStamp) bool {
+	return info.ModTime().UnixNano() == stamp.ModTime && info.Size() == stamp.Size
+}
+
+// fileStamp returns the stamp of a file, hashing it unless it was already
+// hashed in this build and has not been modified since
+func (c *Cache) fileStamp(path string, info os.FileInfo) (FileStamp, error) {
+	stamp := FileStamp{ModTime: info.ModTime().UnixNano(), Size: info.Size()}
+	if c.Strategy == "timestamp" {
+		return stamp, nil
 	}
 
-	// last fallback; note: use something else because this is slow
-	for _, depPath := range dependencies {
-		depInfo, err := os.Stat(depPath)
-		if err != nil {
-			return true, nil
-		}
+	hash, err := c.FS.Hash(path, info, c.CalculateFileHash)
+	if err != nil {
+		return FileStamp{}, err
+	}
 
-		rebuild, err := c.NeedsRebuild(depPath, []string{}, "")
-		if err != nil || rebuild {
-			return true, err
-		}
+	stamp.Hash = hash
+	return stamp, nil
+}
 
-		// if this dep is newer than the target
-		if depInfo.ModTime().Unix() > entry.Timestamp {
-			return true, nil
+// checkSkew records files modified in the future, which means the clock of
+// the machine writing them is ahead of ours
+func (c *Cache) checkSkew(path string, modTime time.Time) {
+	if skew := time.Until(modTime); skew > timestampSlack {
+		if c.skewed == nil {
+			c.skewed = make(map[string]time.Duration)
 		}
+		c.skewed[path] = skew
 	}
+}
 
-	return false, nil
+// ClockSkew returns the files found with modification times in the future,
+// with how far ahead they are, and forgets them
+func (c *Cache) ClockSkew() map[string]time.Duration {
+	skewed := c.skewed
+	c.skewed = nil
+	return skewed
 }
 
 // UpdateEntry updates a cache entry after a successful build
 func (c *Cache) UpdateEntry(path string, dependencies []string, commandHash string, objectFile string, compilationTime time.Duration) error {
-	fileInfo, err := os.Stat(path)
+	recordedAt := time.Now().UnixNano()
+	// the output was just written, so whatever was known of it is stale
+	c.FS.Forget(path)
+	fileInfo, err := c.FS.Stat(path)
 	if err != nil {
 		return fmt.Errorf("failed to stat file: %w", err)
 	}
 
-	hash, err := c.CalculateFileHash(path)
+	output, err := c.fileStamp(path, fileInfo)
 	if err != nil {
 		return fmt.Errorf("failed to calculate hash: %w", err)
 	}
 
+	inputs := make(map[string]FileStamp, len(dependencies))
+	for _, dep := range dependencies {
+		info, err := c.FS.Stat(dep)
+		if err != nil {
+			return fmt.Errorf("failed to stat dependency: %w", err)
+		}
+
+		stamp, err := c.fileStamp(dep, info)
+		if err != nil {
+			return fmt.Errorf("failed to calculate hash: %w", err)
+		}
+		inputs[c.key(dep)] = stamp
+	}
+
 	entry := &CacheEntry{
-		Path:            path,
-		Hash:            hash,
+		Path:            c.key(path),
+		Hash:            output.Hash,
 		Timestamp:       fileInfo.ModTime().Unix(),
 		Dependencies:    dependencies,
 		CommandHash:     commandHash,
 		ObjectFile:      objectFile,
 		CompilationTime: compilationTime,
+		Output:          output,
+		Inputs:          inputs,
+		RecordedAt:      recordedAt,
 	}
 
 	c.PutEntry(entry)
 	return nil
 }
 
+// EntriesUnder returns the paths of the entries of the files inside dir
+func (c *Cache) EntriesUnder(dir string) []string {
+	if c.BuildCache == nil {
+		return nil
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+
+	var paths []string
+	for key := range c.BuildCache.Entries {
+		path := c.localPath(key)
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(absDir, absPath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
 // Clean removes entries for files that no longer exist
 func (c *Cache) Clean() {
 	if c.BuildCache == nil {
 		return
 	}
 
-	for path := range c.BuildCache.Entries {
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			c.RemoveEntry(path)
+	for key := range c.BuildCache.Entries {
+		if _, err := os.Stat(c.localPath(key)); os.IsNotExist(err) {
+			delete(c.BuildCache.Entries, key)
+		}
+	}
+}
+
+// CacheDir returns the directory holding the build cache, the scanned
+// dependencies and the probe results of the project configured by cfg
+func CacheDir(cfg *config.Config) string {
+	return filepath.Join(cfg.StateDir(), "cache")
+}
+
+// ClearCache deletes the build cache, the scanned dependencies and the
+// probe results of the project configured by cfg, so that the next build
+// compiles everything again
+func ClearCache(cfg *config.Config) error {
+	if err := os.RemoveAll(CacheDir(cfg)); err != nil {
+		return fmt.Errorf("failed to remove cache directory: %w", err)
+	}
+	return nil
+}
+
+// CacheStats describe the entries of a build cache
+type CacheStats struct {
+	Entries  int       // files with an entry
+	Missing  int       // entries of files that no longer exist
+	Size     int64     // of the files with an entry, when they were recorded
+	FileSize int64     // of the cache file itself
+	Oldest   time.Time // when the oldest entry was recorded
+	Newest   time.Time // when the newest entry was recorded
+}
+
+// Stats describes the entries of the cache
+func (c *Cache) Stats() CacheStats {
+	var stats CacheStats
+	if info, err := os.Stat(c.Path); err == nil {
+		stats.FileSize = info.Size()
+	}
+	if c.BuildCache == nil {
+		return stats
+	}
+
+	for key, entry := range c.BuildCache.Entries {
+		stats.Entries++
+		stats.Size += entry.Output.Size
+		if _, err := os.Stat(c.localPath(key)); os.IsNotExist(err) {
+			stats.Missing++
+		}
+
+		recorded := time.Unix(0, entry.RecordedAt)
+		if stats.Oldest.IsZero() || recorded.Before(stats.Oldest) {
+			stats.Oldest = recorded
+		}
+		if recorded.After(stats.Newest) {
+			stats.Newest = recorded
+		}
+	}
+	return stats
+}
+
+// Prune removes the entries of files that no longer exist and those
+// recorded before before, unless it is zero. With a maxSize, it then
+// removes the oldest entries until the cache file would be at most maxSize
+// bytes. It returns how many entries it removed.
+func (c *Cache) Prune(before time.Time, maxSize int64) (int, error) {
+	if c.BuildCache == nil {
+		return 0, nil
+	}
+	entries := c.BuildCache.Entries
+	count := len(entries)
+
+	c.Clean()
+	if !before.IsZero() {
+		for key, entry := range entries {
+			if entry.RecordedAt < before.UnixNano() {
+				delete(entries, key)
+			}
+		}
+	}
+
+	if maxSize > 0 {
+		data, err := json.MarshalIndent(c.BuildCache, "", "  ")
+		if err != nil {
+			return 0, fmt.Errorf("failed to serialize cache: %w", err)
+		}
+		size := int64(len(data))
+
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return entries[keys[i]].RecordedAt < entries[keys[j]].RecordedAt
+		})
+		for _, key := range keys {
+			if size <= maxSize {
+				break
+			}
+			// the entry, its quoted key and the indentation and separators
+			// around them
+			data, err := json.MarshalIndent(entries[key], "    ", "  ")
+			if err != nil {
+				return 0, fmt.Errorf("failed to serialize cache: %w", err)
+			}
+			size -= int64(len(data) + len(key) + 10)
+			delete(entries, key)
 		}
 	}
+	return count - len(entries), nil
 }
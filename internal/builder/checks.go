@@ -0,0 +1,341 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// checkSizes are the candidate results of a size check, in bytes
+var checkSizes = []int{1, 2, 4, 8, 16}
+
+// Define is a preprocessor definition produced by a feature check; an
+// empty value means the check failed and the macro stays undefined
+type Define struct {
+	Name  string
+	Value string
+}
+
+// featureCheck is a single configured check and the macro it controls
+type featureCheck struct {
+	description string
+	define      string
+	run         func() (string, error)
+}
+
+// runChecks runs the configured feature checks, remembers their defines
+// for compilation and writes the config header
+func (b *Builder) runChecks() error {
+	checks := b.Config.Checks
+	if len(checks.Headers)+len(checks.Functions)+len(checks.Symbols)+len(checks.Sizes)+len(checks.TryCompile) == 0 {
+		return nil
+	}
+
+	b.logger.Info("running feature checks...")
+	b.checkDefines = nil
+	if err := b.loadProbes(); err != nil {
+		return err
+	}
+
+	compileFlags := b.probeFlags(false, nil)
+	linkFlags := b.probeFlags(true, nil)
+
+	// headers come first so that size checks can include the ones available
+	headerDefines, err := b.runFeatureChecks(b.headerChecks(compileFlags))
+	if err != nil {
+		return err
+	}
+
+	var available []string
+	for i, header := range checks.Headers {
+		if headerDefines[i].Value != "" {
+			available = append(available, header)
+		}
+	}
+
+	var others []featureCheck
+	others = append(others, b.functionChecks(linkFlags)...)
+	others = append(others, b.symbolChecks(linkFlags)...)
+	others = append(others, b.sizeChecks(available, compileFlags)...)
+	others = append(others, b.tryCompileChecks()...)
+	defines, err := b.runFeatureChecks(others)
+	if err != nil {
+		return err
+	}
+
+	b.checkDefines = append(headerDefines, defines...)
+	if err := b.probes.save(); err != nil {
+		b.logger.Warning("%v", err)
+	}
+
+	if err := b.writeConfigHeader(); err != nil {
+		return err
+	}
+
+	passed := 0
+	for _, define := range b.checkDefines {
+		if define.Value != "" {
+			passed++
+		}
+	}
+	b.logger.Success("feature checks complete: %d of %d passed", passed, len(b.checkDefines))
+	return nil
+}
+
+// runFeatureChecks runs checks in parallel and returns their defines in order
+func (b *Builder) runFeatureChecks(checks []featureCheck) ([]Define, error) {
+	defines := make([]Define, len(checks))
+	errs := make([]error, len(checks))
+	slots := make(chan struct{}, runtime.NumCPU())
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check featureCheck) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			value, err := check.run()
+			defines[i] = Define{Name: check.define, Value: value}
+			errs[i] = err
+		}(i, check)
+	}
+	wg.Wait()
+
+	for i, check := range checks {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("check for %s failed: %w", check.description, errs[i])
+		}
+
+		if b.Verbose {
+			result := defines[i].Value
+			switch result {
+			case "":
+				result = "no"
+			case "1":
+				result = "yes"
+			}
+			b.logger.Note("checking for %s... %s", check.description, result)
+		}
+	}
+
+	return defines, nil
+}
+
+// headerChecks checks that each header can be included
+func (b *Builder) headerChecks(flags []string) []featureCheck {
+	var checks []featureCheck
+	for _, header := range b.Config.Checks.Headers {
+		source := fmt.Sprintf("#include <%s>\nint main(void) { return 0; }\n", header)
+		checks = append(checks, featureCheck{
+			description: header,
+			define:      defineName("HAVE_", header),
+			run:         b.probeRunner(source, false, flags),
+		})
+	}
+	return checks
+}
+
+// functionChecks checks that each function can be linked, declaring it
+// with a dummy prototype the way autoconf does
+func (b *Builder) functionChecks(flags []string) []featureCheck {
+	var checks []featureCheck
+	for _, function := range b.Config.Checks.Functions {
+		source := fmt.Sprintf(`#ifdef __cplusplus
+extern "C"
+#endif
+char %[1]s(void);
+int main(void) { return %[1]s(); }
+`, function)
+		checks = append(checks, featureCheck{
+			description: function,
+			define:      defineName("HAVE_", function),
+			run:         b.probeRunner(source, true, flags),
+		})
+	}
+	return checks
+}
+
+// symbolChecks checks that each symbol is declared by its headers, either
+// as a macro or as a function or variable that can be linked
+func (b *Builder) symbolChecks(flags []string) []featureCheck {
+	var checks []featureCheck
+	for _, symbol := range b.Config.Checks.Symbols {
+		var source strings.Builder
+		for _, header := range symbol.Headers {
+			fmt.Fprintf(&source, "#include <%s>\n", header)
+		}
+		fmt.Fprintf(&source, `int main(int argc, char **argv) {
+	(void)argv;
+#ifndef %[1]s
+	return ((int *)(&%[1]s))[argc];
+#else
+	(void)argc;
+	return 0;
+#endif
+}
+`, symbol.Name)
+
+		checks = append(checks, featureCheck{
+			description: symbol.Name,
+			define:      defineName("HAVE_", symbol.Name),
+			run:         b.probeRunner(source.String(), true, flags),
+		})
+	}
+	return checks
+}
+
+// sizeChecks determine the size of each type by compiling a static
+// assertion for every candidate size, which also works when cross compiling
+func (b *Builder) sizeChecks(headers, flags []string) []featureCheck {
+	var prelude strings.Builder
+	prelude.WriteString("#include <stddef.h>\n#include <stdint.h>\n")
+	for _, header := range headers {
+		fmt.Fprintf(&prelude, "#include <%s>\n", header)
+	}
+
+	var checks []featureCheck
+	for _, typeName := range b.Config.Checks.Sizes {
+		checks = append(checks, featureCheck{
+			description: "size of " + typeName,
+			define:      defineName("SIZEOF_", typeName),
+			run: func() (string, error) {
+				for _, size := range checkSizes {
+					source := fmt.Sprintf("%sstatic char styx_check[sizeof(%s) == %d ? 1 : -1];\nint main(void) { return styx_check[0]; }\n",
+						prelude.String(), typeName, size)
+					ok, err := b.tryCompile(source, b.checksUseCpp(), false, flags)
+					if err != nil || ok {
+						return strconv.Itoa(size), err
+					}
+				}
+				return "", nil
+			},
+		})
+	}
+	return checks
+}
+
+// tryCompileChecks runs the snippets configured as try_compile, each with
+// its own extra flags
+func (b *Builder) tryCompileChecks() []featureCheck {
+	var checks []featureCheck
+	for _, probe := range b.Config.Checks.TryCompile {
+		checks = append(checks, featureCheck{
+			description: probe.Define,
+			define:      probe.Define,
+			run: func() (string, error) {
+				source, language := probe.Source, ""
+				if probe.File != "" {
+					data, err := os.ReadFile(probe.File)
+					if err != nil {
+						return "", fmt.Errorf("failed to read %s: %w", probe.File, err)
+					}
+					source, language = string(data), languageOf(probe.File)
+				}
+
+				ok, err := b.tryCompile(source, b.probeUsesCpp(language), probe.Link, b.probeFlags(probe.Link, probe.Flags))
+				if err != nil || !ok {
+					return "", err
+				}
+				return "1", nil
+			},
+		})
+	}
+	return checks
+}
+
+// probeRunner returns a check running source as a probe, defining its
+// macro to 1 when the probe succeeds
+func (b *Builder) probeRunner(source string, link bool, flags []string) func() (string, error) {
+	return func() (string, error) {
+		ok, err := b.tryCompile(source, b.checksUseCpp(), link, flags)
+		if err != nil || !ok {
+			return "", err
+		}
+		return "1", nil
+	}
+}
+
+// checksUseCpp reports whether checks are compiled as C++
+func (b *Builder) checksUseCpp() bool {
+	return b.probeUsesCpp("")
+}
+
+// checkFlags returns the flags exposing the results of the feature checks
+func (b *Builder) checkFlags() []string {
+	if b.checkDefines == nil {
+		return nil
+	}
+
+	flags := []string{"-I" + b.generatedIncludeDir()}
+	for _, define := range b.checkDefines {
+		if define.Value != "" {
+			flags = append(flags, "-D"+define.Name+"="+define.Value)
+		}
+	}
+	return flags
+}
+
+// generatedIncludeDir returns the directory holding generated headers
+func (b *Builder) generatedIncludeDir() string {
+	return filepath.Join(b.targetOutputDir(), "include")
+}
+
+// writeConfigHeader writes the check results as a C header, leaving the
+// file untouched when nothing changed so dependent objects stay up to date
+func (b *Builder) writeConfigHeader() error {
+	name := b.Config.Checks.ConfigHeader
+	path := filepath.Join(b.generatedIncludeDir(), name)
+
+	var content bytes.Buffer
+	guard := defineName("", name)
+	fmt.Fprintf(&content, "/* generated by styx from the [checks] section; do not edit */\n")
+	fmt.Fprintf(&content, "#ifndef %s\n#define %s\n\n", guard, guard)
+	for _, define := range b.checkDefines {
+		if define.Value == "" {
+			fmt.Fprintf(&content, "/* #undef %s */\n", define.Name)
+		} else {
+			fmt.Fprintf(&content, "#define %s %s\n", define.Name, define.Value)
+		}
+	}
+	fmt.Fprintf(&content, "\n#endif\n")
+
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, content.Bytes()) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create generated include directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, content.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	b.Cache.FS.Forget(path)
+
+	return nil
+}
+
+// defineName turns a header, function or type name into a macro name, e.g.
+// sys/mman.h into HAVE_SYS_MMAN_H and void * into SIZEOF_VOID_P
+func defineName(prefix, name string) string {
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	for _, r := range strings.ToUpper(strings.TrimSpace(name)) {
+		switch {
+		case r == '*':
+			sb.WriteRune('P')
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
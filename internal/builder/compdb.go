@@ -0,0 +1,77 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// CompileCommand is an entry of a JSON compilation database
+type CompileCommand struct {
+	Directory string   `json:"directory"`
+	File      string   `json:"file"`
+	Arguments []string `json:"arguments"`
+	Output    string   `json:"output"`
+}
+
+// recordCompileCommand adds the invocation of a compile task to the
+// compilation database, whether or not the task ends up running
+func (b *Builder) recordCompileCommand(task *Task) {
+	b.compileCommands = append(b.compileCommands, CompileCommand{
+		File:      task.SourceFile,
+		Arguments: append([]string{task.Command}, task.Args...),
+		Output:    task.OutputFile,
+	})
+}
+
+// writeCompileCommands writes the recorded invocations to
+// compile_commands.json in dir and returns its path
+func (b *Builder) writeCompileCommands(dir string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	commands := make([]CompileCommand, len(b.compileCommands))
+	for i, command := range b.compileCommands {
+		command.Directory = wd
+		commands[i] = command
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].File < commands[j].File
+	})
+
+	data, err := json.MarshalIndent(commands, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode compilation database: %w", err)
+	}
+
+	path := filepath.Join(dir, "compile_commands.json")
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("failed to write compilation database: %w", err)
+	}
+
+	return path, nil
+}
+
+// GenerateCompileCommands writes compile_commands.json for the current
+// target without compiling anything and returns its path
+func (b *Builder) GenerateCompileCommands() (string, error) {
+	b.compileCommands = nil
+	if _, err := b.Plan(); err != nil {
+		return "", err
+	}
+	return b.writeCompileCommands(b.OutputDir)
+}
+
+// compileCommandsDir returns the directory builds write the compilation
+// database to: the output directory, or that of the target when built
+// together with others
+func (b *Builder) compileCommandsDir() string {
+	if b.targetLock != nil {
+		return b.targetOutputDir()
+	}
+	return b.OutputDir
+}
@@ -0,0 +1,253 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/deviceix/styx/internal/compiler"
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// container runs build tasks inside a Docker or Podman image. While a build
+// is running, one container is kept alive and tasks are started in it with
+// exec; outside of builds every command gets a container of its own.
+// External containers, like dev containers, are managed by another tool and
+// only ever exec'd into, with the first mount found at remoteRoot.
+type container struct {
+	runtime    string
+	image      string
+	env        map[string]string
+	mounts     []string
+	id         string
+	user       string
+	remoteRoot string
+	external   bool
+}
+
+// newContainer prepares the container of an environment, picking docker or
+// podman when the environment does not name a runtime. The workspace and
+// stateDir are mounted at their own paths, so paths in commands stay valid.
+func newContainer(env *config.EnvironmentConfig, stateDir string) (*container, error) {
+	runtime := env.ContainerRuntime
+	if runtime == "" {
+		for _, candidate := range []string{"docker", "podman"} {
+			if _, err := exec.LookPath(candidate); err == nil {
+				runtime = candidate
+				break
+			}
+		}
+		if runtime == "" {
+			return nil, errors.New("no container runtime found (install docker or podman)")
+		}
+	} else if _, err := exec.LookPath(runtime); err != nil {
+		return nil, fmt.Errorf("container runtime not found: %s", runtime)
+	}
+
+	workspace, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &container{
+		runtime: runtime,
+		image:   env.Container,
+		env:     env.Env,
+		mounts:  []string{workspace},
+	}
+	if err := c.mount(stateDir); err != nil {
+		return nil, fmt.Errorf("failed to mount %s: %w", stateDir, err)
+	}
+	return c, nil
+}
+
+// mount makes dir available in the container at the same path, unless it
+// already is through one of the other mounts
+func (c *container) mount(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for _, mount := range c.mounts {
+		if abs == mount || strings.HasPrefix(abs, mount+string(filepath.Separator)) {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return err
+	}
+	c.mounts = append(c.mounts, abs)
+	return nil
+}
+
+// start launches the container tasks are executed in until stop is called
+func (c *container) start() error {
+	if c.id != "" {
+		return nil
+	}
+
+	args := append([]string{"run", "-d", "--rm", "--entrypoint", "sleep"}, c.runOptions()...)
+	output, err := exec.Command(c.runtime, append(args, c.image, "infinity")...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("failed to start container %s: %s", c.image, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return fmt.Errorf("failed to start container %s: %w", c.image, err)
+	}
+
+	c.id = strings.TrimSpace(string(output))
+	return nil
+}
+
+// stop removes the running container
+func (c *container) stop() {
+	if c.id == "" || c.external {
+		return
+	}
+	_ = exec.Command(c.runtime, "rm", "-f", c.id).Run()
+	c.id = ""
+}
+
+// runOptions returns the options every container is created with: the
+// mounts, the environment and, so outputs are not owned by root, the user
+func (c *container) runOptions() []string {
+	var options []string
+	for _, mount := range c.mounts {
+		options = append(options, "-v", mount+":"+mount)
+	}
+	for _, key := range sortedKeys(c.env) {
+		options = append(options, "-e", key+"="+c.env[key])
+	}
+
+	if uid := os.Getuid(); uid >= 0 {
+		if c.runtime == "podman" {
+			options = append(options, "--userns=keep-id")
+		} else {
+			options = append(options, "--user", fmt.Sprintf("%d:%d", uid, os.Getgid()))
+		}
+	}
+	return options
+}
+
+// command returns the command running name in the container with dir as
+// working directory, or in the current directory when dir is empty
+func (c *container) command(ctx context.Context, dir string, env map[string]string, name string, args ...string) *exec.Cmd {
+	workdir := c.mounts[0]
+	if abs, err := filepath.Abs(dir); err == nil {
+		workdir = c.remotePath(abs)
+	}
+
+	options := []string{"-w", workdir}
+	if c.user != "" {
+		options = append(options, "-u", c.user)
+	}
+	for _, key := range sortedKeys(env) {
+		options = append(options, "-e", key+"="+env[key])
+	}
+
+	var runtimeArgs []string
+	if c.id != "" {
+		runtimeArgs = append(append([]string{"exec"}, options...), c.id, name)
+	} else {
+		runtimeArgs = append([]string{"run", "--rm", "--entrypoint", name}, c.runOptions()...)
+		runtimeArgs = append(append(runtimeArgs, options...), c.image)
+	}
+
+	return exec.CommandContext(ctx, c.runtime, append(runtimeArgs, args...)...)
+}
+
+// remotePath maps a path of the host to the path it is mounted at
+func (c *container) remotePath(path string) string {
+	if c.remoteRoot == "" {
+		return path
+	}
+	rel, err := filepath.Rel(c.mounts[0], path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.ToSlash(filepath.Join(c.remoteRoot, rel))
+}
+
+// detectCompiler finds the configured compiler in the image, preferring
+// clang over gcc like the detection on the host does
+func (c *container) detectCompiler(name string) (compiler.Compiler, error) {
+	candidates := []string{"clang", "gcc"}
+	if name != "" && name != "auto" {
+		candidates = []string{strings.ToLower(name)}
+	}
+
+	for _, candidate := range candidates {
+		output, err := c.command(context.Background(), "", nil, candidate, "--version").Output()
+		if err != nil {
+			continue
+		}
+
+		version := strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0])
+		info := compiler.ParseVersion(string(output))
+		if info.Target == "" {
+			if machine, err := c.command(context.Background(), "", nil, candidate, "-dumpmachine").Output(); err == nil {
+				info.Target = strings.TrimSpace(string(machine))
+			}
+		}
+
+		if comp, err := compiler.New(candidate, version, info, platform.PlatformLinux); err == nil {
+			return comp, nil
+		}
+	}
+
+	if name == "" || name == "auto" {
+		return nil, fmt.Errorf("%w in container %s", compiler.ErrCompilerNotFound, c.image)
+	}
+	return nil, fmt.Errorf("%w in container %s: %s", compiler.ErrCompilerNotFound, c.image, name)
+}
+
+// sortedKeys returns the keys of an environment map in sorted order
+func sortedKeys(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// startContainer starts the container of the active environment, if it has
+// one, for the duration of a build
+func (b *Builder) startContainer() error {
+	if b.container == nil {
+		return nil
+	}
+
+	if err := b.container.mount(b.OutputDir); err != nil {
+		return fmt.Errorf("failed to mount %s: %w", b.OutputDir, err)
+	}
+
+	if !b.container.external {
+		b.logger.Info("starting container: %s", b.container.image)
+	}
+	return b.container.start()
+}
+
+// stopContainer removes the container started by startContainer
+func (b *Builder) stopContainer() {
+	if b.container != nil {
+		b.container.stop()
+	}
+}
+
+// command returns the command running name with args, inside the container
+// of the active environment if it has one
+func (b *Builder) command(name string, args ...string) *exec.Cmd {
+	if b.container != nil {
+		return b.container.command(context.Background(), "", nil, name, args...)
+	}
+	return exec.Command(name, args...)
+}
@@ -0,0 +1,326 @@
+package builder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/deviceix/styx/internal/coverage"
+	"github.com/deviceix/styx/internal/dependency"
+)
+
+// gcovBatchSize is the number of objects gcov is run on at once
+const gcovBatchSize = 200
+
+// maxCoverageCulprits is the number of files below min_coverage named when
+// the coverage is too low
+const maxCoverageCulprits = 10
+
+// ErrCoverageTooLow is returned by Coverage when the tests run less of the
+// project than [test] min_coverage asks for
+var ErrCoverageTooLow = errors.New("coverage too low")
+
+// coverageFlags returns the flags instrumenting the build for coverage, with
+// gcov under GCC or source-based coverage under Clang; the compiler and the
+// linker both need them
+func (b *Builder) coverageFlags() []string {
+	if !b.coverage {
+		return nil
+	}
+	if b.clangCoverage() {
+		return []string{"-fprofile-instr-generate", "-fcoverage-mapping"}
+	}
+	return []string{"--coverage"}
+}
+
+// clangCoverage reports whether coverage is measured the way of Clang,
+// rather than with gcov
+func (b *Builder) clangCoverage() bool {
+	return strings.Contains(b.Compiler.GetVersionInfo().Vendor, "clang")
+}
+
+// Coverage builds the tests selected by names, all of them when names is
+// empty, instrumented for coverage into the coverage directory of the output
+// directory, runs them and writes the coverage of the sources of the project
+// there, as an HTML page or, with format lcov, an lcov tracefile. It returns
+// the results of the tests and the path of the report, which covers the
+// tests that failed too.
+func (b *Builder) Coverage(names []string, format string) ([]TestResult, string, error) {
+	if format != "html" && format != "lcov" {
+		return nil, "", fmt.Errorf("invalid coverage format: %s (must be html or lcov)", format)
+	}
+	switch b.Compiler.GetVersionInfo().Vendor {
+	case "gcc", "clang", "apple-clang":
+	default:
+		return nil, "", fmt.Errorf("coverage needs GCC or Clang, not %s", b.Compiler.GetName())
+	}
+
+	// instrumented objects are kept apart from the others, so switching
+	// between both rebuilds nothing
+	root := b.OutputDir
+	b.OutputDir = filepath.Join(root, "coverage")
+	defer func() { b.OutputDir = root }()
+	b.coverage = true
+	defer func() { b.coverage = false }()
+
+	if err := b.clearCoverage(); err != nil {
+		return nil, "", err
+	}
+
+	if err := b.startContainer(); err != nil {
+		return nil, "", err
+	}
+	defer b.stopContainer()
+
+	if !b.sharedExecutor {
+		b.Executor.Start()
+		defer b.Executor.Shutdown()
+	}
+
+	tests, err := b.buildTests(names)
+	if err != nil {
+		return nil, "", err
+	}
+	results := b.runTests(tests)
+
+	b.logger.Info("collecting coverage...")
+	report, err := b.collectCoverage(tests)
+	if err != nil {
+		return results, "", fmt.Errorf("failed to collect coverage: %w", err)
+	}
+	b.keepProjectCoverage(report, root)
+
+	path := filepath.Join(b.OutputDir, "index.html")
+	if format == "lcov" {
+		path = filepath.Join(b.OutputDir, "coverage.info")
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return results, "", fmt.Errorf("failed to create coverage report: %w", err)
+	}
+	defer file.Close()
+
+	if format == "lcov" {
+		err = report.WriteLcov(file)
+	} else {
+		err = coverage.WriteHTML(file, report, fmt.Sprintf("%s (%s)", b.Config.Project.Name, b.Target))
+	}
+	if err != nil {
+		return results, "", fmt.Errorf("failed to write coverage report: %w", err)
+	}
+
+	hit, found := report.Covered()
+	b.logger.Info("line coverage: %.1f%% (%d of %d lines in %d files)", coverage.Percent(hit, found), hit, found, len(report.Files))
+	if hit, found := report.FunctionsCovered(); found > 0 {
+		b.logger.Info("function coverage: %.1f%% (%d of %d functions)", coverage.Percent(hit, found), hit, found)
+	}
+	return results, path, b.checkMinCoverage(report)
+}
+
+// checkMinCoverage fails with ErrCoverageTooLow when the line or function
+// coverage of report is below [test] min_coverage, and names the files
+// below it that leave the most lines uncovered
+func (b *Builder) checkMinCoverage(report *coverage.Report) error {
+	minimum := b.Config.Test.MinCoverage
+	if minimum == 0 {
+		return nil
+	}
+
+	var below []string
+	if hit, found := report.Covered(); coverage.Percent(hit, found) < minimum {
+		below = append(below, fmt.Sprintf("line coverage %.1f%%", coverage.Percent(hit, found)))
+	}
+	if hit, found := report.FunctionsCovered(); coverage.Percent(hit, found) < minimum {
+		below = append(below, fmt.Sprintf("function coverage %.1f%%", coverage.Percent(hit, found)))
+	}
+	if len(below) == 0 {
+		return nil
+	}
+
+	var files []*coverage.File
+	for _, path := range report.Paths() {
+		f := report.Files[path]
+		if hit, found := f.Covered(); coverage.Percent(hit, found) < minimum {
+			files = append(files, f)
+		}
+	}
+	uncovered := func(f *coverage.File) int {
+		hit, found := f.Covered()
+		return found - hit
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return uncovered(files[i]) > uncovered(files[j])
+	})
+	for _, f := range files[:min(len(files), maxCoverageCulprits)] {
+		hit, found := f.Covered()
+		b.logger.Warning("%s: %.1f%% of lines covered, %d lines not run", f.Path, coverage.Percent(hit, found), found-hit)
+	}
+	if len(files) > maxCoverageCulprits {
+		b.logger.Warning("and %d more files below %g%%", len(files)-maxCoverageCulprits, minimum)
+	}
+
+	return fmt.Errorf("%w: %s below min_coverage %g%%", ErrCoverageTooLow, strings.Join(below, " and "), minimum)
+}
+
+// profileDir returns the directory the tests write their raw profiles to
+// under Clang
+func (b *Builder) profileDir() string {
+	return filepath.Join(b.OutputDir, "profiles")
+}
+
+// testEnv returns the environment variables a test runs with: with Clang
+// coverage, where to write its profile, one per process
+func (b *Builder) testEnv(test testBinary) map[string]string {
+	if !b.coverage || !b.clangCoverage() {
+		return nil
+	}
+	dir, err := filepath.Abs(b.profileDir())
+	if err != nil {
+		dir = b.profileDir()
+	}
+	return map[string]string{"LLVM_PROFILE_FILE": filepath.Join(dir, test.name+"-%p.profraw")}
+}
+
+// clearCoverage removes the coverage data of the previous run, which the
+// tests would add to otherwise
+func (b *Builder) clearCoverage() error {
+	if err := os.RemoveAll(b.profileDir()); err != nil {
+		return fmt.Errorf("failed to remove profiles: %w", err)
+	}
+	return filepath.WalkDir(b.OutputDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".gcda" {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove coverage data: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// collectCoverage reads the coverage of the test run: with gcov from the
+// notes GCC wrote next to every object, covering the objects no test ran as
+// well, or with llvm-cov from the profiles the tests wrote and the mappings
+// in the test executables
+func (b *Builder) collectCoverage(tests []testBinary) (*coverage.Report, error) {
+	report := coverage.NewReport()
+	if b.clangCoverage() {
+		return report, b.collectClangCoverage(report, tests)
+	}
+
+	var notes []string
+	err := filepath.WalkDir(b.OutputDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && filepath.Ext(path) == ".gcno" {
+			notes = append(notes, path)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	gcov := b.compilerTool("gcov", "gcov")
+	for len(notes) > 0 {
+		// gcov reads the notes in batches, so long lists of objects fit
+		// on a command line
+		n := min(len(notes), gcovBatchSize)
+		output, err := b.coverageTool(gcov, append([]string{"--json-format", "--stdout"}, notes[:n]...)...)
+		if err != nil {
+			return nil, err
+		}
+		if err := report.AddGcov(bytes.NewReader(output)); err != nil {
+			return nil, err
+		}
+		notes = notes[n:]
+	}
+	return report, nil
+}
+
+// collectClangCoverage merges the profiles of the tests with llvm-profdata
+// and adds their coverage, exported by llvm-cov, to report
+func (b *Builder) collectClangCoverage(report *coverage.Report, tests []testBinary) error {
+	profiles, err := filepath.Glob(filepath.Join(b.profileDir(), "*.profraw"))
+	if err != nil || len(profiles) == 0 {
+		return fmt.Errorf("the tests wrote no profile")
+	}
+
+	merged := filepath.Join(b.OutputDir, "coverage.profdata")
+	args := append([]string{"merge", "-sparse", "-o", merged}, profiles...)
+	if _, err := b.coverageTool(b.compilerTool("llvm-profdata", "llvm-profdata"), args...); err != nil {
+		return err
+	}
+
+	args = []string{"export", "-format=lcov", "-instr-profile=" + merged}
+	for i, test := range tests {
+		if i > 0 {
+			args = append(args, "-object")
+		}
+		args = append(args, test.output)
+	}
+	output, err := b.coverageTool(b.compilerTool("llvm-cov", "llvm-cov"), args...)
+	if err != nil {
+		return err
+	}
+	return report.AddLcov(bytes.NewReader(output))
+}
+
+// coverageTool runs a coverage tool and returns what it wrote to its
+// standard output
+func (b *Builder) coverageTool(name string, args ...string) ([]byte, error) {
+	cmd := b.command(name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", filepath.Base(name), err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// keepProjectCoverage keeps the coverage of the sources and headers of the
+// project, with their paths relative to it, leaving out the system and
+// dependency headers, the files generated into the output directory root
+// and the tests themselves
+func (b *Builder) keepProjectCoverage(report *coverage.Report, root string) {
+	wd, _ := os.Getwd()
+	tests, _ := dependency.FindSourceFiles(b.Config.Test.Sources, nil)
+	support, _ := dependency.FindSourceFiles(b.Config.Test.Support, nil)
+	excluded := make(map[string]bool)
+	for _, path := range append(tests, support...) {
+		excluded[filepath.Clean(path)] = true
+	}
+
+	relative := coverage.NewReport()
+	for path, file := range report.Files {
+		if filepath.IsAbs(path) && wd != "" {
+			if rel, err := filepath.Rel(wd, path); err == nil {
+				path = rel
+			}
+		}
+		path = filepath.Clean(path)
+		if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) || excluded[path] {
+			continue
+		}
+		if within(path, b.Config.StateDir()) || (filepath.Clean(root) != "." && within(path, root)) {
+			continue
+		}
+		file.Path = path
+		relative.Files[path] = file
+	}
+	report.Files = relative.Files
+}
+
+// within reports whether path is dir or inside it
+func within(path, dir string) bool {
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
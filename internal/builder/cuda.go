@@ -0,0 +1,185 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/deviceix/styx/internal/dependency"
+)
+
+// deviceLinkObject is the object the device code of a binary or library is
+// linked into, next to its other objects
+const deviceLinkObject = "device_link.o"
+
+// cudaCompiler returns the compiler of the CUDA sources
+func (b *Builder) cudaCompiler() string {
+	if b.Config.Toolchain.CUDACompiler != "" {
+		return b.Config.Toolchain.CUDACompiler
+	}
+	return "nvcc"
+}
+
+// cudaWithClang reports whether the CUDA sources are compiled by clang in
+// CUDA mode rather than by nvcc
+func (b *Builder) cudaWithClang() bool {
+	return strings.Contains(filepath.Base(b.cudaCompiler()), "clang")
+}
+
+// cudaFlags returns the flags CUDA sources are compiled with besides the
+// configured ones: the GPU architectures, and relocatable device code with
+// cuda_separable
+func (b *Builder) cudaFlags(language string) []string {
+	if language != "cuda" {
+		return nil
+	}
+
+	flags := b.cudaArchFlags()
+	if b.Config.Toolchain.CUDASeparable {
+		flags = append(flags, "-rdc=true")
+	}
+	return flags
+}
+
+// cudaArchFlags returns the flags generating device code for the GPU
+// architectures of cuda_archs
+func (b *Builder) cudaArchFlags() []string {
+	var flags []string
+	for _, arch := range b.Config.Toolchain.CUDAArchs {
+		if b.cudaWithClang() {
+			flags = append(flags, "--cuda-gpu-arch="+arch)
+			continue
+		}
+		// the PTX of the architecture and its machine code, sm_80 from compute_80
+		flags = append(flags, fmt.Sprintf("-gencode=arch=compute_%s,code=%s", strings.TrimPrefix(arch, "sm_"), arch))
+	}
+	return flags
+}
+
+// nvccHostFlag returns an option of the host compiler the way nvcc passes
+// it on: include directories, macros, the optimization and debug levels and
+// the standard as they are, and the others through -Xcompiler. Options
+// taking a separate value, or holding the commas -Xcompiler splits on, are
+// left out.
+func nvccHostFlag(f flag) []string {
+	switch {
+	case f.class != flagOther, f.key == "-O", f.key == "-g", f.key == "-std=":
+		return f.args
+	case len(f.args) > 1 || strings.Contains(f.args[0], ","):
+		return nil
+	}
+	return []string{"-Xcompiler=" + f.args[0]}
+}
+
+// checkCUDA makes sure the CUDA compiler is there, and can link relocatable
+// device code when cuda_separable asks for it
+func (b *Builder) checkCUDA() error {
+	if b.Config.Toolchain.CUDASeparable && b.cudaWithClang() {
+		return fmt.Errorf("cuda_separable needs nvcc to link device code")
+	}
+	// the compiler of the image is not on the host
+	if b.container != nil {
+		return nil
+	}
+	if _, err := exec.LookPath(b.cudaCompiler()); err != nil {
+		return fmt.Errorf("CUDA compiler %s not found: %w", b.cudaCompiler(), err)
+	}
+	return nil
+}
+
+// cudaLinkingFlags returns the flags linking the CUDA runtime, found in the
+// toolkit of nvcc, once CUDA sources were compiled
+func (b *Builder) cudaLinkingFlags() []string {
+	if !b.hasCUDAFiles {
+		return nil
+	}
+
+	var flags []string
+	if dir := b.cudaLibDir(); dir != "" {
+		flags = append(flags, "-L"+dir)
+	}
+	if b.Config.Toolchain.CUDASeparable {
+		flags = append(flags, "-lcudadevrt")
+	}
+	return append(flags, "-lcudart")
+}
+
+// cudaLibDir returns the library directory of the CUDA toolkit nvcc belongs
+// to, or "" when it is unknown, as it is for clang and in containers
+func (b *Builder) cudaLibDir() string {
+	if b.container != nil || b.cudaWithClang() {
+		return ""
+	}
+	path, err := exec.LookPath(b.cudaCompiler())
+	if err != nil {
+		return ""
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	root := filepath.Dir(filepath.Dir(path))
+	for _, name := range []string{"lib64", "lib"} {
+		if info, err := os.Stat(filepath.Join(root, name)); err == nil && info.IsDir() {
+			return filepath.Join(root, name)
+		}
+	}
+	return ""
+}
+
+// deviceLinkStep returns the step linking the relocatable device code of
+// the objects of compileSteps into an object in objectDir, which the
+// executable or shared library of outputType links along. There is none
+// without cuda_separable, CUDA sources, or for static libraries, whose
+// device code is linked by what links them.
+func (b *Builder) deviceLinkStep(compileSteps []*Step, objectDir, outputType string) (*Step, error) {
+	if !b.Config.Toolchain.CUDASeparable || outputType == "static_lib" {
+		return nil, nil
+	}
+
+	var inputs []string
+	var needs []*Step
+	for _, step := range compileSteps {
+		if languageOf(step.Task.SourceFile) == "cuda" {
+			inputs = append(inputs, step.Task.OutputFile)
+			needs = append(needs, step)
+		}
+	}
+	if len(inputs) == 0 {
+		return nil, nil
+	}
+
+	output := filepath.Join(objectDir, deviceLinkObject)
+	if _, exists := b.Graph.GetNode(output); !exists {
+		if err := b.Graph.AddNode(&dependency.Node{ID: output, Type: dependency.NodeTypeObject, Path: output}); err != nil {
+			return nil, fmt.Errorf("failed to add device link node: %w", err)
+		}
+	}
+	for _, input := range inputs {
+		if err := b.Graph.AddDependency(output, input); err != nil {
+			return nil, fmt.Errorf("failed to add dependency: %w", err)
+		}
+	}
+	args := append([]string{"-dlink"}, inputs...)
+	args = append(append(args, "-o", output), b.cudaArchFlags()...)
+	if outputType == "shared_lib" {
+		args = append(args, "-Xcompiler=-fPIC")
+	}
+
+	step := &Step{
+		Kind:   StepDeviceLink,
+		Task:   &Task{ID: output, Command: b.cudaCompiler(), Args: args, OutputFile: output},
+		Inputs: inputs,
+		Needs:  needs,
+		Reason: recreated,
+	}
+	for _, need := range needs {
+		if !need.UpToDate {
+			step.Reason = "inputs changed"
+			break
+		}
+	}
+	return step, nil
+}
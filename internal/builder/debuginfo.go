@@ -0,0 +1,70 @@
+package builder
+
+import (
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// debugInfoExtension is the extension of the files the debug information of
+// outputs is split into
+const debugInfoExtension = ".debug"
+
+// stripsOutputs reports whether the outputs of the current target are
+// stripped or have their debug information split off. Both need objcopy and
+// ELF outputs, so neither is done on macOS and Windows.
+func (b *Builder) stripsOutputs() bool {
+	target := b.Config.Targets[b.Target]
+	if !target.Strip && !target.SplitDebugInfo {
+		return false
+	}
+	return b.platformInfo.Platform != platform.PlatformMacOS && b.platformInfo.Platform != platform.PlatformWindows
+}
+
+// stripSteps returns the steps run on the executable or shared library at
+// outputPath once output linked it: copying its debug information to a
+// .debug file with split_debug_info, then stripping it in place, of every
+// symbol with strip or of its debug information alone otherwise, and
+// pointing it at the .debug file
+func (b *Builder) stripSteps(output *Step, outputType, outputPath string) []*Step {
+	if !b.stripsOutputs() || (outputType != "executable" && outputType != "shared_lib") {
+		return nil
+	}
+	target := b.Config.Targets[b.Target]
+	// the file of a versioned shared library, rather than its symlinks
+	if len(output.names) > 0 {
+		outputPath = output.names[0]
+	}
+
+	var steps []*Step
+	previous := output
+	add := func(id, outputFile string, args []string) {
+		step := &Step{
+			Kind:     StepStrip,
+			Task:     &Task{ID: id, Command: b.objcopyCommand(), Args: args, OutputFile: outputFile},
+			Inputs:   []string{outputPath},
+			Needs:    []*Step{previous},
+			Reason:   output.Reason,
+			artifact: output.artifact,
+		}
+		steps = append(steps, step)
+		previous = step
+	}
+
+	debugPath := outputPath + debugInfoExtension
+	if target.SplitDebugInfo {
+		add(debugPath, debugPath, []string{"--only-keep-debug", outputPath, debugPath})
+	}
+
+	// shared libraries keep the symbols programs link against
+	strip := "--strip-debug"
+	if target.Strip && outputType == "shared_lib" {
+		strip = "--strip-unneeded"
+	} else if target.Strip {
+		strip = "--strip-all"
+	}
+	args := []string{strip}
+	if target.SplitDebugInfo {
+		args = append(args, "--add-gnu-debuglink="+debugPath)
+	}
+	add("strip "+outputPath, outputPath, append(args, outputPath))
+	return steps
+}
@@ -0,0 +1,172 @@
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/dependency"
+	"github.com/deviceix/styx/internal/deps"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// resolveDependencies resolves the configured dependencies and registers
+// their outputs as library nodes in the dependency graph
+func (b *Builder) resolveDependencies() error {
+	// dependencies are resolved once per builder; repeated builds reuse them
+	if len(b.Config.Dependencies) == 0 || b.Packages != nil {
+		return nil
+	}
+
+	b.logger.Info("resolving %d dependencies...", len(b.Config.Dependencies))
+
+	// other projects of the workspace have been built already
+	var packages []*deps.Package
+	remaining := make(map[string]config.DependencyConfig)
+	for _, name := range sortedDependencyNames(b.Config.Dependencies) {
+		if pkg, ok := b.memberPackages[name]; ok {
+			// their headers are edited along with the sources using them
+			b.Scanner.AddIncludeDirs(pkg.IncludeDirs...)
+			packages = append(packages, pkg)
+			continue
+		}
+		remaining[name] = b.Config.Dependencies[name]
+	}
+
+	if len(remaining) > 0 {
+		manager := deps.NewProjectManager(b.Config, b.logger)
+		manager.Dependencies = remaining
+		resolved, err := manager.ResolveAll()
+		if err != nil {
+			return err
+		}
+		packages = append(packages, resolved...)
+	}
+
+	for _, pkg := range packages {
+		for _, output := range pkg.Outputs {
+			node := &dependency.Node{
+				ID:   output,
+				Type: dependency.NodeTypeLibrary,
+				Path: output,
+			}
+			if err := b.Graph.AddNode(node); err != nil {
+				return fmt.Errorf("failed to add output node for %s: %w", pkg.Name, err)
+			}
+		}
+	}
+
+	b.Packages = packages
+	b.logger.Success("dependencies resolved")
+	return nil
+}
+
+// sortedDependencyNames returns the names of dependencies in sorted order
+func sortedDependencyNames(dependencies map[string]config.DependencyConfig) []string {
+	names := make([]string, 0, len(dependencies))
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dependencyIncludeFlags returns the include flags contributed by dependencies
+func (b *Builder) dependencyIncludeFlags() []string {
+	var flags []string
+	for _, pkg := range b.Packages {
+		for _, dir := range pkg.IncludeDirs {
+			flags = append(flags, "-I"+dir)
+		}
+	}
+	return flags
+}
+
+// dependencyLibraries returns the library files contributed by dependencies
+func (b *Builder) dependencyLibraries() []string {
+	var libs []string
+	for _, pkg := range b.Packages {
+		libs = append(libs, pkg.Libraries...)
+	}
+	return libs
+}
+
+// dependencyFingerprint identifies the versions and patches of all
+// dependencies, so that changing one invalidates every object compiled against it
+func (b *Builder) dependencyFingerprint() []string {
+	var fingerprint []string
+	for _, pkg := range b.Packages {
+		entry := pkg.Name + "@" + pkg.Version
+		if pkg.PatchDigest != "" {
+			entry += "+" + pkg.PatchDigest
+		}
+		fingerprint = append(fingerprint, entry)
+	}
+	return fingerprint
+}
+
+// dependencyLinkFlags returns the runtime search path flags needed to load
+// shared libraries contributed by dependencies
+func (b *Builder) dependencyLinkFlags() []string {
+	return b.runtimePathFlags(b.dependencyLibraries())
+}
+
+// runtimePathFlags returns the runtime search path flags for the shared
+// libraries among libs
+func (b *Builder) runtimePathFlags(libs []string) []string {
+	if !platform.IsUnixLike(b.platformInfo.Platform) {
+		return nil
+	}
+
+	var flags []string
+	seen := make(map[string]bool)
+	for _, lib := range libs {
+		ext := filepath.Ext(lib)
+		if ext != ".so" && ext != ".dylib" {
+			continue
+		}
+
+		dir, err := filepath.Abs(filepath.Dir(lib))
+		if err != nil || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		flags = append(flags, "-Wl,-rpath,"+dir)
+	}
+
+	return flags
+}
+
+// addOutputNode registers an output in the dependency graph, linked to the
+// objects and dependency libraries it is produced from
+func (b *Builder) addOutputNode(outputPath, outputType string, objectFiles []string) error {
+	nodeType := dependency.NodeTypeLibrary
+	if outputType == "executable" {
+		nodeType = dependency.NodeTypeExecutable
+	}
+
+	node := &dependency.Node{
+		ID:   outputPath,
+		Type: nodeType,
+		Path: outputPath,
+	}
+	if _, exists := b.Graph.GetNode(outputPath); !exists {
+		if err := b.Graph.AddNode(node); err != nil {
+			return err
+		}
+	}
+
+	inputs := append([]string{}, objectFiles...)
+	if outputType != "static_lib" {
+		inputs = append(inputs, b.dependencyLibraries()...)
+	}
+
+	for _, input := range inputs {
+		if err := b.Graph.AddDependency(outputPath, input); err != nil {
+			return err
+		}
+	}
+
+	return b.Graph.MarkEntryPoint(outputPath)
+}
@@ -0,0 +1,36 @@
+package builder
+
+import "github.com/deviceix/styx/internal/config"
+
+// EffectiveConfig returns the configuration the current target is built
+// with: the sections of the platform, the compiler and the environment
+// merged in, the compiler found for the toolchain, the output names
+// expanded, and the current target and environment the only ones
+func (b *Builder) EffectiveConfig() *config.Config {
+	cfg := *b.Config
+	cfg.Toolchain.Compiler = b.Compiler.GetPath()
+	if cfg.Build.OutputName != "" {
+		cfg.Build.OutputName = b.outputFileName(cfg.Build.OutputName, cfg.Project.Name)
+	}
+
+	cfg.Targets = nil
+	if target, ok := b.Config.Targets[b.Target]; ok {
+		cfg.Targets = map[string]config.TargetConfig{b.Target: target}
+	}
+	cfg.Environment = nil
+	if env := b.Config.ActiveEnvironment(); env != nil {
+		cfg.Environment = map[string]config.EnvironmentConfig{b.Config.Env: *env}
+	}
+
+	expand := func(artifacts []config.ArtifactConfig) []config.ArtifactConfig {
+		expanded := make([]config.ArtifactConfig, len(artifacts))
+		for i, a := range artifacts {
+			expanded[i] = a
+			expanded[i].OutputName = b.outputFileName(a.OutputNameTemplate(), a.Name)
+		}
+		return expanded
+	}
+	cfg.Binaries = expand(cfg.Binaries)
+	cfg.Libraries = expand(cfg.Libraries)
+	return &cfg
+}
@@ -0,0 +1,318 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/deps"
+	"github.com/deviceix/styx/internal/logger"
+)
+
+// nixIgnoredVars are the variables of a development shell that nix develop
+// does not export either, since they only make sense inside the builder
+var nixIgnoredVars = map[string]bool{
+	"BASHOPTS": true, "HOME": true, "NIX_BUILD_TOP": true, "NIX_ENFORCE_PURITY": true,
+	"NIX_LOG_FD": true, "NIX_REMOTE": true, "OLDPWD": true, "PPID": true, "SHELL": true,
+	"SHELLOPTS": true, "SHLVL": true, "TEMP": true, "TEMPDIR": true, "TERM": true,
+	"TMP": true, "TMPDIR": true, "TZ": true, "UID": true,
+}
+
+// toolchainEnvironment prepares the environment the toolchain of cfg runs
+// in. It returns the container build tasks have to run in, if any, and a
+// digest of the environment that is part of every compile command hash, so
+// changing the environment recompiles what was built in the previous one.
+func toolchainEnvironment(cfg *config.Config) (*container, string, error) {
+	env := cfg.ActiveEnvironment()
+	switch {
+	case env == nil:
+		return nil, "", nil
+
+	case env.Container != "":
+		ctr, err := newContainer(env, cfg.StateDir())
+		return ctr, "container:" + env.Container, err
+
+	case env.Nix != "":
+		digest, err := nixDigest(env.Nix)
+		if err != nil {
+			return nil, "", err
+		}
+		// already inside a shell, e.g. started with nix develop -c styx
+		if os.Getenv("IN_NIX_SHELL") == "" {
+			if err := enterNixShell(env.Nix); err != nil {
+				return nil, "", err
+			}
+		}
+		return nil, "nix:" + digest, nil
+
+	case env.Devcontainer:
+		root, err := devcontainerRoot()
+		if err != nil {
+			return nil, "", err
+		}
+		digest, err := devcontainerDigest(root)
+		if err != nil {
+			return nil, "", err
+		}
+		if insideDevcontainer() {
+			return nil, "devcontainer:" + digest, nil
+		}
+		ctr, err := upDevcontainer(env, root)
+		return ctr, "devcontainer:" + digest, err
+	}
+
+	return nil, "", nil
+}
+
+// variablesDigest hashes the variables an environment sets for the tasks
+func variablesDigest(vars map[string]string) string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\n", name, vars[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// useToolchain installs the downloaded toolchain selected by cfg when it is
+// missing and puts its compilers first in PATH. The returned digest changes
+// whenever the pinned archive does.
+func useToolchain(cfg *config.Config, log *logger.Logger) (string, error) {
+	name := cfg.Toolchain.Use
+	manager := deps.NewProjectManager(cfg, log)
+	dir, sha, err := manager.InstallToolchain(name, cfg.Toolchains[name])
+	if err != nil {
+		return "", err
+	}
+
+	bin := filepath.Join(dir, "bin")
+	path := os.Getenv("PATH")
+	if !strings.HasPrefix(path, bin+string(os.PathListSeparator)) {
+		if err := os.Setenv("PATH", bin+string(os.PathListSeparator)+path); err != nil {
+			return "", err
+		}
+	}
+
+	return "toolchain:" + name + ":" + sha, nil
+}
+
+// nixArgs returns the arguments selecting the development shell of ref:
+// a flake reference, or a directory or file holding shell.nix
+func nixArgs(ref string) []string {
+	if info, err := os.Stat(ref); err == nil {
+		if !info.IsDir() {
+			return []string{"--file", ref}
+		}
+		if _, err := os.Stat(filepath.Join(ref, "flake.nix")); err != nil {
+			return []string{"--file", filepath.Join(ref, "shell.nix")}
+		}
+	}
+	return []string{ref}
+}
+
+// nixDigest hashes the files defining the development shell of ref. Remote
+// flakes are identified by their reference, which pins them through the
+// revision or lock of the referring flake.
+func nixDigest(ref string) (string, error) {
+	local := strings.TrimPrefix(strings.SplitN(ref, "#", 2)[0], "path:")
+	if local == "" {
+		local = "."
+	}
+
+	info, err := os.Stat(local)
+	if err != nil {
+		return ref, nil
+	}
+
+	files := []string{local}
+	if info.IsDir() {
+		files = nil
+		for _, name := range []string{"flake.nix", "flake.lock", "shell.nix", "default.nix"} {
+			files = append(files, filepath.Join(local, name))
+		}
+	}
+
+	return hashFiles(ref, files)
+}
+
+// enterNixShell evaluates the development shell of ref once and applies its
+// variables to the styx process, like running styx with nix develop -c does;
+// every command started afterwards, including compiler lookups, sees them
+func enterNixShell(ref string) error {
+	if _, err := exec.LookPath("nix"); err != nil {
+		return errors.New("nix not found (install nix or choose another environment)")
+	}
+
+	args := append([]string{"--extra-experimental-features", "nix-command flakes", "print-dev-env", "--json"}, nixArgs(ref)...)
+	cmd := exec.Command("nix", args...)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to evaluate nix shell %s: %w", ref, err)
+	}
+
+	var shell struct {
+		Variables map[string]struct {
+			Type  string          `json:"type"`
+			Value json.RawMessage `json:"value"`
+		} `json:"variables"`
+	}
+	if err := json.Unmarshal(output, &shell); err != nil {
+		return fmt.Errorf("failed to parse nix shell %s: %w", ref, err)
+	}
+
+	for name, variable := range shell.Variables {
+		if variable.Type != "exported" || nixIgnoredVars[name] {
+			continue
+		}
+
+		var value string
+		if err := json.Unmarshal(variable.Value, &value); err != nil {
+			continue
+		}
+		if name == "PATH" && os.Getenv("PATH") != "" {
+			value += string(os.PathListSeparator) + os.Getenv("PATH")
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+
+	return os.Setenv("IN_NIX_SHELL", "impure")
+}
+
+// devcontainerRoot finds the directory holding the dev container
+// configuration, starting from the working directory and going up
+func devcontainerRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		for _, name := range []string{filepath.Join(".devcontainer", "devcontainer.json"), ".devcontainer.json"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return dir, nil
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("no devcontainer.json found")
+		}
+		dir = parent
+	}
+}
+
+// devcontainerDigest hashes the dev container configuration under root,
+// including the Dockerfiles and scripts next to devcontainer.json
+func devcontainerDigest(root string) (string, error) {
+	files := []string{filepath.Join(root, ".devcontainer.json")}
+	err := filepath.Walk(filepath.Join(root, ".devcontainer"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hashFiles("", files)
+}
+
+// insideDevcontainer reports whether styx already runs in a dev container
+func insideDevcontainer() bool {
+	for _, name := range []string{"REMOTE_CONTAINERS", "CODESPACES", "DEVCONTAINER"} {
+		if os.Getenv(name) == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// upDevcontainer starts the dev container of the workspace at root with the
+// devcontainer CLI, or reuses it if it is running, and returns a container
+// build tasks are executed in
+func upDevcontainer(env *config.EnvironmentConfig, root string) (*container, error) {
+	if _, err := exec.LookPath("devcontainer"); err != nil {
+		return nil, errors.New("devcontainer CLI not found (install @devcontainers/cli)")
+	}
+
+	runtime := env.ContainerRuntime
+	if runtime == "" {
+		runtime = "docker"
+	}
+
+	cmd := exec.Command("devcontainer", "up", "--workspace-folder", root, "--docker-path", runtime)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start dev container: %w", err)
+	}
+
+	// the result is the last line; the ones before it are progress
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	var result struct {
+		Outcome               string `json:"outcome"`
+		Message               string `json:"message"`
+		ContainerID           string `json:"containerId"`
+		RemoteUser            string `json:"remoteUser"`
+		RemoteWorkspaceFolder string `json:"remoteWorkspaceFolder"`
+	}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse devcontainer output: %w", err)
+	}
+	if result.Outcome != "success" {
+		return nil, fmt.Errorf("failed to start dev container: %s", result.Message)
+	}
+
+	return &container{
+		runtime:    runtime,
+		image:      "devcontainer " + result.ContainerID,
+		env:        env.Env,
+		mounts:     []string{root},
+		id:         result.ContainerID,
+		user:       result.RemoteUser,
+		remoteRoot: result.RemoteWorkspaceFolder,
+		external:   true,
+	}, nil
+}
+
+// hashFiles computes a digest over the names and contents of the files that
+// exist among files, prefixed with label
+func hashFiles(label string, files []string) (string, error) {
+	hasher := sha256.New()
+	hasher.Write([]byte(label))
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		hasher.Write([]byte(filepath.Base(path)))
+		hasher.Write(data)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
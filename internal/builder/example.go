@@ -0,0 +1,165 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/deviceix/styx/internal/config"
+)
+
+// BuildExample builds the project, then the example called name against
+// its libraries, and returns the path of the example executable
+func (b *Builder) BuildExample(name string) (string, error) {
+	var example *config.ExampleConfig
+	for i := range b.Config.Examples {
+		if b.Config.Examples[i].Name == name {
+			example = &b.Config.Examples[i]
+		}
+	}
+	if example == nil {
+		return "", fmt.Errorf("example not found: %s", name)
+	}
+
+	if _, err := b.Build(); err != nil {
+		return "", err
+	}
+
+	if err := b.startContainer(); err != nil {
+		return "", err
+	}
+	defer b.stopContainer()
+
+	if !b.sharedExecutor {
+		b.renewExecutor()
+		b.Executor.Start()
+		defer b.Executor.Shutdown()
+	}
+
+	b.logger.Info("building example: %s", example.Name)
+	exampleDir := filepath.Join(b.targetOutputDir(), "examples", example.Name)
+	a, err := b.exampleArtifact(example, exampleDir)
+	if err != nil {
+		return "", err
+	}
+
+	sourceFiles, err := b.findSources(example.Sources, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to find source files: %w", err)
+	}
+	if len(sourceFiles) == 0 {
+		return "", fmt.Errorf("example %s: no source files found", example.Name)
+	}
+	if err := b.buildDependencyGraph(sourceFiles); err != nil {
+		return "", fmt.Errorf("failed to build dependency graph: %w", err)
+	}
+
+	objectFiles, err := b.scheduleCompilationTasks(sourceFiles, filepath.Join(exampleDir, "obj"), b.artifactCompilationFlags(a))
+	if err != nil {
+		return "", fmt.Errorf("failed to compile example %s: %w", example.Name, err)
+	}
+
+	libs := linkedLibraries(a)
+	if !b.hasArtifacts() {
+		libs = []string{b.GetOutputPath()}
+	}
+	cpp, err := b.exampleUsesCpp(a, sourceFiles)
+	if err != nil {
+		return "", err
+	}
+	link := &Step{
+		Kind:     StepLink,
+		Inputs:   append(append([]string{}, objectFiles...), libs...),
+		Reason:   recreated,
+		artifact: example.Name,
+	}
+	link.Task = b.newLinkTask(link.Inputs, a.output, b.artifactLinkFlags(a, libs), cpp)
+	link.Task.ID = "link-example-" + example.Name
+	if err := b.runStep(link); err != nil {
+		return "", stepError(link, err)
+	}
+
+	if err := b.Cache.Save(); err != nil {
+		b.logger.Warning("failed to save build cache: %v", err)
+	}
+	b.saveScanGraph()
+
+	return a.output, nil
+}
+
+// exampleUsesCpp reports whether an example, compiled from sourceFiles, or
+// the libraries it links have C++ sources
+func (b *Builder) exampleUsesCpp(a *artifact, sourceFiles []string) (bool, error) {
+	if hasCppSources(sourceFiles) {
+		return true, nil
+	}
+	if !b.hasArtifacts() {
+		projectSources, err := b.findSources(b.Config.Build.Sources, b.Config.Build.Exclude)
+		if err != nil {
+			return false, fmt.Errorf("failed to find source files: %w", err)
+		}
+		return hasCppSources(addGeneratedSources(projectSources, b.generated, "")), nil
+	}
+
+	visited := make(map[*artifact]bool)
+	var visit func(lib *artifact) (bool, error)
+	visit = func(lib *artifact) (bool, error) {
+		if visited[lib] {
+			return false, nil
+		}
+		visited[lib] = true
+		libSources, err := b.artifactSources(lib)
+		if err != nil || hasCppSources(addGeneratedSources(libSources, b.generated, lib.Name)) {
+			return err == nil, err
+		}
+		for _, dep := range lib.links {
+			if cpp, err := visit(dep); cpp || err != nil {
+				return cpp, err
+			}
+		}
+		return false, nil
+	}
+	for _, lib := range a.links {
+		if cpp, err := visit(lib); cpp || err != nil {
+			return cpp, err
+		}
+	}
+	return false, nil
+}
+
+// exampleArtifact returns an example as an executable linking the libraries
+// it names, or every library of the project, with its output in exampleDir
+func (b *Builder) exampleArtifact(example *config.ExampleConfig, exampleDir string) (*artifact, error) {
+	a := &artifact{
+		ArtifactConfig: config.ArtifactConfig{
+			Name:        example.Name,
+			Type:        "executable",
+			Sources:     example.Sources,
+			IncludeDirs: example.IncludeDirs,
+			Links:       example.Links,
+			LinkerFlags: example.LinkerFlags,
+		},
+		output: filepath.Join(exampleDir, example.Name+b.Compiler.GetExecutableExtension()),
+	}
+	if err := os.MkdirAll(exampleDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create example output directory: %w", err)
+	}
+	if !b.hasArtifacts() {
+		return a, nil
+	}
+
+	all, err := b.artifacts(b.targetOutputDir())
+	if err != nil {
+		return nil, err
+	}
+	for _, lib := range all {
+		if lib.Type == "executable" {
+			continue
+		}
+		if len(example.Links) == 0 || slices.Contains(example.Links, lib.Name) {
+			a.links = append(a.links, lib)
+		}
+	}
+	return a, nil
+}
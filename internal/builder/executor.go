@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/deviceix/styx/internal/logger"
+	"github.com/deviceix/styx/internal/platform"
 )
 
 // Task represents a build task
@@ -21,6 +24,8 @@ type Task struct {
 	Dir          string
 	Env          map[string]string
 	Output       *bytes.Buffer
+	Input        io.Reader // fed to the command on stdin
+	Stderr       string    // what the command wrote to stderr, like warnings
 	SourceFile   string
 	OutputFile   string
 	Dependencies []*Task
@@ -29,6 +34,8 @@ type Task struct {
 	Error        error
 	StartTime    time.Time
 	EndTime      time.Time
+	CPUTime      time.Duration // user and system time of the command
+	Worker       int           // the worker that ran the task, -1 for none
 }
 
 // Result represents the result of a task execution
@@ -37,7 +44,9 @@ type Result struct {
 	Success  bool
 	Error    error
 	Output   string
+	Stderr   string
 	Duration time.Duration
+	CPUTime  time.Duration
 }
 
 // Executor manages parallel execution of build tasks
@@ -51,6 +60,12 @@ type Executor struct {
 	CompletedTasks map[string]bool
 	TasksMutex     sync.Mutex
 	logger         *logger.Logger
+	container      *container
+	env            map[string]string // set for every task run on the host
+	maxLoad        float64           // no task starts at this load average while another runs
+	running        int32
+	tracing        bool    // the finished tasks are kept for a trace
+	traced         []*Task // guarded by TasksMutex
 }
 
 // NewExecutor creates a new executor with the specified number of workers
@@ -79,7 +94,7 @@ func (e *Executor) SetLogger(log *logger.Logger) {
 
 // SetVerbose sets the verbose mode for the executor's logger
 func (e *Executor) SetVerbose(verbose bool) {
-	e.logger = logger.New(verbose)
+	e.logger.SetVerbose(verbose)
 }
 
 // Start starts the worker pool
@@ -130,32 +145,52 @@ func (e *Executor) worker(id int) {
 				continue
 			}
 
+			e.waitForLoad()
+			atomic.AddInt32(&e.running, 1)
+
 			result := &Result{
 				Task: task,
 			}
 
+			task.Worker = id
 			task.StartTime = time.Now()
-
-			cmd := exec.CommandContext(e.Context, task.Command, task.Args...)
-			cmd.Dir = task.Dir
-
-			env := os.Environ()
-			for k, v := range task.Env {
-				env = append(env, k+"="+v)
+			if e.logger != nil {
+				e.logger.Event("task_start", map[string]interface{}{
+					"id":      task.ID,
+					"command": append([]string{task.Command}, task.Args...),
+					"output":  task.OutputFile,
+				})
 			}
-			cmd.Env = env
+
+			cmd := e.command(task)
 
 			var stderr bytes.Buffer
 			if task.Output == nil {
 				task.Output = &bytes.Buffer{}
 			}
 
+			cmd.Stdin = task.Input
 			cmd.Stdout = task.Output
 			cmd.Stderr = &stderr
 			err := cmd.Run()
+			atomic.AddInt32(&e.running, -1)
 
 			task.EndTime = time.Now()
+			task.Stderr = stderr.String()
+			result.Stderr = task.Stderr
 			result.Duration = task.EndTime.Sub(task.StartTime)
+			if cmd.ProcessState != nil {
+				task.CPUTime = cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+				result.CPUTime = task.CPUTime
+			}
+			e.trace(task)
+			if e.logger != nil {
+				e.logger.Event("task_end", map[string]interface{}{
+					"id":          task.ID,
+					"success":     err == nil,
+					"duration_ms": result.Duration.Milliseconds(),
+				})
+			}
 
 			if err != nil {
 				// failed
@@ -195,11 +230,83 @@ func (e *Executor) worker(id int) {
 				}
 			}
 
-			e.Results <- result
+			// results are only collected by WaitForAll; the others are
+			// dropped rather than blocking the worker once the buffer is full
+			select {
+			case e.Results <- result:
+			default:
+			}
+		}
+	}
+}
+
+// waitForLoad delays the start of a task while the load average of the
+// system is at or above maxLoad and another task is running, like make -l
+func (e *Executor) waitForLoad() {
+	if e.maxLoad <= 0 {
+		return
+	}
+
+	for atomic.LoadInt32(&e.running) > 0 {
+		load, err := platform.LoadAverage()
+		if err != nil || load < e.maxLoad {
+			return
+		}
+		select {
+		case <-e.Context.Done():
+			return
+		case <-time.After(100 * time.Millisecond):
 		}
 	}
 }
 
+// command returns the command executing task, inside the build container
+// when there is one
+func (e *Executor) command(task *Task) *exec.Cmd {
+	if e.container != nil {
+		return e.container.command(e.Context, task.Dir, task.Env, task.Command, task.Args...)
+	}
+
+	cmd := exec.CommandContext(e.Context, task.Command, task.Args...)
+	cmd.Dir = task.Dir
+
+	env := os.Environ()
+	for k, v := range e.env {
+		env = append(env, k+"="+v)
+	}
+	for k, v := range task.Env {
+		env = append(env, k+"="+v)
+	}
+	cmd.Env = env
+	return cmd
+}
+
+// StartTrace makes the executor keep the tasks it runs from now on, for
+// TracedTasks
+func (e *Executor) StartTrace() {
+	e.TasksMutex.Lock()
+	defer e.TasksMutex.Unlock()
+	e.tracing = true
+	e.traced = nil
+}
+
+// TracedTasks returns the tasks run since StartTrace, in the order they
+// finished
+func (e *Executor) TracedTasks() []*Task {
+	e.TasksMutex.Lock()
+	defer e.TasksMutex.Unlock()
+	return append([]*Task(nil), e.traced...)
+}
+
+// trace keeps a finished task for the trace, if one is recorded
+func (e *Executor) trace(task *Task) {
+	e.TasksMutex.Lock()
+	defer e.TasksMutex.Unlock()
+	if e.tracing {
+		e.traced = append(e.traced, task)
+	}
+}
+
 // Submit submits a task for execution
 func (e *Executor) Submit(task *Task) {
 	if task.CompleteCh == nil {
@@ -254,7 +361,9 @@ func (e *Executor) WaitForTask(task *Task) *Result {
 		Success:  task.Error == nil,
 		Error:    task.Error,
 		Output:   task.Output.String(),
+		Stderr:   task.Stderr,
 		Duration: task.EndTime.Sub(task.StartTime),
+		CPUTime:  task.CPUTime,
 	}
 }
 
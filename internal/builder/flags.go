@@ -0,0 +1,257 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// flagsWithValue are the compiler options taking their value as the next
+// argument; the two are kept together
+var flagsWithValue = map[string]bool{
+	"-I": true, "-isystem": true, "-iquote": true, "-idirafter": true,
+	"-D": true, "-U": true, "-include": true, "-imacros": true,
+	"-x": true, "-arch": true, "-target": true, "-isysroot": true,
+	"-Xclang": true, "-Xpreprocessor": true, "-Xassembler": true, "-Xlinker": true,
+	"-MF": true, "-MT": true, "-MQ": true,
+}
+
+// exclusiveValues are the options of which only the last value counts
+var exclusiveValues = []string{"-std=", "-march=", "-mtune=", "-mcpu=", "-stdlib=", "-fvisibility="}
+
+// optimizationLevels and debugLevels are the values of -O and -g, of which
+// only the last one counts
+var (
+	optimizationLevels = map[string]bool{
+		"-O": true, "-O0": true, "-O1": true, "-O2": true, "-O3": true,
+		"-Os": true, "-Oz": true, "-Og": true, "-Ofast": true,
+	}
+	debugLevels = map[string]bool{
+		"-g": true, "-g0": true, "-g1": true, "-g2": true, "-g3": true,
+		"-ggdb": true, "-ggdb0": true, "-ggdb1": true, "-ggdb2": true, "-ggdb3": true,
+	}
+)
+
+// flagClass orders the flags of a merged command line
+type flagClass int
+
+const (
+	flagInclude flagClass = iota
+	flagDefine
+	flagOther
+)
+
+// flag is one option of a command line, with its value when it takes one
+type flag struct {
+	args  []string
+	class flagClass
+	// key is shared by the options overriding each other, empty when the
+	// option only replaces an identical one
+	key string
+}
+
+// parseFlags splits flags into options, joining -I, -D and -U with their
+// value when it is given apart
+func parseFlags(flags []string) []flag {
+	var parsed []flag
+	for i := 0; i < len(flags); i++ {
+		args := []string{flags[i]}
+		if flagsWithValue[flags[i]] && i+1 < len(flags) {
+			i++
+			switch args[0] {
+			case "-I", "-D", "-U":
+				args = []string{args[0] + flags[i]}
+			default:
+				args = append(args, flags[i])
+			}
+		}
+		parsed = append(parsed, classifyFlag(args))
+	}
+	return parsed
+}
+
+// classifyFlag returns the class and the key of an option
+func classifyFlag(args []string) flag {
+	name := args[0]
+	f := flag{args: args, class: flagOther}
+	switch {
+	case len(args) == 1 && strings.HasPrefix(name, "-I"),
+		name == "-isystem", name == "-iquote", name == "-idirafter":
+		f.class = flagInclude
+	case len(args) == 1 && (strings.HasPrefix(name, "-D") || strings.HasPrefix(name, "-U")):
+		f.class = flagDefine
+		macro, _, _ := strings.Cut(name[2:], "=")
+		f.key = "-D" + macro
+	case optimizationLevels[name]:
+		f.key = "-O"
+	case debugLevels[name]:
+		f.key = "-g"
+	case len(args) == 1:
+		for _, prefix := range exclusiveValues {
+			if strings.HasPrefix(name, prefix) {
+				f.key = prefix
+				return f
+			}
+		}
+		f.key = switchKey(name)
+	}
+	return f
+}
+
+// switchKey returns the key shared by an -f or -W option and its negation,
+// like -fexceptions and -fno-exceptions, or "" for other options
+func switchKey(name string) string {
+	if len(name) < 3 || strings.ContainsAny(name, "=,") {
+		return ""
+	}
+	prefix := name[:2]
+	if prefix != "-f" && prefix != "-W" {
+		return ""
+	}
+	return prefix + strings.TrimPrefix(name[2:], "no-")
+}
+
+// mergeFlags returns flags without duplicates and with the options that
+// override each other reduced to the last one, include directories first,
+// then macros, then the other options, each in the order they were given.
+// It also describes every option that was overridden by a different one.
+func mergeFlags(flags []string) ([]string, []string) {
+	parsed := parseFlags(flags)
+
+	// the last option of every key wins
+	last := make(map[string]int)
+	for i, f := range parsed {
+		if f.key != "" {
+			last[f.key] = i
+		}
+	}
+
+	var conflicts []string
+	var classes [3][]string
+	seen := make(map[string]bool)
+	for i, f := range parsed {
+		text := strings.Join(f.args, " ")
+		if f.key != "" && last[f.key] != i {
+			if winner := strings.Join(parsed[last[f.key]].args, " "); winner != text {
+				conflicts = append(conflicts, fmt.Sprintf("%s is overridden by %s", text, winner))
+			}
+			continue
+		}
+		if seen[text] {
+			continue
+		}
+		seen[text] = true
+		classes[f.class] = append(classes[f.class], f.args...)
+	}
+
+	merged := make([]string, 0, len(flags))
+	for _, class := range classes {
+		merged = append(merged, class...)
+	}
+	return merged, conflicts
+}
+
+// mergeCompileFlags merges the compile flags of a command like mergeFlags,
+// so equivalent configurations give the same commands and command hashes,
+// and warns once about every overridden option
+func (b *Builder) mergeCompileFlags(flags []string) []string {
+	merged, conflicts := mergeFlags(flags)
+	for _, conflict := range conflicts {
+		if b.flagConflicts[conflict] {
+			continue
+		}
+		if b.flagConflicts == nil {
+			b.flagConflicts = make(map[string]bool)
+		}
+		b.flagConflicts[conflict] = true
+		b.logger.Warning("conflicting flags: %s", conflict)
+	}
+	return merged
+}
+
+// languageFlags returns the flags of the toolchain and the target that only
+// apply to sources in language, with the standard of the project when it is
+// the language of the project or the one it extends, like C for Objective-C
+func (b *Builder) languageFlags(language string) []string {
+	toolchainFlags, targetFlags := b.languageFlagLists(language)
+	flags := append(append([]string{}, toolchainFlags...), targetFlags...)
+	flags = append(flags, b.objcFlags(language)...)
+	flags = append(flags, b.cudaFlags(language)...)
+	if baseLanguage(language) == baseLanguage(b.Config.Project.Language) && b.Config.Project.Standard != "" {
+		standard, _ := b.Compiler.GetVersionInfo().StandardName(b.Config.Project.Standard)
+		flags = append(flags, "-std="+standard)
+	}
+	return flags
+}
+
+// languageFlagLists returns the configured flags of sources in language:
+// those of the toolchain and those of the current target
+func (b *Builder) languageFlagLists(language string) ([]string, []string) {
+	toolchain := b.Config.Toolchain
+	target := b.Config.Targets[b.Target]
+	switch language {
+	case "c":
+		return toolchain.CFlags, target.CFlags
+	case "c++":
+		return toolchain.CXXFlags, target.CXXFlags
+	case "objective-c":
+		return toolchain.OBJCFlags, target.OBJCFlags
+	case "objective-c++":
+		return toolchain.OBJCXXFlags, target.OBJCXXFlags
+	case "asm":
+		return toolchain.ASMFlags, target.ASMFlags
+	case "cuda":
+		return toolchain.CUDAFlags, target.CUDAFlags
+	}
+	return nil, nil
+}
+
+// sourceFlags returns the flags compiling sourceFile out of cFlags, the ones
+// of the sources in the language of the project. Sources in another language
+// get the flags of their own language in place of those of the project, and
+// the ones given to an assembler other than the compiler driver only keep the
+// include directories and macros besides. nvcc gets the other options meant
+// for the host compiler through -Xcompiler.
+func (b *Builder) sourceFlags(sourceFile string, cFlags []string) []string {
+	language := languageOf(sourceFile)
+	if language == "" || language == b.Config.Project.Language {
+		return cFlags
+	}
+
+	projectFlags := make(map[string]bool)
+	for _, f := range parseFlags(b.languageFlags(b.Config.Project.Language)) {
+		projectFlags[strings.Join(f.args, " ")] = true
+	}
+	external := b.assemblerFor(sourceFile) != ""
+	nvcc := language == "cuda" && !b.cudaWithClang()
+
+	var flags []string
+	for _, f := range parseFlags(cFlags) {
+		if projectFlags[strings.Join(f.args, " ")] || (external && f.class == flagOther) {
+			continue
+		}
+		if nvcc {
+			flags = append(flags, nvccHostFlag(f)...)
+			continue
+		}
+		flags = append(flags, f.args...)
+	}
+	return b.mergeCompileFlags(append(flags, b.languageFlags(language)...))
+}
+
+// sourceCommandHash returns the command hash of compiling sourceFile with
+// flags, keeping the hash of every language in hashes as the flags only
+// vary with it
+func (b *Builder) sourceCommandHash(sourceFile string, flags []string, hashes map[string]string) string {
+	language := languageOf(sourceFile)
+	if assembler := b.assemblerFor(sourceFile); assembler != "" {
+		language = assembler
+		flags = append(append([]string{}, flags...), assembler)
+	} else if language == "cuda" {
+		flags = append(append([]string{}, flags...), b.cudaCompiler())
+	}
+	if hash, exists := hashes[language]; exists {
+		return hash
+	}
+	hashes[language] = b.compileCommandHash(flags)
+	return hashes[language]
+}
@@ -0,0 +1,118 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// canonicalPlan is the form of a plan that is the same on every machine:
+// paths are relative to the project, the compiler is named by its file name
+// and the state of the cache, which decides what is up to date, is left out
+type canonicalPlan struct {
+	Target  string          `json:"target"`
+	Steps   []canonicalStep `json:"steps"`
+	Outputs []string        `json:"outputs"`
+}
+
+// canonicalStep is a step of a canonicalPlan; Needs holds the outputs of
+// the steps it waits for
+type canonicalStep struct {
+	Kind    StepKind `json:"kind"`
+	Output  string   `json:"output"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Inputs  []string `json:"inputs,omitempty"`
+	Needs   []string `json:"needs,omitempty"`
+}
+
+// CanonicalJSON serializes the plan in a form that only changes when the
+// commands of the build do. Paths inside root, the project directory, are
+// made relative to it, and paths inside stateDir, the state directory of
+// the project, start with $STATE when it is absolute.
+func (p *Plan) CanonicalJSON(root, stateDir string) ([]byte, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve project directory: %w", err)
+	}
+	replacements := []string{root + string(filepath.Separator), ""}
+	if filepath.IsAbs(stateDir) {
+		// a relative state directory is inside the project already
+		replacements = append([]string{stateDir + string(filepath.Separator), "$STATE/"}, replacements...)
+	}
+	replacer := strings.NewReplacer(replacements...)
+	canonical := func(values []string) []string {
+		result := make([]string, len(values))
+		for i, value := range values {
+			result[i] = filepath.ToSlash(replacer.Replace(value))
+		}
+		return result
+	}
+
+	out := canonicalPlan{
+		Target:  p.Target,
+		Steps:   make([]canonicalStep, 0, len(p.Steps)),
+		Outputs: canonical(p.Outputs),
+	}
+	for _, step := range p.Steps {
+		needs := make([]string, 0, len(step.Needs))
+		for _, need := range step.Needs {
+			needs = append(needs, need.Task.OutputFile)
+		}
+		out.Steps = append(out.Steps, canonicalStep{
+			Kind:    step.Kind,
+			Output:  canonical([]string{step.Task.OutputFile})[0],
+			Command: filepath.Base(step.Task.Command),
+			Args:    canonical(step.Task.Args),
+			Inputs:  canonical(step.Inputs),
+			Needs:   canonical(needs),
+		})
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize plan: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// CheckGolden compares a canonical plan with the golden file at path, and
+// reports the first line they differ in. With update, the golden file is
+// replaced by the plan instead.
+func CheckGolden(path string, data []byte, update bool) error {
+	if update {
+		if err := platform.WriteFileAtomic(path, data, 0644); err != nil {
+			return fmt.Errorf("failed to write golden plan: %w", err)
+		}
+		return nil
+	}
+
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read golden plan: %w", err)
+	}
+	if bytes.Equal(golden, data) {
+		return nil
+	}
+
+	want := strings.Split(string(golden), "\n")
+	got := strings.Split(string(data), "\n")
+	for i := 0; i < len(want) || i < len(got); i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w != g {
+			return fmt.Errorf("plan differs from %s at line %d:\n  want: %s\n  got:  %s", path, i+1, strings.TrimSpace(w), strings.TrimSpace(g))
+		}
+	}
+	return fmt.Errorf("plan differs from %s", path)
+}
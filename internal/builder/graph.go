@@ -0,0 +1,139 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+)
+
+// LoadGraph fills the dependency graph of the current target without
+// compiling anything and returns the objects and outputs the next build
+// would rebuild, according to the cache
+func (b *Builder) LoadGraph() (map[string]bool, error) {
+	if err := b.resolveDependencies(); err != nil {
+		return nil, fmt.Errorf("failed to resolve dependencies: %w", err)
+	}
+
+	if err := b.runChecks(); err != nil {
+		return nil, fmt.Errorf("feature checks failed: %w", err)
+	}
+
+	generated, err := b.planRules(&Plan{})
+	if err != nil {
+		return nil, err
+	}
+	b.generated = generated
+
+	dirty := make(map[string]bool)
+	for output, step := range generated.producers {
+		if !step.UpToDate {
+			dirty[output] = true
+		}
+	}
+
+	targetOutputDir := b.targetOutputDir()
+	if !b.hasArtifacts() {
+		sourceFiles, err := b.findSources(b.Config.Build.Sources, b.Config.Build.Exclude)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find source files: %w", err)
+		}
+		sourceFiles = addGeneratedSources(sourceFiles, generated, "")
+
+		objectFiles, err := b.loadObjects(sourceFiles, targetOutputDir, b.getCompilationFlags(), dirty)
+		if err != nil {
+			return nil, err
+		}
+
+		outputPath := b.getOutputPath(targetOutputDir)
+		if err := b.loadOutput(outputPath, b.Config.Build.OutputType, objectFiles, dirty); err != nil {
+			return nil, err
+		}
+		return dirty, nil
+	}
+
+	artifacts, err := b.artifacts(targetOutputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// libraries come first, so their state is known to what links them
+	for _, a := range artifacts {
+		sourceFiles, err := b.artifactSources(a)
+		if err != nil {
+			return nil, err
+		}
+		sourceFiles = addGeneratedSources(sourceFiles, generated, a.Name)
+
+		objectFiles, err := b.loadObjects(sourceFiles, artifactObjectDir(a, targetOutputDir), b.artifactCompilationFlags(a), dirty)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", a.Name, err)
+		}
+
+		inputs := objectFiles
+		if a.Type != "static_lib" {
+			inputs = append(inputs, linkedLibraries(a)...)
+		}
+		if err := b.loadOutput(a.output, a.Type, inputs, dirty); err != nil {
+			return nil, fmt.Errorf("%s: %w", a.Name, err)
+		}
+	}
+
+	return dirty, nil
+}
+
+// loadObjects adds sourceFiles and the objects compiled from them to the
+// graph, marking the objects that are out of date as dirty
+func (b *Builder) loadObjects(sourceFiles []string, outputDir string, cFlags []string, dirty map[string]bool) ([]string, error) {
+	if err := b.buildDependencyGraph(sourceFiles); err != nil {
+		return nil, fmt.Errorf("failed to build dependency graph: %w", err)
+	}
+
+	objectFiles, err := b.objectFilePaths(sourceFiles, outputDir)
+	if err != nil {
+		return nil, err
+	}
+	checks := make(map[string][]string)
+	for i, sourceFile := range sourceFiles {
+		objectFile := objectFiles[i]
+		dependencies, err := b.addObjectNode(sourceFile, objectFile)
+		if err != nil {
+			return nil, err
+		}
+		checks[objectFile] = dependencies
+	}
+	b.Cache.Prehash(checks)
+
+	commandHashes := make(map[string]string)
+	for i, sourceFile := range sourceFiles {
+		objectFile := objectFiles[i]
+		dependencies := checks[objectFile]
+
+		commandHash := b.sourceCommandHash(sourceFile, b.sourceFlags(sourceFile, cFlags), commandHashes)
+
+		if needsRebuild, _ := b.needsRebuild(objectFile, dependencies, commandHash); needsRebuild || dirty[sourceFile] {
+			dirty[objectFile] = true
+		}
+	}
+
+	return objectFiles, nil
+}
+
+// loadOutput adds an output to the graph, marking it dirty when it is
+// missing or one of its inputs is dirty
+func (b *Builder) loadOutput(outputPath, outputType string, inputs []string, dirty map[string]bool) error {
+	if err := b.addOutputNode(outputPath, outputType, inputs); err != nil {
+		return fmt.Errorf("failed to add output node: %w", err)
+	}
+
+	if _, err := os.Stat(outputPath); err != nil {
+		dirty[outputPath] = true
+		return nil
+	}
+
+	for _, input := range inputs {
+		if dirty[input] {
+			dirty[outputPath] = true
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package builder
+
+import (
+	"os"
+	"runtime"
+	"sync"
+)
+
+// mappedHashSize is the size from which files are mapped into memory to be
+// hashed; mapping smaller ones costs more than reading them
+const mappedHashSize = 256 << 10
+
+// parallelHashCount is the number of files from which Prehash hashes them
+// with a pool of workers rather than one after the other
+const parallelHashCount = 16
+
+// hashJob is a file to hash, with what it was when it was found to need it
+type hashJob struct {
+	path string
+	info os.FileInfo
+}
+
+// Prehash hashes, with a worker per CPU, the files the rebuild checks of
+// outputs, which maps every output to its inputs, will compare by hash, so
+// the checks find their hashes in the FS of the cache instead of reading the
+// files one after the other. Files that cannot be read are left to the
+// checks to report. With a single CPU there is nothing to gain.
+func (c *Cache) Prehash(outputs map[string][]string) {
+	if c.Strategy == "timestamp" || runtime.NumCPU() < 2 {
+		return
+	}
+
+	var jobs []*hashJob
+	queued := make(map[string]bool)
+	queue := func(path string, stamp FileStamp, racy bool) {
+		if queued[path] {
+			return
+		}
+		info, err := c.FS.Stat(path)
+		if err != nil || !c.comparesByHash(info, stamp, racy) {
+			return
+		}
+		queued[path] = true
+		jobs = append(jobs, &hashJob{path: path, info: info})
+	}
+
+	for output, inputs := range outputs {
+		entry, exists := c.GetEntry(output)
+		if !exists {
+			continue
+		}
+		queue(output, entry.Output, false)
+		for _, input := range inputs {
+			if stamp, ok := entry.Inputs[c.key(input)]; ok {
+				queue(input, stamp, stamp.ModTime >= entry.RecordedAt-int64(timestampSlack))
+			}
+		}
+	}
+
+	// files already hashed in this invocation are found in the FS
+	hash := func(job *hashJob) {
+		_, _ = c.FS.Hash(job.path, job.info, c.CalculateFileHash)
+	}
+	if len(jobs) < parallelHashCount {
+		for _, job := range jobs {
+			hash(job)
+		}
+	} else {
+		next := make(chan *hashJob)
+		var wg sync.WaitGroup
+		for i := 0; i < min(runtime.NumCPU(), len(jobs)); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for job := range next {
+					hash(job)
+				}
+			}()
+		}
+		for _, job := range jobs {
+			next <- job
+		}
+		close(next)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,57 @@
+package builder
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// imageFormats maps an output format to the objcopy target producing it and
+// the extension of the image
+var imageFormats = map[string][2]string{
+	"bin": {"binary", ".bin"},
+	"hex": {"ihex", ".hex"},
+}
+
+// linkerScriptFlags returns the flags linking executables with the
+// configured linker script
+func (b *Builder) linkerScriptFlags() []string {
+	if b.Config.Build.LinkerScript == "" {
+		return nil
+	}
+	return []string{"-T", b.Config.Build.LinkerScript}
+}
+
+// imagePath returns the path of the raw image made from the executable at
+// outputPath, or an empty string when the output format is elf
+func (b *Builder) imagePath(outputPath string) string {
+	format, ok := imageFormats[b.Config.Build.OutputFormat]
+	if !ok {
+		return ""
+	}
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + format[1]
+}
+
+// newImageTask returns the task converting the linked executable at
+// outputPath into the configured raw output format with objcopy, or nil when
+// the output format is elf
+func (b *Builder) newImageTask(outputPath string) *Task {
+	imagePath := b.imagePath(outputPath)
+	if imagePath == "" {
+		return nil
+	}
+
+	return &Task{
+		ID:         imagePath,
+		Command:    b.objcopyCommand(),
+		Args:       []string{"-O", imageFormats[b.Config.Build.OutputFormat][0], outputPath, imagePath},
+		OutputFile: imagePath,
+	}
+}
+
+// objcopyCommand returns the objcopy of the toolchain
+func (b *Builder) objcopyCommand() string {
+	if b.Config.Toolchain.Objcopy != "" {
+		return b.Config.Toolchain.Objcopy
+	}
+	return "objcopy"
+}
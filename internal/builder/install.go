@@ -0,0 +1,272 @@
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// installManifest is the file, in the output directory, listing the files
+// installed by the last styx install so they can be uninstalled
+const installManifest = "install_manifest.txt"
+
+// installer copies files below destDir, recording where they went
+type installer struct {
+	destDir string
+	files   []string
+}
+
+// Install copies the outputs of the current target, the public headers and
+// the files named by the install rules below prefix, staged under destDir
+// when it is set, and writes the list of installed files to the manifest.
+// The target has to be built already.
+func (b *Builder) Install(prefix, destDir string) ([]string, error) {
+	rules := b.Config.Install
+	if prefix == "" {
+		prefix = rules.Prefix
+	}
+	if prefix == "" {
+		prefix = "/usr/local"
+	}
+	prefix, err := filepath.Abs(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := func(configured, fallback string) string {
+		if configured == "" {
+			configured = fallback
+		}
+		if filepath.IsAbs(configured) {
+			return configured
+		}
+		return filepath.Join(prefix, configured)
+	}
+	binDir := dir(rules.BinDir, "bin")
+	libDir := dir(rules.LibDir, "lib")
+	includeDir := dir(rules.IncludeDir, "include")
+
+	inst := &installer{destDir: destDir}
+	if err := b.installOutputs(inst, binDir, libDir); err != nil {
+		return inst.files, err
+	}
+
+	for _, headerDir := range b.installHeaderDirs() {
+		if err := inst.copyTree(headerDir, includeDir); err != nil {
+			return inst.files, fmt.Errorf("failed to install headers from %s: %w", headerDir, err)
+		}
+	}
+
+	for _, rule := range rules.Files {
+		for _, pattern := range rule.Sources {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return inst.files, fmt.Errorf("invalid install pattern %s: %w", pattern, err)
+			}
+			if len(matches) == 0 {
+				return inst.files, fmt.Errorf("no files to install match %s", pattern)
+			}
+
+			for _, match := range matches {
+				dest := filepath.Join(dir(rule.Destination, ""), filepath.Base(match))
+				if err := inst.copyTree(match, dest); err != nil {
+					return inst.files, fmt.Errorf("failed to install %s: %w", match, err)
+				}
+			}
+		}
+	}
+
+	manifest := strings.Join(inst.files, "\n") + "\n"
+	if err := platform.WriteFileAtomic(filepath.Join(b.OutputDir, installManifest), []byte(manifest), 0644); err != nil {
+		return inst.files, fmt.Errorf("failed to write install manifest: %w", err)
+	}
+	return inst.files, nil
+}
+
+// installOutputs installs executables into binDir and libraries into libDir,
+// limited to the outputs named by the install rules when there are any
+func (b *Builder) installOutputs(inst *installer, binDir, libDir string) error {
+	selected := make(map[string]bool)
+	for _, name := range b.Config.Install.Outputs {
+		selected[name] = true
+	}
+
+	for _, out := range b.ResolveArtifacts() {
+		if len(selected) > 0 && !selected[out.Name] {
+			continue
+		}
+
+		if _, err := os.Stat(out.Path); err != nil {
+			return fmt.Errorf("%s has not been built for target %s", out.Name, b.Target)
+		}
+
+		dest := libDir
+		if out.Type == "executable" {
+			dest = binDir
+		}
+		if err := inst.copyLinked(out.Path, dest); err != nil {
+			return fmt.Errorf("failed to install %s: %w", out.Name, err)
+		}
+	}
+	return nil
+}
+
+// copyLinked installs the file at path into destDir, with the symlinks
+// leading to it from path when it is one, like those of a versioned shared
+// library
+func (i *installer) copyLinked(path, destDir string) error {
+	for {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return i.copyFile(path, filepath.Join(destDir, filepath.Base(path)))
+		}
+
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if err := i.symlink(target, filepath.Join(destDir, filepath.Base(path))); err != nil {
+			return err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+}
+
+// installHeaderDirs returns the directories whose headers are installed:
+// the configured ones, or the include directories of the libraries
+func (b *Builder) installHeaderDirs() []string {
+	if len(b.Config.Install.Headers) > 0 {
+		return b.Config.Install.Headers
+	}
+
+	if !b.hasArtifacts() {
+		if b.Config.Build.OutputType == "executable" {
+			return nil
+		}
+		return b.Config.Build.IncludeDirs
+	}
+
+	var dirs []string
+	for _, lib := range b.Config.Libraries {
+		dirs = append(dirs, lib.IncludeDirs...)
+	}
+	return dirs
+}
+
+// copyTree installs src at dest, or the files below src into dest when it
+// is a directory
+func (i *installer) copyTree(src, dest string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return i.copyFile(src, dest)
+	}
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		return i.copyFile(path, filepath.Join(dest, rel))
+	})
+}
+
+// copyFile installs src at dest below the staging directory, keeping its
+// permissions. The file is replaced by renaming, so running executables and
+// loaded libraries are not overwritten in place.
+func (i *installer) copyFile(src, dest string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	target := dest
+	if i.destDir != "" {
+		target = filepath.Join(i.destDir, dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := platform.WriteFileAtomic(target, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	i.files = append(i.files, target)
+	return nil
+}
+
+// symlink installs a symlink to target at dest below the staging directory,
+// replacing the file there
+func (i *installer) symlink(target, dest string) error {
+	link := dest
+	if i.destDir != "" {
+		link = filepath.Join(i.destDir, dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(target, link); err != nil {
+		return err
+	}
+
+	i.files = append(i.files, link)
+	return nil
+}
+
+// Uninstall removes the files listed in the manifest of the last install
+// and returns them; files that are already gone are skipped
+func (b *Builder) Uninstall() ([]string, error) {
+	path := filepath.Join(b.OutputDir, installManifest)
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no install manifest found in %s (run styx install first)", b.OutputDir)
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var removed []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		installed := strings.TrimSpace(scanner.Text())
+		if installed == "" {
+			continue
+		}
+		if err := os.Remove(installed); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, fmt.Errorf("failed to remove %s: %w", installed, err)
+		}
+		removed = append(removed, installed)
+	}
+	if err := scanner.Err(); err != nil {
+		return removed, err
+	}
+
+	_ = file.Close()
+	return removed, os.Remove(path)
+}
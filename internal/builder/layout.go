@@ -0,0 +1,77 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// layoutRecord is the file, in the output directory, naming the output
+// layout of the last build, so that commands find its outputs even when
+// the layout was changed since
+const layoutRecord = "output_layout.txt"
+
+// output layouts: outputs in build/<target>, in
+// build/<platform>-<arch>/<target>, or in the output directory itself
+const (
+	LayoutTarget   = "target"
+	LayoutPlatform = "platform"
+	LayoutFlat     = "flat"
+)
+
+// checkLayout checks that layout is a known output layout, empty for the
+// default one
+func checkLayout(layout string) error {
+	switch layout {
+	case "", LayoutTarget, LayoutPlatform, LayoutFlat:
+		return nil
+	}
+	return fmt.Errorf("invalid output layout: %s (must be target, platform or flat)", layout)
+}
+
+// targetOutputDir returns the directory the outputs of the current target
+// are written to, following the output layout
+func (b *Builder) targetOutputDir() string {
+	return b.layoutDir(b.OutputLayout)
+}
+
+// layoutDir returns the directory of the outputs of the current target in
+// layout
+func (b *Builder) layoutDir(layout string) string {
+	switch layout {
+	case LayoutFlat:
+		return b.OutputDir
+	case LayoutPlatform:
+		return filepath.Join(b.OutputDir, b.platformInfo.Name+"-"+b.targetArch(), b.Target)
+	}
+	return filepath.Join(b.OutputDir, b.Target)
+}
+
+// recordLayout records the output layout of the build in the output
+// directory
+func (b *Builder) recordLayout() error {
+	if b.recordedLayout() == b.OutputLayout {
+		return nil
+	}
+	if err := platform.WriteFileAtomic(filepath.Join(b.OutputDir, layoutRecord), []byte(b.OutputLayout+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to record output layout: %w", err)
+	}
+	return nil
+}
+
+// recordedLayout returns the output layout of the last build in the output
+// directory, empty when none was recorded
+func (b *Builder) recordedLayout() string {
+	data, err := os.ReadFile(filepath.Join(b.OutputDir, layoutRecord))
+	if err != nil {
+		return ""
+	}
+	layout := strings.TrimSpace(string(data))
+	if checkLayout(layout) != nil {
+		return ""
+	}
+	return layout
+}
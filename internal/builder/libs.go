@@ -0,0 +1,82 @@
+package builder
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// libraryFlags returns the linker flags for the libraries, library
+// directories and frameworks of the build and the current target. They
+// follow every object and library of the project on the command line, so
+// the libraries resolve the symbols those leave undefined.
+func (b *Builder) libraryFlags() []string {
+	build := b.Config.Build
+	libs := append([]string{}, build.Libs...)
+	dirs := append([]string{}, build.LibDirs...)
+	frameworks := append([]string{}, build.Frameworks...)
+	if target, ok := b.Config.Targets[b.Target]; ok {
+		libs = append(libs, target.Libs...)
+		dirs = append(dirs, target.LibDirs...)
+		frameworks = append(frameworks, target.Frameworks...)
+	}
+
+	if b.Compiler.GetVersionInfo().Vendor == "msvc" {
+		return msvcLibraryFlags(libs, dirs, b.ltoLinkerOptions())
+	}
+
+	var flags []string
+	for _, dir := range dirs {
+		flags = append(flags, "-L"+dir)
+	}
+	for _, lib := range libs {
+		if isLibraryFile(lib) {
+			flags = append(flags, lib)
+		} else {
+			flags = append(flags, "-l"+lib)
+		}
+	}
+	// frameworks only exist on macOS, where they are searched like libraries
+	if b.platformInfo.Platform == platform.PlatformMacOS {
+		for _, framework := range frameworks {
+			flags = append(flags, "-framework", framework)
+		}
+	}
+	return flags
+}
+
+// msvcLibraryFlags returns the flags of cl linking libs, searched in dirs.
+// Libraries are named by their import library, and the directories are
+// options of the linker, which have to come last after /link, along with
+// the other options.
+func msvcLibraryFlags(libs, dirs, options []string) []string {
+	var flags []string
+	for _, lib := range libs {
+		if !strings.EqualFold(filepath.Ext(lib), ".lib") {
+			lib += ".lib"
+		}
+		flags = append(flags, lib)
+	}
+	if len(dirs)+len(options) > 0 {
+		flags = append(flags, "/link")
+		for _, dir := range dirs {
+			flags = append(flags, "/LIBPATH:"+dir)
+		}
+		flags = append(flags, options...)
+	}
+	return flags
+}
+
+// isLibraryFile reports whether lib names a library file, like
+// vendor/libfoo.a, rather than a library searched by name
+func isLibraryFile(lib string) bool {
+	if strings.ContainsAny(lib, `/\`) {
+		return true
+	}
+	switch filepath.Ext(lib) {
+	case ".a", ".so", ".dylib", ".lib", ".tbd":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/deviceix/styx/internal/dependency"
+)
+
+// warnIneffectiveConfig warns about the parts of the configuration that
+// have no effect on the build of the current target, once per builder, so
+// that misspelled keys and stale paths do not go unnoticed
+func (b *Builder) warnIneffectiveConfig() {
+	if b.configChecked {
+		return
+	}
+	b.configChecked = true
+
+	for _, warning := range b.ineffectiveConfig() {
+		b.logger.Warning("%s", warning)
+	}
+}
+
+// ineffectiveConfig describes the parts of the configuration that have no
+// effect on the build of the current target
+func (b *Builder) ineffectiveConfig() []string {
+	var warnings []string
+	for _, key := range b.Config.UnknownKeys() {
+		warnings = append(warnings, fmt.Sprintf("unknown key %s has no effect", key))
+	}
+	for _, name := range b.Config.UnknownVariables() {
+		warnings = append(warnings, fmt.Sprintf("environment variable %s overrides no key", name))
+	}
+	warnings = append(warnings, b.missingIncludeDirs()...)
+	warnings = append(warnings, b.unmatchedExcludes()...)
+	warnings = append(warnings, b.unusedEnvironments()...)
+	warnings = append(warnings, b.duplicateFlags()...)
+	if target := b.Config.Targets[b.Target]; (target.Strip || target.SplitDebugInfo) && !b.stripsOutputs() {
+		warnings = append(warnings, fmt.Sprintf("strip and split_debug_info of target %s have no effect on %s", b.Target, b.platformInfo.Name))
+	}
+	return warnings
+}
+
+// missingIncludeDirs describes the include directories that do not exist.
+// Directories in the output directory or holding the outputs of rules may
+// be created by the build.
+func (b *Builder) missingIncludeDirs() []string {
+	dirs := append([]string{}, b.Config.Build.IncludeDirs...)
+	for _, a := range artifactConfigs(b.Config) {
+		dirs = append(dirs, a.IncludeDirs...)
+	}
+
+	var warnings []string
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if seen[dir] || isWithin(dir, b.OutputDir) || b.ruleOutputsWithin(dir) {
+			continue
+		}
+		seen[dir] = true
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			warnings = append(warnings, fmt.Sprintf("include directory %s does not exist", dir))
+		}
+	}
+	return warnings
+}
+
+// ruleOutputsWithin reports whether a rule creates files in dir
+func (b *Builder) ruleOutputsWithin(dir string) bool {
+	for _, rule := range b.Config.Rules {
+		for _, output := range rule.Outputs {
+			if isWithin(output, dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unmatchedExcludes describes the exclude patterns that exclude none of the
+// sources: those of [build] excluding nothing from [build] or any binary or
+// library, and those of a binary or library excluding nothing from it
+func (b *Builder) unmatchedExcludes() []string {
+	var warnings []string
+	unmatched := func(patterns, exclude []string, where string) {
+		if len(exclude) == 0 {
+			return
+		}
+		files, err := dependency.FindSourceFiles(patterns, nil)
+		if err != nil {
+			return
+		}
+		for _, pattern := range exclude {
+			if !excludesAny(pattern, files) {
+				warnings = append(warnings, fmt.Sprintf("exclude pattern %s in %s matches no source", pattern, where))
+			}
+		}
+	}
+
+	patterns := append([]string{}, b.Config.Build.Sources...)
+	for _, a := range artifactConfigs(b.Config) {
+		patterns = append(patterns, a.Sources...)
+		unmatched(a.Sources, a.Exclude, a.Name)
+	}
+	unmatched(patterns, b.Config.Build.Exclude, "[build]")
+	return warnings
+}
+
+// excludesAny reports whether the exclude pattern excludes any of files
+func excludesAny(pattern string, files []string) bool {
+	for _, file := range files {
+		if excluded, err := dependency.Excludes(pattern, file); err != nil || excluded {
+			return true
+		}
+	}
+	return false
+}
+
+// unusedEnvironments describes the variables of environments and targets
+// that are set for nothing: environments only apply when selected with
+// --env, and targets have no variables of their own
+func (b *Builder) unusedEnvironments() []string {
+	var warnings []string
+	if b.Config.ActiveEnvironment() == nil {
+		var names []string
+		for name, env := range b.Config.Environment {
+			if len(env.Env) > 0 {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		if len(names) > 0 {
+			warnings = append(warnings, fmt.Sprintf("the variables of environment %s have no effect without --env or STYX_ENV", strings.Join(names, ", ")))
+		}
+	}
+	if len(b.Config.Targets[b.Target].Env) > 0 {
+		warnings = append(warnings, fmt.Sprintf("env of target %s has no effect; set it in an [environment] instead", b.Target))
+	}
+	return warnings
+}
+
+// duplicateFlags describes the compile flags given twice, in the same list
+// or in the lists of both the toolchain and the current target, where the
+// second one is dropped
+func (b *Builder) duplicateFlags() []string {
+	toolchain := b.Config.Toolchain
+	target := b.Config.Targets[b.Target]
+	targetName := "targets." + b.Target
+
+	var warnings []string
+	for _, lists := range []struct {
+		key              string
+		toolchain, flags []string
+	}{
+		{"c_flags", toolchain.CFlags, target.CFlags},
+		{"cxx_flags", toolchain.CXXFlags, target.CXXFlags},
+		{"objc_flags", toolchain.OBJCFlags, target.OBJCFlags},
+		{"objcxx_flags", toolchain.OBJCXXFlags, target.OBJCXXFlags},
+		{"asm_flags", toolchain.ASMFlags, target.ASMFlags},
+		{"cuda_flags", toolchain.CUDAFlags, target.CUDAFlags},
+	} {
+		where := make(map[string]string)
+		for _, list := range []struct {
+			name  string
+			flags []string
+		}{
+			{"toolchain." + lists.key, lists.toolchain},
+			{targetName + "." + lists.key, lists.flags},
+		} {
+			for _, f := range parseFlags(list.flags) {
+				text := strings.Join(f.args, " ")
+				switch previous, exists := where[text]; {
+				case !exists:
+					where[text] = list.name
+				case previous == list.name:
+					warnings = append(warnings, fmt.Sprintf("flag %s is given twice in %s", text, list.name))
+				default:
+					warnings = append(warnings, fmt.Sprintf("flag %s of %s is already in %s", text, list.name, previous))
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+// isWithin reports whether path is dir or inside it
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
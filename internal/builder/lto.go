@@ -0,0 +1,91 @@
+package builder
+
+import "github.com/deviceix/styx/internal/platform"
+
+// ltoMode returns the link-time optimization of the current target, thin,
+// full, or empty for none
+func (b *Builder) ltoMode() string {
+	if b.ltoUnsupported {
+		return ""
+	}
+	return string(b.Config.Targets[b.Target].LTO)
+}
+
+// ltoFlags returns the flags compiling the current target for link-time
+// optimization; GCC and Clang need them when linking as well. GCC has no
+// ThinLTO, so thin runs its own LTO, in parallel since GCC 10.
+func (b *Builder) ltoFlags() []string {
+	mode := b.ltoMode()
+	if mode == "" {
+		return nil
+	}
+
+	info := b.Compiler.GetVersionInfo()
+	switch info.Vendor {
+	case "msvc":
+		return []string{"/GL"}
+	case "gcc":
+		if info.Major >= 10 {
+			return []string{"-flto=auto"}
+		}
+		return []string{"-flto"}
+	default:
+		return []string{"-flto=" + mode}
+	}
+}
+
+// ltoLinkFlags returns the flags linking the current target with link-time
+// optimization. The linker of MSVC takes /LTCG after /link, with the
+// library directories.
+func (b *Builder) ltoLinkFlags() []string {
+	if b.Compiler.GetVersionInfo().Vendor == "msvc" {
+		return nil
+	}
+	return b.ltoFlags()
+}
+
+// ltoLinkerOptions returns the options of the linker of MSVC for link-time
+// optimization
+func (b *Builder) ltoLinkerOptions() []string {
+	if b.ltoMode() == "" {
+		return nil
+	}
+	return []string{"/LTCG"}
+}
+
+// checkLTO makes sure the compiler supports the link-time optimization of
+// the current target, and builds without it otherwise
+func (b *Builder) checkLTO() {
+	// SupportsFlag runs the compiler of the host, not the one of the image
+	if b.ltoMode() == "" || b.container != nil || b.Compiler.GetVersionInfo().Vendor == "msvc" {
+		return
+	}
+
+	for _, flag := range b.ltoFlags() {
+		if !b.Compiler.SupportsFlag(flag) {
+			b.logger.Warning("%s does not support %s, building without link-time optimization", b.Compiler.GetName(), flag)
+			b.ltoUnsupported = true
+			return
+		}
+	}
+	if b.archiverCommand() == "ar" && b.platformInfo.Platform != platform.PlatformMacOS {
+		b.logger.Warning("no archiver for link-time optimization found next to %s, static libraries are archived with ar", b.Compiler.GetName())
+	}
+}
+
+// archiverCommand returns the archiver of static libraries: ar, or with
+// link-time optimization the archiver of the compiler, which indexes the
+// symbols of its intermediate objects. On macOS, ar does so already.
+func (b *Builder) archiverCommand() string {
+	if b.ltoMode() == "" || b.platformInfo.Platform == platform.PlatformMacOS {
+		return "ar"
+	}
+
+	switch b.Compiler.GetVersionInfo().Vendor {
+	case "gcc":
+		return b.compilerTool("gcc-ar", "ar")
+	case "clang":
+		return b.compilerTool("llvm-ar", "ar")
+	}
+	return "ar"
+}
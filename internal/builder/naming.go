@@ -0,0 +1,225 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// outputFileName expands the output_name template of the output of [build],
+// or of the binary or library called name
+func (b *Builder) outputFileName(template, name string) string {
+	return config.ExpandOutputName(template, config.OutputNameValues{
+		Name:     name,
+		Version:  b.Config.Project.Version,
+		Platform: b.platformInfo.Name,
+		Arch:     b.targetArch(),
+		Target:   b.Target,
+	})
+}
+
+// artifactPath returns the output of a binary or library in outputDir
+func (b *Builder) artifactPath(outputDir string, a config.ArtifactConfig) string {
+	return b.outputPath(outputDir, b.outputFileName(a.OutputNameTemplate(), a.Name), a.Type)
+}
+
+// ResolvedArtifact is an output of the current target: a binary, a library,
+// or the output of [build]
+type ResolvedArtifact struct {
+	Name string // of the binary or library, or the output name of [build]
+	Type string // executable, static_lib or shared_lib
+	Path string
+}
+
+// ResolveArtifacts returns the outputs of the current target where builds
+// write them, libraries first, then binaries, each in the order of the
+// configuration. Every command looking for the outputs goes through it, so
+// they agree with the build on the output directory, layout and names.
+func (b *Builder) ResolveArtifacts() []ResolvedArtifact {
+	return b.artifactsIn(b.targetOutputDir())
+}
+
+// artifactsIn returns the outputs of the current target in outputDir
+func (b *Builder) artifactsIn(outputDir string) []ResolvedArtifact {
+	if !b.hasArtifacts() {
+		name := b.outputFileName(b.Config.Build.OutputName, b.Config.Project.Name)
+		return []ResolvedArtifact{{Name: name, Type: b.Config.Build.OutputType, Path: b.getOutputPath(outputDir)}}
+	}
+
+	var resolved []ResolvedArtifact
+	for _, a := range artifactConfigs(b.Config) {
+		resolved = append(resolved, ResolvedArtifact{Name: a.Name, Type: a.Type, Path: b.artifactPath(outputDir, a)})
+	}
+	return resolved
+}
+
+// GetOutputPath returns the main output of the current target: the output
+// of [build], or of the first binary, or the first library without binaries
+func (b *Builder) GetOutputPath() string {
+	return b.getOutputPath(b.targetOutputDir())
+}
+
+// targetArch returns the architecture built for: the one of the target
+// triple of the compiler, or of the host when it has none
+func (b *Builder) targetArch() string {
+	if arch, _, _ := strings.Cut(b.Compiler.GetVersionInfo().Target, "-"); arch != "" {
+		return arch
+	}
+	return platform.Arch()
+}
+
+// libraryVersion completes the version and soversion of a shared library,
+// either of which may be left out: the soversion defaults to the major
+// version, and the version to the soversion
+func libraryVersion(version, soversion string) (string, string) {
+	if soversion == "" {
+		soversion, _, _ = strings.Cut(version, ".")
+	}
+	if version == "" {
+		version = soversion
+	}
+	return version, soversion
+}
+
+// sharedLibNames returns the files of the shared library whose link name,
+// the one linkers look for, is outputPath: the library itself, then the
+// symlinks to it, each pointing at the one before. Versioned libraries are
+// libfoo.so.1.2.3, libfoo.so.1 and libfoo.so on Linux and libfoo.1.2.3.dylib,
+// libfoo.1.dylib and libfoo.dylib on macOS; on Windows, the version is
+// kept in a resource of the DLL instead.
+func (b *Builder) sharedLibNames(outputPath, version, soversion string) []string {
+	version, soversion = libraryVersion(version, soversion)
+	if version == "" || b.platformInfo.Platform == platform.PlatformWindows {
+		return []string{outputPath}
+	}
+
+	versioned := func(v string) string { return outputPath + "." + v }
+	if b.platformInfo.Platform == platform.PlatformMacOS {
+		base := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+		versioned = func(v string) string { return base + "." + v + filepath.Ext(outputPath) }
+	}
+
+	names := []string{versioned(version)}
+	if soversion != version {
+		names = append(names, versioned(soversion))
+	}
+	return append(names, outputPath)
+}
+
+// sharedLibVersionFlags returns the flags recording the soname of a shared
+// library, the name of names programs linking it load it by, and on macOS
+// its current and compatibility versions
+func (b *Builder) sharedLibVersionFlags(names []string, version, soversion string) []string {
+	version, soversion = libraryVersion(version, soversion)
+	if len(names) < 2 {
+		return nil
+	}
+
+	soname := filepath.Base(names[len(names)-2])
+	if b.platformInfo.Platform == platform.PlatformMacOS {
+		return []string{
+			"-Wl,-install_name,@rpath/" + soname,
+			"-Wl,-compatibility_version," + soversion,
+			"-Wl,-current_version," + version,
+		}
+	}
+	return []string{"-Wl,-soname," + soname}
+}
+
+// linkSharedLibNames points the symlinks of a versioned shared library,
+// names after the first, each at the name before it
+func linkSharedLibNames(names []string) error {
+	for i := 1; i < len(names); i++ {
+		if err := os.Remove(names[i]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to replace %s: %w", names[i], err)
+		}
+		if err := os.Symlink(filepath.Base(names[i-1]), names[i]); err != nil {
+			return fmt.Errorf("failed to link %s: %w", names[i], err)
+		}
+	}
+	return nil
+}
+
+// versionResourceStep returns the step compiling the version resource of
+// the DLL at outputPath, described by name, on Windows, with the resource
+// compiler of MSVC or windres. There is none elsewhere or without a
+// version.
+func (b *Builder) versionResourceStep(outputPath, name, version, soversion string) *Step {
+	version, _ = libraryVersion(version, soversion)
+	if version == "" || b.platformInfo.Platform != platform.PlatformWindows {
+		return nil
+	}
+
+	script := strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".rc"
+	task := &Task{ID: script}
+	if b.Compiler.GetVersionInfo().Vendor == "msvc" {
+		task.OutputFile = strings.TrimSuffix(script, ".rc") + ".res"
+		task.Command = "rc"
+		task.Args = []string{"/nologo", "/fo", task.OutputFile, script}
+	} else {
+		task.OutputFile = strings.TrimSuffix(script, ".rc") + ".res.o"
+		task.Command = b.compilerTool("windres", "windres")
+		task.Args = []string{"-i", script, "-O", "coff", "-o", task.OutputFile}
+	}
+
+	return &Step{
+		Kind:     StepResource,
+		Task:     task,
+		Reason:   recreated,
+		resource: versionResource(filepath.Base(outputPath), name, b.Config.Project.Name, version),
+	}
+}
+
+// versionResource returns the resource script of the version of a DLL, in
+// the form Windows shows in the properties of the file
+func versionResource(fileName, name, product, version string) string {
+	numbers := strings.Split(version, ".")
+	for len(numbers) < 4 {
+		numbers = append(numbers, "0")
+	}
+	binary := strings.Join(numbers, ",")
+
+	var s strings.Builder
+	s.WriteString("/* generated by styx; do not edit */\n")
+	s.WriteString("#include <winver.h>\n\n")
+	s.WriteString("1 VERSIONINFO\n")
+	fmt.Fprintf(&s, "FILEVERSION %s\nPRODUCTVERSION %s\n", binary, binary)
+	s.WriteString("FILEOS VOS_NT_WINDOWS32\nFILETYPE VFT_DLL\n")
+	s.WriteString("BEGIN\n  BLOCK \"StringFileInfo\"\n  BEGIN\n    BLOCK \"040904b0\"\n    BEGIN\n")
+	for _, value := range [][2]string{
+		{"FileDescription", name},
+		{"FileVersion", version},
+		{"InternalName", name},
+		{"OriginalFilename", fileName},
+		{"ProductName", product},
+		{"ProductVersion", version},
+	} {
+		fmt.Fprintf(&s, "      VALUE \"%s\", \"%s\"\n", value[0], strings.ReplaceAll(value[1], `"`, `""`))
+	}
+	s.WriteString("    END\n  END\n  BLOCK \"VarFileInfo\"\n  BEGIN\n    VALUE \"Translation\", 0x409, 1200\n  END\nEND\n")
+	return s.String()
+}
+
+// writeResource writes the script of a resource step, which its task
+// compiles
+func writeResource(step *Step) error {
+	if err := os.MkdirAll(filepath.Dir(step.Task.ID), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := os.WriteFile(step.Task.ID, []byte(step.resource), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", step.Task.ID, err)
+	}
+	return nil
+}
+
+// compileResource writes the script of a resource step and compiles it
+func (b *Builder) compileResource(step *Step) error {
+	if err := writeResource(step); err != nil {
+		return err
+	}
+	return b.runTask(step, "compiling version resource", "resource compilation failed", "")
+}
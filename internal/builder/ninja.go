@@ -0,0 +1,167 @@
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// ninjaRules are the rules of a generated build.ninja. Every edge carries
+// its full command line, the one styx itself would run, in $cmd.
+const ninjaRules = `rule cc
+  command = $cmd
+  description = CC $out
+  depfile = $out.d
+  deps = gcc
+
+rule link
+  command = $cmd
+  description = LINK $out
+
+rule ar
+  command = rm -f $out && $cmd
+  description = AR $out
+
+rule objcopy
+  command = $cmd
+  description = OBJCOPY $out
+
+rule gen
+  command = $cmd
+  description = GEN $out
+
+rule rc
+  command = $cmd
+  description = RC $out
+
+rule symlink
+  command = ln -sf $target $out
+  description = LN $out
+`
+
+// ninjaWriter accumulates the build statements of a ninja file
+type ninjaWriter struct {
+	strings.Builder
+}
+
+// build writes a build statement producing outputs from inputs with rule,
+// depending on implicit as well, and running task
+func (w *ninjaWriter) build(rule string, outputs, inputs, implicit []string, task *Task) {
+	w.WriteString("\nbuild")
+	for _, output := range outputs {
+		w.WriteString(" " + ninjaPath(output))
+	}
+	fmt.Fprintf(w, ": %s", rule)
+	for _, input := range inputs {
+		w.WriteString(" " + ninjaPath(input))
+	}
+	if len(implicit) > 0 {
+		w.WriteString(" |")
+		for _, input := range implicit {
+			w.WriteString(" " + ninjaPath(input))
+		}
+	}
+	fmt.Fprintf(w, "\n  cmd = %s\n", ninjaEscape(shellCommand(task.Command, task.Args)))
+}
+
+// GenerateNinja writes a build.ninja for the current target into the
+// output directory, with the commands of the steps of its plan, and returns
+// its path. Nothing is compiled.
+func (b *Builder) GenerateNinja() (string, error) {
+	if b.container != nil {
+		return "", fmt.Errorf("ninja files cannot be generated for container environments")
+	}
+
+	plan, err := b.Plan()
+	if err != nil {
+		return "", err
+	}
+
+	w := &ninjaWriter{}
+	warnedStrip := false
+	for _, step := range plan.Steps {
+		output := step.Task.OutputFile
+		switch step.Kind {
+		case StepCompile:
+			// the compiler writes a depfile next to every object, from which
+			// ninja learns the headers it includes
+			task := *step.Task
+			task.Args = append(append([]string{}, task.Args...), "-MMD", "-MF", output+".d")
+			w.build("cc", []string{output}, []string{task.SourceFile}, nil, &task)
+		case StepArchive:
+			w.build("ar", []string{output}, step.Inputs, nil, step.Task)
+		case StepLink, StepSharedLib:
+			w.build("link", []string{output}, step.Inputs, b.dependencyLibraries(), step.Task)
+			// the symlinks of a versioned shared library, each to the name before
+			for i := 1; i < len(step.names); i++ {
+				fmt.Fprintf(w, "\nbuild %s: symlink %s\n  target = %s\n", ninjaPath(step.names[i]), ninjaPath(step.names[i-1]), ninjaEscape(filepath.Base(step.names[i-1])))
+			}
+		case StepStrip:
+			// ninja cannot rewrite the output of another statement in place
+			if step.Task.OutputFile != step.Inputs[0] {
+				w.build("objcopy", []string{output}, step.Inputs, nil, step.Task)
+			} else if !warnedStrip {
+				b.logger.Warning("build.ninja does not strip outputs; strip them after building")
+				warnedStrip = true
+			}
+		case StepDeviceLink:
+			w.build("link", []string{output}, step.Inputs, nil, step.Task)
+		case StepResource:
+			// ninja cannot write the resource script, which does not change
+			if err := writeResource(step); err != nil {
+				return "", err
+			}
+			w.build("rc", []string{output}, []string{step.Task.ID}, nil, step.Task)
+		case StepImage:
+			w.build("objcopy", []string{output}, step.Inputs, nil, step.Task)
+		case StepGenerate:
+			w.build("gen", step.outputs, step.Inputs, nil, step.Task)
+		}
+	}
+
+	var file strings.Builder
+	file.WriteString("# generated by styx generate; regenerate it after changing styx.toml\n")
+	fmt.Fprintf(&file, "ninja_required_version = 1.3\nbuilddir = %s\n\n", ninjaEscape(b.OutputDir))
+	file.WriteString(ninjaRules)
+	file.WriteString(w.String())
+	file.WriteString("\ndefault")
+	for _, output := range plan.Outputs {
+		file.WriteString(" " + ninjaPath(output))
+	}
+	file.WriteString("\n")
+
+	path := filepath.Join(b.OutputDir, "build.ninja")
+	if err := platform.WriteFileAtomic(path, []byte(file.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write ninja file: %w", err)
+	}
+	return path, nil
+}
+
+// ninjaPath escapes a path for the build line of a ninja file
+func ninjaPath(path string) string {
+	return strings.NewReplacer("$", "$$", " ", "$ ", ":", "$:").Replace(path)
+}
+
+// ninjaEscape escapes a variable value of a ninja file
+func ninjaEscape(value string) string {
+	return strings.ReplaceAll(value, "$", "$$")
+}
+
+// shellSafe matches the arguments that need no quoting in a shell
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellCommand joins a command and its arguments into a shell command
+// line, quoting the arguments that need it
+func shellCommand(command string, args []string) string {
+	words := make([]string, 0, len(args)+1)
+	for _, word := range append([]string{command}, args...) {
+		if !shellSafe.MatchString(word) {
+			word = "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " ")
+}
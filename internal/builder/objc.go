@@ -0,0 +1,45 @@
+package builder
+
+import (
+	"fmt"
+	"sort"
+)
+
+// objcNames are the names of the Objective-C languages in messages
+var objcNames = map[string]string{"objective-c": "Objective-C", "objective-c++": "Objective-C++"}
+
+// isObjC reports whether language is Objective-C or Objective-C++
+func isObjC(language string) bool {
+	return language == "objective-c" || language == "objective-c++"
+}
+
+// objcFlags returns the flags Objective-C and Objective-C++ sources are
+// compiled with besides the configured ones: automatic reference counting
+// with objc_arc
+func (b *Builder) objcFlags(language string) []string {
+	if !isObjC(language) || !b.Config.Build.ObjCARC {
+		return nil
+	}
+	return []string{"-fobjc-arc"}
+}
+
+// checkObjC makes sure the compiler can compile the Objective-C languages,
+// which gcc is often built without, before the build fails on every source
+func (b *Builder) checkObjC(languages map[string]bool) error {
+	// SupportsLanguage runs the compiler of the host, not the one of the image
+	if b.container != nil {
+		return nil
+	}
+
+	var names []string
+	for language := range languages {
+		names = append(names, language)
+	}
+	sort.Strings(names)
+	for _, language := range names {
+		if !b.Compiler.SupportsLanguage(language) {
+			return fmt.Errorf("%s does not support %s", b.Compiler.GetName(), objcNames[language])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// object layouts: objects in a tree mirroring the sources, or all in the
+// object directory with a hash of their source in their name
+const (
+	ObjectLayoutMirror = "mirror"
+	ObjectLayoutHashed = "hashed"
+)
+
+// externalObjectDir is the directory, in the object directory, mirroring
+// the sources outside the project directory
+const externalObjectDir = "_ext"
+
+// getObjectFilePath calculates the path for an object file
+func (b *Builder) getObjectFilePath(sourceFile, outputDir string) string {
+	baseName := filepath.Base(sourceFile)
+	baseName = baseName[:len(baseName)-len(filepath.Ext(baseName))]
+	objectName := baseName + b.Compiler.GetObjectExtension()
+
+	if b.Config.Build.ObjectLayout == ObjectLayoutHashed {
+		sum := sha256.Sum256([]byte(filepath.ToSlash(sourcePath(sourceFile))))
+		return filepath.Join(outputDir, baseName+"-"+hex.EncodeToString(sum[:4])+b.Compiler.GetObjectExtension())
+	}
+	return filepath.Join(outputDir, mirroredDir(filepath.Dir(sourcePath(sourceFile))), objectName)
+}
+
+// sourcePath returns sourceFile relative to the working directory when it
+// is inside it, and absolute otherwise
+func sourcePath(sourceFile string) string {
+	abs, err := filepath.Abs(sourceFile)
+	if err != nil {
+		return filepath.Clean(sourceFile)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return abs
+	}
+	if rel, err := filepath.Rel(wd, abs); err == nil && isWithin(abs, wd) {
+		return rel
+	}
+	return abs
+}
+
+// mirroredDir returns the directory mirroring dir in the object directory:
+// dir itself when it is relative, and the absolute dir under _ext, with its
+// volume name kept as a directory, otherwise
+func mirroredDir(dir string) string {
+	if !filepath.IsAbs(dir) {
+		return dir
+	}
+	volume := filepath.VolumeName(dir)
+	rest := strings.TrimLeft(dir[len(volume):], `/\`)
+	volume = strings.Map(func(r rune) rune {
+		if r == ':' || r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, volume)
+	return filepath.Join(externalObjectDir, volume, rest)
+}
+
+// objectFilePaths returns the paths of the objects compiled from
+// sourceFiles into outputDir, failing when two sources would be compiled
+// to the same object
+func (b *Builder) objectFilePaths(sourceFiles []string, outputDir string) ([]string, error) {
+	objectFiles := make([]string, len(sourceFiles))
+	sources := make(map[string]string, len(sourceFiles))
+	for i, sourceFile := range sourceFiles {
+		objectFile := b.getObjectFilePath(sourceFile, outputDir)
+		if other, exists := sources[objectFile]; exists && other != sourceFile {
+			return nil, fmt.Errorf("sources %s and %s are both compiled to %s; set object_layout = %q in [build]", other, sourceFile, objectFile, ObjectLayoutHashed)
+		}
+		sources[objectFile] = sourceFile
+		objectFiles[i] = objectFile
+	}
+	return objectFiles, nil
+}
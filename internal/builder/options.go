@@ -0,0 +1,103 @@
+package builder
+
+import (
+	"runtime"
+
+	"github.com/deviceix/styx/internal/compiler"
+	"github.com/deviceix/styx/internal/logger"
+)
+
+// BuilderOptions configures the construction of a Builder. Zero values
+// select the defaults: the build directory, the jobs of the configuration
+// or one worker per CPU, no load limit, the cache directory of the project
+// state, a normal logger and the compiler of the configuration.
+// OutputLayout overrides the output layout of the configuration, and Verbose
+// makes the logger verbose, whether it is the default one or Logger.
+type BuilderOptions struct {
+	OutputDir    string
+	OutputLayout string
+	Jobs         int
+	MaxLoad      float64
+	CacheDir     string
+	Logger       *logger.Logger
+	Verbose      bool
+	Compiler     compiler.Compiler
+}
+
+// Option sets one of the BuilderOptions
+type Option func(*BuilderOptions)
+
+// WithOutputDir sets the directory outputs are written to
+func WithOutputDir(dir string) Option {
+	return func(o *BuilderOptions) {
+		o.OutputDir = dir
+	}
+}
+
+// WithOutputLayout sets how outputs are nested in the output directory:
+// target, platform or flat
+func WithOutputLayout(layout string) Option {
+	return func(o *BuilderOptions) {
+		o.OutputLayout = layout
+	}
+}
+
+// WithJobs sets the number of compile jobs run in parallel, overriding the
+// configuration; 0 runs one per CPU
+func WithJobs(jobs int) Option {
+	return func(o *BuilderOptions) {
+		if jobs <= 0 {
+			jobs = runtime.NumCPU()
+		}
+		o.Jobs = jobs
+	}
+}
+
+// WithMaxLoad keeps jobs from starting while the load average of the system
+// is at or above load, as long as another job is running
+func WithMaxLoad(load float64) Option {
+	return func(o *BuilderOptions) {
+		o.MaxLoad = load
+	}
+}
+
+// WithCacheDir sets the directory holding the build cache
+func WithCacheDir(dir string) Option {
+	return func(o *BuilderOptions) {
+		o.CacheDir = dir
+	}
+}
+
+// WithLogger sets the logger of the builder and its executor
+func WithLogger(log *logger.Logger) Option {
+	return func(o *BuilderOptions) {
+		o.Logger = log
+	}
+}
+
+// WithVerbose sets verbose output mode
+func WithVerbose(verbose bool) Option {
+	return func(o *BuilderOptions) {
+		o.Verbose = verbose
+	}
+}
+
+// newLogger returns the logger of the options, made verbose if they are
+func (o *BuilderOptions) newLogger() *logger.Logger {
+	if o.Logger == nil {
+		return logger.New(o.Verbose)
+	}
+	if o.Verbose {
+		o.Logger.SetVerbose(true)
+	}
+	return o.Logger
+}
+
+// WithCompiler uses the given compiler instead of detecting the one named
+// by the configuration. In a container environment, it has to be a
+// compiler of the image.
+func WithCompiler(comp compiler.Compiler) Option {
+	return func(o *BuilderOptions) {
+		o.Compiler = comp
+	}
+}
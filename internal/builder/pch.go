@@ -0,0 +1,154 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultStdlibHeaders are the standard headers precompiled by stdlib_pch
+// unless the project lists its own; they dominate the parse time of most
+// template-heavy translation units
+var defaultStdlibHeaders = []string{
+	"algorithm",
+	"array",
+	"format",
+	"functional",
+	"map",
+	"memory",
+	"optional",
+	"string",
+	"string_view",
+	"unordered_map",
+	"utility",
+	"variant",
+	"vector",
+}
+
+// stdlibPCHFlags returns the flags that make a C++ compilation with cFlags
+// use the precompiled standard headers, building them on first use. A PCH
+// only works with the flags it was built with, so one is kept for every
+// combination of compiler and flags under the target's pch directory.
+// Failing to build one only costs the speedup, so it is reported and the
+// sources are compiled without it.
+func (b *Builder) stdlibPCHFlags(cFlags []string) []string {
+	if !b.Config.Build.StdlibPCH {
+		return nil
+	}
+
+	// include directories do not affect the standard headers, so artifacts
+	// differing only in them share a PCH
+	cFlags = withoutIncludeDirs(cFlags)
+	content := stdlibPCHHeader(b.stdlibHeaders())
+	key := probeKey(b.compilerCommand(true), b.Compiler.GetVersion(), content, false, cFlags)
+	if flags, ok := b.pchFlags[key]; ok {
+		return flags
+	}
+
+	if b.pchFlags == nil {
+		b.pchFlags = make(map[string][]string)
+	}
+
+	flags, err := b.buildStdlibPCH(filepath.Join(b.targetOutputDir(), "pch", key[:16]), content, cFlags)
+	if err != nil {
+		b.logger.Warning("compiling without precompiled standard headers: %v", err)
+	}
+
+	b.pchFlags[key] = flags
+	return flags
+}
+
+// stdlibHeaders returns the standard headers to precompile
+func (b *Builder) stdlibHeaders() []string {
+	if len(b.Config.Build.StdlibPCHHeaders) > 0 {
+		return b.Config.Build.StdlibPCHHeaders
+	}
+	return defaultStdlibHeaders
+}
+
+// stdlibPCHHeader returns the header that is precompiled; every include is
+// guarded, so headers missing from older standard libraries are skipped
+func stdlibPCHHeader(headers []string) string {
+	var content strings.Builder
+	content.WriteString("/* generated by styx, do not edit */\n")
+	content.WriteString("#pragma once\n")
+	for _, header := range headers {
+		fmt.Fprintf(&content, "#if __has_include(<%s>)\n#include <%s>\n#endif\n", header, header)
+	}
+	return content.String()
+}
+
+// buildStdlibPCH precompiles content into dir unless an earlier build did,
+// and returns the flags including it
+func (b *Builder) buildStdlibPCH(dir, content string, cFlags []string) ([]string, error) {
+	header := filepath.Join(dir, "stdlib.hpp")
+
+	// GCC picks up header.gch by itself, Clang needs to be pointed at it
+	pch := header + ".gch"
+	flags := []string{"-include", header}
+	if b.isClang() {
+		pch = header + ".pch"
+		flags = []string{"-include-pch", pch}
+	}
+
+	if _, err := os.Stat(pch); err == nil {
+		return flags, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create pch directory: %w", err)
+	}
+
+	if err := os.WriteFile(header, []byte(content), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write pch header: %w", err)
+	}
+
+	b.logger.Info("precompiling standard headers...")
+
+	// written under a temporary name so that an interrupted build never
+	// leaves a truncated PCH behind
+	task := &Task{
+		ID:         pch,
+		Command:    b.compilerCommand(true),
+		Args:       append([]string{"-x", "c++-header", header, "-o", pch + ".tmp"}, cFlags...),
+		OutputFile: pch,
+	}
+
+	b.Executor.Submit(task)
+	result := b.waitForTask(task)
+	if result == nil || !result.Success {
+		os.Remove(pch + ".tmp")
+		if result != nil {
+			return nil, fmt.Errorf("failed to precompile standard headers: %v", result.Error)
+		}
+		return nil, fmt.Errorf("failed to precompile standard headers: unknown error")
+	}
+
+	if err := os.Rename(pch+".tmp", pch); err != nil {
+		return nil, fmt.Errorf("failed to store pch: %w", err)
+	}
+
+	b.logger.Success("standard headers precompiled in %.2f seconds", result.Duration.Seconds())
+	return flags, nil
+}
+
+// withoutIncludeDirs returns flags without its -I options
+func withoutIncludeDirs(flags []string) []string {
+	var filtered []string
+	for i := 0; i < len(flags); i++ {
+		switch {
+		case flags[i] == "-I":
+			i++
+		case strings.HasPrefix(flags[i], "-I"):
+		default:
+			filtered = append(filtered, flags[i])
+		}
+	}
+	return filtered
+}
+
+// isClang reports whether the project is built with Clang
+func (b *Builder) isClang() bool {
+	return strings.Contains(strings.ToLower(b.Compiler.GetName()), "clang")
+}
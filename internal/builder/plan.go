@@ -0,0 +1,725 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/deviceix/styx/internal/i18n"
+	"github.com/deviceix/styx/internal/logger"
+)
+
+// StepKind is the kind of command a build step runs
+type StepKind string
+
+const (
+	StepCompile    StepKind = "compile"
+	StepArchive    StepKind = "archive"
+	StepLink       StepKind = "link"
+	StepSharedLib  StepKind = "shared_lib"
+	StepImage      StepKind = "image"
+	StepGenerate   StepKind = "generate"
+	StepResource   StepKind = "resource"
+	StepDeviceLink StepKind = "device_link"
+	StepStrip      StepKind = "strip"
+)
+
+// recreated is the reason of the outputs whose inputs are unchanged, which
+// are created on every build all the same
+const recreated = "created on every build"
+
+// Step is one command of a build plan, producing Task.OutputFile from
+// Inputs once the steps in Needs are done. Reason tells why the step runs;
+// compilations whose object is current are UpToDate and skipped.
+type Step struct {
+	Kind     StepKind
+	Task     *Task
+	Inputs   []string
+	Needs    []*Step
+	Reason   string
+	UpToDate bool
+
+	artifact     string   // binary or library the step belongs to
+	cpp          bool     // link steps: C++ objects are linked, by the C++ driver
+	cFlags       []string // compile steps: to pick the precompiled headers
+	commandHash  string   // compile and generate steps: recorded in the cache
+	outputs      []string // generate steps: every file the rule creates
+	version      string   // shared library steps: the version of the library
+	soversion    string   // and the one of its ABI
+	names        []string // and the library followed by its symlinks
+	resource     string   // resource steps: the script compiled
+	reportUnused bool     // link steps: report the libraries left unused
+	unusedLibs   []string
+	unusedFlags  []string
+}
+
+// Plan is the work of building one target: its steps, each after the steps
+// it needs, and the outputs they produce
+type Plan struct {
+	Target  string
+	Steps   []*Step
+	Outputs []string
+}
+
+// Plan resolves the dependencies, runs the feature checks and computes the
+// steps building the current target, without running any of them. The
+// pre-build commands are not run, and the standard headers are precompiled
+// by Execute once a step needs them. The steps of the custom rules come
+// first.
+func (b *Builder) Plan() (*Plan, error) {
+	if err := b.resolveDependencies(); err != nil {
+		return nil, fmt.Errorf("failed to resolve dependencies: %w", err)
+	}
+
+	if err := b.runChecks(); err != nil {
+		return nil, fmt.Errorf("feature checks failed: %w", err)
+	}
+
+	plan := &Plan{Target: b.Target}
+	generated, err := b.planRules(plan)
+	if err != nil {
+		return nil, err
+	}
+	b.generated = generated
+
+	targetOutputDir := b.targetOutputDir()
+	if b.hasArtifacts() {
+		return plan, b.planArtifacts(plan, targetOutputDir, false)
+	}
+	return plan, b.planOutput(plan, targetOutputDir)
+}
+
+// Execute runs the steps of a plan made by Plan, skipping the compilations
+// that are up to date, and saves the build cache
+func (b *Builder) Execute(plan *Plan) error {
+	if err := b.startContainer(); err != nil {
+		return err
+	}
+	defer b.stopContainer()
+
+	if !b.sharedExecutor {
+		b.Executor.Start()
+		defer b.Executor.Shutdown()
+	}
+
+	if err := b.execute(plan); err != nil {
+		return err
+	}
+	b.saveScanGraph()
+	return b.Cache.Save()
+}
+
+// planOutput plans the compilation of the sources of [build] and the
+// creation of its single output
+func (b *Builder) planOutput(plan *Plan, targetOutputDir string) error {
+	b.logger.Info("finding source files...")
+	sourceFiles, err := b.findSources(b.Config.Build.Sources, b.Config.Build.Exclude)
+	if err != nil {
+		return fmt.Errorf("failed to find source files: %w", err)
+	}
+	sourceFiles = addGeneratedSources(sourceFiles, b.generated, "")
+
+	if len(sourceFiles) == 0 {
+		b.logger.Warning("no source files found. Check your sources configuration.")
+		return fmt.Errorf("no source files found")
+	}
+
+	b.logger.Info("found %d source files", len(sourceFiles))
+	if err := b.buildDependencyGraph(sourceFiles); err != nil {
+		return fmt.Errorf("failed to build dependency graph: %w", err)
+	}
+
+	compileSteps, objectFiles, err := b.planCompile("", sourceFiles, targetOutputDir, b.getCompilationFlags())
+	if err != nil {
+		return err
+	}
+	needGenerated(compileSteps, b.generated)
+	plan.Steps = append(plan.Steps, compileSteps...)
+
+	outputType := b.Config.Build.OutputType
+	deviceLink, err := b.deviceLinkStep(compileSteps, targetOutputDir, outputType)
+	if err != nil {
+		return err
+	}
+	if deviceLink != nil {
+		plan.Steps = append(plan.Steps, deviceLink)
+		objectFiles = append(objectFiles, deviceLink.Task.OutputFile)
+		compileSteps = append(compileSteps, deviceLink)
+	}
+
+	outputPath := b.getOutputPath(targetOutputDir)
+	if err := b.addOutputNode(outputPath, outputType, objectFiles); err != nil {
+		return fmt.Errorf("failed to add output node: %w", err)
+	}
+
+	output := &Step{
+		Inputs:    objectFiles,
+		Needs:     compileSteps,
+		cpp:       hasCppSources(sourceFiles),
+		version:   b.Config.Build.Version,
+		soversion: b.Config.Build.SOVersion,
+	}
+	linkFlags := b.linkMapFlags(outputPath)
+	if outputType == "executable" {
+		linkFlags = append(linkFlags, b.linkerScriptFlags()...)
+	}
+	if outputType != "static_lib" {
+		output.reportUnused = true
+		output.unusedLibs = b.dependencyLibraries()
+		output.unusedFlags = b.getLinkingFlags(output.cpp)
+	}
+
+	return b.planOutputSteps(plan, output, outputType, outputPath, linkFlags)
+}
+
+// planArtifacts adds the configured binaries and libraries to plan in link
+// order, or only the libraries when librariesOnly is set
+func (b *Builder) planArtifacts(plan *Plan, outputDir string, librariesOnly bool) error {
+	order, err := b.artifacts(outputDir)
+	if err != nil {
+		return err
+	}
+
+	producers := make(map[string]*Step)
+	for _, a := range order {
+		if librariesOnly && a.Type == "executable" {
+			continue
+		}
+
+		output, err := b.planArtifact(plan, a, outputDir, producers)
+		if err != nil {
+			return fmt.Errorf("%s: %w", a.Name, err)
+		}
+		producers[a.output] = output
+	}
+
+	return nil
+}
+
+// planArtifact plans the compilation of the sources of a single binary or
+// library and its link, or archive, step, which it returns
+func (b *Builder) planArtifact(plan *Plan, a *artifact, outputDir string, producers map[string]*Step) (*Step, error) {
+	sourceFiles, err := b.artifactSources(a)
+	if err != nil {
+		return nil, err
+	}
+	sourceFiles = addGeneratedSources(sourceFiles, b.generated, a.Name)
+
+	if len(sourceFiles) == 0 {
+		return nil, fmt.Errorf("no source files found")
+	}
+
+	if err := b.buildDependencyGraph(sourceFiles); err != nil {
+		return nil, fmt.Errorf("failed to build dependency graph: %w", err)
+	}
+
+	objectDir := artifactObjectDir(a, outputDir)
+	compileSteps, objectFiles, err := b.planCompile(a.Name, sourceFiles, objectDir, b.artifactCompilationFlags(a))
+	if err != nil {
+		return nil, err
+	}
+	needGenerated(compileSteps, b.generated)
+	plan.Steps = append(plan.Steps, compileSteps...)
+	deviceLink, err := b.deviceLinkStep(compileSteps, objectDir, a.Type)
+	if err != nil {
+		return nil, err
+	}
+	if deviceLink != nil {
+		deviceLink.artifact = a.Name
+		plan.Steps = append(plan.Steps, deviceLink)
+		objectFiles = append(objectFiles, deviceLink.Task.OutputFile)
+		compileSteps = append(compileSteps, deviceLink)
+	}
+
+	libs := linkedLibraries(a)
+	output := &Step{
+		artifact:  a.Name,
+		Inputs:    objectFiles,
+		Needs:     append([]*Step{}, compileSteps...),
+		cpp:       hasCppSources(sourceFiles),
+		version:   a.Version,
+		soversion: a.SOVersion,
+	}
+	if a.Type != "static_lib" {
+		output.Inputs = append(append([]string{}, objectFiles...), libs...)
+		for _, lib := range libs {
+			if producer := producers[lib]; producer != nil {
+				output.Needs = append(output.Needs, producer)
+				// the C++ objects of libraries need the C++ runtime
+				output.cpp = output.cpp || producer.cpp
+			}
+		}
+		output.reportUnused = true
+		output.unusedLibs = a.configuredLibraries(b.dependencyLibraries())
+		output.unusedFlags = append(b.getLinkingFlags(output.cpp), a.LinkerFlags...)
+	}
+
+	if err := b.addOutputNode(a.output, a.Type, output.Inputs); err != nil {
+		return nil, fmt.Errorf("failed to add output node: %w", err)
+	}
+
+	if err := b.planOutputSteps(plan, output, a.Type, a.output, b.artifactLinkFlags(a, libs)); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// planOutputSteps completes output as the step creating outputPath from its
+// inputs, followed by the image of an executable when one is configured,
+// and adds them to plan
+func (b *Builder) planOutputSteps(plan *Plan, output *Step, outputType, outputPath string, linkFlags []string) error {
+	switch outputType {
+	case "executable":
+		output.Kind = StepLink
+		output.Task = b.newLinkTask(output.Inputs, outputPath, linkFlags, output.cpp)
+	case "static_lib":
+		output.Kind = StepArchive
+		args := append(append(append([]string{}, b.getArchiverFlags()...), "rcs", outputPath), output.Inputs...)
+		output.Task = &Task{ID: outputPath, Command: b.archiverCommand(), Args: args, OutputFile: outputPath}
+	case "shared_lib":
+		output.Kind = StepSharedLib
+		output.names = b.sharedLibNames(outputPath, output.version, output.soversion)
+		linkFlags = append(linkFlags, b.sharedLibVersionFlags(output.names, output.version, output.soversion)...)
+		name := output.artifact
+		if name == "" {
+			name = b.Config.Project.Name
+		}
+		if resource := b.versionResourceStep(outputPath, name, output.version, output.soversion); resource != nil {
+			resource.artifact = output.artifact
+			plan.Steps = append(plan.Steps, resource)
+			output.Inputs = append(output.Inputs, resource.Task.OutputFile)
+			output.Needs = append(output.Needs, resource)
+		}
+		output.Task = b.newSharedLibTask(output.Inputs, output.names[0], linkFlags, output.cpp)
+	default:
+		return fmt.Errorf("unsupported output type: %s", outputType)
+	}
+
+	output.Reason = recreated
+	if _, err := os.Stat(outputPath); err != nil {
+		output.Reason = "output missing"
+	} else {
+		for _, need := range output.Needs {
+			if !need.UpToDate && need.Reason != recreated {
+				output.Reason = "inputs changed"
+				break
+			}
+		}
+	}
+	plan.Steps = append(plan.Steps, output)
+	plan.Outputs = append(plan.Outputs, outputPath)
+
+	last := output
+	for _, step := range b.stripSteps(output, outputType, outputPath) {
+		plan.Steps = append(plan.Steps, step)
+		if filepath.Ext(step.Task.OutputFile) == debugInfoExtension {
+			plan.Outputs = append(plan.Outputs, step.Task.OutputFile)
+		}
+		last = step
+	}
+
+	if outputType != "executable" {
+		return nil
+	}
+	if task := b.newImageTask(outputPath); task != nil {
+		plan.Steps = append(plan.Steps, &Step{
+			Kind:     StepImage,
+			Task:     task,
+			Inputs:   []string{outputPath},
+			Needs:    []*Step{last},
+			Reason:   output.Reason,
+			artifact: output.artifact,
+		})
+		plan.Outputs = append(plan.Outputs, task.OutputFile)
+	}
+	return nil
+}
+
+// planCompile plans the compilation of sourceFiles into outputDir, whose
+// dependencies are in the graph already, and returns the steps and the
+// objects they produce
+func (b *Builder) planCompile(artifact string, sourceFiles []string, outputDir string, cFlags []string) ([]*Step, []string, error) {
+	objcLanguages := make(map[string]bool)
+	cuda := false
+	for _, sourceFile := range sourceFiles {
+		if language := languageOf(sourceFile); isObjC(language) {
+			objcLanguages[language] = true
+		} else if language == "cuda" {
+			cuda = true
+		}
+	}
+	if err := b.checkObjC(objcLanguages); err != nil {
+		return nil, nil, err
+	}
+	if len(objcLanguages) > 0 {
+		b.hasObjCFiles = true
+	}
+	if cuda {
+		if err := b.checkCUDA(); err != nil {
+			return nil, nil, err
+		}
+		b.hasCUDAFiles = true
+	}
+
+	commandHashes := make(map[string]string)
+
+	objectFiles, err := b.objectFilePaths(sourceFiles, outputDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	checks := make(map[string][]string)
+	for i, sourceFile := range sourceFiles {
+		objectFile := objectFiles[i]
+		dependencies, err := b.addObjectNode(sourceFile, objectFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		checks[objectFile] = dependencies
+	}
+	b.Cache.Prehash(checks)
+
+	var steps []*Step
+	for i, sourceFile := range sourceFiles {
+		objectFile := objectFiles[i]
+		dependencies := checks[objectFile]
+
+		flags := b.sourceFlags(sourceFile, cFlags)
+		commandHash := b.sourceCommandHash(sourceFile, flags, commandHashes)
+		task := b.newCompileTask(sourceFile, objectFile, flags)
+		b.recordCompileCommand(task)
+
+		needsRebuild, reason := b.needsRebuild(objectFile, dependencies, commandHash)
+		if reason == "" {
+			b.logger.Warning("error checking if %s needs rebuild: %v", sourceFile, reason)
+			needsRebuild = true
+			reason = "error occurred"
+		}
+
+		steps = append(steps, &Step{
+			Kind:        StepCompile,
+			Task:        task,
+			Inputs:      dependencies,
+			Reason:      reason,
+			UpToDate:    !needsRebuild,
+			artifact:    artifact,
+			cFlags:      flags,
+			commandHash: commandHash,
+		})
+	}
+
+	return steps, objectFiles, nil
+}
+
+// execute runs the steps of plan in order on the running executor. The
+// compilations of every binary or library run in parallel.
+func (b *Builder) execute(plan *Plan) error {
+	for i := 0; i < len(plan.Steps); {
+		step := plan.Steps[i]
+		if step.Kind != StepCompile {
+			if err := b.runStep(step); err != nil {
+				return stepError(step, err)
+			}
+			if step.Kind == StepLink || step.Kind == StepSharedLib {
+				if err := b.notifyPlugins(eventPostLink, stepEvent(step, map[string]interface{}{
+					"output": step.Task.OutputFile,
+					"kind":   string(step.Kind),
+				})); err != nil {
+					return err
+				}
+			}
+			i++
+			continue
+		}
+
+		end := i + 1
+		for end < len(plan.Steps) && plan.Steps[end].Kind == StepCompile && plan.Steps[end].artifact == step.artifact {
+			end++
+		}
+
+		if step.artifact != "" {
+			b.logger.Info("building %s (%d source files)", step.artifact, end-i)
+		} else {
+			b.logger.Info("compiling source files...")
+		}
+		if err := b.compile(plan.Steps[i:end]); err != nil {
+			return stepError(step, err)
+		}
+		i = end
+	}
+	return nil
+}
+
+// stepErrors describe the failure of every kind of step
+var stepErrors = map[StepKind]string{
+	StepCompile:    "failed to compile source files",
+	StepArchive:    "failed to create static library",
+	StepLink:       "failed to link object files",
+	StepSharedLib:  "failed to create shared library",
+	StepGenerate:   "failed to run rule",
+	StepResource:   "failed to compile version resource",
+	StepDeviceLink: "failed to link device code",
+	StepStrip:      "failed to strip output",
+}
+
+// stepError wraps the error of a failed step, naming its binary or library
+func stepError(step *Step, err error) error {
+	if message, ok := stepErrors[step.Kind]; ok {
+		err = fmt.Errorf("%s: %w", message, err)
+	}
+	if step.artifact != "" {
+		err = fmt.Errorf("%s: %w", step.artifact, err)
+	}
+	return err
+}
+
+// scheduleCompilationTasks compiles the out of date sourceFiles into
+// outputDir and returns the objects of all of them
+func (b *Builder) scheduleCompilationTasks(sourceFiles []string, outputDir string, cFlags []string) ([]string, error) {
+	steps, objectFiles, err := b.planCompile("", sourceFiles, outputDir, cFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.compile(steps); err != nil {
+		return nil, err
+	}
+	return objectFiles, nil
+}
+
+// CompileError is the failure of the compilation of a source, with the
+// diagnostics the compiler reported about it
+type CompileError struct {
+	File        string
+	Diagnostics []logger.BuilderEvent
+	Err         error
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("Compilation of %s failed: %v", e.File, e.Err)
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// CompileErrors are the failures of the sources compiled together, which
+// errors.As finds each CompileError of
+type CompileErrors []*CompileError
+
+func (e CompileErrors) Error() string {
+	return fmt.Sprintf(i18n.T("compilation failed with %d errors"), len(e))
+}
+
+func (e CompileErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// compile runs the compile steps that are not up to date in parallel and
+// records the objects they produce in the cache
+func (b *Builder) compile(steps []*Step) error {
+	var tasks []*Task
+	var taskSteps []*Step
+
+	compiledCount := 0
+	b.logger.StartProgress(len(steps), "compiling")
+
+	for _, step := range steps {
+		sourceFile := step.Task.SourceFile
+		if b.rulesRan {
+			if err := b.refreshCompileStep(step); err != nil {
+				b.logger.StopProgress()
+				return err
+			}
+		}
+		if step.UpToDate {
+			compiledCount++
+			b.logger.UpdateProgress(compiledCount, fmt.Sprintf(i18n.T("Skipping %s (up to date)"), filepath.Base(sourceFile)))
+			if b.Verbose {
+				b.logger.Note("Skipping up-to-date file: %s", sourceFile)
+			}
+			continue
+		}
+
+		objDir := filepath.Dir(step.Task.OutputFile)
+		if err := os.MkdirAll(objDir, 0755); err != nil {
+			b.logger.StopProgress()
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+
+		tasks = append(tasks, step.Task)
+		taskSteps = append(taskSteps, step)
+	}
+
+	// the standard headers are only precompiled once something needs them
+	var pchFlags []string
+	pchPrepared := false
+	for i, task := range tasks {
+		if languageOf(task.SourceFile) != "c++" {
+			continue
+		}
+		if !pchPrepared {
+			pchFlags = b.stdlibPCHFlags(taskSteps[i].cFlags)
+			pchPrepared = true
+		}
+		task.Args = append(task.Args, pchFlags...)
+	}
+
+	cFlags := make(map[*Task][]string, len(tasks))
+	for i, task := range tasks {
+		cFlags[task] = taskSteps[i].cFlags
+	}
+	submitted := b.batchTasks(tasks)
+	preprocess := b.preprocessTasks(submitted, cFlags, len(pchFlags) > 0)
+	b.submitCompilations(b.longestFirst(submitted), preprocess)
+
+	var compilationErrors CompileErrors
+	var pluginErr error
+	for i, task := range tasks {
+		result := b.waitForTask(task)
+		if pre := preprocess[task]; pre != nil && result.Success {
+			result.Duration += pre.EndTime.Sub(pre.StartTime)
+			result.CPUTime += pre.CPUTime
+		}
+		compiledCount++
+		b.logger.UpdateProgress(compiledCount, fmt.Sprintf(i18n.T("Compiled %s"), filepath.Base(task.SourceFile)))
+
+		if result == nil || !result.Success {
+			if result != nil {
+				compilationErrors = append(compilationErrors, &CompileError{
+					File:        task.SourceFile,
+					Diagnostics: b.parseCompilerOutput(result.Error.Error(), task.SourceFile),
+					Err:         result.Error,
+				})
+			} else {
+				err := &CompileError{File: task.SourceFile, Err: errors.New("unknown error")}
+				compilationErrors = append(compilationErrors, err)
+				b.logger.Error("%v", err)
+			}
+			continue
+		}
+
+		if result.Stderr != "" {
+			b.parseCompilerOutput(result.Stderr, task.SourceFile)
+		}
+		if b.Verbose {
+			b.logger.Note("Compiled %s in %.2f seconds", filepath.Base(task.SourceFile), result.Duration.Seconds())
+		}
+
+		b.recordStep(StepCompile, task.SourceFile, result)
+
+		step := taskSteps[i]
+		if err := b.Cache.UpdateEntry(task.OutputFile, step.Inputs, step.commandHash, task.OutputFile, result.Duration); err != nil {
+			b.logger.Warning("Failed to update cache entry for %s: %v", task.SourceFile, err)
+		}
+
+		if err := b.notifyPlugins(eventPostCompile, stepEvent(step, map[string]interface{}{
+			"source":      task.SourceFile,
+			"object":      task.OutputFile,
+			"duration_ms": result.Duration.Milliseconds(),
+		})); err != nil && pluginErr == nil {
+			pluginErr = err
+		}
+	}
+
+	b.logger.StopProgress()
+	if len(compilationErrors) > 0 {
+		for _, err := range compilationErrors {
+			b.logger.Error("%v", err)
+		}
+		return compilationErrors
+	}
+	if pluginErr != nil {
+		return pluginErr
+	}
+
+	b.logger.Success("Compilation complete")
+	return nil
+}
+
+// runStep runs a link, archive, device link, strip, image, resource or rule
+// step
+func (b *Builder) runStep(step *Step) error {
+	outputPath := step.Task.OutputFile
+	var err error
+	switch step.Kind {
+	case StepLink:
+		b.logger.Info("linking executable: %s", filepath.Base(outputPath))
+		err = b.runTask(step, "linking executable", "linking failed", "linking complete")
+	case StepArchive:
+		b.logger.Info("creating static library: %s", filepath.Base(outputPath))
+		err = b.scheduleArchiveTask(step.Inputs, outputPath)
+	case StepSharedLib:
+		b.logger.Info("creating shared library: %s", filepath.Base(outputPath))
+		err = b.runTask(step, "Creating shared library", "shared library creation failed", "Shared library created")
+		if err == nil {
+			err = linkSharedLibNames(step.names)
+		}
+	case StepResource:
+		err = b.compileResource(step)
+	case StepStrip:
+		if filepath.Ext(outputPath) == debugInfoExtension {
+			b.logger.Info("splitting debug information: %s", filepath.Base(outputPath))
+			err = b.runTask(step, "splitting debug information", "splitting debug information failed", "")
+		} else {
+			b.logger.Info("stripping: %s", filepath.Base(outputPath))
+			err = b.runTask(step, "stripping", "stripping failed", "")
+		}
+	case StepDeviceLink:
+		b.logger.Info("linking device code: %s", filepath.Base(outputPath))
+		err = b.runTask(step, "linking device code", "device linking failed", "")
+	case StepImage:
+		b.logger.Info("creating %s image: %s", b.Config.Build.OutputFormat, filepath.Base(outputPath))
+		err = b.runTask(step, "creating image", "image creation failed", "")
+	case StepGenerate:
+		if step.UpToDate {
+			return nil
+		}
+		err = b.runRule(step)
+	default:
+		err = fmt.Errorf("unsupported step: %s", step.Kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	if step.reportUnused {
+		b.reportUnusedLibraries(outputPath, step.unusedLibs, step.unusedFlags)
+	}
+	return nil
+}
+
+// runTask runs the task of a link, image or rule step and waits for it
+func (b *Builder) runTask(step *Step, progress, failure, success string) error {
+	task := step.Task
+	outDir := filepath.Dir(task.OutputFile)
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	b.logger.StartProgress(1, progress)
+
+	b.Executor.Submit(task)
+	result := b.waitForTask(task)
+
+	b.logger.StopProgress()
+
+	if result == nil || !result.Success {
+		if result != nil {
+			b.logger.Error("%s: %v", failure, result.Error)
+			return fmt.Errorf("%s: %v", failure, result.Error)
+		}
+		b.logger.Error("%s: unknown error", failure)
+		return fmt.Errorf("%s: unknown error", failure)
+	}
+	b.recordStep(step.Kind, task.OutputFile, result)
+
+	if success != "" {
+		b.logger.Success("%s", success)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/deviceix/styx/internal/config"
+)
+
+// the events of a build plugins receive
+const (
+	eventPreBuild    = "pre-build"
+	eventPostCompile = "post-compile"
+	eventPostLink    = "post-link"
+	eventPostBuild   = "post-build"
+)
+
+// plugin is an external tool notified of the events of builds
+type plugin struct {
+	name    string
+	command string
+	args    []string
+	events  map[string]bool // nil for every event
+}
+
+// loadPlugins returns the plugins configured under [plugins], by name,
+// with their commands: the one configured, or styx-<name> on PATH
+func loadPlugins(cfg *config.Config) ([]*plugin, error) {
+	var names []string
+	for name := range cfg.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var plugins []*plugin
+	for _, name := range names {
+		pc := cfg.Plugins[name]
+		p := &plugin{name: name, command: pc.Command, args: pc.Args}
+		if p.command == "" {
+			path, err := exec.LookPath("styx-" + name)
+			if err != nil {
+				return nil, fmt.Errorf("plugin %s: styx-%s not found on PATH", name, name)
+			}
+			p.command = path
+		}
+		if len(pc.Events) > 0 {
+			p.events = make(map[string]bool)
+			for _, event := range pc.Events {
+				p.events[event] = true
+			}
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+// stepEvent adds the binary or library of step, if any, to the fields of
+// an event
+func stepEvent(step *Step, fields map[string]interface{}) map[string]interface{} {
+	if step.artifact != "" {
+		fields["artifact"] = step.artifact
+	}
+	return fields
+}
+
+// notifyPlugins runs the plugins receiving event, one after the other,
+// with the event and fields as a JSON object on stdin. What they print is
+// logged, and the first one to fail fails the event.
+func (b *Builder) notifyPlugins(event string, fields map[string]interface{}) error {
+	if len(b.plugins) == 0 {
+		return nil
+	}
+
+	record := map[string]interface{}{
+		"event":   event,
+		"project": b.Config.Project.Name,
+		"target":  b.Target,
+	}
+	for key, value := range fields {
+		record[key] = value
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to serialize %s event: %w", event, err)
+	}
+
+	for _, p := range b.plugins {
+		if p.events != nil && !p.events[event] {
+			continue
+		}
+
+		cmd := exec.Command(p.command, append(append([]string{}, p.args...), event)...)
+		cmd.Stdin = bytes.NewReader(append(data, '\n'))
+		output, err := cmd.CombinedOutput()
+		text := strings.TrimSpace(string(output))
+		if err != nil {
+			if text != "" {
+				err = fmt.Errorf("%w: %s", err, text)
+			}
+			return fmt.Errorf("plugin %s failed on %s: %w", p.name, event, err)
+		}
+		for _, line := range strings.Split(text, "\n") {
+			if line != "" {
+				b.logger.Info("%s: %s", p.name, line)
+			}
+		}
+	}
+	return nil
+}
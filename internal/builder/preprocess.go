@@ -0,0 +1,96 @@
+package builder
+
+import (
+	"bytes"
+)
+
+// preprocessedLanguages are the languages the compiler is told, with -x,
+// that preprocessed sources of C and C++ are in
+var preprocessedLanguages = map[string]string{
+	"c":   "cpp-output",
+	"c++": "c++-cpp-output",
+}
+
+// preprocessTasks returns, for the compile tasks of C and C++ sources, given
+// the flags of each, the tasks preprocessing their source into memory, and
+// makes them compile what those write instead of the source. Sources compiled
+// with the precompiled standard headers, which preprocessing would expand,
+// are left alone, as are the builds in a container, whose commands get no
+// input.
+func (b *Builder) preprocessTasks(tasks []*Task, cFlags map[*Task][]string, pch bool) map[*Task]*Task {
+	preprocess := make(map[*Task]*Task)
+	if !b.Config.Build.PreprocessAhead || b.container != nil {
+		return preprocess
+	}
+
+	for _, task := range tasks {
+		language := languageOf(task.SourceFile)
+		preprocessed, ok := preprocessedLanguages[language]
+		if !ok || (pch && language == "c++") {
+			continue
+		}
+
+		flags := cFlags[task]
+		pre := &Task{
+			ID:      "preprocess " + task.SourceFile,
+			Command: task.Command,
+			Args:    append([]string{"-E", task.SourceFile}, flags...),
+			Output:  &bytes.Buffer{},
+		}
+		task.Args = append([]string{"-x", preprocessed, "-c", "-", "-o", task.OutputFile}, flags...)
+		task.Input = pre.Output
+		preprocess[task] = pre
+	}
+	return preprocess
+}
+
+// submitCompilations submits the compile tasks in order. The sources of
+// those in preprocess are preprocessed up to one per worker ahead, so idle
+// workers read them while earlier ones compile, and every compilation is
+// submitted once its source is preprocessed. A failed preprocessing fails
+// its compilation without running it.
+func (b *Builder) submitCompilations(tasks []*Task, preprocess map[*Task]*Task) {
+	if len(preprocess) == 0 {
+		for _, task := range tasks {
+			b.Executor.Submit(task)
+		}
+		return
+	}
+
+	// the compilations are waited for before they are submitted
+	var queue []*Task
+	for _, task := range tasks {
+		task.CompleteCh = make(chan struct{})
+		if pre := preprocess[task]; pre != nil {
+			queue = append(queue, pre)
+		}
+	}
+
+	go func() {
+		submitted, done := 0, 0
+		for _, task := range tasks {
+			for submitted < len(queue) && submitted < done+b.Executor.WorkerCount {
+				b.Executor.Submit(queue[submitted])
+				submitted++
+			}
+
+			pre := preprocess[task]
+			if pre == nil {
+				b.Executor.Submit(task)
+				continue
+			}
+
+			result := b.Executor.WaitForTask(pre)
+			done++
+			if !result.Success {
+				task.Output = &bytes.Buffer{}
+				task.Error = result.Error
+				task.Stderr = result.Stderr
+				task.StartTime, task.EndTime = pre.StartTime, pre.StartTime
+				close(task.CompleteCh)
+				continue
+			}
+			b.Executor.Submit(task)
+		}
+	}()
+}
@@ -0,0 +1,249 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// Probe is a snippet of code compiled by TryCompile; Language is "c" or
+// "c++" and defaults to the project language
+type Probe struct {
+	Source   string
+	Language string
+	Link     bool
+	Flags    []string
+}
+
+// probeCache remembers the outcome of compiler probes across builds
+type probeCache struct {
+	path    string
+	results map[string]bool
+	changed bool
+	mutex   sync.Mutex
+}
+
+// newProbeCache loads the probe results stored at path, starting empty if
+// there are none
+func newProbeCache(path string) (*probeCache, error) {
+	cache := &probeCache{
+		path:    path,
+		results: make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read probe cache: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &cache.results); err != nil {
+		return nil, fmt.Errorf("failed to parse probe cache: %w", err)
+	}
+
+	return cache, nil
+}
+
+// lookup returns the recorded outcome of a probe
+func (c *probeCache) lookup(key string) (bool, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	result, ok := c.results[key]
+	return result, ok
+}
+
+// store records the outcome of a probe
+func (c *probeCache) store(key string, result bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.results[key] = result
+	c.changed = true
+}
+
+// save writes the cache back if a probe was recorded since it was loaded
+func (c *probeCache) save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.changed {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c.results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize probe cache: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	if err := platform.WriteFileAtomic(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write probe cache: %w", err)
+	}
+
+	c.changed = false
+	return nil
+}
+
+// loadProbes loads the probe cache unless it has been loaded already
+func (b *Builder) loadProbes() error {
+	if b.probes != nil {
+		return nil
+	}
+
+	cache, err := newProbeCache(filepath.Join(b.Config.StateDir(), "cache", "probes.json"))
+	if err != nil {
+		return err
+	}
+
+	b.probes = cache
+	return nil
+}
+
+// TryCompile reports whether a probe compiles, and also links when
+// requested, with the flags of the current target followed by its own. It
+// shares the cache of the feature checks, so repeated probes are free.
+func (b *Builder) TryCompile(probe Probe) (bool, error) {
+	if err := b.resolveDependencies(); err != nil {
+		return false, fmt.Errorf("failed to resolve dependencies: %w", err)
+	}
+
+	result, err := b.tryCompile(probe.Source, b.probeUsesCpp(probe.Language), probe.Link, b.probeFlags(probe.Link, probe.Flags))
+	if err != nil {
+		return false, err
+	}
+
+	if err := b.probes.save(); err != nil {
+		b.logger.Warning("%v", err)
+	}
+
+	return result, nil
+}
+
+// probeFlags returns the flags a probe is compiled with: those of the
+// current target, the linker flags when it is linked, then extra. Probes
+// are linked by the driver of their language, which brings its runtime.
+func (b *Builder) probeFlags(link bool, extra []string) []string {
+	flags := b.getCompilationFlags()
+	if link {
+		flags = append(flags, b.getLinkingFlags(false)...)
+	}
+	return append(flags, extra...)
+}
+
+// probeUsesCpp reports whether a probe in language is compiled as C++
+func (b *Builder) probeUsesCpp(language string) bool {
+	if language == "" {
+		language = b.Config.Project.Language
+	}
+	return baseLanguage(language) == "c++"
+}
+
+// languageOf returns the language of a source file from its extension
+func languageOf(path string) string {
+	switch filepath.Ext(path) {
+	case ".cpp", ".cc", ".cxx", ".C":
+		return "c++"
+	case ".c":
+		return "c"
+	case ".s", ".S", ".asm":
+		return "asm"
+	case ".m":
+		return "objective-c"
+	case ".mm":
+		return "objective-c++"
+	case ".cu":
+		return "cuda"
+	}
+	return ""
+}
+
+// baseLanguage returns the language Objective-C, Objective-C++ and CUDA
+// extend, C or C++, and other languages as they are
+func baseLanguage(language string) string {
+	switch language {
+	case "objective-c":
+		return "c"
+	case "objective-c++", "cuda":
+		return "c++"
+	}
+	return language
+}
+
+// tryCompile reports whether source compiles with flags, and also links
+// when link is set. Outcomes are cached by compiler, flags and source, so
+// a probe only ever runs once until one of them changes.
+func (b *Builder) tryCompile(source string, cpp, link bool, flags []string) (bool, error) {
+	if err := b.loadProbes(); err != nil {
+		return false, err
+	}
+
+	command := b.compilerCommand(cpp)
+	key := probeKey(command, b.Compiler.GetVersion(), source, link, flags)
+	if result, ok := b.probes.lookup(key); ok {
+		return result, nil
+	}
+
+	// probes have to be visible in the build container, if there is one
+	tempDir := ""
+	if b.container != nil {
+		tempDir = filepath.Join(b.Config.StateDir(), "tmp")
+		if err := os.MkdirAll(tempDir, 0755); err != nil {
+			return false, fmt.Errorf("failed to create probe directory: %w", err)
+		}
+	}
+	dir, err := os.MkdirTemp(tempDir, "styx-probe-")
+	if err != nil {
+		return false, fmt.Errorf("failed to create probe directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sourceFile := filepath.Join(dir, "probe.c")
+	if cpp {
+		sourceFile = filepath.Join(dir, "probe.cpp")
+	}
+	if err := os.WriteFile(sourceFile, []byte(source), 0644); err != nil {
+		return false, fmt.Errorf("failed to write probe source: %w", err)
+	}
+
+	args := []string{"-c", sourceFile, "-o", filepath.Join(dir, "probe"+b.Compiler.GetObjectExtension())}
+	if link {
+		args = []string{sourceFile, "-o", filepath.Join(dir, "probe"+b.Compiler.GetExecutableExtension())}
+	}
+	args = append(args, flags...)
+
+	// a failing compiler is the expected negative outcome; anything else,
+	// such as a missing compiler, is an error and is not cached
+	err = b.command(command, args...).Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return false, fmt.Errorf("failed to run %s: %w", command, err)
+	}
+
+	result := err == nil
+	b.probes.store(key, result)
+	return result, nil
+}
+
+// probeKey identifies a probe by everything that can change its outcome
+func probeKey(command, version, source string, link bool, flags []string) string {
+	hasher := sha256.New()
+	for _, part := range append([]string{command, version, source, fmt.Sprint(link)}, flags...) {
+		hasher.Write([]byte(part))
+		hasher.Write([]byte{0})
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
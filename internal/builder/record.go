@@ -0,0 +1,116 @@
+package builder
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/logger"
+	"github.com/deviceix/styx/internal/report"
+)
+
+// LastBuildPath returns the file keeping the record of the last build of
+// the project configured by cfg, which styx report renders
+func LastBuildPath(cfg *config.Config) string {
+	return filepath.Join(cfg.StateDir(), "last-build.json")
+}
+
+// severities are the names of the severities of compiler diagnostics
+var severities = map[logger.MessageType]string{
+	logger.TypeWarning: "warning",
+	logger.TypeError:   "error",
+	logger.TypeNote:    "note",
+	logger.TypeInfo:    "note",
+}
+
+// saveRecord writes the record of a build that started at start and ended
+// with outputs or err. Builds that failed before planning are recorded too,
+// without steps.
+func (b *Builder) saveRecord(start time.Time, plan *Plan, outputs []string, err error) {
+	record := &report.Build{
+		Project:     b.Config.Project.Name,
+		Version:     b.Config.Project.Version,
+		Target:      b.Target,
+		Compiler:    b.Compiler.GetName(),
+		Started:     start,
+		DurationMS:  time.Since(start).Milliseconds(),
+		Jobs:        b.Executor.WorkerCount,
+		Success:     err == nil,
+		Outputs:     outputs,
+		Steps:       []report.Step{},
+		Diagnostics: []report.Diagnostic{},
+		Graph:       b.graphSnapshot(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+		record.Outputs = nil
+	}
+	if record.Outputs == nil {
+		record.Outputs = []string{}
+	}
+
+	for _, t := range b.timings {
+		record.Steps = append(record.Steps, report.Step{Kind: string(t.kind), Name: t.name, WallMS: milliseconds(t.wall), CPUMS: milliseconds(t.cpu)})
+	}
+	if plan != nil {
+		for _, unit := range b.unitTimes(plan) {
+			if unit.cached {
+				record.Steps = append(record.Steps, report.Step{Kind: string(StepCompile), Name: unit.name, WallMS: milliseconds(unit.wall), UpToDate: true})
+				record.Cache.SavedMS += milliseconds(unit.wall)
+			}
+		}
+		for _, step := range plan.Steps {
+			if step.Kind == StepCompile {
+				record.Cache.Units++
+				if step.UpToDate {
+					record.Cache.Hits++
+				}
+			}
+		}
+	}
+
+	for _, event := range b.diagnostics {
+		record.Diagnostics = append(record.Diagnostics, report.Diagnostic{
+			Severity: severities[event.Type],
+			File:     event.Source,
+			Line:     event.Line,
+			Column:   event.Column,
+			Message:  event.Message,
+		})
+	}
+
+	if err := report.Save(LastBuildPath(b.Config), record); err != nil {
+		b.logger.Warning("%v", err)
+	}
+}
+
+// graphSnapshot returns the nodes and edges of the dependency graph, sorted
+// so that records are stable
+func (b *Builder) graphSnapshot() report.Graph {
+	graph := report.Graph{Nodes: []report.Node{}, Edges: []report.Edge{}}
+	if b.Graph == nil {
+		return graph
+	}
+	for id, node := range b.Graph.Nodes {
+		graph.Nodes = append(graph.Nodes, report.Node{ID: id, Type: node.Type.String()})
+		for _, dep := range node.Dependencies {
+			graph.Edges = append(graph.Edges, report.Edge{From: dep.ID, To: id})
+		}
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		return graph.Nodes[i].ID < graph.Nodes[j].ID
+	})
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		return graph.Edges[i].To < graph.Edges[j].To
+	})
+	return graph
+}
+
+// milliseconds returns d in milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
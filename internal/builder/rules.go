@@ -0,0 +1,247 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/dependency"
+)
+
+// generatedSources are the C and C++ sources created by the rules of a plan,
+// by the binary or library they are compiled into ("" for [build]), with
+// the step creating each of them
+type generatedSources struct {
+	byArtifact map[string][]string
+	producers  map[string]*Step
+}
+
+// planRules adds the steps running the custom rules to plan, one for every
+// input of a rule, and returns the sources they generate. A step is up to
+// date when all its outputs are, according to the cache.
+func (b *Builder) planRules(plan *Plan) (*generatedSources, error) {
+	generated := &generatedSources{
+		byArtifact: make(map[string][]string),
+		producers:  make(map[string]*Step),
+	}
+	if len(b.Config.Rules) == 0 {
+		return generated, nil
+	}
+
+	for i, rule := range b.Config.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i+1)
+		}
+
+		inputs, err := dependency.FindSourceFiles(rule.Inputs, nil)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+
+		for _, input := range inputs {
+			step, err := b.planRule(rule, input)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", name, err)
+			}
+
+			for _, output := range step.outputs {
+				if generated.producers[output] != nil {
+					return nil, fmt.Errorf("rule %s: %s is generated twice", name, output)
+				}
+				generated.producers[output] = step
+				if languageOf(output) != "" {
+					generated.byArtifact[rule.Artifact] = append(generated.byArtifact[rule.Artifact], output)
+				}
+			}
+			plan.Steps = append(plan.Steps, step)
+		}
+	}
+
+	return generated, nil
+}
+
+// planRule plans the command of rule creating its outputs from input, and
+// adds the outputs to the dependency graph
+func (b *Builder) planRule(rule config.RuleConfig, input string) (*Step, error) {
+	outputs := make([]string, len(rule.Outputs))
+	for i, output := range rule.Outputs {
+		outputs[i] = filepath.Clean(expandRule(output, input, nil))
+	}
+
+	var args []string
+	for _, field := range strings.Fields(rule.Command) {
+		if field == "{outputs}" {
+			args = append(args, outputs...)
+			continue
+		}
+		args = append(args, expandRule(field, input, outputs))
+	}
+
+	if err := b.addGeneratedNodes(input, outputs); err != nil {
+		return nil, err
+	}
+
+	// the outputs are part of the hash, so renaming them runs the rule again
+	commandHash := b.Cache.CalculateCommandHash("rule", append(append(append([]string{}, args...), outputs...), b.envDigest))
+	step := &Step{
+		Kind: StepGenerate,
+		Task: &Task{
+			ID:         "rule-" + outputs[0],
+			Command:    args[0],
+			Args:       args[1:],
+			OutputFile: outputs[0],
+		},
+		Inputs:      []string{input},
+		Reason:      "up to date",
+		UpToDate:    true,
+		commandHash: commandHash,
+		outputs:     outputs,
+	}
+	for _, output := range outputs {
+		if needsRebuild, reason := b.needsRebuild(output, step.Inputs, commandHash); needsRebuild {
+			step.Reason = reason
+			step.UpToDate = false
+			break
+		}
+	}
+	return step, nil
+}
+
+// expandRule replaces the placeholders of a rule in value: {input}, the
+// {name} of the input without directory and extension, its {dir} and the
+// first {output}
+func expandRule(value, input string, outputs []string) string {
+	base := filepath.Base(input)
+	pairs := []string{
+		"{input}", input,
+		"{name}", strings.TrimSuffix(base, filepath.Ext(base)),
+		"{dir}", filepath.Dir(input),
+	}
+	if len(outputs) > 0 {
+		pairs = append(pairs, "{output}", outputs[0])
+	}
+	return strings.NewReplacer(pairs...).Replace(value)
+}
+
+// addGeneratedNodes adds the outputs of a rule to the dependency graph,
+// depending on its input
+func (b *Builder) addGeneratedNodes(input string, outputs []string) error {
+	if err := b.Graph.AddNode(&dependency.Node{ID: input, Type: dependency.NodeTypeSource, Path: input}); err != nil {
+		if !errors.Is(err, dependency.ErrNodeExists) {
+			return fmt.Errorf("failed to add source node: %w", err)
+		}
+	}
+
+	for _, output := range outputs {
+		if err := b.Graph.AddNode(&dependency.Node{ID: output, Type: dependency.NodeTypeGenerated, Path: output}); err != nil {
+			if !errors.Is(err, dependency.ErrNodeExists) {
+				return fmt.Errorf("failed to add generated node: %w", err)
+			}
+		}
+		if err := b.Graph.AddDependency(output, input); err != nil {
+			return fmt.Errorf("failed to add dependency: %w", err)
+		}
+	}
+	return nil
+}
+
+// addGeneratedSources adds the sources generated for an artifact, or for
+// [build] when artifact is empty, to sourceFiles unless they are matched
+// by its patterns already
+func addGeneratedSources(sourceFiles []string, generated *generatedSources, artifact string) []string {
+	seen := make(map[string]bool, len(sourceFiles))
+	for _, sourceFile := range sourceFiles {
+		seen[filepath.Clean(sourceFile)] = true
+	}
+	for _, sourceFile := range generated.byArtifact[artifact] {
+		if !seen[sourceFile] {
+			sourceFiles = append(sourceFiles, sourceFile)
+		}
+	}
+	return sourceFiles
+}
+
+// needGenerated makes the compile steps of generated sources wait for the
+// rules creating them
+func needGenerated(steps []*Step, generated *generatedSources) {
+	for _, step := range steps {
+		if producer := generated.producers[filepath.Clean(step.Task.SourceFile)]; producer != nil {
+			step.Needs = append(step.Needs, producer)
+		}
+	}
+}
+
+// runRule runs the command of a rule step and records its outputs in the
+// cache. Sources may include what it generated, so the compile steps are
+// checked again once it ran.
+func (b *Builder) runRule(step *Step) error {
+	for _, output := range step.outputs {
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	b.logger.Info("generating %s from %s", strings.Join(step.outputs, ", "), step.Inputs[0])
+	if err := b.runTask(step, "running rule", "rule failed", ""); err != nil {
+		return err
+	}
+	// the includes of sources were looked up before the outputs existed
+	b.Cache.FS.Forget(step.outputs...)
+
+	for _, output := range step.outputs {
+		if _, err := os.Stat(output); err != nil {
+			return fmt.Errorf("rule did not create %s", output)
+		}
+		if err := b.Cache.UpdateEntry(output, step.Inputs, step.commandHash, output, 0); err != nil {
+			b.logger.Warning("failed to update cache entry for %s: %v", output, err)
+		}
+	}
+
+	b.rulesRan = true
+	return nil
+}
+
+// generate plans and runs the custom rules on their own, for the builds
+// that do not go through Plan
+func (b *Builder) generate() error {
+	plan := &Plan{Target: b.Target}
+	generated, err := b.planRules(plan)
+	if err != nil {
+		return err
+	}
+	b.generated = generated
+	return b.execute(plan)
+}
+
+// refreshCompileStep scans the source of a compile step again and decides
+// anew whether it is up to date, after a rule created files it may include
+func (b *Builder) refreshCompileStep(step *Step) error {
+	sourceFile := step.Task.SourceFile
+	objectFile := step.Task.OutputFile
+	_ = b.Graph.ClearDependencies(sourceFile)
+	_ = b.Graph.ClearDependencies(objectFile)
+	delete(b.scanned, sourceFile)
+	if producer := b.generated.producers[sourceFile]; producer != nil {
+		if err := b.Graph.AddDependency(sourceFile, producer.Inputs[0]); err != nil {
+			return fmt.Errorf("failed to add dependency: %w", err)
+		}
+	}
+
+	if err := b.scanSource(sourceFile); err != nil {
+		return err
+	}
+	dependencies, err := b.addObjectNode(sourceFile, objectFile)
+	if err != nil {
+		return err
+	}
+
+	needsRebuild, reason := b.needsRebuild(objectFile, dependencies, step.commandHash)
+	step.Inputs = dependencies
+	step.UpToDate = !needsRebuild
+	step.Reason = reason
+	return nil
+}
@@ -0,0 +1,34 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sanitizerFlags returns the flags instrumenting the current target with its
+// sanitizers; the compiler and the linker both need them
+func (b *Builder) sanitizerFlags() []string {
+	sanitizers := b.Config.Targets[b.Target].Sanitizers
+	if len(sanitizers) == 0 {
+		return nil
+	}
+
+	// frame pointers keep the stack traces of the reports complete
+	return []string{"-fsanitize=" + strings.Join(sanitizers, ","), "-fno-omit-frame-pointer"}
+}
+
+// checkSanitizers makes sure the compiler supports every sanitizer of the
+// current target, so an unsupported one fails before anything is compiled
+func (b *Builder) checkSanitizers() error {
+	// SupportsFlag runs the compiler of the host, not the one of the image
+	if b.container != nil {
+		return nil
+	}
+
+	for _, sanitizer := range b.Config.Targets[b.Target].Sanitizers {
+		if !b.Compiler.SupportsFlag("-fsanitize=" + sanitizer) {
+			return fmt.Errorf("%s does not support the %s sanitizer", b.Compiler.GetName(), sanitizer)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package builder
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// Shard is the part Index, from 1 to Count, of the work of a build split
+// across machines. The zero Shard is the whole build.
+type Shard struct {
+	Index int
+	Count int
+}
+
+// ParseShard parses a shard given as index/count, like 2/5
+func ParseShard(spec string) (Shard, error) {
+	index, count, found := strings.Cut(spec, "/")
+	i, err := strconv.Atoi(index)
+	if !found || err != nil {
+		return Shard{}, fmt.Errorf("invalid shard: %s (must be index/count, like 2/5)", spec)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 1 || i < 1 || i > n {
+		return Shard{}, fmt.Errorf("invalid shard: %s (index must be between 1 and the count)", spec)
+	}
+	return Shard{Index: i, Count: n}, nil
+}
+
+// String returns the shard as index/count
+func (s Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// Has reports whether the task or test id belongs to the shard. Ids are
+// spread by their hash, so every machine computes the same split.
+func (s Shard) Has(id string) bool {
+	if s.Count <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index-1
+}
+
+// SetShard makes builds compile, and tests run, only the part of the work
+// belonging to shard
+func (b *Builder) SetShard(shard Shard) {
+	b.shard = shard
+	if shard.Count > 1 {
+		b.Cache.Shard = fmt.Sprintf("%d-of-%d", shard.Index, shard.Count)
+	}
+}
+
+// sharded reports whether builds do only part of the work
+func (b *Builder) sharded() bool {
+	return b.shard.Count > 1
+}
+
+// shardPlan keeps the steps of plan belonging to the shard: its share of
+// the compilations and every rule, whose outputs may be compiled. Nothing
+// is linked, as the objects of the other shards are missing.
+func (b *Builder) shardPlan(plan *Plan) *Plan {
+	sharded := &Plan{Target: plan.Target}
+	units, mine := 0, 0
+	for _, step := range plan.Steps {
+		switch step.Kind {
+		case StepGenerate:
+			sharded.Steps = append(sharded.Steps, step)
+		case StepCompile:
+			units++
+			if b.shard.Has(step.Task.ID) {
+				mine++
+				sharded.Steps = append(sharded.Steps, step)
+			}
+		}
+	}
+	b.logger.Info("shard %s: compiling %d of %d translation units, linking is left to an unsharded build", b.shard, mine, units)
+	return sharded
+}
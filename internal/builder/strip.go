@@ -0,0 +1,84 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// deadCodeCompileFlags returns the compile flags placing every function and
+// object in its own section, so the linker can drop the unreferenced ones
+func (b *Builder) deadCodeCompileFlags() []string {
+	if !b.Config.Build.StripDeadCode {
+		return nil
+	}
+
+	// Mach-O already splits sections per symbol
+	if b.platformInfo.Platform == platform.PlatformMacOS {
+		return nil
+	}
+	return []string{"-ffunction-sections", "-fdata-sections"}
+}
+
+// deadCodeLinkFlags returns the linker flags discarding unreferenced
+// sections. MSVC would need /Gy and /OPT:REF, but it is not a supported
+// toolchain yet.
+func (b *Builder) deadCodeLinkFlags() []string {
+	if !b.Config.Build.StripDeadCode {
+		return nil
+	}
+
+	if b.platformInfo.Platform == platform.PlatformMacOS {
+		return []string{"-Wl,-dead_strip"}
+	}
+	return []string{"-Wl,--gc-sections"}
+}
+
+// outputSizes returns the sizes of the outputs left by the previous build,
+// which the build summary compares the new ones against
+func (b *Builder) outputSizes(targetOutputDir string) map[string]int64 {
+	if !b.Config.Build.StripDeadCode {
+		return nil
+	}
+
+	sizes := make(map[string]int64)
+	for _, a := range b.artifactsIn(targetOutputDir) {
+		if info, err := os.Stat(a.Path); err == nil {
+			sizes[a.Path] = info.Size()
+		}
+	}
+	return sizes
+}
+
+// describeSize formats the size of output and how it changed since the
+// previous build
+func describeSize(output string, previousSizes map[string]int64) string {
+	info, err := os.Stat(output)
+	if err != nil {
+		return "size unknown"
+	}
+
+	size := FormatSize(info.Size())
+	previous, ok := previousSizes[output]
+	if !ok || previous == info.Size() {
+		return size
+	}
+
+	delta := info.Size() - previous
+	if delta < 0 {
+		return fmt.Sprintf("%s, -%s since last build", size, FormatSize(-delta))
+	}
+	return fmt.Sprintf("%s, +%s since last build", size, FormatSize(delta))
+}
+
+// FormatSize formats a size in bytes for humans
+func FormatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
@@ -0,0 +1,142 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AllTargets returns the names of the configured targets, sorted
+func (b *Builder) AllTargets() []string {
+	targets := make([]string, 0, len(b.Config.Targets))
+	for name := range b.Config.Targets {
+		targets = append(targets, name)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// BuildTargets builds several targets in one go, like Build for each, and
+// returns their results in the same order. The builders of the targets
+// share the scanned dependency graph, the cache and the executor, and run
+// concurrently, each in its own output directory: only one of them works
+// at a time, but the others go on while it waits for its commands. Rules
+// and containers are shared by the targets as well, so their targets are
+// built one after the other. Every target writes its compilation database
+// to its own output directory, and the first one to the output directory
+// as well, for the tools looking for it there.
+func (b *Builder) BuildTargets(targets []string) ([]*BuildResult, error) {
+	for _, target := range targets {
+		if _, exists := b.Config.Targets[target]; !exists && target != "debug" {
+			return nil, fmt.Errorf("%w: %s", ErrTargetNotFound, target)
+		}
+	}
+	if len(targets) > 1 && b.OutputLayout == LayoutFlat {
+		return nil, fmt.Errorf("cannot build several targets with the %s output layout, where they share one output directory", LayoutFlat)
+	}
+
+	if !b.sharedExecutor {
+		b.Executor.Start()
+		defer b.Executor.Shutdown()
+	}
+	if b.TracePath != "" && !b.sharedExecutor {
+		start := time.Now()
+		b.Executor.StartTrace()
+		defer func() {
+			name := fmt.Sprintf("build %s (%s)", b.Config.Project.Name, strings.Join(targets, ", "))
+			if err := writeTrace(b.TracePath, name, start, time.Now(), b.Executor.TracedTasks()); err != nil {
+				b.logger.Warning("%v", err)
+				return
+			}
+			b.logger.Info("trace written to %s", b.TracePath)
+		}()
+	}
+
+	if b.scanned == nil {
+		b.scanned = make(map[string]bool)
+	}
+	lock := &sync.Mutex{}
+	concurrent := len(b.Config.Rules) == 0 && b.container == nil
+
+	results := make([]*BuildResult, len(targets))
+	errs := make([]error, len(targets))
+	builders := make([]*Builder, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		t := b.forTarget(target)
+		t.targetLock = lock
+		builders[i] = t
+		build := func() {
+			lock.Lock()
+			defer lock.Unlock()
+			if results[i], errs[i] = t.Build(); errs[i] != nil {
+				errs[i] = fmt.Errorf("target %s: %w", target, errs[i])
+			}
+		}
+
+		if !concurrent {
+			build()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			build()
+		}()
+	}
+	wg.Wait()
+
+	if len(builders) > 0 && len(builders[0].compileCommands) > 0 {
+		if _, err := builders[0].writeCompileCommands(b.OutputDir); err != nil {
+			b.logger.Warning("%v", err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// forTarget returns a builder of target sharing the configuration, the
+// compiler, the scanned dependency graph, the cache and the executor of b
+func (b *Builder) forTarget(target string) *Builder {
+	return &Builder{
+		Config:         b.Config,
+		Compiler:       b.Compiler,
+		Scanner:        b.Scanner,
+		Graph:          b.Graph,
+		Cache:          b.Cache,
+		Executor:       b.Executor,
+		Target:         target,
+		OutputDir:      b.OutputDir,
+		OutputLayout:   b.OutputLayout,
+		Verbose:        b.Verbose,
+		Profile:        b.Profile,
+		platformInfo:   b.platformInfo,
+		logger:         b.logger,
+		scanned:        b.scanned,
+		probes:         b.probes,
+		container:      b.container,
+		envDigest:      b.envDigest,
+		graphPath:      b.graphPath,
+		plugins:        b.plugins,
+		configChecked:  b.configChecked,
+		memberPackages: b.memberPackages,
+		sharedExecutor: true,
+	}
+}
+
+// waitForTask waits for task to complete. Builders building targets
+// together let the others work meanwhile.
+func (b *Builder) waitForTask(task *Task) *Result {
+	if b.targetLock == nil {
+		return b.Executor.WaitForTask(task)
+	}
+	b.targetLock.Unlock()
+	defer b.targetLock.Lock()
+	return b.Executor.WaitForTask(task)
+}
@@ -0,0 +1,336 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deviceix/styx/internal/i18n"
+)
+
+// TestResult is the outcome of running a single test executable
+type TestResult struct {
+	Name     string
+	Path     string
+	Passed   bool
+	ExitCode int
+	Output   string
+	Duration time.Duration
+}
+
+// testBinary is a test executable built from a single test source
+type testBinary struct {
+	name   string
+	source string
+	output string
+}
+
+// Test builds the test executables selected by names (all of them when names
+// is empty), runs them and returns their results
+func (b *Builder) Test(names []string) ([]TestResult, error) {
+	if err := b.startContainer(); err != nil {
+		return nil, err
+	}
+	defer b.stopContainer()
+
+	if !b.sharedExecutor {
+		b.Executor.Start()
+		defer b.Executor.Shutdown()
+	}
+
+	tests, err := b.buildTests(names)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.sharded() {
+		var mine []testBinary
+		for _, test := range tests {
+			if b.shard.Has(test.name) {
+				mine = append(mine, test)
+			}
+		}
+		b.logger.Info("shard %s: running %d of %d tests", b.shard, len(mine), len(tests))
+		tests = mine
+	}
+	return b.runTests(tests), nil
+}
+
+// buildTests builds the test executables selected by names, all of them when
+// names is empty, with the executor started
+func (b *Builder) buildTests(names []string) ([]testBinary, error) {
+	b.logger.Info("building tests for target: %s", b.Target)
+
+	targetOutputDir := b.targetOutputDir()
+	testOutputDir := filepath.Join(targetOutputDir, "tests")
+	if err := os.MkdirAll(testOutputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create test output directory: %w", err)
+	}
+
+	tests, err := b.discoverTests(names, testOutputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.resolveDependencies(); err != nil {
+		return nil, fmt.Errorf("failed to resolve dependencies: %w", err)
+	}
+
+	if err := b.runChecks(); err != nil {
+		return nil, fmt.Errorf("feature checks failed: %w", err)
+	}
+
+	// the files excluded here usually define main() for the project executable
+	exclude := append(append([]string{}, b.Config.Build.Exclude...), b.Config.Test.Exclude...)
+	projectSources, err := b.findSources(b.Config.Build.Sources, exclude)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find source files: %w", err)
+	}
+
+	testFlags := append(b.getCompilationFlags(), b.getTestCompilationFlags()...)
+	if b.hasArtifacts() {
+		// tests link against the project libraries instead of its sources
+		for _, lib := range b.Config.Libraries {
+			for _, dir := range lib.IncludeDirs {
+				testFlags = append(testFlags, "-I"+dir)
+			}
+		}
+	}
+	testFlags = b.mergeCompileFlags(testFlags)
+
+	supportSources, err := b.findSources(b.Config.Test.Support, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find test support files: %w", err)
+	}
+
+	testSources := make([]string, len(tests))
+	for i, test := range tests {
+		testSources[i] = test.source
+	}
+
+	allSources := append(append(append([]string{}, projectSources...), supportSources...), testSources...)
+	if err := b.buildDependencyGraph(allSources); err != nil {
+		return nil, fmt.Errorf("failed to build dependency graph: %w", err)
+	}
+
+	if err := b.generate(); err != nil {
+		return nil, err
+	}
+
+	var sharedObjects []string
+	cpp := hasCppSources(allSources)
+	if b.hasArtifacts() {
+		b.logger.Info("building project libraries...")
+		libs, libsCpp, err := b.buildArtifacts(targetOutputDir, true)
+		if err != nil {
+			return nil, err
+		}
+		cpp = cpp || libsCpp
+
+		// libraries are built before the ones linking them but have to
+		// follow them on the link line
+		for i := len(libs) - 1; i >= 0; i-- {
+			sharedObjects = append(sharedObjects, libs[i])
+		}
+	} else {
+		if len(b.Config.Rules) > 0 {
+			projectSources = addGeneratedSources(projectSources, b.generated, "")
+			cpp = cpp || hasCppSources(projectSources)
+			if err := b.buildDependencyGraph(projectSources); err != nil {
+				return nil, fmt.Errorf("failed to build dependency graph: %w", err)
+			}
+		}
+
+		b.logger.Info("compiling project sources...")
+		sharedObjects, err = b.scheduleCompilationTasks(projectSources, targetOutputDir, b.getCompilationFlags())
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile source files: %w", err)
+		}
+	}
+
+	objectDir := filepath.Join(testOutputDir, "obj")
+	if len(supportSources) > 0 {
+		b.logger.Info("compiling test support files...")
+		supportObjects, err := b.scheduleCompilationTasks(supportSources, objectDir, testFlags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile test support files: %w", err)
+		}
+		sharedObjects = append(sharedObjects, supportObjects...)
+	}
+
+	b.logger.Info("compiling tests...")
+	testObjects, err := b.scheduleCompilationTasks(testSources, objectDir, testFlags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile tests: %w", err)
+	}
+
+	if err := b.linkTests(tests, testObjects, sharedObjects, cpp); err != nil {
+		return nil, err
+	}
+
+	if err := b.Cache.Save(); err != nil {
+		b.logger.Warning("failed to save build cache: %v", err)
+	}
+	b.saveScanGraph()
+
+	return tests, nil
+}
+
+// discoverTests lists the test executables to build, one per test source,
+// each in its own output directory
+func (b *Builder) discoverTests(names []string, testOutputDir string) ([]testBinary, error) {
+	if len(b.Config.Test.Sources) == 0 {
+		return nil, fmt.Errorf("no test sources configured")
+	}
+
+	sources, err := b.findSources(b.Config.Test.Sources, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find test sources: %w", err)
+	}
+
+	found := make(map[string]string)
+	for _, source := range sources {
+		name := strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+		if existing, ok := found[name]; ok {
+			return nil, fmt.Errorf("duplicate test name %s: %s and %s", name, existing, source)
+		}
+		found[name] = source
+	}
+
+	if len(names) == 0 {
+		for name := range found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var tests []testBinary
+	for _, name := range names {
+		source, ok := found[name]
+		if !ok {
+			return nil, fmt.Errorf("test not found: %s", name)
+		}
+
+		tests = append(tests, testBinary{
+			name:   name,
+			source: source,
+			output: filepath.Join(testOutputDir, name, name+b.Compiler.GetExecutableExtension()),
+		})
+	}
+
+	if len(tests) == 0 {
+		return nil, fmt.Errorf("no test sources found")
+	}
+
+	return tests, nil
+}
+
+// linkTests links every test object with the shared project and support
+// objects into its own executable, with the C++ driver when cpp is set
+func (b *Builder) linkTests(tests []testBinary, testObjects, sharedObjects []string, cpp bool) error {
+	linkFlags := append(b.getLinkingFlags(cpp), b.Config.Test.LinkerFlags...)
+	linkFlags = append(linkFlags, b.runtimePathFlags(sharedObjects)...)
+	linkFlags = append(linkFlags, testFrameworkFlags(b.Config.Test.Framework)...)
+
+	compilerCmd := b.compilerCommand(cpp)
+
+	b.logger.StartProgress(len(tests), "linking tests")
+
+	tasks := make([]*Task, len(tests))
+	for i, test := range tests {
+		if err := os.MkdirAll(filepath.Dir(test.output), 0755); err != nil {
+			b.logger.StopProgress()
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+
+		inputs := append([]string{testObjects[i]}, sharedObjects...)
+		inputs = append(inputs, b.dependencyLibraries()...)
+		tasks[i] = &Task{
+			ID:         "link-test-" + test.name,
+			Command:    compilerCmd,
+			Args:       append(append(inputs, "-o", test.output), linkFlags...),
+			OutputFile: test.output,
+		}
+		b.Executor.Submit(tasks[i])
+	}
+
+	var failed []string
+	for i, task := range tasks {
+		result := b.waitForTask(task)
+		b.logger.UpdateProgress(i+1, fmt.Sprintf(i18n.T("linked %s"), tests[i].name))
+		if result == nil || !result.Success {
+			failed = append(failed, tests[i].name)
+		}
+	}
+
+	b.logger.StopProgress()
+	if len(failed) > 0 {
+		return fmt.Errorf("linking failed for tests: %s", strings.Join(failed, ", "))
+	}
+
+	b.logger.Success("linking complete")
+	return nil
+}
+
+// runTests runs the test executables in parallel and collects their results
+func (b *Builder) runTests(tests []testBinary) []TestResult {
+	b.logger.Info("running %d tests...", len(tests))
+
+	tasks := make([]*Task, len(tests))
+	for i, test := range tests {
+		tasks[i] = &Task{
+			ID:      "test-" + test.name,
+			Command: test.output,
+			Env:     b.testEnv(test),
+		}
+		b.Executor.Submit(tasks[i])
+	}
+
+	results := make([]TestResult, len(tests))
+	for i, task := range tasks {
+		result := b.waitForTask(task)
+		results[i] = TestResult{
+			Name:     tests[i].name,
+			Path:     tests[i].output,
+			Passed:   result.Success,
+			Output:   result.Output,
+			Duration: result.Duration,
+		}
+
+		if !result.Success {
+			results[i].ExitCode = -1
+			var exitErr *exec.ExitError
+			if errors.As(result.Error, &exitErr) {
+				results[i].ExitCode = exitErr.ExitCode()
+			}
+			results[i].Output += result.Error.Error()
+		}
+	}
+
+	return results
+}
+
+// getTestCompilationFlags gets the extra compilation flags for test sources
+func (b *Builder) getTestCompilationFlags() []string {
+	if baseLanguage(b.Config.Project.Language) == "c" {
+		return b.Config.Test.CFlags
+	}
+	return b.Config.Test.CXXFlags
+}
+
+// testFrameworkFlags returns the libraries a test framework links against
+func testFrameworkFlags(framework string) []string {
+	switch framework {
+	case "gtest":
+		return []string{"-lgtest_main", "-lgtest", "-pthread"}
+	case "catch2":
+		return []string{"-lCatch2Main", "-lCatch2"}
+	default:
+		return nil
+	}
+}
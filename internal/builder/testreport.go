@@ -0,0 +1,196 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// test report formats: JUnit XML, which most CI systems render, and the
+// Test Anything Protocol
+const (
+	TestReportJUnit = "junit"
+	TestReportTAP   = "tap"
+)
+
+// CheckTestReport checks that format is a known test report format
+func CheckTestReport(format string) error {
+	switch format {
+	case TestReportJUnit, TestReportTAP:
+		return nil
+	}
+	return fmt.Errorf("invalid test report format: %s (must be junit or tap)", format)
+}
+
+// junitSuites is the root of a JUnit XML report
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+// junitSuite is a suite of a JUnit XML report, all the tests of a run
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+// junitCase is a test executable in a JUnit XML report
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// junitFailure is the failure of a test in a JUnit XML report, with its
+// output as text
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteTestReport writes results to path in format, junit or tap, or to
+// the standard output when path is -. suite names the tests in the report,
+// usually after the project.
+func WriteTestReport(format, path, suite string, results []TestResult) error {
+	var buf bytes.Buffer
+	switch format {
+	case TestReportJUnit:
+		if err := writeJUnit(&buf, suite, results); err != nil {
+			return fmt.Errorf("failed to write JUnit report: %w", err)
+		}
+	case TestReportTAP:
+		writeTAP(&buf, results)
+	default:
+		return CheckTestReport(format)
+	}
+
+	if path == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	if err := platform.WriteFileAtomic(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write test report: %w", err)
+	}
+	return nil
+}
+
+// writeJUnit writes results as a JUnit XML report of a single suite. The
+// output of failed tests is the text of their failure, that of the others
+// their system-out.
+func writeJUnit(buf *bytes.Buffer, suite string, results []TestResult) error {
+	s := junitSuite{
+		Name:      suite,
+		Tests:     len(results),
+		Timestamp: time.Now().Format("2006-01-02T15:04:05"),
+	}
+	var total time.Duration
+	for _, result := range results {
+		total += result.Duration
+		c := junitCase{
+			Name:      result.Name,
+			ClassName: suite,
+			Time:      junitSeconds(result.Duration),
+		}
+		if result.Passed {
+			c.SystemOut = result.Output
+		} else {
+			s.Failures++
+			c.Failure = &junitFailure{
+				Message: failureMessage(result),
+				Type:    "failure",
+				Text:    result.Output,
+			}
+		}
+		s.Cases = append(s.Cases, c)
+	}
+	s.Time = junitSeconds(total)
+
+	root := junitSuites{
+		Name:     suite,
+		Tests:    s.Tests,
+		Failures: s.Failures,
+		Time:     s.Time,
+		Suites:   []junitSuite{s},
+	}
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	buf.WriteString("\n")
+	return nil
+}
+
+// junitSeconds formats d in seconds, as JUnit reports times
+func junitSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// writeTAP writes results in the version 13 of the Test Anything Protocol,
+// with the duration of every test, and the message and output of failed
+// ones, in its YAML block
+func writeTAP(buf *bytes.Buffer, results []TestResult) {
+	buf.WriteString("TAP version 13\n")
+	fmt.Fprintf(buf, "1..%d\n", len(results))
+	for i, result := range results {
+		status := "ok"
+		if !result.Passed {
+			status = "not ok"
+		}
+		fmt.Fprintf(buf, "%s %d - %s\n", status, i+1, tapDescription(result.Name))
+
+		buf.WriteString("  ---\n")
+		fmt.Fprintf(buf, "  duration_ms: %s\n", strconv.FormatFloat(float64(result.Duration.Microseconds())/1000, 'f', 3, 64))
+		if !result.Passed {
+			fmt.Fprintf(buf, "  message: %s\n", strconv.Quote(failureMessage(result)))
+			fmt.Fprintf(buf, "  exit_code: %d\n", result.ExitCode)
+			if output := strings.TrimRight(result.Output, "\n"); output != "" {
+				buf.WriteString("  output: |\n")
+				for _, line := range strings.Split(output, "\n") {
+					buf.WriteString("    " + strings.TrimRight(line, "\r") + "\n")
+				}
+			}
+		}
+		buf.WriteString("  ...\n")
+	}
+}
+
+// tapDescription returns name as the description of a TAP test line, where
+// # starts a directive
+func tapDescription(name string) string {
+	return strings.ReplaceAll(name, "#", `\#`)
+}
+
+// failureMessage describes why a test failed: its exit code, or the error
+// running it, the last line of its output, when it has none
+func failureMessage(result TestResult) string {
+	if result.ExitCode >= 0 {
+		return fmt.Sprintf("exit code %d", result.ExitCode)
+	}
+	output := strings.TrimRight(result.Output, "\n")
+	if i := strings.LastIndex(output, "\n"); i >= 0 {
+		output = output[i+1:]
+	}
+	if output == "" {
+		return "test failed"
+	}
+	return output
+}
@@ -0,0 +1,322 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/i18n"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// slowestShown is the number of translation units listed after a build
+const slowestShown = 5
+
+// stepTime is how long a command run by a build took
+type stepTime struct {
+	kind   StepKind
+	name   string // the source of compile steps, the output of the others
+	wall   time.Duration
+	cpu    time.Duration
+	cached bool // an up to date translation unit, timed when it was compiled
+}
+
+// recordStep adds a command that ran to the timings of the build
+func (b *Builder) recordStep(kind StepKind, name string, result *Result) {
+	b.timings = append(b.timings, stepTime{kind: kind, name: name, wall: result.Duration, cpu: result.CPUTime})
+}
+
+// unitTimes returns the compile times of the translation units of plan,
+// slowest first. The units that were up to date take the time of their last
+// compilation from the cache.
+func (b *Builder) unitTimes(plan *Plan) []stepTime {
+	ran := make(map[string]stepTime)
+	for _, t := range b.timings {
+		if t.kind == StepCompile {
+			ran[t.name] = t
+		}
+	}
+
+	var units []stepTime
+	for _, step := range plan.Steps {
+		if step.Kind != StepCompile {
+			continue
+		}
+		source := step.Task.SourceFile
+		if t, ok := ran[source]; ok {
+			units = append(units, t)
+			continue
+		}
+		if entry, ok := b.Cache.GetEntry(step.Task.OutputFile); ok && entry.CompilationTime > 0 {
+			units = append(units, stepTime{kind: StepCompile, name: source, wall: entry.CompilationTime, cached: true})
+		}
+	}
+
+	sort.SliceStable(units, func(i, j int) bool {
+		return units[i].wall > units[j].wall
+	})
+	return units
+}
+
+// criticalShare is the share of the wall clock time of a build above which
+// its critical path is what bounds it, rather than the number of workers
+const criticalShare = 0.8
+
+// criticalPath returns the chain of steps of plan, each needing the one
+// before it, whose commands took longest in all: the build cannot be faster
+// than that chain however many workers it has. Steps that did not run take
+// no time.
+func (b *Builder) criticalPath(plan *Plan) ([]stepTime, time.Duration) {
+	ran := make(map[string]stepTime)
+	for _, t := range b.timings {
+		ran[string(t.kind)+" "+t.name] = t
+	}
+	timeOf := func(step *Step) stepTime {
+		name := step.Task.OutputFile
+		if step.Kind == StepCompile {
+			name = step.Task.SourceFile
+		}
+		return ran[string(step.Kind)+" "+name]
+	}
+
+	// steps come after the steps they need
+	length := make(map[*Step]time.Duration)
+	previous := make(map[*Step]*Step)
+	var last *Step
+	for _, step := range plan.Steps {
+		for _, need := range step.Needs {
+			if length[need] > length[step] {
+				length[step] = length[need]
+				previous[step] = need
+			}
+		}
+		length[step] += timeOf(step).wall
+		if last == nil || length[step] > length[last] {
+			last = step
+		}
+	}
+	if last == nil {
+		return nil, 0
+	}
+
+	var path []stepTime
+	for step := last; step != nil; step = previous[step] {
+		if t := timeOf(step); t.wall > 0 {
+			path = append([]stepTime{t}, path...)
+		}
+	}
+	return path, length[last]
+}
+
+// reportCriticalPath lists the critical path of plan and tells what would
+// make the build faster: more workers, splitting the longest step of the
+// path, or neither when the time went elsewhere than into commands
+func (b *Builder) reportCriticalPath(plan *Plan, wall time.Duration) {
+	path, length := b.criticalPath(plan)
+	if len(path) == 0 {
+		return
+	}
+
+	b.logger.Info("critical path: %s of %s wall clock (%.0f%%)", formatSeconds(length), formatSeconds(wall), share(length, wall))
+	longest := path[0]
+	for _, t := range path {
+		b.logger.Note("%8s  %s %s", formatSeconds(t.wall), t.kind, t.name)
+		if t.wall > longest.wall {
+			longest = t
+		}
+	}
+
+	// the time the commands kept every worker busy
+	var busy time.Duration
+	for _, t := range b.timings {
+		busy += t.wall
+	}
+	busy /= time.Duration(max(b.Executor.WorkerCount, 1))
+
+	switch {
+	case length.Seconds() >= criticalShare*wall.Seconds():
+		b.logger.Info("the critical path bounds the build: more workers would not make it faster, splitting %s would", longest.name)
+	case busy > length:
+		b.logger.Info("the build took %.1fx its critical path: more workers could make it faster", wall.Seconds()/length.Seconds())
+	default:
+		b.logger.Info("the build spent most of its time between commands, more workers would not make it faster")
+	}
+}
+
+// longestFirst returns tasks in the order to submit them: the ones that took
+// longest to compile last time first, so that no long compilation starts
+// late and holds up the build while the other workers idle. Tasks compiled
+// for the first time are expected to take the average of the others.
+func (b *Builder) longestFirst(tasks []*Task) []*Task {
+	estimates := make(map[*Task]time.Duration, len(tasks))
+	var total time.Duration
+	for _, task := range tasks {
+		if entry, exists := b.Cache.GetEntry(task.OutputFile); exists && entry.CompilationTime > 0 {
+			estimates[task] = entry.CompilationTime
+			total += entry.CompilationTime
+		}
+	}
+	if len(estimates) == 0 {
+		return tasks
+	}
+
+	average := total / time.Duration(len(estimates))
+	for _, task := range tasks {
+		if _, exists := estimates[task]; !exists {
+			estimates[task] = average
+		}
+	}
+
+	ordered := append([]*Task{}, tasks...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return estimates[ordered[i]] > estimates[ordered[j]]
+	})
+	if b.Verbose {
+		b.logger.Note("compiling %s first (%s last time)", ordered[0].SourceFile, formatSeconds(estimates[ordered[0]]))
+	}
+	return ordered
+}
+
+// cpuTime returns the CPU time of the commands the build ran
+func (b *Builder) cpuTime() time.Duration {
+	var total time.Duration
+	for _, t := range b.timings {
+		total += t.cpu
+	}
+	return total
+}
+
+// reportTimings lists the slowest translation units of plan and compares
+// the wall clock time of the build with the CPU time of its commands. Builds
+// that compiled nothing have nothing to report.
+func (b *Builder) reportTimings(plan *Plan, wall time.Duration) {
+	compiled := false
+	for _, t := range b.timings {
+		compiled = compiled || t.kind == StepCompile
+	}
+	if !compiled {
+		return
+	}
+
+	units := b.unitTimes(plan)
+	if len(units) > slowestShown {
+		units = units[:slowestShown]
+	}
+	if len(units) > 0 {
+		b.logger.Info("slowest translation units:")
+		for _, unit := range units {
+			if unit.cached {
+				b.logger.Note("%8s  %s (up to date)", formatSeconds(unit.wall), unit.name)
+				continue
+			}
+			b.logger.Note("%8s  %s", formatSeconds(unit.wall), unit.name)
+		}
+	}
+
+	b.reportCriticalPath(plan, wall)
+
+	// the report written by writeProfile stays in English, this line does not
+	commands := fmt.Sprintf(i18n.T("%d commands"), len(b.timings))
+	if len(b.timings) == 1 {
+		commands = i18n.T("1 command")
+	}
+	cpu := b.cpuTime()
+	b.logger.Info("%s wall clock, %s CPU in %s (%.1fx parallelism)",
+		formatSeconds(wall), formatSeconds(cpu), commands, cpu.Seconds()/wall.Seconds())
+}
+
+// ReportsDir returns the directory timing reports are written to
+func ReportsDir(cfg *config.Config) string {
+	return filepath.Join(cfg.StateDir(), "reports")
+}
+
+// writeProfile writes the timing report of a build of plan to the reports
+// directory and returns its path. It lists every translation unit and every
+// other command the build ran.
+func (b *Builder) writeProfile(plan *Plan, start time.Time, wall time.Duration) (string, error) {
+	var report strings.Builder
+	cpu := b.cpuTime()
+	fmt.Fprintf(&report, "project:     %s\n", b.Config.Project.Name)
+	fmt.Fprintf(&report, "target:      %s\n", b.Target)
+	fmt.Fprintf(&report, "compiler:    %s\n", b.Compiler.GetName())
+	fmt.Fprintf(&report, "started:     %s\n", start.Format(time.RFC3339))
+	fmt.Fprintf(&report, "jobs:        %d\n", b.Executor.WorkerCount)
+	fmt.Fprintf(&report, "wall clock:  %s\n", formatSeconds(wall))
+	fmt.Fprintf(&report, "cpu:         %s in %s\n", formatSeconds(cpu), countCommands(len(b.timings)))
+	if wall > 0 {
+		fmt.Fprintf(&report, "parallelism: %.1fx\n", cpu.Seconds()/wall.Seconds())
+	}
+
+	units := b.unitTimes(plan)
+	var compiled time.Duration
+	for _, unit := range units {
+		if !unit.cached {
+			compiled += unit.wall
+		}
+	}
+
+	fmt.Fprintf(&report, "\ntranslation units, slowest first (%d):\n", len(units))
+	w := tabwriter.NewWriter(&report, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "wall\tcpu\tshare\t\tsource")
+	for _, unit := range units {
+		if unit.cached {
+			fmt.Fprintf(w, "%s\t-\t-\t\t%s (up to date, last compile)\n", formatSeconds(unit.wall), unit.name)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.1f%%\t\t%s\n", formatSeconds(unit.wall), formatSeconds(unit.cpu), share(unit.wall, compiled), unit.name)
+	}
+	w.Flush()
+
+	var others []stepTime
+	for _, t := range b.timings {
+		if t.kind != StepCompile {
+			others = append(others, t)
+		}
+	}
+	if len(others) > 0 {
+		fmt.Fprintf(&report, "\nother commands (%d):\n", len(others))
+		w = tabwriter.NewWriter(&report, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "wall\tcpu\tstep\t\toutput")
+		for _, t := range others {
+			fmt.Fprintf(w, "%s\t%s\t%s\t\t%s\n", formatSeconds(t.wall), formatSeconds(t.cpu), t.kind, t.name)
+		}
+		w.Flush()
+	}
+
+	dir := ReportsDir(b.Config)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create reports directory: %w", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("build-%s-%s.txt", b.Target, start.Format("20060102-150405")))
+	if err := platform.WriteFileAtomic(path, []byte(report.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write timing report: %w", err)
+	}
+	return path, nil
+}
+
+// countCommands returns "1 command" or "n commands"
+func countCommands(n int) string {
+	if n == 1 {
+		return "1 command"
+	}
+	return fmt.Sprintf("%d commands", n)
+}
+
+// share returns part as a percentage of total
+func share(part, total time.Duration) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * part.Seconds() / total.Seconds()
+}
+
+// formatSeconds formats a duration in seconds with two decimals, like the
+// other times styx prints
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.2fs", d.Seconds())
+}
@@ -0,0 +1,117 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// traceEvent is an event of the Chrome trace event format, which
+// about://tracing and Perfetto open. Times are in microseconds.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   int64                  `json:"ts"`
+	Dur  int64                  `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// writeTrace writes the tasks run between start and end to path as a
+// Chrome trace. Every worker of the executor is a thread of the trace, and
+// the thread 0 spans the whole build, named name, so the gaps between tasks
+// show where the build did something else than running commands.
+func writeTrace(path, name string, start, end time.Time, tasks []*Task) error {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].StartTime.Before(tasks[j].StartTime)
+	})
+	micros := func(t time.Time) int64 {
+		return t.Sub(start).Microseconds()
+	}
+
+	events := []traceEvent{
+		{Name: "process_name", Ph: "M", Pid: 1, Args: map[string]interface{}{"name": "styx"}},
+		{Name: "thread_name", Ph: "M", Pid: 1, Tid: 0, Args: map[string]interface{}{"name": "build"}},
+		{Name: name, Cat: "build", Ph: "X", Ts: 0, Dur: micros(end), Pid: 1, Tid: 0},
+	}
+
+	// tasks run outside the executor, with no worker, go on the build thread
+	workers := map[int]bool{0: true}
+	for _, task := range tasks {
+		tid := task.Worker + 1
+		if !workers[tid] {
+			workers[tid] = true
+			events = append(events, traceEvent{
+				Name: "thread_name", Ph: "M", Pid: 1, Tid: tid,
+				Args: map[string]interface{}{"name": fmt.Sprintf("worker %d", task.Worker)},
+			})
+		}
+
+		args := map[string]interface{}{
+			"id":      task.ID,
+			"command": strings.Join(append([]string{task.Command}, task.Args...), " "),
+			"cpu_ms":  task.CPUTime.Milliseconds(),
+			"success": task.Error == nil,
+		}
+		if task.OutputFile != "" {
+			args["output"] = task.OutputFile
+		}
+		events = append(events, traceEvent{
+			Name: traceName(task),
+			Cat:  filepath.Base(task.Command),
+			Ph:   "X",
+			Ts:   micros(task.StartTime),
+			Dur:  task.EndTime.Sub(task.StartTime).Microseconds(),
+			Pid:  1,
+			Tid:  tid,
+			Args: args,
+		})
+	}
+
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"traceEvents":     events,
+		"displayTimeUnit": "ms",
+	}, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to encode trace: %w", err)
+	}
+	if err := platform.WriteFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	return nil
+}
+
+// traceName names a task in a trace: compile tasks by their source, the
+// others by their output
+func traceName(task *Task) string {
+	switch {
+	case task.SourceFile != "":
+		return task.SourceFile
+	case task.OutputFile != "":
+		return filepath.Base(task.OutputFile)
+	default:
+		return task.ID
+	}
+}
+
+// saveTrace writes the trace of a build that started at start, when one was
+// asked for. Builders sharing the executor of a workspace leave it to the
+// workspace.
+func (b *Builder) saveTrace(start time.Time) {
+	if b.TracePath == "" || b.sharedExecutor {
+		return
+	}
+	name := fmt.Sprintf("build %s (%s)", b.Config.Project.Name, b.Target)
+	if err := writeTrace(b.TracePath, name, start, time.Now(), b.Executor.TracedTasks()); err != nil {
+		b.logger.Warning("%v", err)
+		return
+	}
+	b.logger.Info("trace written to %s", b.TracePath)
+}
@@ -0,0 +1,199 @@
+package builder
+
+import (
+	"bufio"
+	"debug/elf"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// linkMapFlags returns the flags writing a link map next to outputPath when
+// unused libraries are reported; the map tells which inputs the linker used
+func (b *Builder) linkMapFlags(outputPath string) []string {
+	if !b.Config.Build.ReportUnusedLibs || b.platformInfo.Platform != platform.PlatformLinux {
+		return nil
+	}
+	return []string{"-Wl,-Map," + outputPath + ".map"}
+}
+
+// reportUnusedLibraries warns about the libraries that contributed no
+// symbols to outputPath: the configured ones in libs, and those named with
+// -l in linkFlags. Static archives are unused when the link map shows no
+// member was pulled from them, shared libraries when the output imports
+// none of the symbols they export. The analysis needs ELF outputs and a
+// GNU style link map; anything else is skipped rather than guessed at.
+func (b *Builder) reportUnusedLibraries(outputPath string, libs, linkFlags []string) {
+	if !b.Config.Build.ReportUnusedLibs {
+		return
+	}
+
+	if b.platformInfo.Platform != platform.PlatformLinux {
+		b.logger.Note("unused library detection is only supported on linux")
+		return
+	}
+
+	loaded, pulled, err := readLinkMap(outputPath + ".map")
+	if err != nil {
+		b.logger.Warning("cannot detect unused libraries: %v", err)
+		return
+	}
+
+	imported, err := importedSymbols(outputPath)
+	if err != nil {
+		b.logger.Warning("cannot detect unused libraries: %v", err)
+		return
+	}
+
+	used := func(path string) (bool, bool) {
+		if filepath.Ext(path) == ".a" {
+			return pulled[filepath.Clean(path)], true
+		}
+		exported, err := exportedSymbols(path)
+		if err != nil {
+			// linker scripts such as libc.so stand in for the real libraries
+			return false, false
+		}
+		for _, symbol := range exported {
+			if imported[symbol] {
+				return true, true
+			}
+		}
+		return false, true
+	}
+
+	var unused []string
+	for _, lib := range libs {
+		if ok, known := used(lib); known && !ok {
+			unused = append(unused, lib)
+		}
+	}
+
+	for _, name := range linkedLibraryNames(linkFlags) {
+		analyzed, contributed := false, false
+		for _, path := range loaded {
+			if !strings.HasPrefix(filepath.Base(path), "lib"+name+".") {
+				continue
+			}
+			if ok, known := used(path); known {
+				analyzed = true
+				contributed = contributed || ok
+			}
+		}
+		if analyzed && !contributed {
+			unused = append(unused, "-l"+name)
+		}
+	}
+
+	for _, lib := range unused {
+		b.logger.Warning("%s: %s contributed no symbols; consider removing it", filepath.Base(outputPath), lib)
+	}
+}
+
+// linkedLibraryNames returns the libraries named with -l in flags, except
+// the C++ runtime styx links by itself
+func linkedLibraryNames(flags []string) []string {
+	var names []string
+	for i := 0; i < len(flags); i++ {
+		name := ""
+		switch {
+		case flags[i] == "-l" && i+1 < len(flags):
+			i++
+			name = flags[i]
+		case strings.HasPrefix(flags[i], "-l"):
+			name = strings.TrimPrefix(flags[i], "-l")
+		}
+
+		if name != "" && name != "stdc++" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// readLinkMap reads a GNU ld link map and returns the files loaded by the
+// link and the archives that had at least one member pulled in
+func readLinkMap(path string) ([]string, map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read link map: %w", err)
+	}
+	defer file.Close()
+
+	var loaded []string
+	pulled := make(map[string]bool)
+	inArchiveMembers := false
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.Contains(line, "to satisfy reference by file"):
+			inArchiveMembers = strings.HasPrefix(line, "Archive member included")
+		case strings.HasPrefix(line, "LOAD "):
+			loaded = append(loaded, strings.TrimPrefix(line, "LOAD "))
+		case inArchiveMembers && line != "" && !strings.HasPrefix(line, " "):
+			// members are listed as archive(member), followed by the reference
+			if open := strings.Index(line, "("); open > 0 {
+				pulled[filepath.Clean(line[:open])] = true
+			} else {
+				inArchiveMembers = false
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read link map: %w", err)
+	}
+
+	if len(loaded) == 0 {
+		return nil, nil, fmt.Errorf("unrecognized link map format: %s", path)
+	}
+
+	return loaded, pulled, nil
+}
+
+// importedSymbols returns the dynamic symbols an ELF output leaves undefined
+func importedSymbols(path string) (map[string]bool, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	symbols, err := file.ImportedSymbols()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read symbols of %s: %w", path, err)
+	}
+
+	imported := make(map[string]bool)
+	for _, symbol := range symbols {
+		imported[symbol.Name] = true
+	}
+	return imported, nil
+}
+
+// exportedSymbols returns the dynamic symbols an ELF shared library defines
+func exportedSymbols(path string) ([]string, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	symbols, err := file.DynamicSymbols()
+	if err != nil {
+		return nil, err
+	}
+
+	var exported []string
+	for _, symbol := range symbols {
+		if symbol.Section != elf.SHN_UNDEF {
+			exported = append(exported, symbol.Name)
+		}
+	}
+	return exported, nil
+}
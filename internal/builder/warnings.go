@@ -0,0 +1,72 @@
+package builder
+
+// gccEverything are the warnings GCC, which has no -Weverything, enables
+// for the everything level on top of the strict ones
+var gccEverything = []string{
+	"-Wshadow", "-Wconversion", "-Wsign-conversion", "-Wcast-qual", "-Wformat=2",
+	"-Wundef", "-Wnull-dereference", "-Wdouble-promotion",
+}
+
+// warningLevel returns the warning level of the current target and whether
+// its warnings are errors, the ones of the build unless the target
+// overrides them
+func (b *Builder) warningLevel() (string, bool) {
+	level, asErrors := b.Config.Build.Warnings, b.Config.Build.WarningsAsErrors
+	if target, ok := b.Config.Targets[b.Target]; ok {
+		if target.Warnings != "" {
+			level = target.Warnings
+		}
+		if target.WarningsAsErrors != nil {
+			asErrors = *target.WarningsAsErrors
+		}
+	}
+	return level, asErrors
+}
+
+// warningFlags returns the flags setting the warning level of the current
+// target, spelled the way the compiler knows them. They come before the
+// configured flags, which may enable or disable single warnings on top.
+func (b *Builder) warningFlags() []string {
+	level, asErrors := b.warningLevel()
+
+	var flags []string
+	if b.Compiler.GetVersionInfo().Vendor == "msvc" {
+		switch level {
+		case "none":
+			flags = append(flags, "/W0")
+		case "default":
+			flags = append(flags, "/W3")
+		case "strict":
+			flags = append(flags, "/W4")
+		case "everything":
+			flags = append(flags, "/Wall")
+		}
+		if asErrors {
+			flags = append(flags, "/WX")
+		}
+		return flags
+	}
+
+	switch level {
+	case "none":
+		flags = append(flags, "-w")
+	case "default":
+		flags = append(flags, "-Wall")
+	case "strict":
+		flags = append(flags, "-Wall", "-Wextra", "-Wpedantic")
+	case "everything":
+		if b.Compiler.GetVersionInfo().Vendor == "gcc" {
+			flags = append(append(flags, "-Wall", "-Wextra", "-Wpedantic"), gccEverything...)
+			break
+		}
+		flags = append(flags, "-Weverything")
+		if baseLanguage(b.Config.Project.Language) == "c++" {
+			// compatibility with C++98 is not what modern code is after
+			flags = append(flags, "-Wno-c++98-compat", "-Wno-c++98-compat-pedantic")
+		}
+	}
+	if asErrors {
+		flags = append(flags, "-Werror")
+	}
+	return flags
+}
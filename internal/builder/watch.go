@@ -0,0 +1,364 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/deviceix/styx/internal/dependency"
+)
+
+// watchDebounce is how long Watch waits for further changes before rebuilding,
+// so that saving several files at once triggers a single build
+const watchDebounce = 200 * time.Millisecond
+
+// watchedExtensions are the files whose changes trigger a rebuild
+var watchedExtensions = map[string]bool{
+	".c": true, ".cc": true, ".cpp": true, ".cxx": true, ".C": true, ".m": true, ".mm": true, ".cu": true,
+	".h": true, ".hh": true, ".hpp": true, ".hxx": true, ".inl": true, ".ipp": true, ".cuh": true,
+	".s": true, ".S": true, ".asm": true, ".inc": true,
+}
+
+// process is a running instance of the built executable
+type process struct {
+	cmd    *exec.Cmd
+	done   chan struct{}
+	killed atomic.Bool
+}
+
+// Watch builds the project, then rebuilds it whenever a source or header in
+// the configured directories changes, until interrupted. With run set, the
+// executable is restarted with args after every successful build. With a
+// reload command, the command runs through the shell after every successful
+// rebuild, for programs loading the shared libraries of the project to load
+// them again; ${output} in it is replaced by the paths of the libraries.
+func (b *Builder) Watch(run bool, reload string, args []string) error {
+	if run && b.Config.Build.OutputType != "executable" && len(b.Config.Binaries) == 0 {
+		return fmt.Errorf("cannot run non-executable output")
+	}
+	var libs []string
+	if reload != "" {
+		if libs = b.sharedLibraries(); len(libs) == 0 {
+			return fmt.Errorf("cannot reload without a shared library output")
+		}
+	}
+
+	var proc *process
+	rebuild := func(paths []string) bool {
+		proc.stop()
+		result, err := b.Build()
+		if err != nil {
+			b.logger.Error("build failed: %v", err)
+			return false
+		}
+
+		if run {
+			outputPath, _ := result.Executable("")
+			if proc, err = b.startProcess(outputPath, args); err != nil {
+				b.logger.Error("failed to start %s: %v", outputPath, err)
+			}
+		}
+		// the first build has nothing loaded yet to reload
+		if reload != "" && paths != nil {
+			b.reload(reload, libs)
+		}
+		return true
+	}
+
+	return b.watch(rebuild, func() { proc.stop() })
+}
+
+// sharedLibraries returns the absolute paths of the shared libraries among
+// the outputs of the current target
+func (b *Builder) sharedLibraries() []string {
+	var libs []string
+	for _, a := range b.ResolveArtifacts() {
+		if a.Type != "shared_lib" {
+			continue
+		}
+		if path, err := filepath.Abs(a.Path); err == nil {
+			libs = append(libs, path)
+		}
+	}
+	return libs
+}
+
+// reload runs the reload command of Watch through the shell, with ${output}
+// replaced by libs
+func (b *Builder) reload(command string, libs []string) {
+	command = strings.ReplaceAll(command, "${output}", strings.Join(libs, " "))
+	b.logger.Info("reloading: %s", command)
+
+	cmd := exec.Command("sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		b.logger.Warning("reload command failed: %v", err)
+	}
+}
+
+// watch calls rebuild, then calls it again with the changed paths whenever
+// files the build uses change, until interrupted, when it calls stop.
+// rebuild reports whether it succeeded: after a failure any change counts.
+func (b *Builder) watch(rebuild func(paths []string) bool, stop func()) error {
+	watcher := b.newFileWatcher(b.watchRoots())
+	defer func(watcher fileWatcher) {
+		_ = watcher.Close()
+	}(watcher)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	failed := !rebuild(nil)
+	b.logger.Info("watching for changes; press Ctrl+C to stop")
+
+	changed := make(map[string]bool)
+	var debounce <-chan time.Time
+	for {
+		select {
+		case path, ok := <-watcher.Changes():
+			if !ok {
+				return nil
+			}
+
+			changed[path] = true
+			debounce = time.After(watchDebounce)
+
+		case err, ok := <-watcher.Errors():
+			if !ok {
+				return nil
+			}
+			b.logger.Warning("file watcher error: %v", err)
+
+		case <-debounce:
+			paths := make([]string, 0, len(changed))
+			for path := range changed {
+				paths = append(paths, path)
+			}
+			changed = make(map[string]bool)
+			debounce = nil
+
+			// after a failed build any change may be the fix, such as a
+			// header that was missing; otherwise only those the build uses count
+			if !failed {
+				paths = b.affectedPaths(paths)
+				if len(paths) == 0 {
+					continue
+				}
+			}
+
+			sort.Strings(paths)
+			b.logger.Info("changed: %s", strings.Join(paths, ", "))
+			b.Invalidate(paths)
+			b.Cache.FS.Reset()
+
+			b.renewExecutor()
+			failed = !rebuild(paths)
+
+		case <-interrupt:
+			stop()
+			b.logger.Info("stopped watching")
+			return nil
+		}
+	}
+}
+
+// Invalidate drops the scanned includes of the given files and of every
+// source depending on them, so the next build rescans only those sources
+// and reuses the rest of the dependency graph
+func (b *Builder) Invalidate(paths []string) {
+	for _, path := range paths {
+		affected := []string{path}
+		for _, dependent := range b.Graph.GetDependents(path) {
+			affected = append(affected, dependent.ID)
+		}
+
+		for _, id := range affected {
+			node, exists := b.Graph.GetNode(id)
+			if !exists || (node.Type != dependency.NodeTypeSource && node.Type != dependency.NodeTypeGenerated) {
+				continue
+			}
+
+			_ = b.Graph.ClearDependencies(id)
+			delete(b.scanned, id)
+		}
+	}
+}
+
+// watchRoots returns the directories holding the configured sources and
+// include directories
+func (b *Builder) watchRoots() []string {
+	patterns := slices.Clone(b.Config.Build.Sources)
+	for _, a := range artifactConfigs(b.Config) {
+		patterns = append(patterns, a.Sources...)
+	}
+	if b.watchingTests {
+		patterns = append(append(patterns, b.Config.Test.Sources...), b.Config.Test.Support...)
+	}
+
+	var roots []string
+	for _, pattern := range patterns {
+		root := pattern
+		if i := strings.IndexAny(root, "*?["); i >= 0 {
+			root = root[:i]
+		}
+		roots = append(roots, filepath.Dir(root))
+	}
+
+	roots = append(roots, b.Config.Build.IncludeDirs...)
+	return append(roots, artifactIncludeDirs(b.Config)...)
+}
+
+// watchedSources returns the sources matched by the configured patterns,
+// and by those of the tests when they are watched
+func (b *Builder) watchedSources() map[string]bool {
+	patterns := slices.Clone(b.Config.Build.Sources)
+	exclude := slices.Clone(b.Config.Build.Exclude)
+	for _, a := range artifactConfigs(b.Config) {
+		patterns = append(patterns, a.Sources...)
+		exclude = append(exclude, a.Exclude...)
+	}
+
+	sources := make(map[string]bool)
+	if files, err := dependency.FindSourceFiles(patterns, exclude); err == nil {
+		for _, file := range files {
+			sources[filepath.Clean(file)] = true
+		}
+	}
+	if b.watchingTests {
+		tests := append(append([]string{}, b.Config.Test.Sources...), b.Config.Test.Support...)
+		if files, err := dependency.FindSourceFiles(tests, nil); err == nil {
+			for _, file := range files {
+				sources[filepath.Clean(file)] = true
+			}
+		}
+	}
+	return sources
+}
+
+// affectedPaths returns the changed paths that matter to the build: nodes
+// of the dependency graph and sources newly matched by the configured
+// patterns, including those below a directory that changed as a whole
+func (b *Builder) affectedPaths(changed []string) []string {
+	sources := b.watchedSources()
+
+	var affected []string
+	for _, path := range changed {
+		if _, exists := b.Graph.GetNode(path); exists || sources[path] {
+			affected = append(affected, path)
+			continue
+		}
+
+		if watchedExtensions[filepath.Ext(path)] {
+			continue
+		}
+
+		prefix := path + string(filepath.Separator)
+		for id, node := range b.Graph.Nodes {
+			if (node.Type == dependency.NodeTypeSource || node.Type == dependency.NodeTypeHeader) && strings.HasPrefix(id, prefix) {
+				affected = append(affected, id)
+			}
+		}
+		for source := range sources {
+			if _, exists := b.Graph.GetNode(source); !exists && strings.HasPrefix(source, prefix) {
+				affected = append(affected, source)
+			}
+		}
+	}
+
+	return affected
+}
+
+// walkWatchedDirs calls fn for root and every directory below it, skipping
+// hidden directories and the build output
+func (b *Builder) walkWatchedDirs(root string, fn func(dir string) error) error {
+	outputDir := filepath.Clean(b.OutputDir)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// directories may disappear while being walked
+			return nil
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if path != root && (strings.HasPrefix(info.Name(), ".") || filepath.Clean(path) == outputDir) {
+			return filepath.SkipDir
+		}
+
+		return fn(path)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", root, err)
+	}
+
+	return nil
+}
+
+// startProcess runs the built executable in the background
+func (b *Builder) startProcess(path string, args []string) (*process, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	proc := &process{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		err := cmd.Wait()
+		if !proc.killed.Load() {
+			if err != nil {
+				b.logger.Warning("%s exited: %v", filepath.Base(path), err)
+			} else {
+				b.logger.Note("%s exited", filepath.Base(path))
+			}
+		}
+		close(proc.done)
+	}()
+
+	return proc, nil
+}
+
+// stop kills the process if it is still running and waits for it to exit
+func (p *process) stop() {
+	if p == nil {
+		return
+	}
+
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+
+	p.killed.Store(true)
+	_ = p.cmd.Process.Kill()
+	<-p.done
+}
+
+// renewExecutor replaces the executor with a new one set up the same way,
+// since an executor cannot be restarted once it has been shut down
+func (b *Builder) renewExecutor() {
+	executor := NewExecutor(b.Executor.WorkerCount)
+	executor.SetLogger(b.logger)
+	executor.container = b.container
+	executor.env = b.Executor.env
+	executor.maxLoad = b.Executor.maxLoad
+	b.Executor = executor
+}
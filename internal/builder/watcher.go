@@ -0,0 +1,298 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchPollInterval is how often the polling watcher rescans the watched trees
+const watchPollInterval = 500 * time.Millisecond
+
+// fileWatcher reports the paths of changed sources and headers below a set of
+// directory trees; directories created, removed or renamed are reported by
+// their own path
+type fileWatcher interface {
+	Changes() <-chan string
+	Errors() <-chan error
+	Close() error
+}
+
+// newFileWatcher watches roots with the native backend of the platform
+// (inotify, FSEvents or ReadDirectoryChangesW), falling back to polling
+// where it is unavailable or runs out of watches
+func (b *Builder) newFileWatcher(roots []string) fileWatcher {
+	watcher, err := b.newNativeWatcher(roots)
+	if err == nil {
+		return watcher
+	}
+
+	b.logger.Warning("%v; polling for changes instead", err)
+	return b.newPollingWatcher(roots)
+}
+
+// nativeWatcher is a fileWatcher backed by fsnotify
+type nativeWatcher struct {
+	builder *Builder
+	watcher *fsnotify.Watcher
+	roots   []string
+	changes chan string
+	errors  chan error
+	done    chan struct{}
+}
+
+// newNativeWatcher starts watching roots and every directory below them
+func (b *Builder) newNativeWatcher(roots []string) (*nativeWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &nativeWatcher{
+		builder: b,
+		watcher: watcher,
+		roots:   make([]string, len(roots)),
+		changes: make(chan string),
+		errors:  make(chan error),
+		done:    make(chan struct{}),
+	}
+
+	for i, root := range roots {
+		root = filepath.Clean(root)
+		w.roots[i] = root
+		if err := w.watchTree(root); err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+
+		// the parent reports the root itself being moved away or back
+		if parent := filepath.Dir(root); root != "." && parent != root {
+			_ = watcher.Add(parent)
+		}
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// run translates fsnotify events into changed paths until the watcher is closed
+func (w *nativeWatcher) run() {
+	defer close(w.changes)
+	defer close(w.errors)
+
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+
+			path := filepath.Clean(event.Name)
+
+			// new directories, including ones moved into a watched tree, are
+			// not watched automatically
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(path); err == nil && info.IsDir() {
+					if !w.inRoots(path) {
+						continue
+					}
+					if err := w.watchTree(path); err != nil {
+						w.sendError(err)
+					}
+					w.send(path)
+					continue
+				}
+			}
+
+			// a directory moved or deleted takes its files with it; watches
+			// below it would keep reporting the old paths
+			if event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
+				if w.unwatchTree(path) || w.isRoot(path) {
+					w.send(path)
+					continue
+				}
+			}
+
+			if !watchedExtensions[filepath.Ext(path)] || event.Op == fsnotify.Chmod {
+				continue
+			}
+			w.send(path)
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.sendError(err)
+		}
+	}
+}
+
+// send reports a changed path unless the watcher is being closed
+func (w *nativeWatcher) send(path string) {
+	select {
+	case w.changes <- path:
+	case <-w.done:
+	}
+}
+
+// sendError reports an error unless the watcher is being closed
+func (w *nativeWatcher) sendError(err error) {
+	select {
+	case w.errors <- err:
+	case <-w.done:
+	}
+}
+
+// watchTree adds root and every directory below it to the watcher
+func (w *nativeWatcher) watchTree(root string) error {
+	return w.builder.walkWatchedDirs(root, func(dir string) error {
+		return w.watcher.Add(dir)
+	})
+}
+
+// unwatchTree removes the watches on root and the directories below it,
+// reporting whether there were any
+func (w *nativeWatcher) unwatchTree(root string) bool {
+	watched := false
+	prefix := root + string(filepath.Separator)
+	for _, dir := range w.watcher.WatchList() {
+		if dir == root || strings.HasPrefix(dir, prefix) {
+			_ = w.watcher.Remove(dir)
+			watched = true
+		}
+	}
+	return watched
+}
+
+// isRoot reports whether path is one of the watched roots
+func (w *nativeWatcher) isRoot(path string) bool {
+	for _, root := range w.roots {
+		if path == root {
+			return true
+		}
+	}
+	return false
+}
+
+// inRoots reports whether path is one of the watched roots or below one;
+// the parents of the roots are watched too, but not their other children
+func (w *nativeWatcher) inRoots(path string) bool {
+	for _, root := range w.roots {
+		if root == "." || path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *nativeWatcher) Changes() <-chan string { return w.changes }
+func (w *nativeWatcher) Errors() <-chan error   { return w.errors }
+
+func (w *nativeWatcher) Close() error {
+	close(w.done)
+	return w.watcher.Close()
+}
+
+// pollingWatcher is a fileWatcher comparing snapshots of the watched trees
+type pollingWatcher struct {
+	builder *Builder
+	roots   []string
+	changes chan string
+	errors  chan error
+	done    chan struct{}
+}
+
+// fileState is what the polling watcher compares to detect a change
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// newPollingWatcher starts polling roots for changes
+func (b *Builder) newPollingWatcher(roots []string) *pollingWatcher {
+	w := &pollingWatcher{
+		builder: b,
+		roots:   roots,
+		changes: make(chan string),
+		errors:  make(chan error),
+		done:    make(chan struct{}),
+	}
+
+	go w.run()
+	return w
+}
+
+// run rescans the watched trees every watchPollInterval and reports files
+// that were added, removed or modified since the previous scan
+func (w *pollingWatcher) run() {
+	defer close(w.changes)
+	defer close(w.errors)
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	previous := w.snapshot()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		current := w.snapshot()
+		for path, state := range current {
+			if old, ok := previous[path]; !ok || old != state {
+				w.send(path)
+			}
+		}
+		for path := range previous {
+			if _, ok := current[path]; !ok {
+				w.send(path)
+			}
+		}
+		previous = current
+	}
+}
+
+// send reports a changed path unless the watcher is being closed
+func (w *pollingWatcher) send(path string) {
+	select {
+	case w.changes <- path:
+	case <-w.done:
+	}
+}
+
+// snapshot records the state of every watched file below the roots
+func (w *pollingWatcher) snapshot() map[string]fileState {
+	files := make(map[string]fileState)
+	for _, root := range w.roots {
+		_ = w.builder.walkWatchedDirs(root, func(dir string) error {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return nil
+			}
+
+			for _, entry := range entries {
+				if entry.IsDir() || !watchedExtensions[filepath.Ext(entry.Name())] {
+					continue
+				}
+				if info, err := entry.Info(); err == nil {
+					files[filepath.Join(dir, entry.Name())] = fileState{info.ModTime(), info.Size()}
+				}
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+func (w *pollingWatcher) Changes() <-chan string { return w.changes }
+func (w *pollingWatcher) Errors() <-chan error   { return w.errors }
+
+func (w *pollingWatcher) Close() error {
+	close(w.done)
+	return nil
+}
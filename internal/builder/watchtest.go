@@ -0,0 +1,185 @@
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deviceix/styx/internal/dependency"
+)
+
+// WatchTests runs the tests selected by names, all of them when names is
+// empty, then rebuilds them whenever a file they use changes and runs those
+// whose dependency closure holds a changed file, along with those still
+// failing, until interrupted
+func (b *Builder) WatchTests(names []string) error {
+	b.watchingTests = true
+	failing := make(map[string]bool)
+	// no test ran since the tests last failed to build
+	broken := false
+
+	rebuild := func(paths []string) bool {
+		if err := b.startContainer(); err != nil {
+			b.logger.Error("%v", err)
+			return false
+		}
+		defer b.stopContainer()
+
+		if !b.sharedExecutor {
+			b.Executor.Start()
+			defer b.Executor.Shutdown()
+		}
+
+		tests, err := b.buildTests(names)
+		if err != nil {
+			b.logger.Error("failed to build tests: %v", err)
+			broken = true
+			return false
+		}
+
+		selected := tests
+		if paths != nil && !broken {
+			selected = b.affectedTests(tests, paths, failing)
+		}
+		if len(selected) == 0 {
+			b.logger.Info("no test is affected by the change")
+			return true
+		}
+
+		broken = false
+		start := time.Now()
+		results := b.runTests(selected)
+		for _, result := range results {
+			failing[result.Name] = !result.Passed
+		}
+		b.reportTests(results, len(tests)-len(selected), time.Since(start))
+		return true
+	}
+
+	return b.watch(rebuild, func() {})
+}
+
+// affectedTests returns the tests whose dependency closure holds one of the
+// changed paths, and those that failed last time they ran
+func (b *Builder) affectedTests(tests []testBinary, paths []string, failing map[string]bool) []testBinary {
+	linked := b.testedSources()
+	support, _ := dependency.FindSourceFiles(b.Config.Test.Support, nil)
+
+	var affected []testBinary
+	for _, test := range tests {
+		closure := b.testClosure(test, linked, support)
+		hit := failing[test.name]
+		for _, path := range paths {
+			hit = hit || closure[path]
+		}
+		if hit {
+			affected = append(affected, test)
+		}
+	}
+	return affected
+}
+
+// testedSources returns the sources of the project linked into the tests:
+// those of its libraries, or its sources but the ones excluded from tests
+func (b *Builder) testedSources() map[string]bool {
+	var sources []string
+	if b.hasArtifacts() {
+		for _, lib := range b.Config.Libraries {
+			files, _ := dependency.FindSourceFiles(lib.Sources, lib.Exclude)
+			sources = append(sources, files...)
+		}
+	} else {
+		exclude := append(append([]string{}, b.Config.Build.Exclude...), b.Config.Test.Exclude...)
+		sources, _ = dependency.FindSourceFiles(b.Config.Build.Sources, exclude)
+	}
+
+	linked := make(map[string]bool)
+	for _, source := range sources {
+		linked[filepath.Clean(source)] = true
+	}
+	return linked
+}
+
+// testClosure returns the files a test depends on: its source and the
+// support sources with the headers they include, and the linked sources of
+// the project including one of those headers, which usually implement them.
+// Linked sources that none of its headers lead to are left out, although the
+// test links them too.
+func (b *Builder) testClosure(test testBinary, linked map[string]bool, support []string) map[string]bool {
+	closure := make(map[string]bool)
+	b.addDependencies(test.source, closure)
+	for _, source := range support {
+		b.addDependencies(source, closure)
+	}
+
+	var implementations []string
+	for id, node := range b.Graph.Nodes {
+		if closure[id] || !(linked[id] || node.Type == dependency.NodeTypeGenerated) {
+			continue
+		}
+		for _, dep := range node.Dependencies {
+			if dep.Type == dependency.NodeTypeHeader && closure[dep.ID] {
+				implementations = append(implementations, id)
+				break
+			}
+		}
+	}
+	for _, source := range implementations {
+		b.addDependencies(source, closure)
+	}
+	return closure
+}
+
+// addDependencies adds id and everything it depends on in the graph to
+// closure
+func (b *Builder) addDependencies(id string, closure map[string]bool) {
+	if closure[id] {
+		return
+	}
+	closure[id] = true
+	if node, exists := b.Graph.GetNode(id); exists {
+		for _, dep := range node.Dependencies {
+			b.addDependencies(dep.ID, closure)
+		}
+	}
+}
+
+// reportTests prints the results of a run of the tests in a couple of
+// lines: the tests that passed in green, the failed ones in red with their
+// output, and how many were not affected
+func (b *Builder) reportTests(results []TestResult, skipped int, elapsed time.Duration) {
+	var passed, failed []string
+	for _, result := range results {
+		if result.Passed {
+			passed = append(passed, result.Name)
+		} else {
+			failed = append(failed, result.Name)
+		}
+	}
+	sort.Strings(passed)
+
+	if len(passed) > 0 {
+		b.logger.Success("PASS %s", strings.Join(passed, " "))
+	}
+	for _, result := range results {
+		if !result.Passed {
+			b.logger.Error("FAIL %s (exit code %d)", result.Name, result.ExitCode)
+			if result.Output != "" {
+				b.logger.Note("%s", strings.TrimRight(result.Output, "\n"))
+			}
+		}
+	}
+
+	summary := fmt.Sprintf("%d passed, %d failed", len(passed), len(failed))
+	if skipped > 0 {
+		summary += fmt.Sprintf(", %d not affected", skipped)
+	}
+	summary += fmt.Sprintf(" in %.2fs", elapsed.Seconds())
+	if len(failed) > 0 {
+		b.logger.Error("%s", summary)
+	} else {
+		b.logger.Success("%s", summary)
+	}
+}
@@ -0,0 +1,350 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/deps"
+	"github.com/deviceix/styx/internal/logger"
+)
+
+// Workspace builds the projects listed by a workspace root in dependency
+// order, sharing one executor and build cache between them
+type Workspace struct {
+	Root     string
+	Members  []*Member
+	Cache    *Cache
+	Executor *Executor
+	Target   string
+	Verbose  bool
+	Profile  bool
+	Trace    string // the path of the Chrome trace of the build
+	logger   *logger.Logger
+}
+
+// Member is one of the projects of a workspace; Dir is relative to the
+// workspace root and Requires lists the members it depends on by name
+type Member struct {
+	Dir      string
+	Config   *config.Config
+	Requires map[string]*Member
+}
+
+// NewWorkspace loads the members of the workspace rooted at root and orders
+// them so that every member comes after the members it depends on. The
+// environment named env is selected in the members defining it. Of the
+// options, only the jobs and the load limit apply to the whole workspace.
+func NewWorkspace(cfg *config.Config, root, env string, opts ...Option) (*Workspace, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve workspace root: %w", err)
+	}
+
+	members, err := loadMembers(root, cfg.Workspace.Members, env)
+	if err != nil {
+		return nil, err
+	}
+
+	ordered, err := orderMembers(members)
+	if err != nil {
+		return nil, err
+	}
+
+	stateDir := cfg.StateDir()
+	if !filepath.IsAbs(stateDir) {
+		stateDir = filepath.Join(root, stateDir)
+	}
+
+	cache := NewCache(filepath.Join(stateDir, "cache", "build.json"))
+	cache.Strategy = cfg.Cache.RebuildStrategy
+	if err := cache.Load(); err != nil {
+		return nil, fmt.Errorf("failed to load cache: %w", err)
+	}
+
+	var options BuilderOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.Jobs == 0 {
+		options.Jobs = cfg.Build.Jobs
+	}
+
+	log := options.newLogger()
+	executor := NewExecutor(options.Jobs)
+	executor.SetLogger(log)
+	executor.maxLoad = options.MaxLoad
+	return &Workspace{
+		Root:     root,
+		Members:  ordered,
+		Cache:    cache,
+		Executor: executor,
+		Verbose:  options.Verbose,
+		logger:   log,
+	}, nil
+}
+
+// loadMembers parses the configuration of every member matched by patterns
+// and links each member to the members its local dependencies point to
+func loadMembers(root string, patterns []string, env string) ([]*Member, error) {
+	var members []*Member
+	byDir := make(map[string]*Member)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(root, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid member pattern %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("workspace member not found: %s", pattern)
+		}
+
+		for _, dir := range matches {
+			if byDir[dir] != nil {
+				continue
+			}
+
+			manifest := filepath.Join(dir, "styx.toml")
+			if _, err := os.Stat(manifest); err != nil {
+				if !strings.ContainsAny(pattern, "*?[") {
+					return nil, fmt.Errorf("workspace member %s has no styx.toml", pattern)
+				}
+				continue
+			}
+
+			cfg, err := config.ParseFile(manifest)
+			if err != nil {
+				return nil, fmt.Errorf("member %s: %w", pattern, err)
+			}
+			if cfg.IsWorkspace() {
+				return nil, fmt.Errorf("member %s: workspaces cannot be nested", pattern)
+			}
+			if _, ok := cfg.Environment[env]; ok {
+				if err := cfg.SelectEnvironment(env); err != nil {
+					return nil, err
+				}
+			}
+
+			rel, err := filepath.Rel(root, dir)
+			if err != nil {
+				return nil, err
+			}
+			member := &Member{Dir: rel, Config: cfg, Requires: make(map[string]*Member)}
+			members = append(members, member)
+			byDir[dir] = member
+		}
+	}
+
+	for _, member := range members {
+		for name, dep := range member.Config.Dependencies {
+			if dep.Local == "" {
+				continue
+			}
+
+			dir := dep.Local
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(root, member.Dir, dir)
+			}
+			if required := byDir[filepath.Clean(dir)]; required != nil {
+				member.Requires[name] = required
+			}
+		}
+	}
+
+	return members, nil
+}
+
+// orderMembers sorts members so that each one follows the members it
+// requires, keeping the declared order otherwise
+func orderMembers(members []*Member) ([]*Member, error) {
+	var ordered []*Member
+	state := make(map[*Member]int) // 1 while visiting, 2 once ordered
+	var stack []string
+
+	var visit func(member *Member) error
+	visit = func(member *Member) error {
+		switch state[member] {
+		case 1:
+			return fmt.Errorf("workspace members depend on each other in a cycle: %s -> %s", strings.Join(stack, " -> "), member.Dir)
+		case 2:
+			return nil
+		}
+
+		state[member] = 1
+		stack = append(stack, member.Dir)
+		for _, name := range sortedMemberNames(member.Requires) {
+			if err := visit(member.Requires[name]); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[member] = 2
+
+		ordered = append(ordered, member)
+		return nil
+	}
+
+	for _, member := range members {
+		if err := visit(member); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// sortedMemberNames returns the dependency names of required members in
+// sorted order
+func sortedMemberNames(members map[string]*Member) []string {
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetTarget sets the build target of every member
+func (w *Workspace) SetTarget(target string) {
+	w.Target = target
+}
+
+// SetVerbose sets verbose output mode
+func (w *Workspace) SetVerbose(verbose bool) {
+	w.Verbose = verbose
+	w.logger.SetVerbose(verbose)
+}
+
+// SetProfile makes every member write a timing report of its build
+func (w *Workspace) SetProfile(profile bool) {
+	w.Profile = profile
+}
+
+// SetTrace makes the build write a Chrome trace of the commands run for
+// every member to path
+func (w *Workspace) SetTrace(path string) {
+	w.Trace = path
+}
+
+// Build builds every member in dependency order
+func (w *Workspace) Build() error {
+	w.Executor.Start()
+	defer w.Executor.Shutdown()
+
+	if w.Trace != "" {
+		start := time.Now()
+		w.Executor.StartTrace()
+		defer func() {
+			target := w.Target
+			if target == "" {
+				target = "debug"
+			}
+			name := fmt.Sprintf("build workspace (%s)", target)
+			if err := writeTrace(w.Trace, name, start, time.Now(), w.Executor.TracedTasks()); err != nil {
+				w.logger.Warning("%v", err)
+				return
+			}
+			w.logger.Info("trace written to %s", w.Trace)
+		}()
+	}
+
+	return w.each(func(member *Member, b *Builder) error {
+		_, err := b.Build()
+		return err
+	})
+}
+
+// Test builds every member and runs the tests of those that have any,
+// naming the results after their member
+func (w *Workspace) Test(names []string) ([]TestResult, error) {
+	w.Executor.Start()
+	defer w.Executor.Shutdown()
+
+	var results []TestResult
+	err := w.each(func(member *Member, b *Builder) error {
+		if _, err := b.Build(); err != nil {
+			return err
+		}
+		if len(member.Config.Test.Sources) == 0 {
+			return nil
+		}
+
+		memberResults, err := b.Test(names)
+		if err != nil {
+			return err
+		}
+		for _, result := range memberResults {
+			result.Name = filepath.ToSlash(member.Dir) + "/" + result.Name
+			results = append(results, result)
+		}
+		return nil
+	})
+	return results, err
+}
+
+// Clean cleans every member like Builder.Clean, forgetting its outputs in
+// the shared cache
+func (w *Workspace) Clean(opts CleanOptions) error {
+	return w.each(func(member *Member, b *Builder) error {
+		return b.Clean(opts)
+	})
+}
+
+// each runs fn with a builder for every member in dependency order, from
+// within the member's directory. Builders share the workspace executor and
+// cache, and link the libraries of the members built before them.
+func (w *Workspace) each(fn func(member *Member, b *Builder) error) error {
+	defer func() {
+		_ = os.Chdir(w.Root)
+	}()
+
+	built := make(map[*Member]*deps.Package)
+	for _, member := range w.Members {
+		name := member.Config.Project.Name
+		w.logger.Info("project %s (%s)", name, member.Dir)
+
+		dir := filepath.Join(w.Root, member.Dir)
+		if err := os.Chdir(dir); err != nil {
+			return fmt.Errorf("failed to enter %s: %w", member.Dir, err)
+		}
+
+		b, err := NewBuilder(member.Config, WithLogger(w.logger), WithVerbose(w.Verbose))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := b.SetTarget(w.Target); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		b.SetProfile(w.Profile)
+
+		w.Cache.Dir = member.Dir
+		w.Executor.container = b.container
+		w.Executor.env = b.Executor.env
+		// paths are relative to the member, so each one keeps its own FS
+		w.Cache.FS = b.Cache.FS
+		b.Cache = w.Cache
+		b.Executor = w.Executor
+		b.sharedExecutor = true
+		b.memberPackages = make(map[string]*deps.Package)
+		for depName, required := range member.Requires {
+			pkg := built[required]
+			if pkg == nil {
+				return fmt.Errorf("%s: dependency %s does not build a library", name, depName)
+			}
+			b.memberPackages[depName] = pkg
+		}
+
+		if err := fn(member, b); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		pkg, err := deps.ProjectPackage(member.Config, dir, filepath.Join(dir, b.targetOutputDir()))
+		if err == nil {
+			built[member] = pkg
+		}
+	}
+
+	return nil
+}
@@ -13,6 +13,7 @@ import (
 type ClangCompiler struct {
 	Path         string
 	Version      string
+	VersionInfo  Version
 	Platform     platform.Platform
 	TargetTriple string
 }
@@ -22,11 +23,21 @@ func (c *ClangCompiler) GetName() string {
 	return "Clang"
 }
 
+// GetPath returns the executable the compiler is run as
+func (c *ClangCompiler) GetPath() string {
+	return c.Path
+}
+
 // GetVersion returns the compiler version
 func (c *ClangCompiler) GetVersion() string {
 	return c.Version
 }
 
+// GetVersionInfo returns the parsed compiler version
+func (c *ClangCompiler) GetVersionInfo() Version {
+	return c.VersionInfo
+}
+
 // Compile compiles a source file into an object file
 func (c *ClangCompiler) Compile(source, output string, flags []string) error {
 	args := append([]string{"-c", source, "-o", output}, flags...)
@@ -43,8 +54,10 @@ func (c *ClangCompiler) Compile(source, output string, flags []string) error {
 	return cmd.Run()
 }
 
+// GetCXXCompilerName returns the C++ driver next to the compiler, keeping
+// its prefix and suffix like x86_64-elf-clang++ or clang++-17
 func (c *ClangCompiler) GetCXXCompilerName() string {
-	return "clang++"
+	return cxxDriver(c.Path, "clang", "clang++")
 }
 
 // Link links object files into an executable
@@ -132,7 +145,7 @@ func (c *ClangCompiler) SupportsLanguage(language string) bool {
 		return true
 	case "c++":
 		return true // Clang supports C++ out of the box
-	case "objective-c":
+	case "objective-c", "objective-c++":
 		return true // Clang has excellent Objective-C support
 	default:
 		return false
@@ -146,14 +159,15 @@ func NewClangCompiler(path string, targetTriple string) (*ClangCompiler, error)
 		var err error
 		path, err = exec.LookPath("clang")
 		if err != nil {
-			return nil, fmt.Errorf("clang not found: %w", err)
+			return nil, fmt.Errorf("%w: %w", ErrCompilerNotFound, err)
 		}
 	}
 
-	version := getCompilerVersion(path, "--version")
+	version, info := detectVersion(path)
 	return &ClangCompiler{
 		Path:         path,
 		Version:      version,
+		VersionInfo:  info,
 		Platform:     platform.DetectPlatform(),
 		TargetTriple: targetTriple,
 	}, nil
@@ -21,29 +21,93 @@ func NewErrorParser(log *logger.Logger) *ErrorParser {
 	}
 }
 
+// gccLocation matches a GCC or Clang diagnostic like
+// "main.c:3:5: error: message"
+var gccLocation = regexp.MustCompile(`^(.*?):(\d+):(?:(\d+):)?\s+(warning|error|note|fatal error):\s+(.*)$`)
+
+// msvcLocation matches an MSVC diagnostic like
+// "main.cpp(3,5): error C2065: message", with an optional column and code
+var msvcLocation = regexp.MustCompile(`^(.*?)\((\d+)(?:,(\d+))?\)\s*:\s+(warning|error|note|fatal error)(?:\s+([A-Z]+\d+))?\s*:\s+(.*)$`)
+
+// snippetLine matches the source lines GCC and Clang echo below a
+// diagnostic, like "    3 | int x = y;", and the caret lines under them
+var snippetLine = regexp.MustCompile(`^\s*(\d+)?\s*\|\s?(.*)$`)
+
+// diagnostic is a located message found in compiler output
+type diagnostic struct {
+	file    string
+	line    int
+	column  int
+	kind    string
+	message string
+}
+
 // ParseGCCOutput parses GCC/Clang error output
 func (p *ErrorParser) ParseGCCOutput(output, sourceFile string) []logger.BuilderEvent {
+	return parseDiagnostics(output, matchGCC)
+}
+
+// ParseMSVCOutput parses MSVC error output, keeping the error code, like
+// C2065, at the start of every message
+func (p *ErrorParser) ParseMSVCOutput(output, sourceFile string) []logger.BuilderEvent {
+	return parseDiagnostics(output, matchMSVC)
+}
+
+// Parse parses compiler output in either format, line by line
+func (p *ErrorParser) Parse(output, sourceFile string) []logger.BuilderEvent {
+	return parseDiagnostics(output, func(line string) *diagnostic {
+		if d := matchGCC(line); d != nil {
+			return d
+		}
+		return matchMSVC(line)
+	})
+}
+
+// matchGCC reads a GCC or Clang diagnostic line
+func matchGCC(line string) *diagnostic {
+	matches := gccLocation.FindStringSubmatch(line)
+	if matches == nil {
+		return nil
+	}
+	return newDiagnostic(matches[1], matches[2], matches[3], matches[4], matches[5])
+}
+
+// matchMSVC reads an MSVC diagnostic line
+func matchMSVC(line string) *diagnostic {
+	matches := msvcLocation.FindStringSubmatch(line)
+	if matches == nil {
+		return nil
+	}
+	message := matches[6]
+	if matches[5] != "" {
+		message = matches[5] + ": " + message
+	}
+	return newDiagnostic(matches[1], matches[2], matches[3], matches[4], message)
+}
+
+// newDiagnostic creates a diagnostic from the parts of a matched line
+func newDiagnostic(file, line, column, kind, message string) *diagnostic {
+	d := &diagnostic{file: file, kind: kind, message: message}
+	d.line, _ = strconv.Atoi(line)
+	if column != "" {
+		d.column, _ = strconv.Atoi(column)
+	}
+	return d
+}
+
+// parseDiagnostics turns the lines recognized by match into events. Notes
+// and unrecognized lines are attached to the event before them, and echoed
+// source lines become its code.
+func parseDiagnostics(output string, match func(line string) *diagnostic) []logger.BuilderEvent {
 	var events []logger.BuilderEvent
-	// defo need better message matching lol
-	reFileLocation := regexp.MustCompile(`^(.*?):(\d+):(?:(\d+):)?\s+(warning|error|note|fatal error):\s+(.*)$`)
 	scanner := bufio.NewScanner(strings.NewReader(output))
-	var currentEvent *logger.BuilderEvent
+	current := -1
 
 	for scanner.Scan() {
 		line := scanner.Text()
-		if matches := reFileLocation.FindStringSubmatch(line); matches != nil {
-			file := matches[1]
-			lineNum, _ := strconv.Atoi(matches[2])
-			colNum := 0
-			if matches[3] != "" {
-				colNum, _ = strconv.Atoi(matches[3])
-			}
-
-			errorType := matches[4]
-			message := matches[5]
-
+		if d := match(line); d != nil {
 			var msgType logger.MessageType
-			switch errorType {
+			switch d.kind {
 			case "warning":
 				msgType = logger.TypeWarning
 			case "error", "fatal error":
@@ -55,28 +119,32 @@ func (p *ErrorParser) ParseGCCOutput(output, sourceFile string) []logger.Builder
 			}
 
 			// If this is a note for an existing error, add it to the suggestions
-			if errorType == "note" && currentEvent != nil {
-				currentEvent.Suggestions = append(currentEvent.Suggestions, message)
+			if d.kind == "note" && current >= 0 {
+				events[current].Suggestions = append(events[current].Suggestions, d.message)
 				continue
 			}
 
 			// Create a new event
-			event := logger.BuilderEvent{
+			events = append(events, logger.BuilderEvent{
 				Type:        msgType,
-				Message:     message,
-				Source:      file,
-				Line:        lineNum,
-				Column:      colNum,
+				Message:     d.message,
+				Source:      d.file,
+				Line:        d.line,
+				Column:      d.column,
 				Suggestions: []string{},
-			}
-
-			events = append(events, event)
-			currentEvent = &events[len(events)-1]
-		} else if strings.TrimSpace(line) != "" && currentEvent != nil {
-			if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
-				currentEvent.Code = strings.TrimSpace(line)
+			})
+			current = len(events) - 1
+		} else if strings.TrimSpace(line) != "" && current >= 0 {
+			event := &events[current]
+			if snippet := snippetLine.FindStringSubmatch(line); snippet != nil {
+				// the caret lines are drawn again when the event is reported
+				if snippet[1] != "" && event.Code == "" {
+					event.Code = snippet[2]
+				}
+			} else if line[0] == ' ' || line[0] == '\t' {
+				event.Code = strings.TrimSpace(line)
 			} else {
-				currentEvent.Suggestions = append(currentEvent.Suggestions, line)
+				event.Suggestions = append(event.Suggestions, line)
 			}
 		}
 	}
@@ -86,7 +154,7 @@ func (p *ErrorParser) ParseGCCOutput(output, sourceFile string) []logger.Builder
 
 // Report formats and logs the parsed errors
 func (p *ErrorParser) Report(output, sourceFile string) {
-	events := p.ParseGCCOutput(output, sourceFile)
+	events := p.Parse(output, sourceFile)
 
 	for _, event := range events {
 		p.logger.ReportBuildEvent(event)
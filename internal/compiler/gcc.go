@@ -13,6 +13,7 @@ import (
 type GCCCompiler struct {
 	Path         string
 	Version      string
+	VersionInfo  Version
 	Platform     platform.Platform
 	TargetTriple string // ttt for cross-compiling
 }
@@ -22,11 +23,21 @@ func (c *GCCCompiler) GetName() string {
 	return "GCC"
 }
 
+// GetPath returns the executable the compiler is run as
+func (c *GCCCompiler) GetPath() string {
+	return c.Path
+}
+
 // GetVersion returns the compiler version
 func (c *GCCCompiler) GetVersion() string {
 	return c.Version
 }
 
+// GetVersionInfo returns the parsed compiler version
+func (c *GCCCompiler) GetVersionInfo() Version {
+	return c.VersionInfo
+}
+
 // Compile compiles a source file into an object file
 func (c *GCCCompiler) Compile(source, output string, flags []string) error {
 	args := append([]string{"-c", source, "-o", output}, flags...)
@@ -41,8 +52,10 @@ func (c *GCCCompiler) Compile(source, output string, flags []string) error {
 	return cmd.Run()
 }
 
+// GetCXXCompilerName returns the C++ driver next to the compiler, keeping
+// its prefix and suffix like x86_64-elf-g++ or g++-17
 func (c *GCCCompiler) GetCXXCompilerName() string {
-	return "g++"
+	return cxxDriver(c.Path, "gcc", "g++")
 }
 
 // Link links object files into an executable
@@ -114,7 +127,7 @@ func (c *GCCCompiler) GetSharedLibraryExtension() string {
 
 // SupportsFlag checks if the compiler supports a specific flag
 func (c *GCCCompiler) SupportsFlag(flag string) bool {
-	cmd := exec.Command(c.Path, "-Werror", "-fsyntax-only", "-c", "-", "-o", os.DevNull, flag)
+	cmd := exec.Command(c.Path, "-Werror", "-fsyntax-only", "-xc", "-c", "-", "-o", os.DevNull, flag)
 	cmd.Stdin = strings.NewReader("int main() { return 0; }")
 
 	err := cmd.Run()
@@ -132,8 +145,11 @@ func (c *GCCCompiler) SupportsLanguage(language string) bool {
 	case "c++":
 		_, err := exec.LookPath("g++")
 		return err == nil
-	case "objective-c":
-		return c.SupportsFlag("-ObjC")
+	case "objective-c", "objective-c++":
+		// gcc is built without the front ends of Objective-C on many systems
+		cmd := exec.Command(c.Path, "-fsyntax-only", "-x", language, "-")
+		cmd.Stdin = strings.NewReader("int main(void) { return 0; }")
+		return cmd.Run() == nil
 	default:
 		return false
 	}
@@ -146,14 +162,15 @@ func NewGCCCompiler(path string, targetTriple string) (*GCCCompiler, error) {
 		var err error
 		path, err = exec.LookPath("gcc")
 		if err != nil {
-			return nil, fmt.Errorf("gcc not found: %w", err)
+			return nil, fmt.Errorf("%w: %w", ErrCompilerNotFound, err)
 		}
 	}
 
-	version := getCompilerVersion(path, "--version")
+	version, info := detectVersion(path)
 	return &GCCCompiler{
 		Path:         path,
 		Version:      version,
+		VersionInfo:  info,
 		Platform:     platform.DetectPlatform(),
 		TargetTriple: targetTriple,
 	}, nil
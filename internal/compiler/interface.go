@@ -3,16 +3,24 @@ package compiler
 import (
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/deviceix/styx/internal/platform"
 )
 
+// ErrCompilerNotFound is returned when a compiler is not installed
+var ErrCompilerNotFound = errors.New("compiler not found")
+
 // Compiler defines the interface for compiler operations
 type Compiler interface {
 	GetName() string
+	GetPath() string
 	GetVersion() string
+	GetVersionInfo() Version
 	Compile(source, output string, flags []string) error
 	Link(objects []string, output string, flags []string) error
 	Archive(objects []string, output string, flags []string) error
@@ -37,74 +45,170 @@ const (
 	CompilerMSVC
 )
 
-// compilerRegistry stores available compilers
-var compilerRegistry = make(map[string]Compiler)
+// probe is the memoized result of running one compiler executable to find
+// its vendor and version
+type probe struct {
+	once     sync.Once
+	compiler Compiler
+	err      error
+}
 
-// RegisterCompiler adds a compiler to the registry
-func RegisterCompiler(compiler Compiler) {
-	compilerRegistry[strings.ToLower(compiler.GetName())] = compiler
+// compilerRegistry stores the compilers registered explicitly and the ones
+// probed so far, both by the absolute path of their executable, so every
+// executable is probed once; aliases map other names to registered paths.
+// Lookups may run concurrently with registration and refreshes.
+var compilerRegistry = struct {
+	sync.RWMutex
+	registered map[string]Compiler
+	aliases    map[string]string
+	probes     map[string]*probe
+	detection  *detection
+}{
+	registered: make(map[string]Compiler),
+	aliases:    make(map[string]string),
+	probes:     make(map[string]*probe),
+	detection:  &detection{},
 }
 
-// GetCompiler retrieves a compiler by name
-func GetCompiler(name string) (Compiler, error) {
-	compiler, exists := compilerRegistry[strings.ToLower(name)]
-	if !exists {
-		return nil, fmt.Errorf("compiler not found: %s", name)
+// detection is the memoized result of DetectCompilers
+type detection struct {
+	once      sync.Once
+	compilers []Compiler
+}
+
+// RegisterCompiler adds a compiler to the registry under its path and the
+// given aliases. Registered compilers are kept by RefreshCompilers.
+func RegisterCompiler(compiler Compiler, aliases ...string) {
+	compilerRegistry.Lock()
+	defer compilerRegistry.Unlock()
+
+	compilerRegistry.registered[compiler.GetPath()] = compiler
+	for _, alias := range aliases {
+		compilerRegistry.aliases[alias] = compiler.GetPath()
 	}
-	return compiler, nil
 }
 
-// DetectCompilers finds available compilers on the system
-func DetectCompilers() []Compiler {
-	var compilers []Compiler
+// RefreshCompilers forgets the compilers probed and detected so far, so the
+// next lookups probe the executables again, for instance after a compiler
+// was upgraded in place
+func RefreshCompilers() {
+	compilerRegistry.Lock()
+	defer compilerRegistry.Unlock()
 
-	if path, err := exec.LookPath("gcc"); err == nil {
-		version := getCompilerVersion(path, "--version")
+	compilerRegistry.probes = make(map[string]*probe)
+	compilerRegistry.detection = &detection{}
+}
 
-		compiler := &GCCCompiler{
-			Path:     path,
-			Version:  version,
-			Platform: platform.DetectPlatform(),
-		}
+// GetCompiler finds a compiler by name. Names are resolved in this order:
+//
+//  1. a registered alias
+//  2. a path to the executable, when the name contains a path separator
+//  3. a command searched in PATH, such as gcc-13 or x86_64-elf-gcc; display
+//     names like GCC or Clang are searched as their lowercase command
+//
+// The executable found is probed for its vendor and version unless it has
+// been registered or probed already. Since commands are searched every time,
+// changes to PATH select the compilers they bring first.
+func GetCompiler(name string) (Compiler, error) {
+	compilerRegistry.RLock()
+	if path, ok := compilerRegistry.aliases[name]; ok {
+		compiler := compilerRegistry.registered[path]
+		compilerRegistry.RUnlock()
+		return compiler, nil
+	}
+	compilerRegistry.RUnlock()
 
-		compilers = append(compilers, compiler)
-		RegisterCompiler(compiler)
+	path, err := compilerPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCompilerNotFound, name)
 	}
 
-	if path, err := exec.LookPath("clang"); err == nil {
-		version := getCompilerVersion(path, "--version")
+	compilerRegistry.Lock()
+	if compiler, ok := compilerRegistry.registered[path]; ok {
+		compilerRegistry.Unlock()
+		return compiler, nil
+	}
+	p, ok := compilerRegistry.probes[path]
+	if !ok {
+		p = &probe{}
+		compilerRegistry.probes[path] = p
+	}
+	compilerRegistry.Unlock()
+
+	// concurrent lookups of the same executable wait for a single probe
+	p.once.Do(func() {
+		version, info := detectVersion(path)
+		p.compiler, p.err = New(path, version, info, platform.DetectPlatform())
+	})
+	return p.compiler, p.err
+}
 
-		compiler := &ClangCompiler{
-			Path:     path,
-			Version:  version,
-			Platform: platform.DetectPlatform(),
+// compilerPath returns the absolute path of the executable a compiler name
+// refers to
+func compilerPath(name string) (string, error) {
+	if strings.ContainsRune(name, filepath.Separator) || strings.ContainsRune(name, '/') {
+		if _, err := os.Stat(name); err != nil {
+			return "", err
 		}
-
-		compilers = append(compilers, compiler)
-		RegisterCompiler(compiler)
+		return filepath.Abs(name)
 	}
 
-	if platform.DetectPlatform() == platform.PlatformWindows {
-		// TODO: impl
+	path, err := exec.LookPath(name)
+	if err != nil && strings.ToLower(name) != name {
+		path, err = exec.LookPath(strings.ToLower(name))
 	}
-
-	return compilers
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
 }
 
-// getCompilerVersion runs the compiler with version flag and parses the output
-func getCompilerVersion(path, versionFlag string) string {
-	cmd := exec.Command(path, versionFlag)
-	output, err := cmd.Output()
-	if err != nil {
-		return "unknown"
+// New creates the compiler of the vendor named by info, run as path
+func New(path, version string, info Version, p platform.Platform) (Compiler, error) {
+	switch info.Vendor {
+	case "gcc":
+		return &GCCCompiler{Path: path, Version: version, VersionInfo: info, Platform: p}, nil
+	case "clang", "apple-clang":
+		return &ClangCompiler{Path: path, Version: version, VersionInfo: info, Platform: p}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compiler %s: %s", path, version)
 	}
+}
 
-	lines := strings.Split(string(output), "\n")
-	if len(lines) > 0 {
-		return strings.TrimSpace(lines[0])
+// cxxDriver derives the C++ driver from the path of a C compiler by
+// replacing the last occurrence of c in its name with cxx, or cc with c++
+func cxxDriver(path, c, cxx string) string {
+	dir, name := filepath.Split(path)
+	if i := strings.LastIndex(name, c); i >= 0 {
+		return dir + name[:i] + cxx + name[i+len(c):]
+	}
+	if name == "cc" {
+		return dir + "c++"
 	}
+	return dir + cxx
+}
+
+// DetectCompilers finds the compilers installed on the system as gcc and
+// clang, in that order. The search runs on the first call only; later calls
+// return its result until RefreshCompilers is called.
+func DetectCompilers() []Compiler {
+	compilerRegistry.RLock()
+	d := compilerRegistry.detection
+	compilerRegistry.RUnlock()
+
+	d.once.Do(func() {
+		for _, name := range []string{"gcc", "clang"} {
+			if compiler, err := GetCompiler(name); err == nil {
+				d.compilers = append(d.compilers, compiler)
+			}
+		}
+
+		if platform.DetectPlatform() == platform.PlatformWindows {
+			// TODO: impl
+		}
+	})
 
-	return "unknown"
+	return append([]Compiler(nil), d.compilers...)
 }
 
 // GetDefaultCompiler tries to find a suitable compiler
@@ -119,7 +223,7 @@ func GetDefaultCompiler(preferredType string) (Compiler, error) {
 	// otherwise detect all available compilers
 	compilers := DetectCompilers()
 	if len(compilers) == 0 {
-		return nil, errors.New("no compilers found on the system")
+		return nil, fmt.Errorf("%w: neither gcc nor clang is installed", ErrCompilerNotFound)
 	}
 
 	// clang is pretty goated so clang comes first
@@ -0,0 +1,168 @@
+package compiler
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is the parsed version banner of a compiler. Vendor is "gcc",
+// "clang" or "apple-clang", and Target is the default target triple.
+type Version struct {
+	Vendor string
+	Major  int
+	Minor  int
+	Patch  int
+	Target string
+}
+
+// versionPattern matches a dotted version number in a banner
+var versionPattern = regexp.MustCompile(`\b(\d+)\.(\d+)(?:\.(\d+))?\b`)
+
+// ParseVersion extracts the vendor, version and default target from the
+// output of --version. Clang prints its version after "version" and its
+// target on a line of its own; GCC ends the first line with its version.
+func ParseVersion(output string) Version {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	banner := lines[0]
+
+	var v Version
+	lower := strings.ToLower(banner)
+	switch {
+	case strings.Contains(lower, "apple clang"):
+		v.Vendor = "apple-clang"
+	case strings.Contains(lower, "clang"):
+		v.Vendor = "clang"
+	case strings.Contains(lower, "gcc"), strings.Contains(lower, "g++"):
+		v.Vendor = "gcc"
+	case strings.Contains(output, "Free Software Foundation"):
+		// GCC installed as cc or under another name
+		v.Vendor = "gcc"
+	}
+
+	// anything after the version, like "(clang-1500.3.9.4)", is left out
+	var match []string
+	if i := strings.Index(lower, "version "); i >= 0 {
+		match = versionPattern.FindStringSubmatch(banner[i:])
+	} else if matches := versionPattern.FindAllStringSubmatch(banner, -1); len(matches) > 0 {
+		match = matches[len(matches)-1]
+	}
+	if match != nil {
+		v.Major, _ = strconv.Atoi(match[1])
+		v.Minor, _ = strconv.Atoi(match[2])
+		v.Patch, _ = strconv.Atoi(match[3])
+	}
+
+	for _, line := range lines[1:] {
+		if target, ok := strings.CutPrefix(strings.TrimSpace(line), "Target:"); ok {
+			v.Target = strings.TrimSpace(target)
+		}
+	}
+	return v
+}
+
+// Known reports whether a version number could be parsed
+func (v Version) Known() bool {
+	return v.Major > 0
+}
+
+// Compare returns -1, 0 or 1 as v is older than, the same as or newer than
+// major.minor.patch
+func (v Version) Compare(major, minor, patch int) int {
+	for _, pair := range [][2]int{{v.Major, major}, {v.Minor, minor}, {v.Patch, patch}} {
+		if pair[0] < pair[1] {
+			return -1
+		}
+		if pair[0] > pair[1] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// AtLeast reports whether v is major.minor or newer
+func (v Version) AtLeast(major, minor int) bool {
+	return v.Compare(major, minor, 0) >= 0
+}
+
+// String formats the version like "clang 17.0.6 (x86_64-pc-linux-gnu)"
+func (v Version) String() string {
+	s := fmt.Sprintf("%s %d.%d.%d", v.Vendor, v.Major, v.Minor, v.Patch)
+	if v.Target != "" {
+		s += " (" + v.Target + ")"
+	}
+	return s
+}
+
+// standardSpellings lists, for the standards that were known by a draft
+// name first, the first major version of each vendor taking the draft name
+// and the first one taking the final name
+var standardSpellings = []struct {
+	standard   string
+	draft      string
+	clangDraft int
+	clangFinal int
+	gccDraft   int
+	gccFinal   int
+}{
+	{"c++20", "c++2a", 5, 10, 8, 10},
+	{"c++23", "c++2b", 12, 17, 11, 11},
+	{"c++26", "c++2c", 17, 17, 14, 14},
+	{"c23", "c2x", 9, 18, 9, 14},
+}
+
+// StandardName returns the name v accepts for a language standard with
+// -std, which is the draft name on versions older than the final one, or an
+// error when v predates the standard altogether
+func (v Version) StandardName(standard string) (string, error) {
+	if !v.Known() || v.Vendor == "" {
+		return standard, nil
+	}
+
+	gnu := strings.HasPrefix(standard, "gnu")
+	base := standard
+	if gnu {
+		base = "c" + strings.TrimPrefix(standard, "gnu")
+	}
+
+	for _, spelling := range standardSpellings {
+		if spelling.standard != base {
+			continue
+		}
+
+		draft, final := spelling.gccDraft, spelling.gccFinal
+		if v.Vendor == "clang" || v.Vendor == "apple-clang" {
+			draft, final = spelling.clangDraft, spelling.clangFinal
+		}
+		switch {
+		case v.Major >= final:
+			return standard, nil
+		case v.Major < draft:
+			return "", fmt.Errorf("%s %d.%d does not support %s (%d or newer is required)", v.Vendor, v.Major, v.Minor, standard, draft)
+		case gnu:
+			return "gnu" + strings.TrimPrefix(spelling.draft, "c"), nil
+		default:
+			return spelling.draft, nil
+		}
+	}
+	return standard, nil
+}
+
+// detectVersion runs the compiler at path to find its version banner and
+// parsed version. GCC does not print its target, so it is asked for it.
+func detectVersion(path string) (string, Version) {
+	output, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return "unknown", Version{}
+	}
+
+	version := ParseVersion(string(output))
+	if version.Target == "" {
+		if machine, err := exec.Command(path, "-dumpmachine").Output(); err == nil {
+			version.Target = strings.TrimSpace(string(machine))
+		}
+	}
+	return strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0]), version
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// platforms are the platforms [build.<platform>] sections can be written for
+var platforms = []string{"linux", "windows", "macos"}
+
+// compilers are the compilers [toolchain.<compiler>] sections can be
+// written for
+var compilers = []string{"gcc", "clang"}
+
+// conditional is a section merged into [build] or [toolchain] only on some
+// platform or with some compiler
+type conditional struct {
+	value   any             // *BuildConfig or *ToolchainConfig
+	defined map[string]bool // the keys the section sets
+}
+
+// parseConditionals reads the [build.<platform>] and [toolchain.<compiler>]
+// sections of a configuration
+func parseConditionals(data string, config *Config) error {
+	var sections struct {
+		Build     map[string]toml.Primitive `toml:"build"`
+		Toolchain map[string]toml.Primitive `toml:"toolchain"`
+	}
+	md, err := toml.Decode(data, &sections)
+	if err != nil {
+		return fmt.Errorf("failed to parse configuration: %w", err)
+	}
+
+	for name, value := range sections.Build {
+		if md.Type("build", name) != "Hash" {
+			continue
+		}
+		if !slices.Contains(platforms, name) {
+			return fmt.Errorf("unknown platform in [build.%s] (must be one of %s)", name, strings.Join(platforms, ", "))
+		}
+		var build BuildConfig
+		if err := md.PrimitiveDecode(value, &build); err != nil {
+			return fmt.Errorf("build.%s: %w", name, err)
+		}
+		if config.platformSections == nil {
+			config.platformSections = make(map[string]conditional)
+		}
+		config.platformSections[name] = conditional{value: &build, defined: definedKeys(md, "build", name)}
+	}
+
+	for name, value := range sections.Toolchain {
+		if md.Type("toolchain", name) != "Hash" {
+			continue
+		}
+		if !slices.Contains(compilers, name) {
+			return fmt.Errorf("unknown compiler in [toolchain.%s] (must be one of %s)", name, strings.Join(compilers, ", "))
+		}
+		var toolchain ToolchainConfig
+		if err := md.PrimitiveDecode(value, &toolchain); err != nil {
+			return fmt.Errorf("toolchain.%s: %w", name, err)
+		}
+		defined := definedKeys(md, "toolchain", name)
+		if defined["compiler"] || defined["use"] {
+			return fmt.Errorf("toolchain.%s: compiler and use cannot be set in the section of a compiler", name)
+		}
+		if config.compilerSections == nil {
+			config.compilerSections = make(map[string]conditional)
+		}
+		config.compilerSections[name] = conditional{value: &toolchain, defined: defined}
+	}
+
+	for _, key := range undecodedKeys(md) {
+		if len(key) > 2 && config.isConditional(key[0], key[1]) {
+			config.unknownKeys = append(config.unknownKeys, key.String())
+		}
+	}
+	return nil
+}
+
+// isConditional reports whether the table table.name, like build.linux, is
+// a conditional section
+func (c *Config) isConditional(table, name string) bool {
+	switch table {
+	case "build":
+		_, ok := c.platformSections[name]
+		return ok
+	case "toolchain":
+		_, ok := c.compilerSections[name]
+		return ok
+	}
+	return false
+}
+
+// dropConditional removes the key at path, like build.libs, from the
+// conditional sections setting it, so the value of the base section stays
+func (c *Config) dropConditional(path []string) {
+	if len(path) != 2 {
+		return
+	}
+	sections := c.platformSections
+	if path[0] == "toolchain" {
+		sections = c.compilerSections
+	} else if path[0] != "build" {
+		return
+	}
+	for _, section := range sections {
+		delete(section.defined, path[1])
+	}
+}
+
+// undecodedKeys returns the keys md did not decode, leaving out the keys of
+// tables that were not decoded as a whole
+func undecodedKeys(md toml.MetaData) []toml.Key {
+	var keys []toml.Key
+	undecoded := make(map[string]bool)
+	for _, key := range md.Undecoded() {
+		if len(key) > 1 && undecoded[key[:len(key)-1].String()] {
+			undecoded[key.String()] = true
+			continue
+		}
+		undecoded[key.String()] = true
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// definedKeys returns the keys set in the table at path
+func definedKeys(md toml.MetaData, path ...string) map[string]bool {
+	defined := make(map[string]bool)
+	for _, key := range md.Keys() {
+		if len(key) == len(path)+1 && slices.Equal(key[:len(path)], path) {
+			defined[key[len(path)]] = true
+		}
+	}
+	return defined
+}
+
+// validateConditionals checks the configuration each platform section
+// would give, so that errors show on every platform
+func validateConditionals(config *Config) error {
+	for name, section := range config.platformSections {
+		merged := *config
+		mergeSection(&merged.Build, *section.value.(*BuildConfig), section.defined)
+		if err := validateConfig(&merged); err != nil {
+			return fmt.Errorf("build.%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// SelectConditions merges the [build.<platform>] section of platform and the
+// [toolchain.<compiler>] section of compiler into the configuration. Lists
+// are appended to those of the base sections, other keys replace them.
+func (c *Config) SelectConditions(platform, compiler string) error {
+	selected := platform + "/" + compiler
+	if selected == c.conditions {
+		return nil
+	}
+	if c.conditions != "" {
+		return fmt.Errorf("configuration is already merged for %s", c.conditions)
+	}
+
+	if section, exists := c.platformSections[platform]; exists {
+		mergeSection(&c.Build, *section.value.(*BuildConfig), section.defined)
+	}
+	if section, exists := c.compilerSections[compiler]; exists {
+		mergeSection(&c.Toolchain, *section.value.(*ToolchainConfig), section.defined)
+	}
+	c.conditions = selected
+	return nil
+}
+
+// mergeSection merges the defined keys of section into dst; lists are
+// copied rather than shared
+func mergeSection[T any](dst *T, section T, defined map[string]bool) {
+	to := reflect.ValueOf(dst).Elem()
+	from := reflect.ValueOf(section)
+	for i := 0; i < to.NumField(); i++ {
+		key, _, _ := strings.Cut(to.Type().Field(i).Tag.Get("toml"), ",")
+		if !defined[key] {
+			continue
+		}
+		field := to.Field(i)
+		if field.Kind() == reflect.Slice {
+			list := reflect.MakeSlice(field.Type(), 0, field.Len()+from.Field(i).Len())
+			field.Set(reflect.AppendSlice(reflect.AppendSlice(list, field), from.Field(i)))
+		} else {
+			field.Set(from.Field(i))
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+// fixtureConfigs returns the styx.toml files of the fixtures
+func fixtureConfigs(t testing.TB) map[string][]byte {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join("..", "..", "testdata", "fixtures", "*", "styx.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no fixture configurations found")
+	}
+
+	configs := make(map[string][]byte, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		configs[path] = data
+	}
+	return configs
+}
+
+// sameExported reports whether a and b have the same exported fields; the
+// unexported ones record how a configuration was parsed rather than what
+// it configures
+func sameExported(a, b *Config) bool {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for i := 0; i < va.NumField(); i++ {
+		if va.Type().Field(i).IsExported() && !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			return false
+		}
+	}
+	return true
+}
+
+// roundTrip parses data, encodes the configuration back to TOML and parses
+// it again, failing when the two configurations differ. Data that does not
+// parse is skipped.
+func roundTrip(t *testing.T, data []byte) {
+	t.Helper()
+	first, err := Parse(data)
+	if err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(first); err != nil {
+		t.Fatalf("failed to encode configuration: %v", err)
+	}
+	second, err := Parse(buf.Bytes())
+	if err != nil {
+		t.Fatalf("failed to parse encoded configuration: %v\n%s", err, buf.String())
+	}
+
+	if !sameExported(first, second) {
+		t.Fatalf("configuration changed in a round trip:\n%+v\n%+v\n%s", *first, *second, buf.String())
+	}
+}
+
+func TestRoundTripFixtures(t *testing.T) {
+	for path, data := range fixtureConfigs(t) {
+		t.Run(filepath.Base(filepath.Dir(path)), func(t *testing.T) {
+			if _, err := Parse(data); err != nil {
+				t.Fatalf("failed to parse %s: %v", path, err)
+			}
+			roundTrip(t, data)
+		})
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, data := range fixtureConfigs(f) {
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		roundTrip(t, data)
+	})
+}
+
+func FuzzParseScriptSource(f *testing.F) {
+	for _, data := range fixtureConfigs(f) {
+		f.Add(string(data))
+	}
+	f.Add(`Project("example", "0.1.0")
+Language("c++", "c++17")
+Compiler("gcc")
+Flags("-Wall", "-Wextra")
+Executable("example", [Sources("src/*.cpp"), Exclude("src/legacy/*.cpp"), IncludeDirs("include")])
+Target("debug", [Flags("-g", "-O0")])
+Target("release", [Flags("-O2")])
+`)
+	f.Add(`Project("example", "0.1.0", language = "c", standard = "c17")
+
+warnings = ["-Wall", "-Wextra"]
+if host.os == "linux":
+    warnings.append("-pthread")
+Flags(warnings)
+
+Executable("example", sources = glob("src/**/*.c", exclude = ["src/legacy/*.c"]), include_dirs = ["include"])
+
+for name, level in {"debug": "-O0", "release": "-O2"}.items():
+    Target(name, flags = [level] + env("EXTRA_CFLAGS").split())
+`)
+	f.Fuzz(func(t *testing.T, content string) {
+		// scripts may fail, but must not panic or hang
+		ParseScriptSource(content)
+	})
+}
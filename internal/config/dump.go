@@ -0,0 +1,113 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Dump writes the configuration to w as TOML or JSON, with the keys of
+// styx.toml. Settings left empty are left out.
+func (c *Config) Dump(w io.Writer, format string) error {
+	settings, _ := dumpValue(reflect.ValueOf(*c)).(map[string]interface{})
+	if settings == nil {
+		settings = make(map[string]interface{})
+	}
+
+	switch format {
+	case "toml":
+		encoder := toml.NewEncoder(w)
+		encoder.Indent = ""
+		if err := encoder.Encode(settings); err != nil {
+			return fmt.Errorf("failed to encode configuration: %w", err)
+		}
+	case "json":
+		data, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode configuration: %w", err)
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("failed to write configuration: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown format: %s (must be toml or json)", format)
+	}
+	return nil
+}
+
+// dumpValue returns v as maps keyed by the keys of styx.toml, slices and
+// plain values, or nil when it is empty
+func dumpValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		// a value set through a pointer is set even when it is zero
+		if v.Kind() == reflect.Pointer && v.Elem().Kind() != reflect.Struct {
+			return v.Elem().Interface()
+		}
+		return dumpValue(v.Elem())
+	case reflect.Struct:
+		settings := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			key, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+			if !field.IsExported() || key == "" || key == "-" {
+				continue
+			}
+			if value := dumpValue(v.Field(i)); value != nil {
+				settings[key] = value
+			}
+		}
+		if len(settings) == 0 {
+			return nil
+		}
+		return settings
+	case reflect.Map:
+		settings := make(map[string]interface{})
+		iter := v.MapRange()
+		for iter.Next() {
+			// tables with nothing set are kept, as their names count
+			value := dumpValue(iter.Value())
+			if value == nil && iter.Value().Kind() == reflect.Struct {
+				value = map[string]interface{}{}
+			}
+			if value != nil {
+				settings[iter.Key().String()] = value
+			}
+		}
+		if len(settings) == 0 {
+			return nil
+		}
+		return settings
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Struct {
+			tables := make([]map[string]interface{}, v.Len())
+			for i := range tables {
+				tables[i], _ = dumpValue(v.Index(i)).(map[string]interface{})
+				if tables[i] == nil {
+					tables[i] = map[string]interface{}{}
+				}
+			}
+			return tables
+		}
+		values := make([]interface{}, v.Len())
+		for i := range values {
+			values[i] = v.Index(i).Interface()
+		}
+		return values
+	default:
+		if v.IsZero() {
+			return nil
+		}
+		return v.Interface()
+	}
+}
@@ -0,0 +1,401 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Editor edits a TOML configuration in place, keeping its comments, the
+// order of its tables and keys and their formatting. It changes arrays of
+// strings only, in tables and in the entries of [[binaries]] and
+// [[libraries]], which is what styx add needs.
+type Editor struct {
+	lines   []string
+	newline string
+}
+
+// section is a table of the configuration: the line of its header, -1 for
+// the keys before the first table, and the line of the next header
+type section struct {
+	header int
+	end    int
+}
+
+// arrayValue is a string of an array, with where it ends
+type arrayValue struct {
+	value string
+	line  int
+	col   int
+}
+
+// array is an array of strings, from its opening to its closing bracket
+type array struct {
+	startLine, startCol int
+	endLine, endCol     int
+	values              []arrayValue
+}
+
+// NewEditor returns an Editor of the configuration data
+func NewEditor(data []byte) *Editor {
+	text := string(data)
+	newline := "\n"
+	if strings.Contains(text, "\r\n") {
+		newline = "\r\n"
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+	}
+	return &Editor{lines: strings.Split(text, "\n"), newline: newline}
+}
+
+// Bytes returns the edited configuration
+func (e *Editor) Bytes() []byte {
+	return []byte(strings.Join(e.lines, e.newline))
+}
+
+// AddToArray adds value to the array key of the table named table, like
+// build or targets.release, creating the key and the table when they are
+// missing. It reports whether value was added, rather than found there.
+func (e *Editor) AddToArray(table, key, value string) (bool, error) {
+	sec, ok := e.table(table)
+	if !ok {
+		e.appendTable("["+table+"]", key, value)
+		return true, nil
+	}
+	return e.addToSection(sec, "["+table+"]", key, value)
+}
+
+// AddTable adds an empty table named name at the end, unless there is one
+// already, and reports whether it was added
+func (e *Editor) AddTable(name string) bool {
+	if _, ok := e.table(name); ok {
+		return false
+	}
+	e.trimEnd()
+	e.lines = append(e.lines, "["+name+"]", "")
+	return true
+}
+
+// AddToArtifactArray adds value to the array key of the binary or library
+// called name, creating the key when it is missing. It reports whether
+// value was added, rather than found there.
+func (e *Editor) AddToArtifactArray(name, key, value string) (bool, error) {
+	for _, kind := range []string{"binaries", "libraries"} {
+		for _, sec := range e.sections() {
+			if sec.header < 0 {
+				continue
+			}
+			if header, array, _ := parseHeader(e.lines[sec.header]); !array || header != kind {
+				continue
+			}
+			if e.stringKey(sec, "name") == name {
+				return e.addToSection(sec, "[["+kind+"]]", key, value)
+			}
+		}
+	}
+	return false, fmt.Errorf("no binary or library named %s", name)
+}
+
+// table returns the section of the table called name
+func (e *Editor) table(name string) (section, bool) {
+	for _, sec := range e.sections() {
+		if sec.header < 0 {
+			continue
+		}
+		if header, array, _ := parseHeader(e.lines[sec.header]); !array && header == name {
+			return sec, true
+		}
+	}
+	return section{}, false
+}
+
+// sections splits the configuration into its tables
+func (e *Editor) sections() []section {
+	var sections []section
+	current := section{header: -1}
+	for i := 0; i < len(e.lines); i++ {
+		if _, _, ok := parseHeader(e.lines[i]); ok {
+			current.end = i
+			sections = append(sections, current)
+			current = section{header: i}
+			continue
+		}
+		// headers are not looked for inside arrays spanning lines
+		if _, col, ok := keyValue(e.lines[i]); ok && strings.HasPrefix(e.lines[i][col:], "[") {
+			if arr, ok := e.array(i, col); ok {
+				i = arr.endLine
+			}
+		}
+	}
+	current.end = len(e.lines)
+	return append(sections, current)
+}
+
+// addToSection adds value to the array key of sec, whose header is named
+// header in errors
+func (e *Editor) addToSection(sec section, header, key, value string) (bool, error) {
+	insertAt := sec.header + 1
+	for i := sec.header + 1; i < sec.end; i++ {
+		name, col, ok := keyValue(e.lines[i])
+		if !ok {
+			continue
+		}
+		last := i
+		var arr array
+		isArray := strings.HasPrefix(e.lines[i][col:], "[")
+		if isArray {
+			if arr, isArray = e.array(i, col); isArray {
+				last = arr.endLine
+			}
+		}
+		if name == key {
+			if !isArray {
+				return false, fmt.Errorf("%s in %s is not an array", key, header)
+			}
+			for _, v := range arr.values {
+				if v.value == value {
+					return false, nil
+				}
+			}
+			e.insertValue(arr, value)
+			return true, nil
+		}
+		insertAt = last + 1
+		i = last
+	}
+
+	e.insert(insertAt, key+" = [ "+quote(value)+" ]")
+	return true, nil
+}
+
+// Contains reports whether the edited configuration, once decoded, has
+// value in the array key of the table named table, or of the binary or
+// library called artifact when table is empty
+func (e *Editor) Contains(table, artifact, key, value string) bool {
+	var doc map[string]interface{}
+	if _, err := toml.Decode(string(e.Bytes()), &doc); err != nil {
+		return false
+	}
+
+	var values map[string]interface{}
+	if table != "" {
+		values = doc
+		for _, name := range strings.Split(table, ".") {
+			values, _ = values[name].(map[string]interface{})
+		}
+	} else {
+		for _, kind := range []string{"binaries", "libraries"} {
+			entries, _ := doc[kind].([]map[string]interface{})
+			for _, entry := range entries {
+				if entry["name"] == artifact {
+					values = entry
+				}
+			}
+		}
+	}
+
+	items, _ := values[key].([]interface{})
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// appendTable adds a table with the array key holding value at the end
+func (e *Editor) appendTable(header, key, value string) {
+	e.trimEnd()
+	e.lines = append(e.lines, header, key+" = [ "+quote(value)+" ]", "")
+}
+
+// trimEnd ends the configuration with a blank line, for a table to follow
+func (e *Editor) trimEnd() {
+	for len(e.lines) > 0 && strings.TrimSpace(e.lines[len(e.lines)-1]) == "" {
+		e.lines = e.lines[:len(e.lines)-1]
+	}
+	if len(e.lines) > 0 {
+		e.lines = append(e.lines, "")
+	}
+}
+
+// insertValue adds value at the end of arr, in the layout of the array
+func (e *Editor) insertValue(arr array, value string) {
+	q := quote(value)
+	line := e.lines[arr.endLine]
+	before := strings.TrimRightFunc(line[:arr.endCol], isSpace)
+	space, after := line[len(before):arr.endCol], line[arr.endCol:]
+
+	// the closing bracket follows the opening one or a value on its line
+	if arr.startLine == arr.endLine || strings.TrimSpace(before) != "" {
+		switch {
+		case strings.HasSuffix(before, "["):
+			e.lines[arr.endLine] = before + " " + q + " " + after
+		case strings.HasSuffix(before, ","):
+			e.lines[arr.endLine] = before + " " + q + space + after
+		default:
+			e.lines[arr.endLine] = before + ", " + q + space + after
+		}
+		return
+	}
+
+	// one value per line: the new one goes on a line of its own
+	indent := leadingSpace(e.lines[arr.startLine]) + "    "
+	comma := ""
+	if len(arr.values) > 0 {
+		last := arr.values[len(arr.values)-1]
+		lastLine := e.lines[last.line]
+		indent = leadingSpace(lastLine)
+		if strings.HasPrefix(strings.TrimSpace(lastLine[last.col:]), ",") {
+			comma = ","
+		} else {
+			e.lines[last.line] = lastLine[:last.col] + "," + lastLine[last.col:]
+		}
+	}
+	e.insert(arr.endLine, indent+q+comma)
+}
+
+// insert inserts line before the line at i
+func (e *Editor) insert(i int, line string) {
+	e.lines = append(e.lines[:i], append([]string{line}, e.lines[i:]...)...)
+}
+
+// stringKey returns the string value of key in sec, if it is one
+func (e *Editor) stringKey(sec section, key string) string {
+	for i := sec.header + 1; i < sec.end; i++ {
+		if name, col, ok := keyValue(e.lines[i]); ok && name == key {
+			text := strings.TrimSpace(stripComment(e.lines[i][col:]))
+			if value, err := strconv.Unquote(text); err == nil {
+				return value
+			}
+			return strings.Trim(text, "'")
+		}
+	}
+	return ""
+}
+
+// array reads the array of strings opening at the column col of line i
+func (e *Editor) array(i, col int) (array, bool) {
+	arr := array{startLine: i, startCol: col}
+	depth := 0
+	for line := i; line < len(e.lines); line++ {
+		text := e.lines[line]
+		start := 0
+		if line == i {
+			start = col
+		}
+		for c := start; c < len(text); c++ {
+			switch ch := text[c]; ch {
+			case '#':
+				c = len(text)
+			case '[':
+				depth++
+			case ']':
+				depth--
+				if depth == 0 {
+					arr.endLine, arr.endCol = line, c
+					return arr, true
+				}
+			case '"', '\'':
+				end := stringEnd(text, c)
+				if end < 0 {
+					return array{}, false
+				}
+				value := text[c+1 : end]
+				if ch == '"' {
+					unquoted, err := strconv.Unquote(text[c : end+1])
+					if err != nil {
+						return array{}, false
+					}
+					value = unquoted
+				}
+				if depth == 1 {
+					arr.values = append(arr.values, arrayValue{value: value, line: line, col: end + 1})
+				}
+				c = end
+			}
+		}
+	}
+	return array{}, false
+}
+
+// parseHeader returns the name of the table a line is the header of, and
+// whether it is an array of tables
+func parseHeader(line string) (string, bool, bool) {
+	text := strings.TrimSpace(stripComment(line))
+	if !strings.HasPrefix(text, "[") || !strings.HasSuffix(text, "]") {
+		return "", false, false
+	}
+	array := strings.HasPrefix(text, "[[") && strings.HasSuffix(text, "]]")
+	name := strings.Trim(text, "[]")
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+	return strings.Join(parts, "."), array, true
+}
+
+// keyValue returns the key a line sets and the column its value starts at
+func keyValue(line string) (string, int, bool) {
+	eq := strings.IndexByte(line, '=')
+	if eq < 0 {
+		return "", 0, false
+	}
+	key := strings.TrimSpace(line[:eq])
+	if key == "" || strings.ContainsAny(key, "#[\"'") {
+		return "", 0, false
+	}
+	col := eq + 1
+	for col < len(line) && isSpace(rune(line[col])) {
+		col++
+	}
+	return key, col, true
+}
+
+// stringEnd returns the column of the quote closing the string opening at
+// the column start of text, -1 when it is not closed on the line
+func stringEnd(text string, start int) int {
+	quote := text[start]
+	for c := start + 1; c < len(text); c++ {
+		switch {
+		case text[c] == '\\' && quote == '"':
+			c++
+		case text[c] == quote:
+			return c
+		}
+	}
+	return -1
+}
+
+// stripComment removes the comment at the end of a line
+func stripComment(line string) string {
+	for c := 0; c < len(line); c++ {
+		switch line[c] {
+		case '#':
+			return line[:c]
+		case '"', '\'':
+			end := stringEnd(line, c)
+			if end < 0 {
+				return line
+			}
+			c = end
+		}
+	}
+	return line
+}
+
+// quote returns value as a TOML basic string
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// leadingSpace returns the indentation of a line
+func leadingSpace(line string) string {
+	return line[:len(line)-len(strings.TrimLeftFunc(line, isSpace))]
+}
+
+// isSpace reports whether r is a space or a tab
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// outputNameVarRe matches the variables of output_name templates, like
+// ${version}
+var outputNameVarRe = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// OutputNameValues are the values of the variables of output_name: Name is
+// the name of the project, or of the binary or library, Platform the one
+// built for (linux, macos or windows), Arch its architecture, like x86_64,
+// and Target the target built
+type OutputNameValues struct {
+	Name     string
+	Version  string
+	Platform string
+	Arch     string
+	Target   string
+}
+
+// ExpandOutputName replaces the variables of an output_name template, like
+// myapp-${version}-${platform}, by their values
+func ExpandOutputName(template string, values OutputNameValues) string {
+	return outputNameVarRe.ReplaceAllStringFunc(template, func(variable string) string {
+		switch variable[2 : len(variable)-1] {
+		case "name":
+			return values.Name
+		case "version":
+			return values.Version
+		case "platform":
+			return values.Platform
+		case "arch":
+			return values.Arch
+		case "target":
+			return values.Target
+		}
+		return variable
+	})
+}
+
+// OutputNameTemplate returns the output_name of a binary or library, its
+// name when it has none
+func (a ArtifactConfig) OutputNameTemplate() string {
+	if a.OutputName == "" {
+		return a.Name
+	}
+	return a.OutputName
+}
+
+// validateOutputName checks that an output_name template only uses known
+// variables, ${version} only when the project has a version, and names a
+// file rather than a path
+func validateOutputName(template, projectVersion string) error {
+	if strings.ContainsAny(template, `/\`) {
+		return fmt.Errorf("invalid output_name: %s (must be a file name)", template)
+	}
+	for _, match := range outputNameVarRe.FindAllStringSubmatch(template, -1) {
+		switch match[1] {
+		case "name", "platform", "arch", "target":
+		case "version":
+			if projectVersion == "" {
+				return fmt.Errorf("output_name %s uses ${version}, but the project has no version", template)
+			}
+		default:
+			return fmt.Errorf("invalid output_name: unknown variable %s (must be name, version, platform, arch or target)", match[0])
+		}
+	}
+	return nil
+}
+
+// validateLibraryVersion checks the version and soversion of an output of
+// outputType: only shared libraries have them, and both are made of at
+// most three numbers, like 1.2.3, which the linker of macOS takes as well
+func validateLibraryVersion(outputType, version, soversion string) error {
+	if version == "" && soversion == "" {
+		return nil
+	}
+	if outputType != "shared_lib" {
+		return fmt.Errorf("version and soversion only apply to shared libraries, not %s", outputType)
+	}
+	for _, v := range []string{version, soversion} {
+		if v != "" && !isLibraryVersion(v) {
+			return fmt.Errorf("invalid library version: %s (must be like 1, 1.2 or 1.2.3)", v)
+		}
+	}
+	return nil
+}
+
+// isLibraryVersion reports whether v is one to three numbers separated by
+// dots
+func isLibraryVersion(v string) bool {
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return false
+	}
+	for _, part := range parts {
+		if _, err := strconv.ParseUint(part, 10, 16); err != nil {
+			return false
+		}
+	}
+	return true
+}
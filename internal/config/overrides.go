@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// overridePrefix starts the names of the environment variables overriding
+// keys of the configuration
+const overridePrefix = "STYX_"
+
+// reservedVariables are the variables of styx that override no key
+var reservedVariables = map[string]bool{
+	"STYX_ENV": true, "STYX_THEME": true, "STYX_TELEMETRY": true, "STYX_CACHE_DIR": true,
+}
+
+// override is a key of the configuration an environment variable can set
+type override struct {
+	path []string
+	typ  reflect.Type
+	set  func(reflect.Value)
+}
+
+// overrideVariable returns the environment variable overriding the key at
+// path, like STYX_TOOLCHAIN_COMPILER for toolchain.compiler: STYX_ and
+// the parts of the path joined by underscores, in capitals, with the dashes
+// and dots of names turned into underscores as well
+func overrideVariable(path ...string) string {
+	name := strings.NewReplacer("-", "_", ".", "_").Replace(strings.Join(path, "_"))
+	return overridePrefix + strings.ToUpper(name)
+}
+
+// overrides returns the keys of config environment variables can set, by
+// variable: the keys of its tables, and of the entries of its tables of
+// named entries, like targets. Arrays of tables, like [[binaries]], cannot
+// be set.
+func overrides(config *Config) map[string]override {
+	found := make(map[string]override)
+	add := func(path []string, typ reflect.Type, set func(reflect.Value)) {
+		found[overrideVariable(path...)] = override{path: path, typ: typ, set: set}
+	}
+
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		table, field := tomlKey(v.Type().Field(i)), v.Field(i)
+		if table == "" {
+			continue
+		}
+		switch {
+		case field.Kind() == reflect.Struct:
+			for j := 0; j < field.NumField(); j++ {
+				key, value := tomlKey(field.Type().Field(j)), field.Field(j)
+				if key != "" && settable(value.Type()) {
+					add([]string{table, key}, value.Type(), value.Set)
+				}
+			}
+		case field.Kind() == reflect.Map && field.Type().Elem().Kind() == reflect.Struct:
+			entry := field.Type().Elem()
+			iter := field.MapRange()
+			for iter.Next() {
+				name := iter.Key()
+				for j := 0; j < entry.NumField(); j++ {
+					key := tomlKey(entry.Field(j))
+					if key == "" || !settable(entry.Field(j).Type) {
+						continue
+					}
+					add([]string{table, name.String(), key}, entry.Field(j).Type, func(value reflect.Value) {
+						updated := reflect.New(entry).Elem()
+						updated.Set(field.MapIndex(name))
+						updated.Field(j).Set(value)
+						field.SetMapIndex(name, updated)
+					})
+				}
+			}
+		}
+	}
+	return found
+}
+
+// tomlKey returns the key of a field in styx.toml, empty for none
+func tomlKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+	if !field.IsExported() || key == "-" {
+		return ""
+	}
+	return key
+}
+
+// settable reports whether a variable can set a key of type typ: any but
+// tables and arrays of tables
+func settable(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Struct:
+		return false
+	case reflect.Slice:
+		return typ.Elem().Kind() != reflect.Struct
+	}
+	return true
+}
+
+// applyOverrides sets the keys of config named by the variables of environ,
+// a list of NAME=VALUE like os.Environ. Values are TOML values, except that
+// strings need no quotes and lists may be given as words separated by
+// spaces. It returns the keys set, and records the variables starting with
+// STYX_ that name no key.
+func applyOverrides(config *Config, environ []string) ([][]string, error) {
+	var names []string
+	values := make(map[string]string)
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		if strings.HasPrefix(name, overridePrefix) && !reservedVariables[name] {
+			names = append(names, name)
+			values[name] = value
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	sort.Strings(names)
+
+	keys := overrides(config)
+	var set [][]string
+	for _, name := range names {
+		key, ok := keys[name]
+		if !ok {
+			config.unknownVariables = append(config.unknownVariables, name)
+			continue
+		}
+		value, err := overrideValue(key.typ, values[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value for %s: %w", name, strings.Join(key.path, "."), err)
+		}
+		key.set(value)
+		set = append(set, key.path)
+	}
+	return set, nil
+}
+
+// overrideValue decodes the value of a variable for a key of type typ
+func overrideValue(typ reflect.Type, value string) (reflect.Value, error) {
+	base := typ
+	if base.Kind() == reflect.Pointer {
+		base = base.Elem()
+	}
+
+	literals := []string{value}
+	switch {
+	case base.Kind() == reflect.String:
+		// types reading other TOML values, like lto, get them as well
+		literals = []string{quote(value)}
+		if reflect.PointerTo(base).Implements(reflect.TypeOf((*toml.Unmarshaler)(nil)).Elem()) {
+			literals = []string{value, quote(value)}
+		}
+	case base.Kind() == reflect.Slice && base.Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "["):
+		words := strings.Fields(value)
+		for i, word := range words {
+			words[i] = quote(word)
+		}
+		literals = []string{"[" + strings.Join(words, ", ") + "]"}
+	}
+
+	holder := reflect.StructOf([]reflect.StructField{{Name: "Value", Type: typ, Tag: `toml:"value"`}})
+	var err error
+	for _, literal := range literals {
+		decoded := reflect.New(holder)
+		if _, err = toml.Decode("value = "+literal, decoded.Interface()); err == nil {
+			return decoded.Elem().Field(0), nil
+		}
+	}
+	return reflect.Value{}, err
+}
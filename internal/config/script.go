@@ -3,33 +3,83 @@ package config
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+
+	"github.com/deviceix/styx/internal/platform"
 )
 
-// ScriptParser parses a custom DSL script configuration
-type ScriptParser struct {
-	content      string
-	config       *Config
-	currentBlock string
+// scriptOptions are the dialect of styx.script: Starlark with if, for and
+// while statements allowed at the top level, where build scripts do most of
+// their work, and with globals that can be reassigned
+var scriptOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+}
+
+// script is the state of a configuration script being run: the
+// configuration its builtins fill in
+type script struct {
+	dir    string // the directory of the script, which glob is relative to
+	config *Config
+	flags  []*scriptItem // every item returned by Flags, in order
 }
 
+// scriptItem is a list of strings returned by Sources, Exclude, IncludeDirs
+// or Flags, to be put in the block of an output or a target
+type scriptItem struct {
+	kind   string
+	values []string
+	used   bool // put in a target; Flags outside of targets apply to all
+}
+
+// scriptItem is a starlark.Value
+func (item *scriptItem) String() string {
+	quoted := make([]string, len(item.values))
+	for i, value := range item.values {
+		quoted[i] = starlark.String(value).String()
+	}
+	return fmt.Sprintf("%s(%s)", item.kind, strings.Join(quoted, ", "))
+}
+func (item *scriptItem) Type() string          { return item.kind }
+func (item *scriptItem) Freeze()               {}
+func (item *scriptItem) Truth() starlark.Bool  { return len(item.values) > 0 }
+func (item *scriptItem) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: %s", item.kind) }
+
 // ParseScript parses a configuration script file
 func ParseScript(path string) (*Config, error) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil, fmt.Errorf("script file not found: %s", path)
+		return nil, fmt.Errorf("script %w: %s", ErrConfigNotFound, path)
 	}
 
-	content, err := ioutil.ReadFile(path)
+	content, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read script file: %w", err)
 	}
 
-	parser := &ScriptParser{
-		content: string(content),
+	return runScript(path, filepath.Dir(path), string(content))
+}
+
+// ParseScriptSource parses and validates the content of a configuration
+// script, run from the current directory
+func ParseScriptSource(content string) (*Config, error) {
+	return runScript("styx.script", ".", content)
+}
+
+// runScript runs the script named filename in dir and validates the
+// configuration it declares
+func runScript(filename, dir, content string) (*Config, error) {
+	s := &script{
+		dir: dir,
 		config: &Config{
 			Project:      ProjectConfig{},
 			Build:        BuildConfig{},
@@ -40,217 +90,414 @@ func ParseScript(path string) (*Config, error) {
 		},
 	}
 
-	if err := parser.parse(); err != nil {
-		return nil, err
+	thread := &starlark.Thread{Name: filename}
+	if _, err := starlark.ExecFileOptions(scriptOptions, thread, filename, legacyComments(content), s.builtins()); err != nil {
+		return nil, scriptError(err)
+	}
+
+	for _, item := range s.flags {
+		if !item.used {
+			s.config.Toolchain.CFlags = append(s.config.Toolchain.CFlags, item.values...)
+			s.config.Toolchain.CXXFlags = append(s.config.Toolchain.CXXFlags, item.values...)
+			s.config.Toolchain.OBJCFlags = append(s.config.Toolchain.OBJCFlags, item.values...)
+			s.config.Toolchain.OBJCXXFlags = append(s.config.Toolchain.OBJCXXFlags, item.values...)
+		}
 	}
 
-	if err := validateConfig(parser.config); err != nil {
+	if _, err := applyOverrides(s.config, os.Environ()); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := validateConfig(s.config); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	return parser.config, nil
+	return s.config, nil
 }
 
-// parse processes the script content
-func (p *ScriptParser) parse() error {
-	lines := strings.Split(p.content, "\n")
-
-	for lineNum, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "//") {
-			continue
-		}
-
-		if err := p.parseLine(line, lineNum+1); err != nil {
-			return fmt.Errorf("line %d: %w", lineNum+1, err)
+// legacyComments turns the // comments of scripts written before scripts
+// were Starlark into # comments, keeping the lines where they are
+func legacyComments(content string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		if strings.HasPrefix(trimmed, "//") {
+			lines[i] = line[:len(line)-len(trimmed)] + "#" + trimmed[2:]
 		}
 	}
-
-	return nil
+	return strings.Join(lines, "\n")
 }
 
-// parseLine handles a single line of script
-func (p *ScriptParser) parseLine(line string, lineNum int) error {
-	// Project declaration
-	if match := regexp.MustCompile(`Project\s*\(\s*"([^"]+)"\s*,\s*"([^"]+)"\s*\)`).FindStringSubmatch(line); match != nil {
-		p.config.Project.Name = match[1]
-		p.config.Project.Version = match[2]
-		return nil
+// scriptError returns the error of a script failing, located at the line of
+// the script that failed rather than inside a builtin
+func scriptError(err error) error {
+	var evalErr *starlark.EvalError
+	if !errors.As(err, &evalErr) {
+		return err
 	}
-
-	if match := regexp.MustCompile(`Language\s*\(\s*"([^"]+)"(?:\s*,\s*"([^"]+)")?\s*\)`).FindStringSubmatch(line); match != nil {
-		p.config.Project.Language = match[1]
-		if len(match) > 2 && match[2] != "" {
-			p.config.Project.Standard = match[2]
+	for i := len(evalErr.CallStack) - 1; i >= 0; i-- {
+		if pos := evalErr.CallStack[i].Pos; pos.IsValid() && pos.Filename() != "<builtin>" {
+			return fmt.Errorf("%s: %s", pos, evalErr.Msg)
 		}
-		return nil
 	}
+	return errors.New(evalErr.Msg)
+}
 
-	for _, outputType := range []string{"Executable", "StaticLib", "SharedLib"} {
-		pattern := fmt.Sprintf(`%s\s*\(\s*"([^"]+)"\s*,\s*\[\s*(.*?)\s*\]\s*\)`, outputType)
-		if match := regexp.MustCompile(pattern).FindStringSubmatch(line); match != nil {
-			p.config.Build.OutputName = match[1]
+// builtins returns the functions and values scripts are run with
+func (s *script) builtins() starlark.StringDict {
+	host := starlarkstruct.FromStringDict(starlark.String("host"), starlark.StringDict{
+		"os":   starlark.String(platform.GetPlatformInfo().Name),
+		"arch": starlark.String(runtime.GOARCH),
+	})
+
+	return starlark.StringDict{
+		"host":        host,
+		"Project":     starlark.NewBuiltin("Project", s.project),
+		"Language":    starlark.NewBuiltin("Language", s.language),
+		"Compiler":    starlark.NewBuiltin("Compiler", s.compiler),
+		"Flags":       starlark.NewBuiltin("Flags", s.item),
+		"Sources":     starlark.NewBuiltin("Sources", s.item),
+		"Exclude":     starlark.NewBuiltin("Exclude", s.item),
+		"IncludeDirs": starlark.NewBuiltin("IncludeDirs", s.item),
+		"Executable":  starlark.NewBuiltin("Executable", s.output),
+		"StaticLib":   starlark.NewBuiltin("StaticLib", s.output),
+		"SharedLib":   starlark.NewBuiltin("SharedLib", s.output),
+		"Target":      starlark.NewBuiltin("Target", s.target),
+		"glob":        starlark.NewBuiltin("glob", s.glob),
+		"env":         starlark.NewBuiltin("env", scriptEnv),
+	}
+}
 
-			switch outputType {
-			case "Executable":
-				p.config.Build.OutputType = "executable"
-			case "StaticLib":
-				p.config.Build.OutputType = "static_lib"
-			case "SharedLib":
-				p.config.Build.OutputType = "shared_lib"
-			}
+// outputTypes are the output types declared by the output builtins
+var outputTypes = map[string]string{
+	"Executable": "executable",
+	"StaticLib":  "static_lib",
+	"SharedLib":  "shared_lib",
+}
 
-			if err := p.parseBuildBlock(match[2]); err != nil {
-				return err
-			}
+// project implements Project(name, version="", language="", standard="")
+func (s *script) project(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	project := &s.config.Project
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"name", &project.Name, "version?", &project.Version,
+		"language?", &project.Language, "standard?", &project.Standard); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
 
-			return nil
-		}
+// language implements Language(language, standard="")
+func (s *script) language(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	project := &s.config.Project
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "language", &project.Language, "standard?", &project.Standard); err != nil {
+		return nil, err
 	}
-	// toolchains
-	// compiler
-	if match := regexp.MustCompile(`Compiler\s*\(\s*"([^"]+)"\s*\)`).FindStringSubmatch(line); match != nil {
-		p.config.Toolchain.Compiler = match[1]
-		return nil
+	return starlark.None, nil
+}
+
+// compiler implements Compiler(compiler)
+func (s *script) compiler(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "compiler", &s.config.Toolchain.Compiler); err != nil {
+		return nil, err
 	}
+	return starlark.None, nil
+}
 
-	// c-flags
-	if match := regexp.MustCompile(`Flags\s*\(\s*(.*?)\s*\)`).FindStringSubmatch(line); match != nil {
-		flags, err := parseStringList(match[1])
+// item implements Flags, Sources, Exclude and IncludeDirs, which take
+// strings or lists of strings
+func (s *script) item(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) > 0 {
+		return nil, fmt.Errorf("%s: unexpected keyword argument %s", fn.Name(), kwargs[0][0])
+	}
+	item := &scriptItem{kind: fn.Name()}
+	for _, arg := range args {
+		values, err := stringList(fn.Name(), arg)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		// add to global
-		p.config.Toolchain.CFlags = append(p.config.Toolchain.CFlags, flags...)
-		p.config.Toolchain.CXXFlags = append(p.config.Toolchain.CXXFlags, flags...)
-		return nil
+		item.values = append(item.values, values...)
 	}
+	if item.kind == "Flags" {
+		s.flags = append(s.flags, item)
+	}
+	return item, nil
+}
 
-	if match := regexp.MustCompile(`Target\s*\(\s*"([^"]+)"\s*,\s*\[\s*(.*?)\s*\]\s*\)`).FindStringSubmatch(line); match != nil {
-		targetName := match[1]
-		target := TargetConfig{
-			Env: make(map[string]string),
-		}
+// output implements Executable, StaticLib and SharedLib(name, items=[],
+// sources=[], exclude=[], include_dirs=[], version="", soversion=""), items
+// being the Sources, Exclude and IncludeDirs of the output and name its
+// output_name
+func (s *script) output(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, version, soversion string
+	var items, sources, exclude, includeDirs starlark.Value = starlark.NewList(nil), starlark.NewList(nil), starlark.NewList(nil), starlark.NewList(nil)
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"name", &name, "items?", &items, "sources?", &sources,
+		"exclude?", &exclude, "include_dirs?", &includeDirs,
+		"version?", &version, "soversion?", &soversion); err != nil {
+		return nil, err
+	}
+	build := &s.config.Build
+	if build.OutputName != "" {
+		return nil, fmt.Errorf("%s: the output is already declared as %s", fn.Name(), build.OutputName)
+	}
+	build.OutputName = name
+	build.OutputType = outputTypes[fn.Name()]
+	build.Version = version
+	build.SOVersion = soversion
 
-		if err := p.parseTargetBlock(match[2], &target); err != nil {
-			return err
+	blockItems, err := itemList(fn.Name(), items)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range blockItems {
+		switch item.kind {
+		case "Sources":
+			build.Sources = append(build.Sources, item.values...)
+		case "Exclude":
+			build.Exclude = append(build.Exclude, item.values...)
+		case "IncludeDirs":
+			build.IncludeDirs = append(build.IncludeDirs, item.values...)
+		default:
+			return nil, fmt.Errorf("%s: %s belongs in a Target or at the top level", fn.Name(), item.kind)
 		}
-
-		p.config.Targets[targetName] = target
-		return nil
 	}
 
-	return fmt.Errorf("unrecognized statement: %s", line)
+	for _, field := range []struct {
+		value starlark.Value
+		list  *[]string
+	}{{sources, &build.Sources}, {exclude, &build.Exclude}, {includeDirs, &build.IncludeDirs}} {
+		values, err := stringList(fn.Name(), field.value)
+		if err != nil {
+			return nil, err
+		}
+		*field.list = append(*field.list, values...)
+	}
+	return starlark.None, nil
 }
 
-// parseBuildBlock processes the contents of a build block
-func (p *ScriptParser) parseBuildBlock(content string) error {
-	items := extractBlockItems(content)
+// target implements Target(name, items=[], flags=[], linker_flags=[],
+// libs=[], lib_dirs=[], sanitizers=[], lto=False, env={}), items being the
+// Flags of the target and lto "thin", "full" or a boolean
+func (s *script) target(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var items, flags, linkerFlags, libs, libDirs, sanitizers starlark.Value = starlark.NewList(nil), starlark.NewList(nil), starlark.NewList(nil), starlark.NewList(nil), starlark.NewList(nil), starlark.NewList(nil)
+	var lto starlark.Value = starlark.False
+	env := new(starlark.Dict)
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"name", &name, "items?", &items, "flags?", &flags, "linker_flags?", &linkerFlags,
+		"libs?", &libs, "lib_dirs?", &libDirs, "sanitizers?", &sanitizers, "lto?", &lto, "env?", &env); err != nil {
+		return nil, err
+	}
+	if _, ok := s.config.Targets[name]; ok {
+		return nil, fmt.Errorf("%s: target %s is already declared", fn.Name(), name)
+	}
+	target := TargetConfig{Env: make(map[string]string)}
 
-	for _, item := range items {
-		if match := regexp.MustCompile(`Sources\s*\(\s*(.*?)\s*\)`).FindStringSubmatch(item); match != nil {
-			sources, err := parseStringList(match[1])
-			if err != nil {
-				return err
-			}
-			p.config.Build.Sources = append(p.config.Build.Sources, sources...)
-			continue
+	blockItems, err := itemList(fn.Name(), items)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range blockItems {
+		if item.kind != "Flags" {
+			return nil, fmt.Errorf("%s: %s belongs in an Executable, StaticLib or SharedLib", fn.Name(), item.kind)
 		}
+		item.used = true
+		target.CFlags = append(target.CFlags, item.values...)
+		target.CXXFlags = append(target.CXXFlags, item.values...)
+		target.OBJCFlags = append(target.OBJCFlags, item.values...)
+		target.OBJCXXFlags = append(target.OBJCXXFlags, item.values...)
+	}
 
-		if match := regexp.MustCompile(`Exclude\s*\(\s*(.*?)\s*\)`).FindStringSubmatch(item); match != nil {
-			exclude, err := parseStringList(match[1])
-			if err != nil {
-				return err
-			}
-			p.config.Build.Exclude = append(p.config.Build.Exclude, exclude...)
-			continue
+	values, err := stringList(fn.Name(), flags)
+	if err != nil {
+		return nil, err
+	}
+	target.CFlags = append(target.CFlags, values...)
+	target.CXXFlags = append(target.CXXFlags, values...)
+	target.OBJCFlags = append(target.OBJCFlags, values...)
+	target.OBJCXXFlags = append(target.OBJCXXFlags, values...)
+
+	for _, field := range []struct {
+		value starlark.Value
+		list  *[]string
+	}{{linkerFlags, &target.LinkerFlags}, {libs, &target.Libs}, {libDirs, &target.LibDirs}, {sanitizers, &target.Sanitizers}} {
+		values, err := stringList(fn.Name(), field.value)
+		if err != nil {
+			return nil, err
 		}
+		*field.list = append(*field.list, values...)
+	}
 
-		if match := regexp.MustCompile(`IncludeDirs\s*\(\s*(.*?)\s*\)`).FindStringSubmatch(item); match != nil {
-			includeDirs, err := parseStringList(match[1])
-			if err != nil {
-				return err
-			}
-			p.config.Build.IncludeDirs = append(p.config.Build.IncludeDirs, includeDirs...)
-			continue
+	switch v := lto.(type) {
+	case starlark.String:
+		target.LTO = LTOMode(v)
+	case starlark.Bool:
+		if v {
+			target.LTO = "full"
 		}
+	default:
+		return nil, fmt.Errorf("%s: lto must be \"thin\", \"full\" or a boolean, not %s", fn.Name(), lto.Type())
+	}
 
-		return fmt.Errorf("unrecognized build item: %s", item)
+	for _, pair := range env.Items() {
+		key, ok := starlark.AsString(pair[0])
+		value, ok2 := starlark.AsString(pair[1])
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("%s: env must map strings to strings", fn.Name())
+		}
+		target.Env[key] = value
 	}
 
-	return nil
+	s.config.Targets[name] = target
+	return starlark.None, nil
 }
 
-// parseTargetBlock processes the contents of a target block
-func (p *ScriptParser) parseTargetBlock(content string, target *TargetConfig) error {
-	items := extractBlockItems(content)
-	for _, item := range items {
-		if match := regexp.MustCompile(`Flags\s*\(\s*(.*?)\s*\)`).FindStringSubmatch(item); match != nil {
-			flags, err := parseStringList(match[1])
-			if err != nil {
-				return err
-			}
-			target.CFlags = append(target.CFlags, flags...)
-			target.CXXFlags = append(target.CXXFlags, flags...)
-			continue
+// glob implements glob(*patterns, exclude=[]), returning the files matching
+// patterns relative to the script, sorted. A ** in a pattern matches any
+// number of directories.
+func (s *script) glob(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var exclude starlark.Value = starlark.NewList(nil)
+	if err := starlark.UnpackArgs(fn.Name(), nil, kwargs, "exclude?", &exclude); err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, arg := range args {
+		values, err := stringList(fn.Name(), arg)
+		if err != nil {
+			return nil, err
 		}
+		patterns = append(patterns, values...)
+	}
+	excluded, err := stringList(fn.Name(), exclude)
+	if err != nil {
+		return nil, err
+	}
 
-		return fmt.Errorf("unrecognized target item: %s", item)
+	found := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, err := globFiles(s.dir, filepath.FromSlash(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		for _, match := range matches {
+			found[match] = true
+		}
+	}
+	for _, pattern := range excluded {
+		matches, err := globFiles(s.dir, filepath.FromSlash(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		for _, match := range matches {
+			delete(found, match)
+		}
 	}
 
-	return nil
+	files := make([]string, 0, len(found))
+	for file := range found {
+		files = append(files, filepath.ToSlash(file))
+	}
+	sort.Strings(files)
+	values := make([]starlark.Value, len(files))
+	for i, file := range files {
+		values[i] = starlark.String(file)
+	}
+	return starlark.NewList(values), nil
 }
 
-// parseStringList converts a list of quoted strings to a string slice
-func parseStringList(content string) ([]string, error) {
-	var result []string
+// globFiles returns the files under dir matching pattern, relative to dir
+func globFiles(dir, pattern string) ([]string, error) {
+	if filepath.IsAbs(pattern) {
+		return nil, fmt.Errorf("pattern must be relative: %s", pattern)
+	}
+	before, after, recursive := strings.Cut(pattern, "**"+string(filepath.Separator))
+	if !recursive {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		var files []string
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && !info.IsDir() {
+				rel, _ := filepath.Rel(dir, match)
+				files = append(files, rel)
+			}
+		}
+		return files, nil
+	}
 
-	pattern := `"([^"]+)"`
-	re := regexp.MustCompile(pattern)
-	matches := re.FindAllStringSubmatch(content, -1)
-	for _, match := range matches {
-		if len(match) >= 2 {
-			result = append(result, match[1])
+	// every directory under the part before ** is tried with the part after
+	bases, err := filepath.Glob(filepath.Join(dir, before+"."))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+	}
+	var files []string
+	for _, base := range bases {
+		err := filepath.WalkDir(base, func(path string, entry os.DirEntry, err error) error {
+			if err != nil || !entry.IsDir() {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, path)
+			matches, err := globFiles(dir, filepath.Join(rel, after))
+			files = append(files, matches...)
+			return err
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
+	return files, nil
+}
 
-	return result, nil
+// scriptEnv implements env(name, default=""), returning the value of an
+// environment variable
+func scriptEnv(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, fallback string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "default?", &fallback); err != nil {
+		return nil, err
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return starlark.String(value), nil
+	}
+	return starlark.String(fallback), nil
 }
 
-// extractBlockItems splits a block content into individual items
-// note: this handles comma-separated items
-func extractBlockItems(content string) []string {
-	var items []string
-
-	// split by commas, but respect nested blocks
-	var currentItem strings.Builder
-	depth := 0
-	for _, char := range content {
-		switch char {
-		case '[', '(':
-			depth++
-			currentItem.WriteRune(char)
-		case ']', ')':
-			depth--
-			currentItem.WriteRune(char)
-		case ',':
-			if depth == 0 {
-				items = append(items, strings.TrimSpace(currentItem.String()))
-				currentItem.Reset()
-			} else {
-				currentItem.WriteRune(char)
-			}
-		default:
-			currentItem.WriteRune(char)
+// stringList returns the strings of value, a string or a list or tuple of
+// strings
+func stringList(fnname string, value starlark.Value) ([]string, error) {
+	if s, ok := starlark.AsString(value); ok {
+		return []string{s}, nil
+	}
+	iterable, ok := value.(starlark.Indexable)
+	if !ok {
+		return nil, fmt.Errorf("%s: got %s, want string or list of strings", fnname, value.Type())
+	}
+	values := make([]string, iterable.Len())
+	for i := range values {
+		s, ok := starlark.AsString(iterable.Index(i))
+		if !ok {
+			return nil, fmt.Errorf("%s: got %s in list, want string", fnname, iterable.Index(i).Type())
 		}
+		values[i] = s
 	}
+	return values, nil
+}
 
-	// append last if not empty
-	if currentItem.Len() > 0 {
-		items = append(items, strings.TrimSpace(currentItem.String()))
+// itemList returns the items of a block, a list of what Sources, Exclude,
+// IncludeDirs and Flags return
+func itemList(fnname string, value starlark.Value) ([]*scriptItem, error) {
+	iterable, ok := value.(starlark.Indexable)
+	if !ok {
+		return nil, fmt.Errorf("%s: got %s, want list of items", fnname, value.Type())
 	}
-
-	return items
+	items := make([]*scriptItem, iterable.Len())
+	for i := range items {
+		item, ok := iterable.Index(i).(*scriptItem)
+		if !ok {
+			return nil, fmt.Errorf("%s: got %s in items, want Sources, Exclude, IncludeDirs or Flags", fnname, iterable.Index(i).Type())
+		}
+		items[i] = item
+	}
+	return items, nil
 }
 
 // LoadScriptConfig attempts to load a script configuration file
@@ -287,5 +534,5 @@ func LoadScriptConfig(dir string) (*Config, error) {
 		}
 	}
 
-	return nil, errors.New("no script configuration file found")
+	return nil, fmt.Errorf("script %w", ErrConfigNotFound)
 }
@@ -5,8 +5,21 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/BurntSushi/toml"
+
+	"github.com/deviceix/styx/internal/i18n"
+	"github.com/deviceix/styx/internal/logger"
+)
+
+var (
+	// ErrConfigNotFound is returned when there is no configuration file to load
+	ErrConfigNotFound = errors.New("configuration file not found")
+	// ErrEnvironmentNotFound is returned when selecting an environment that
+	// is not configured
+	ErrEnvironmentNotFound = errors.New("environment not found")
 )
 
 // Config represents the TOML configuration for a Styx project
@@ -17,6 +30,34 @@ type Config struct {
 	Targets      map[string]TargetConfig      `toml:"targets"`
 	Dependencies map[string]DependencyConfig  `toml:"dependencies"`
 	Environment  map[string]EnvironmentConfig `toml:"environment"`
+	Test         TestConfig                   `toml:"test"`
+	Registry     RegistryConfig               `toml:"registry"`
+	Cache        CacheConfig                  `toml:"cache"`
+	Checks       ChecksConfig                 `toml:"checks"`
+	Binaries     []ArtifactConfig             `toml:"binaries"`
+	Libraries    []ArtifactConfig             `toml:"libraries"`
+	Examples     []ExampleConfig              `toml:"examples"`
+	Workspace    WorkspaceConfig              `toml:"workspace"`
+	Toolchains   map[string]ToolchainRelease  `toml:"toolchains"`
+	Install      InstallConfig                `toml:"install"`
+	Rules        []RuleConfig                 `toml:"rules"`
+	Plugins      map[string]PluginConfig      `toml:"plugins"`
+	Output       OutputConfig                 `toml:"output"`
+	UI           UIConfig                     `toml:"ui"`
+
+	// Env names the environment selected on the command line, if any
+	Env string `toml:"-"`
+
+	// the [build.<platform>] and [toolchain.<compiler>] sections, and the
+	// platform and compiler they were merged for
+	platformSections map[string]conditional
+	compilerSections map[string]conditional
+	conditions       string
+
+	// the keys of the file that set nothing, like misspelled ones, and the
+	// variables starting with STYX_ that override no key
+	unknownKeys      []string
+	unknownVariables []string
 }
 
 // ProjectConfig contains project metadata
@@ -27,101 +68,950 @@ type ProjectConfig struct {
 	Standard string `toml:"standard"`
 }
 
-// BuildConfig contains build settings
+// BuildConfig contains build settings. Jobs is the number of commands run
+// in parallel when none is given on the command line, 0 for one per CPU.
+// PreprocessAhead preprocesses the sources on idle workers ahead of their
+// compilation, which reads them from memory.
+// BatchSize is the number of small sources compiled by a single compiler
+// invocation, 0 or 1 to compile every source on its own.
+// OutputName may use the variables of ExpandOutputName. Version and
+// SOVersion version a shared library and its ABI.
+// MaxScanDepth is how deeply includes may nest and source directories be
+// walked, 0 for dependency.DefaultMaxDepth. StrictSources fails builds on
+// sources that cannot be read or are binary files, which are skipped
+// otherwise.
+// Warnings is the warning level of the compiler, none, default, strict or
+// everything, and WarningsAsErrors makes its warnings errors; targets may
+// override both. OutputLayout nests the outputs of targets in the output
+// directory as <target>, <platform>-<arch>/<target>, or not at all with
+// target, platform and flat. ObjectLayout places objects in a tree
+// mirroring the sources with mirror, the default, or in one directory with a
+// hash of their source in their names with hashed. ObjCARC compiles
+// Objective-C and Objective-C++ sources with automatic reference counting.
 type BuildConfig struct {
-	OutputType    string   `toml:"output_type"`
-	OutputName    string   `toml:"output_name"`
-	Sources       []string `toml:"sources"`
-	IncludeDirs   []string `toml:"include_dirs"`
-	Exclude       []string `toml:"exclude"`
-	PreBuildCmds  []string `toml:"pre_build_cmds"`
-	PostBuildCmds []string `toml:"post_build_cmds"`
+	OutputType       string   `toml:"output_type"`
+	OutputName       string   `toml:"output_name"`
+	Version          string   `toml:"version"`
+	SOVersion        string   `toml:"soversion"`
+	Sources          []string `toml:"sources"`
+	IncludeDirs      []string `toml:"include_dirs"`
+	Exclude          []string `toml:"exclude"`
+	PreBuildCmds     []string `toml:"pre_build_cmds"`
+	PostBuildCmds    []string `toml:"post_build_cmds"`
+	StdlibPCH        bool     `toml:"stdlib_pch"`
+	StdlibPCHHeaders []string `toml:"stdlib_pch_headers"`
+	PreprocessAhead  bool     `toml:"preprocess_ahead"`
+	BatchSize        int      `toml:"batch_size"`
+	ReportUnusedLibs bool     `toml:"report_unused_libs"`
+	StripDeadCode    bool     `toml:"strip_dead_code"`
+	LinkerScript     string   `toml:"linker_script"`
+	OutputFormat     string   `toml:"output_format"`
+	Libs             []string `toml:"libs"`
+	LibDirs          []string `toml:"lib_dirs"`
+	Frameworks       []string `toml:"frameworks"`
+	Jobs             int      `toml:"jobs"`
+	MaxScanDepth     int      `toml:"max_scan_depth"`
+	StrictSources    bool     `toml:"strict_sources"`
+	Warnings         string   `toml:"warnings"`
+	WarningsAsErrors bool     `toml:"warnings_as_errors"`
+	OutputLayout     string   `toml:"output_layout"`
+	ObjectLayout     string   `toml:"object_layout"`
+	ObjCARC          bool     `toml:"objc_arc"`
 }
 
-// ToolchainConfig contains compiler settings
+// ToolchainConfig contains compiler settings. Use selects one of the
+// downloadable toolchains declared under [toolchains] instead of the
+// compilers installed on the system. Assembly sources are assembled by the
+// compiler driver, except .asm files when Assembler names another one,
+// like nasm. CUDA sources are compiled by CUDACompiler, nvcc when empty, or
+// clang, for the GPU architectures of CUDAArchs, like sm_80; CUDASeparable
+// compiles relocatable device code, which is linked by a device link step.
 type ToolchainConfig struct {
 	Compiler      string   `toml:"compiler"`
+	Use           string   `toml:"use"`
+	Assembler     string   `toml:"assembler"`
 	CFlags        []string `toml:"c_flags"`
 	CXXFlags      []string `toml:"cxx_flags"`
+	OBJCFlags     []string `toml:"objc_flags"`
+	OBJCXXFlags   []string `toml:"objcxx_flags"`
+	ASMFlags      []string `toml:"asm_flags"`
+	CUDACompiler  string   `toml:"cuda_compiler"`
+	CUDAFlags     []string `toml:"cuda_flags"`
+	CUDAArchs     []string `toml:"cuda_archs"`
+	CUDASeparable bool     `toml:"cuda_separable"`
 	LinkerFlags   []string `toml:"linker_flags"`
 	ArchiverFlags []string `toml:"archiver_flags"`
+	Objcopy       string   `toml:"objcopy"`
+}
+
+// ToolchainRelease pins a downloadable toolchain: an archive per platform
+// (keyed by platform, or platform and architecture like linux-x86_64) and
+// its sha256. The compilers are expected under bin in the archive.
+type ToolchainRelease struct {
+	URLs   map[string]string `toml:"urls"`
+	SHA256 map[string]string `toml:"sha256"`
 }
 
-// TargetConfig contains target-specific build settings
+// TargetConfig contains target-specific build settings. Sanitizers names
+// the runtime sanitizers (address, undefined, thread, memory, leak) every
+// object and output of the target is instrumented with. LTO selects its
+// link-time optimization. Libs, LibDirs and Frameworks are linked in
+// addition to the ones of the build. Warnings and WarningsAsErrors override
+// the ones of the build when set. Strip strips the executables and shared
+// libraries of the target once linked, and SplitDebugInfo moves their debug
+// information to .debug files they link to.
 type TargetConfig struct {
-	CFlags      []string          `toml:"c_flags"`
-	CXXFlags    []string          `toml:"cxx_flags"`
-	LinkerFlags []string          `toml:"linker_flags"`
-	Env         map[string]string `toml:"env"`
+	CFlags           []string          `toml:"c_flags"`
+	CXXFlags         []string          `toml:"cxx_flags"`
+	OBJCFlags        []string          `toml:"objc_flags"`
+	OBJCXXFlags      []string          `toml:"objcxx_flags"`
+	ASMFlags         []string          `toml:"asm_flags"`
+	CUDAFlags        []string          `toml:"cuda_flags"`
+	LinkerFlags      []string          `toml:"linker_flags"`
+	Sanitizers       []string          `toml:"sanitizers"`
+	LTO              LTOMode           `toml:"lto"`
+	Warnings         string            `toml:"warnings"`
+	WarningsAsErrors *bool             `toml:"warnings_as_errors"`
+	Env              map[string]string `toml:"env"`
+	Libs             []string          `toml:"libs"`
+	LibDirs          []string          `toml:"lib_dirs"`
+	Frameworks       []string          `toml:"frameworks"`
+	Strip            bool              `toml:"strip"`
+	SplitDebugInfo   bool              `toml:"split_debug_info"`
+}
+
+// LTOMode is the link-time optimization of a target: "thin", "full", or
+// empty for none. lto = false in styx.toml leaves it out, and lto = true
+// selects full.
+type LTOMode string
+
+// UnmarshalTOML reads lto as a mode name or a boolean
+func (m *LTOMode) UnmarshalTOML(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		*m = LTOMode(v)
+	case bool:
+		*m = ""
+		if v {
+			*m = "full"
+		}
+	default:
+		return fmt.Errorf("invalid lto: %v (must be \"thin\", \"full\" or false)", value)
+	}
+	return nil
+}
+
+// cudaArchRe matches the GPU architectures of cuda_archs, like sm_80 or
+// sm_90a
+var cudaArchRe = regexp.MustCompile(`^sm_[0-9]+[a-z]?$`)
+
+// incompatibleSanitizers lists the sanitizers that cannot instrument the same
+// program, since their runtimes each take over the memory layout
+var incompatibleSanitizers = [][2]string{
+	{"address", "thread"},
+	{"address", "memory"},
+	{"thread", "memory"},
+	{"leak", "thread"},
+	{"leak", "memory"},
 }
 
 // DependencyConfig contains dependency information
 type DependencyConfig struct {
-	Version string `toml:"version"`
-	URL     string `toml:"url"`
-	Local   string `toml:"local"`
+	Version        string            `toml:"version"`
+	URL            string            `toml:"url"`
+	Local          string            `toml:"local"`
+	Git            string            `toml:"git"`
+	Tag            string            `toml:"tag"`
+	Branch         string            `toml:"branch"`
+	Rev            string            `toml:"rev"`
+	Kind           string            `toml:"kind"`
+	HeaderOnly     bool              `toml:"header_only"`
+	BuildCmd       string            `toml:"build_cmd"`
+	Outputs        []string          `toml:"outputs"`
+	IncludeDirs    []string          `toml:"include_dirs"`
+	CMakeOptions   []string          `toml:"cmake_options"`
+	ConfigureFlags []string          `toml:"configure_flags"`
+	URLs           map[string]string `toml:"urls"`
+	SHA256         map[string]string `toml:"sha256"`
+	Patches        []string          `toml:"patches"`
+}
+
+// ArtifactConfig describes one of several executables or libraries built by
+// a project; Links names libraries of the same project to link against.
+// OutputName names its output file instead of Name, and Version and
+// SOVersion version a shared library, as in [build].
+type ArtifactConfig struct {
+	Name        string   `toml:"name"`
+	Type        string   `toml:"type"`
+	OutputName  string   `toml:"output_name"`
+	Version     string   `toml:"version"`
+	SOVersion   string   `toml:"soversion"`
+	Sources     []string `toml:"sources"`
+	Exclude     []string `toml:"exclude"`
+	IncludeDirs []string `toml:"include_dirs"`
+	Links       []string `toml:"links"`
+	LinkerFlags []string `toml:"linker_flags"`
+}
+
+// ExampleConfig is a small program showing the use of the libraries of the
+// project, which styx run --example builds and runs. It links against the
+// libraries named by Links, every library of the project when empty, or
+// the library of [build].
+type ExampleConfig struct {
+	Name        string   `toml:"name"`
+	Sources     []string `toml:"sources"`
+	IncludeDirs []string `toml:"include_dirs"`
+	Links       []string `toml:"links"`
+	LinkerFlags []string `toml:"linker_flags"`
+}
+
+// RuleConfig is a custom build rule: Command runs once for every file
+// matched by Inputs and creates the files named by Outputs, in which {name}
+// is the file name of the input without its extension and {dir} its
+// directory. The generated C and C++ sources are compiled into [build], or
+// into the binary or library named by Artifact.
+type RuleConfig struct {
+	Name     string   `toml:"name"`
+	Inputs   []string `toml:"inputs"`
+	Command  string   `toml:"command"`
+	Outputs  []string `toml:"outputs"`
+	Artifact string   `toml:"artifact"`
+}
+
+// PluginConfig is an external tool notified of the events of builds
+// (pre-build, post-compile, post-link and post-build), each of which runs
+// it with the event as a JSON object on stdin. Command runs it, styx-<name>
+// found on PATH when empty, with Args and then the name of the event.
+// Events selects the events it receives, every one when empty.
+type PluginConfig struct {
+	Command string   `toml:"command"`
+	Args    []string `toml:"args"`
+	Events  []string `toml:"events"`
+}
+
+// pluginEvents are the events plugins may receive
+var pluginEvents = map[string]bool{
+	"pre-build":    true,
+	"post-compile": true,
+	"post-link":    true,
+	"post-build":   true,
 }
 
-// EnvironmentConfig contains environment-specific settings
+// EnvironmentConfig contains environment-specific settings. Container names
+// an image every build task of the environment runs in, using
+// ContainerRuntime (docker or podman, found automatically by default). Nix
+// names a flake (or shell.nix directory) whose development shell the build
+// runs in, and Devcontainer runs it in the project's dev container.
 type EnvironmentConfig struct {
-	Toolchain     string            `toml:"toolchain"`
-	OutputDir     string            `toml:"output_dir"`
-	BuildFlags    []string          `toml:"build_flags"`
-	Env           map[string]string `toml:"env"`
-	PreBuildCmds  []string          `toml:"pre_build_cmds"`
-	PostBuildCmds []string          `toml:"post_build_cmds"`
+	Container        string            `toml:"container"`
+	ContainerRuntime string            `toml:"container_runtime"`
+	Nix              string            `toml:"nix"`
+	Devcontainer     bool              `toml:"devcontainer"`
+	Toolchain        string            `toml:"toolchain"`
+	OutputDir        string            `toml:"output_dir"`
+	BuildFlags       []string          `toml:"build_flags"`
+	Env              map[string]string `toml:"env"`
+	PreBuildCmds     []string          `toml:"pre_build_cmds"`
+	PostBuildCmds    []string          `toml:"post_build_cmds"`
+}
+
+// TestConfig contains test settings; every file matched by Sources becomes
+// its own test executable. MinCoverage is the percentage of the lines and
+// of the functions of the project the tests must run when measuring their
+// coverage, none when 0.
+type TestConfig struct {
+	Sources     []string `toml:"sources"`
+	Support     []string `toml:"support"`
+	Exclude     []string `toml:"exclude"`
+	Framework   string   `toml:"framework"`
+	CFlags      []string `toml:"c_flags"`
+	CXXFlags    []string `toml:"cxx_flags"`
+	LinkerFlags []string `toml:"linker_flags"`
+	MinCoverage float64  `toml:"min_coverage"`
+}
+
+// RegistryConfig contains settings for fetching remote dependencies; Auth
+// maps a host to the environment variable holding its access token
+type RegistryConfig struct {
+	Auth map[string]string `toml:"auth"`
+}
+
+// CacheConfig contains settings for the state styx keeps between builds.
+// Dir moves it out of the workspace, e.g. off a network or bind mount;
+// Network copies files where hard or symbolic links would be made, for
+// filesystems that do not support them. RebuildStrategy decides how changed
+// inputs are found: "hybrid" hashes the files whose timestamps changed,
+// "hash" hashes every file and "timestamp" never hashes.
+type CacheConfig struct {
+	Dir             string `toml:"dir"`
+	Network         bool   `toml:"network"`
+	RebuildStrategy string `toml:"rebuild_strategy"`
+}
+
+// OutputConfig chooses where styx writes its messages: Progress takes the
+// progress and the success, info and note messages, Diagnostics the
+// warnings, errors and compiler diagnostics. Both are "stderr" (the
+// default) or "stdout". ProgressInterval is how many seconds apart the
+// progress is printed with --ci, 10 by default.
+type OutputConfig struct {
+	Progress         string `toml:"progress"`
+	Diagnostics      string `toml:"diagnostics"`
+	ProgressInterval int    `toml:"progress_interval"`
+}
+
+// UIConfig chooses how messages look. Theme is default, colorblind (blue
+// and magenta instead of green and red), light (for light backgrounds) or
+// mono (no colors); STYX_THEME takes precedence over it. Colors and Prefixes
+// replace those of the theme for success, info, note, warning, error,
+// progress or location (of compiler diagnostics), colors being words like
+// "red bold" or "none". Spinner is unicode or ascii. Language is the
+// language of the messages, en or es, taking precedence over that of LANG.
+type UIConfig struct {
+	Theme    string            `toml:"theme"`
+	Colors   map[string]string `toml:"colors"`
+	Prefixes map[string]string `toml:"prefixes"`
+	Spinner  string            `toml:"spinner"`
+	Language string            `toml:"language"`
+}
+
+// WorkspaceConfig lists the directories of the styx projects built together
+// by a workspace; members may be glob patterns
+type WorkspaceConfig struct {
+	Members []string `toml:"members"`
+}
+
+// InstallConfig contains the rules of styx install. The directories are
+// relative to the prefix unless absolute. Outputs names the binaries and
+// libraries to install, all of them by default, and Headers the directories
+// copied into IncludeDir, by default the include directories of libraries.
+type InstallConfig struct {
+	Prefix     string        `toml:"prefix"`
+	BinDir     string        `toml:"bindir"`
+	LibDir     string        `toml:"libdir"`
+	IncludeDir string        `toml:"includedir"`
+	Outputs    []string      `toml:"outputs"`
+	Headers    []string      `toml:"headers"`
+	Files      []InstallFile `toml:"files"`
+}
+
+// InstallFile installs the files matched by Sources into Destination
+type InstallFile struct {
+	Sources     []string `toml:"sources"`
+	Destination string   `toml:"destination"`
+}
+
+// ChecksConfig contains feature checks run before compiling; their results
+// are passed as defines and written to a generated config header
+type ChecksConfig struct {
+	Headers      []string          `toml:"check_header"`
+	Functions    []string          `toml:"check_function"`
+	Symbols      []SymbolCheck     `toml:"check_symbol"`
+	Sizes        []string          `toml:"check_size"`
+	TryCompile   []TryCompileCheck `toml:"try_compile"`
+	ConfigHeader string            `toml:"config_header"`
+}
+
+// SymbolCheck names a symbol, function or macro, expected to be declared
+// by the given headers
+type SymbolCheck struct {
+	Name    string   `toml:"name"`
+	Headers []string `toml:"headers"`
+}
+
+// TryCompileCheck defines Define to 1 when a snippet, given inline as Source
+// or read from File, compiles with the extra Flags, and also links when Link
+// is set
+type TryCompileCheck struct {
+	Define string   `toml:"define"`
+	Source string   `toml:"source"`
+	File   string   `toml:"file"`
+	Link   bool     `toml:"link"`
+	Flags  []string `toml:"flags"`
+}
+
+// IsWorkspace reports whether the configuration is a workspace root rather
+// than a project
+func (c *Config) IsWorkspace() bool {
+	return len(c.Workspace.Members) > 0
+}
+
+// StateDir returns the directory holding the build cache, probe results and
+// dependency builds: STYX_CACHE_DIR, the configured cache directory, or
+// .styx in the project root
+func (c *Config) StateDir() string {
+	if dir := os.Getenv("STYX_CACHE_DIR"); dir != "" {
+		return filepath.Join(dir, c.Project.Name)
+	}
+	if c.Cache.Dir != "" {
+		return c.Cache.Dir
+	}
+	return ".styx"
+}
+
+// SelectEnvironment makes the named environment the active one and applies
+// its toolchain, flags and commands to the configuration; an empty name
+// selects none
+func (c *Config) SelectEnvironment(name string) error {
+	if name == "" || name == c.Env {
+		return nil
+	}
+	if c.Env != "" {
+		return fmt.Errorf("environment %s is already selected", c.Env)
+	}
+	env, exists := c.Environment[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrEnvironmentNotFound, name)
+	}
+
+	// the toolchain is a downloaded toolchain when one has that name, and
+	// a compiler otherwise
+	if env.Toolchain != "" {
+		if _, exists := c.Toolchains[env.Toolchain]; exists {
+			c.Toolchain.Use = env.Toolchain
+		} else {
+			c.Toolchain.Compiler = env.Toolchain
+			c.Toolchain.Use = ""
+		}
+	}
+
+	c.Toolchain.CFlags = append(c.Toolchain.CFlags, env.BuildFlags...)
+	c.Toolchain.CXXFlags = append(c.Toolchain.CXXFlags, env.BuildFlags...)
+	c.Toolchain.OBJCFlags = append(c.Toolchain.OBJCFlags, env.BuildFlags...)
+	c.Toolchain.OBJCXXFlags = append(c.Toolchain.OBJCXXFlags, env.BuildFlags...)
+	c.Build.PreBuildCmds = append(c.Build.PreBuildCmds, env.PreBuildCmds...)
+	c.Build.PostBuildCmds = append(c.Build.PostBuildCmds, env.PostBuildCmds...)
+
+	c.Env = name
+	return nil
+}
+
+// UnknownKeys returns the keys of the configuration file that styx does
+// not know, which have no effect
+func (c *Config) UnknownKeys() []string {
+	return c.unknownKeys
+}
+
+// UnknownVariables returns the environment variables starting with STYX_
+// that override no key of the configuration
+func (c *Config) UnknownVariables() []string {
+	return c.unknownVariables
+}
+
+// ActiveEnvironment returns the selected environment, or nil when there is none
+func (c *Config) ActiveEnvironment() *EnvironmentConfig {
+	env, exists := c.Environment[c.Env]
+	if c.Env == "" || !exists {
+		return nil
+	}
+	return &env
 }
 
 // ParseFile parses a TOML configuration file
 func ParseFile(path string) (*Config, error) {
 	// Check if file exists
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil, fmt.Errorf("configuration file not found: %s", path)
+		return nil, fmt.Errorf("%w: %s", ErrConfigNotFound, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration: %w", err)
 	}
+	return Parse(data)
+}
 
+// Parse parses and validates a TOML configuration
+func Parse(data []byte) (*Config, error) {
 	var config Config
 
 	// Parse TOML
-	_, err := toml.DecodeFile(path, &config)
+	md, err := toml.Decode(string(data), &config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse configuration: %w", err)
 	}
+	if err := parseConditionals(string(data), &config); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	// the conditional sections were decoded on their own
+	for _, key := range undecodedKeys(md) {
+		if len(key) < 2 || !config.isConditional(key[0], key[1]) {
+			config.unknownKeys = append(config.unknownKeys, key.String())
+		}
+	}
+	overridden, err := applyOverrides(&config, os.Environ())
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	// variables override the sections of platforms and compilers as well
+	for _, key := range overridden {
+		config.dropConditional(key)
+	}
 
 	// Validate configuration
 	if err := validateConfig(&config); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
+	if err := validateConditionals(&config); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
 
 	return &config, nil
 }
 
 // validateConfig checks if the configuration is valid
 func validateConfig(config *Config) error {
+	if config.Build.Jobs < 0 {
+		return fmt.Errorf("invalid jobs: %d (must be 0 or more)", config.Build.Jobs)
+	}
+	if config.Build.BatchSize < 0 {
+		return fmt.Errorf("invalid batch_size: %d (must be 0 or more)", config.Build.BatchSize)
+	}
+	if config.Build.MaxScanDepth < 0 {
+		return fmt.Errorf("invalid max_scan_depth: %d (must be 0 or more)", config.Build.MaxScanDepth)
+	}
+	if err := validateWarnings(config.Build.Warnings); err != nil {
+		return err
+	}
+	for _, arch := range config.Toolchain.CUDAArchs {
+		if !cudaArchRe.MatchString(arch) {
+			return fmt.Errorf("invalid cuda_archs entry: %s (must be like sm_80)", arch)
+		}
+	}
+	switch config.Build.OutputLayout {
+	case "", "target", "platform", "flat":
+	default:
+		return fmt.Errorf("invalid output_layout: %s (must be target, platform or flat)", config.Build.OutputLayout)
+	}
+	switch config.Build.ObjectLayout {
+	case "", "mirror", "hashed":
+	default:
+		return fmt.Errorf("invalid object_layout: %s (must be mirror or hashed)", config.Build.ObjectLayout)
+	}
+	for name, plugin := range config.Plugins {
+		if strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid plugin name: %s", name)
+		}
+		for _, event := range plugin.Events {
+			if !pluginEvents[event] {
+				return fmt.Errorf("plugin %s: invalid event: %s (must be pre-build, post-compile, post-link or post-build)", name, event)
+			}
+		}
+	}
+
+	if err := validateOutput(&config.Output); err != nil {
+		return err
+	}
+
+	if _, err := config.UI.NewTheme(); err != nil {
+		return fmt.Errorf("ui: %w", err)
+	}
+	if config.UI.Language != "" {
+		if _, err := i18n.Parse(config.UI.Language); err != nil {
+			return fmt.Errorf("ui: %w", err)
+		}
+	}
+
+	if config.IsWorkspace() {
+		if config.Project.Name != "" {
+			return errors.New("a workspace root cannot define a project; move it into a member")
+		}
+		return nil
+	}
+
 	// Check required fields
 	if config.Project.Name == "" {
 		return errors.New("project name is required")
 	}
 
-	if config.Build.OutputType == "" {
-		return errors.New("build output type is required")
+	if len(config.Binaries)+len(config.Libraries) > 0 {
+		if err := validateArtifacts(config); err != nil {
+			return err
+		}
+	} else {
+		if config.Build.OutputType == "" {
+			return errors.New("build output type is required")
+		}
+
+		// Validate output type
+		validOutputTypes := map[string]bool{
+			"executable": true,
+			"static_lib": true,
+			"shared_lib": true,
+		}
+
+		if !validOutputTypes[config.Build.OutputType] {
+			return fmt.Errorf("invalid output type: %s (must be executable, static_lib, or shared_lib)", config.Build.OutputType)
+		}
+
+		// If no output name specified, use project name
+		if config.Build.OutputName == "" {
+			config.Build.OutputName = config.Project.Name
+		}
+		if err := validateOutputName(config.Build.OutputName, config.Project.Version); err != nil {
+			return err
+		}
+		if err := validateLibraryVersion(config.Build.OutputType, config.Build.Version, config.Build.SOVersion); err != nil {
+			return err
+		}
 	}
 
-	// Validate output type
-	validOutputTypes := map[string]bool{
-		"executable": true,
-		"static_lib": true,
-		"shared_lib": true,
+	switch config.Build.OutputFormat {
+	case "":
+		config.Build.OutputFormat = "elf"
+	case "elf":
+	case "bin", "hex":
+		if config.Build.OutputType != "executable" && len(config.Binaries) == 0 {
+			return fmt.Errorf("output format %s requires an executable", config.Build.OutputFormat)
+		}
+	default:
+		return fmt.Errorf("invalid output format: %s (must be elf, bin, or hex)", config.Build.OutputFormat)
 	}
 
-	if !validOutputTypes[config.Build.OutputType] {
-		return fmt.Errorf("invalid output type: %s (must be executable, static_lib, or shared_lib)", config.Build.OutputType)
+	switch config.Cache.RebuildStrategy {
+	case "":
+		config.Cache.RebuildStrategy = "hybrid"
+	case "hybrid", "hash", "timestamp":
+	default:
+		return fmt.Errorf("invalid rebuild strategy: %s (must be hybrid, hash, or timestamp)", config.Cache.RebuildStrategy)
 	}
 
-	// If no output name specified, use project name
-	if config.Build.OutputName == "" {
-		config.Build.OutputName = config.Project.Name
+	for name, dep := range config.Dependencies {
+		if dep.HeaderOnly && dep.Local == "" && dep.Git == "" && dep.URL == "" && len(dep.URLs) == 0 {
+			return fmt.Errorf("dependency %s: header-only dependencies require local, git, url or urls", name)
+		}
+
+		if dep.BuildCmd != "" && len(dep.Outputs) == 0 {
+			return fmt.Errorf("dependency %s: build_cmd requires at least one declared output", name)
+		}
+
+		if (dep.Kind == "cmake" || dep.Kind == "autotools" || dep.Kind == "styx") && dep.Local == "" && dep.Git == "" && dep.URL == "" && len(dep.URLs) == 0 {
+			return fmt.Errorf("dependency %s: %s dependencies require a local, git or url source", name, dep.Kind)
+		}
+
+		if dep.Git != "" && dep.Local != "" {
+			return fmt.Errorf("dependency %s: git and local are mutually exclusive", name)
+		}
+
+		refs := 0
+		for _, ref := range []string{dep.Tag, dep.Branch, dep.Rev} {
+			if ref != "" {
+				refs++
+			}
+		}
+		if refs > 1 {
+			return fmt.Errorf("dependency %s: only one of tag, branch and rev may be set", name)
+		}
+
+		if len(dep.Patches) > 0 && dep.BuildCmd != "" {
+			return fmt.Errorf("dependency %s: patches cannot be used with build_cmd, which builds the sources in place", name)
+		}
+
+		if dep.Kind == "prebuilt" && dep.URL == "" && len(dep.URLs) == 0 {
+			return fmt.Errorf("dependency %s: prebuilt dependencies require url or urls", name)
+		}
+	}
+
+	switch config.Test.Framework {
+	case "", "none", "gtest", "catch2":
+	default:
+		return fmt.Errorf("invalid test framework: %s (must be none, gtest, or catch2)", config.Test.Framework)
+	}
+	if config.Test.MinCoverage < 0 || config.Test.MinCoverage > 100 {
+		return fmt.Errorf("invalid min_coverage: %g (must be between 0 and 100)", config.Test.MinCoverage)
+	}
+
+	if err := validateInstall(config); err != nil {
+		return err
+	}
+	if err := validateExamples(config); err != nil {
+		return err
+	}
+
+	for name, release := range config.Toolchains {
+		if len(release.URLs) == 0 {
+			return fmt.Errorf("toolchain %s requires urls", name)
+		}
+	}
+	if config.Toolchain.Use != "" {
+		if _, exists := config.Toolchains[config.Toolchain.Use]; !exists {
+			return fmt.Errorf("toolchain %s is not declared under [toolchains]", config.Toolchain.Use)
+		}
+	}
+
+	for i, rule := range config.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i+1)
+		}
+		if len(rule.Inputs) == 0 || rule.Command == "" || len(rule.Outputs) == 0 {
+			return fmt.Errorf("rule %s: inputs, command and outputs are required", name)
+		}
+		if rule.Artifact != "" && !hasArtifact(config, rule.Artifact) {
+			return fmt.Errorf("rule %s: unknown binary or library: %s", name, rule.Artifact)
+		}
+	}
+
+	if err := validateLibs(config.Build.Libs); err != nil {
+		return err
+	}
+	for name, target := range config.Targets {
+		if err := validateSanitizers(target.Sanitizers); err != nil {
+			return fmt.Errorf("target %s: %w", name, err)
+		}
+		if err := validateLibs(target.Libs); err != nil {
+			return fmt.Errorf("target %s: %w", name, err)
+		}
+		switch target.LTO {
+		case "", "thin", "full":
+		default:
+			return fmt.Errorf("target %s: invalid lto: %s (must be thin, full or false)", name, target.LTO)
+		}
+		if err := validateWarnings(target.Warnings); err != nil {
+			return fmt.Errorf("target %s: %w", name, err)
+		}
+	}
+
+	for name, env := range config.Environment {
+		switch env.ContainerRuntime {
+		case "", "docker", "podman":
+		default:
+			return fmt.Errorf("environment %s: invalid container runtime: %s (must be docker or podman)", name, env.ContainerRuntime)
+		}
+
+		kinds := 0
+		for _, set := range []bool{env.Container != "", env.Nix != "", env.Devcontainer} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds > 1 {
+			return fmt.Errorf("environment %s: only one of container, nix and devcontainer can be set", name)
+		}
+	}
+
+	for _, symbol := range config.Checks.Symbols {
+		if symbol.Name == "" {
+			return errors.New("check_symbol entries require a name")
+		}
+	}
+
+	for _, probe := range config.Checks.TryCompile {
+		if probe.Define == "" {
+			return errors.New("try_compile entries require a define")
+		}
+
+		if (probe.Source == "") == (probe.File == "") {
+			return fmt.Errorf("try_compile %s: exactly one of source and file is required", probe.Define)
+		}
+	}
+
+	if config.Checks.ConfigHeader == "" {
+		config.Checks.ConfigHeader = "config.h"
+	} else if filepath.IsAbs(config.Checks.ConfigHeader) {
+		return fmt.Errorf("config_header must be a relative path: %s", config.Checks.ConfigHeader)
+	}
+
+	return nil
+}
+
+// NewTheme returns the theme of the logger chosen by STYX_THEME or by the
+// configuration
+func (ui UIConfig) NewTheme() (*logger.Theme, error) {
+	name := ui.Theme
+	if env := os.Getenv("STYX_THEME"); env != "" {
+		name = env
+	}
+	return logger.NewTheme(name, ui.Colors, ui.Prefixes, ui.Spinner)
+}
+
+// validateOutput checks the streams of [output] and fills in the defaults
+func validateOutput(output *OutputConfig) error {
+	for _, stream := range []*string{&output.Progress, &output.Diagnostics} {
+		switch *stream {
+		case "":
+			*stream = "stderr"
+		case "stdout", "stderr":
+		default:
+			return fmt.Errorf("invalid output stream: %s (must be stdout or stderr)", *stream)
+		}
 	}
 
+	if output.ProgressInterval < 0 {
+		return fmt.Errorf("invalid progress interval: %d (must be 0 or more)", output.ProgressInterval)
+	}
+	if output.ProgressInterval == 0 {
+		output.ProgressInterval = 10
+	}
+	return nil
+}
+
+// validateArtifacts checks the [[binaries]] and [[libraries]] entries,
+// which replace the single output described by [build]
+func validateArtifacts(config *Config) error {
+	if config.Build.OutputType != "" || len(config.Build.Sources) > 0 {
+		return errors.New("build output_type and sources cannot be combined with [[binaries]] or [[libraries]]")
+	}
+
+	names := make(map[string]bool)
+	libraries := make(map[string]bool)
+	for i := range config.Libraries {
+		lib := &config.Libraries[i]
+		if lib.Type == "" {
+			lib.Type = "static_lib"
+		}
+		if lib.Type != "static_lib" && lib.Type != "shared_lib" {
+			return fmt.Errorf("library %s: invalid type: %s (must be static_lib or shared_lib)", lib.Name, lib.Type)
+		}
+		libraries[lib.Name] = true
+	}
+
+	for i := range config.Binaries {
+		bin := &config.Binaries[i]
+		if bin.Type == "" {
+			bin.Type = "executable"
+		}
+		if bin.Type != "executable" {
+			return fmt.Errorf("binary %s: invalid type: %s (must be executable)", bin.Name, bin.Type)
+		}
+	}
+
+	for _, artifact := range append(append([]ArtifactConfig{}, config.Libraries...), config.Binaries...) {
+		if artifact.Name == "" {
+			return errors.New("binaries and libraries require a name")
+		}
+
+		if names[artifact.Name] {
+			return fmt.Errorf("duplicate binary or library name: %s", artifact.Name)
+		}
+		names[artifact.Name] = true
+
+		if len(artifact.Sources) == 0 {
+			return fmt.Errorf("%s: at least one source pattern is required", artifact.Name)
+		}
+
+		for _, link := range artifact.Links {
+			if !libraries[link] {
+				return fmt.Errorf("%s: links to unknown library %s", artifact.Name, link)
+			}
+		}
+
+		if err := validateOutputName(artifact.OutputNameTemplate(), config.Project.Version); err != nil {
+			return fmt.Errorf("%s: %w", artifact.Name, err)
+		}
+		if err := validateLibraryVersion(artifact.Type, artifact.Version, artifact.SOVersion); err != nil {
+			return fmt.Errorf("%s: %w", artifact.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// validateInstall checks that the install rules name outputs of the project
+// and say where their files go
+func validateInstall(config *Config) error {
+	outputs := map[string]bool{config.Build.OutputName: true}
+	for _, artifact := range append(append([]ArtifactConfig{}, config.Libraries...), config.Binaries...) {
+		outputs[artifact.Name] = true
+	}
+	for _, name := range config.Install.Outputs {
+		if !outputs[name] {
+			return fmt.Errorf("install: unknown output %s", name)
+		}
+	}
+
+	for _, file := range config.Install.Files {
+		if len(file.Sources) == 0 || file.Destination == "" {
+			return errors.New("install: files entries require sources and a destination")
+		}
+	}
+	return nil
+}
+
+// validateExamples checks that the examples are named and have sources,
+// and that there are libraries for them to link against
+func validateExamples(config *Config) error {
+	libraries := make(map[string]bool)
+	for _, lib := range config.Libraries {
+		libraries[lib.Name] = true
+	}
+	buildsLibrary := len(libraries) > 0 || (len(config.Binaries) == 0 && config.Build.OutputType != "executable")
+
+	names := make(map[string]bool)
+	for _, example := range config.Examples {
+		if example.Name == "" {
+			return errors.New("examples require a name")
+		}
+		if names[example.Name] {
+			return fmt.Errorf("duplicate example name: %s", example.Name)
+		}
+		names[example.Name] = true
+
+		if len(example.Sources) == 0 {
+			return fmt.Errorf("example %s: at least one source pattern is required", example.Name)
+		}
+		if !buildsLibrary {
+			return fmt.Errorf("example %s: the project builds no library to link against", example.Name)
+		}
+		for _, link := range example.Links {
+			if !libraries[link] {
+				return fmt.Errorf("example %s: links to unknown library %s", example.Name, link)
+			}
+		}
+	}
+	return nil
+}
+
+// hasArtifact reports whether one of the binaries or libraries is named name
+func hasArtifact(config *Config, name string) bool {
+	for _, a := range append(append([]ArtifactConfig{}, config.Binaries...), config.Libraries...) {
+		if a.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateLibs checks that libs names libraries, like m or pthread, or
+// library files rather than linker flags
+func validateLibs(libs []string) error {
+	for _, lib := range libs {
+		if lib == "" {
+			return errors.New("library names cannot be empty")
+		}
+		if strings.HasPrefix(lib, "-") {
+			return fmt.Errorf("invalid library: %s (libs takes names like m or pthread; use linker_flags for flags)", lib)
+		}
+	}
+	return nil
+}
+
+// validateWarnings checks that warnings is a known warning level
+func validateWarnings(warnings string) error {
+	switch warnings {
+	case "", "none", "default", "strict", "everything":
+		return nil
+	}
+	return fmt.Errorf("invalid warnings: %s (must be none, default, strict or everything)", warnings)
+}
+
+// validateSanitizers checks that every sanitizer is known and that none of
+// them rules out another one
+func validateSanitizers(sanitizers []string) error {
+	enabled := make(map[string]bool)
+	for _, sanitizer := range sanitizers {
+		switch sanitizer {
+		case "address", "undefined", "thread", "memory", "leak":
+		default:
+			return fmt.Errorf("invalid sanitizer: %s (must be address, undefined, thread, memory, or leak)", sanitizer)
+		}
+		enabled[sanitizer] = true
+	}
+
+	for _, pair := range incompatibleSanitizers {
+		if enabled[pair[0]] && enabled[pair[1]] {
+			return fmt.Errorf("the %s and %s sanitizers cannot be combined", pair[0], pair[1])
+		}
+	}
 	return nil
 }
 
@@ -159,5 +1049,5 @@ func LoadConfig(dir string) (*Config, error) {
 		}
 	}
 
-	return nil, errors.New("no configuration file found")
+	return nil, ErrConfigNotFound
 }
@@ -0,0 +1,267 @@
+// Package coverage reads the line coverage of test runs, as reported by gcov
+// or llvm-cov, and writes it as an lcov tracefile or a static HTML page.
+package coverage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Report is the coverage of a set of source files
+type Report struct {
+	Files map[string]*File
+}
+
+// File is the coverage of a source file: the number of times every line
+// holding code ran, and every function was called
+type File struct {
+	Path      string
+	Lines     map[int]int64
+	Functions map[string]*Function
+}
+
+// Function is a function of a source file and the number of times it was
+// called
+type Function struct {
+	Name  string
+	Line  int
+	Count int64
+}
+
+// NewReport returns an empty report
+func NewReport() *Report {
+	return &Report{Files: make(map[string]*File)}
+}
+
+// file returns the coverage of path, adding it to the report if needed
+func (r *Report) file(path string) *File {
+	f, ok := r.Files[path]
+	if !ok {
+		f = &File{Path: path, Lines: make(map[int]int64), Functions: make(map[string]*Function)}
+		r.Files[path] = f
+	}
+	return f
+}
+
+// addLine adds count runs to a line of path. Headers are compiled into
+// several objects, whose counts add up.
+func (r *Report) addLine(path string, line int, count int64) {
+	f := r.file(path)
+	f.Lines[line] += count
+}
+
+// addFunction adds count calls to a function of path
+func (r *Report) addFunction(path, name string, line int, count int64) {
+	f := r.file(path)
+	fn, ok := f.Functions[name]
+	if !ok {
+		fn = &Function{Name: name, Line: line}
+		f.Functions[name] = fn
+	}
+	fn.Count += count
+}
+
+// Paths returns the paths of the files of the report, sorted
+func (r *Report) Paths() []string {
+	paths := make([]string, 0, len(r.Files))
+	for path := range r.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Covered returns the number of lines of the file that ran at least once,
+// and the number of lines holding code
+func (f *File) Covered() (hit, found int) {
+	for _, count := range f.Lines {
+		if count > 0 {
+			hit++
+		}
+	}
+	return hit, len(f.Lines)
+}
+
+// Covered returns the number of lines of the report that ran at least once,
+// and the number of lines holding code
+func (r *Report) Covered() (hit, found int) {
+	for _, f := range r.Files {
+		h, n := f.Covered()
+		hit += h
+		found += n
+	}
+	return hit, found
+}
+
+// FunctionsCovered returns the number of functions of the file that were
+// called at least once, and the number of functions
+func (f *File) FunctionsCovered() (hit, found int) {
+	for _, fn := range f.Functions {
+		if fn.Count > 0 {
+			hit++
+		}
+	}
+	return hit, len(f.Functions)
+}
+
+// FunctionsCovered returns the number of functions of the report that were
+// called at least once, and the number of functions
+func (r *Report) FunctionsCovered() (hit, found int) {
+	for _, f := range r.Files {
+		h, n := f.FunctionsCovered()
+		hit += h
+		found += n
+	}
+	return hit, found
+}
+
+// Percent returns hit as a percentage of found, 100 when nothing was found
+func Percent(hit, found int) float64 {
+	if found == 0 {
+		return 100
+	}
+	return 100 * float64(hit) / float64(found)
+}
+
+// gcovOutput is the JSON intermediate format of gcov, of which it writes
+// one document per data file
+type gcovOutput struct {
+	Files []struct {
+		File  string `json:"file"`
+		Lines []struct {
+			LineNumber int   `json:"line_number"`
+			Count      int64 `json:"count"`
+		} `json:"lines"`
+		Functions []struct {
+			Name           string `json:"name"`
+			DemangledName  string `json:"demangled_name"`
+			StartLine      int    `json:"start_line"`
+			ExecutionCount int64  `json:"execution_count"`
+		} `json:"functions"`
+	} `json:"files"`
+}
+
+// AddGcov adds the output of gcov --json-format --stdout to the report
+func (r *Report) AddGcov(output io.Reader) error {
+	decoder := json.NewDecoder(output)
+	for {
+		var doc gcovOutput
+		if err := decoder.Decode(&doc); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to parse gcov output: %w", err)
+		}
+
+		for _, f := range doc.Files {
+			r.file(f.File)
+			for _, line := range f.Lines {
+				r.addLine(f.File, line.LineNumber, line.Count)
+			}
+			for _, fn := range f.Functions {
+				name := fn.DemangledName
+				if name == "" {
+					name = fn.Name
+				}
+				r.addFunction(f.File, name, fn.StartLine, fn.ExecutionCount)
+			}
+		}
+	}
+}
+
+// AddLcov adds an lcov tracefile, like the one llvm-cov export writes, to
+// the report. Only the line and function records are read.
+func (r *Report) AddLcov(tracefile io.Reader) error {
+	scanner := bufio.NewScanner(tracefile)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	path := ""
+	lines := make(map[string]int) // the line of every function of the file
+	for n := 1; scanner.Scan(); n++ {
+		record, value, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		fields := strings.Split(value, ",")
+		switch record {
+		case "SF":
+			path = value
+			r.file(path)
+			lines = make(map[string]int)
+		case "end_of_record":
+			path = ""
+		case "DA", "FN", "FNDA":
+			if path == "" || len(fields) < 2 {
+				return fmt.Errorf("invalid lcov record on line %d: %s", n, scanner.Text())
+			}
+			number, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid lcov record on line %d: %s", n, scanner.Text())
+			}
+			name := strings.Join(fields[1:], ",")
+			switch record {
+			case "DA":
+				count, err := strconv.ParseInt(fields[1], 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid lcov record on line %d: %s", n, scanner.Text())
+				}
+				r.addLine(path, int(number), count)
+			case "FN":
+				lines[name] = int(number)
+			case "FNDA":
+				r.addFunction(path, name, lines[name], number)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read lcov tracefile: %w", err)
+	}
+	return nil
+}
+
+// WriteLcov writes the report as an lcov tracefile, which genhtml and most
+// coverage services read
+func (r *Report) WriteLcov(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "TN:")
+	for _, path := range r.Paths() {
+		f := r.Files[path]
+		fmt.Fprintf(bw, "SF:%s\n", path)
+
+		functions := make([]*Function, 0, len(f.Functions))
+		for _, fn := range f.Functions {
+			functions = append(functions, fn)
+		}
+		sort.Slice(functions, func(i, j int) bool {
+			if functions[i].Line != functions[j].Line {
+				return functions[i].Line < functions[j].Line
+			}
+			return functions[i].Name < functions[j].Name
+		})
+		called := 0
+		for _, fn := range functions {
+			fmt.Fprintf(bw, "FN:%d,%s\n", fn.Line, fn.Name)
+		}
+		for _, fn := range functions {
+			fmt.Fprintf(bw, "FNDA:%d,%s\n", fn.Count, fn.Name)
+			if fn.Count > 0 {
+				called++
+			}
+		}
+		fmt.Fprintf(bw, "FNF:%d\nFNH:%d\n", len(functions), called)
+
+		numbers := make([]int, 0, len(f.Lines))
+		for line := range f.Lines {
+			numbers = append(numbers, line)
+		}
+		sort.Ints(numbers)
+		for _, line := range numbers {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line, f.Lines[line])
+		}
+		hit, found := f.Covered()
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", found, hit)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,163 @@
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+)
+
+// page is what the HTML report is generated from
+type page struct {
+	Title     string
+	Percent   string
+	Hit       int
+	Found     int
+	Functions string
+	Files     []fileView
+}
+
+// fileView is a source file of the report with its lines
+type fileView struct {
+	ID      string
+	Path    string
+	Percent string
+	Fill    float64
+	Hit     int
+	Found   int
+	Missing bool // the source could not be read
+	Lines   []lineView
+}
+
+// lineView is a line of a source file and how often it ran
+type lineView struct {
+	Number int
+	Text   string
+	Count  string
+	Class  string // hit, missed, or empty for lines without code
+}
+
+// WriteHTML writes a static HTML page of the report, titled title: the
+// coverage of every file, and its source with the lines that ran and those
+// that did not. The sources are read from the paths of the report. The page
+// needs no script or network.
+func WriteHTML(w io.Writer, r *Report, title string) error {
+	hit, found := r.Covered()
+	p := page{
+		Title:   title,
+		Percent: fmt.Sprintf("%.1f%%", Percent(hit, found)),
+		Hit:     hit,
+		Found:   found,
+	}
+
+	functions, called := 0, 0
+	for i, path := range r.Paths() {
+		f := r.Files[path]
+		for _, fn := range f.Functions {
+			functions++
+			if fn.Count > 0 {
+				called++
+			}
+		}
+
+		h, n := f.Covered()
+		view := fileView{
+			ID:      fmt.Sprintf("file%d", i),
+			Path:    path,
+			Percent: fmt.Sprintf("%.1f%%", Percent(h, n)),
+			Fill:    Percent(h, n),
+			Hit:     h,
+			Found:   n,
+		}
+		source, err := os.ReadFile(path)
+		if err != nil {
+			view.Missing = true
+		}
+		for j, text := range strings.Split(strings.TrimSuffix(string(source), "\n"), "\n") {
+			if err != nil {
+				break
+			}
+			line := lineView{Number: j + 1, Text: strings.TrimRight(text, "\r")}
+			if count, ok := f.Lines[j+1]; ok {
+				line.Count = fmt.Sprint(count)
+				line.Class = "missed"
+				if count > 0 {
+					line.Class = "hit"
+				}
+			}
+			view.Lines = append(view.Lines, line)
+		}
+		p.Files = append(p.Files, view)
+	}
+	if functions > 0 {
+		p.Functions = fmt.Sprintf("%d of %d (%.1f%%)", called, functions, Percent(called, functions))
+	}
+
+	return pageTemplate.Execute(w, p)
+}
+
+var pageTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}: coverage report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 1100px; color: #24292f; padding: 0 1em; }
+h2 { border-bottom: 1px solid #d0d7de; padding-bottom: .3em; margin-top: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .3em .6em; border-bottom: 1px solid #eaeef2; vertical-align: top; }
+th { background: #f6f8fa; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; white-space: nowrap; }
+.summary th { width: 12em; }
+.files td.bar { width: 30%; }
+.files .track { background: #ffebe9; height: 1em; border-radius: 2px; }
+.files .fill { background: #4ac26b; height: 1em; border-radius: 2px; }
+.muted { color: #6e7781; }
+code, .source td { font-family: ui-monospace, Menlo, Consolas, monospace; font-size: .9em; }
+.source td { border: none; padding: 0 .6em; white-space: pre; }
+.source td.num { color: #6e7781; }
+.source tr.hit td { background: #dafbe1; }
+.source tr.missed td { background: #ffebe9; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+
+<table class="summary">
+<tr><th>Lines</th><td>{{.Hit}} of {{.Found}} ({{.Percent}})</td></tr>
+{{- if .Functions}}
+<tr><th>Functions</th><td>{{.Functions}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Files</h2>
+{{- if .Files}}
+<table class="files">
+<tr><th>File</th><th class="num">Lines</th><th class="num">Coverage</th><th></th></tr>
+{{- range .Files}}
+<tr><td><a href="#{{.ID}}"><code>{{.Path}}</code></a></td><td class="num">{{.Hit}} / {{.Found}}</td><td class="num">{{.Percent}}</td><td class="bar"><div class="track"><div class="fill" style="width: {{printf "%.1f" .Fill}}%"></div></div></td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p class="muted">No source of the project was covered by the tests.</p>
+{{- end}}
+
+{{- range .Files}}
+
+<h2 id="{{.ID}}"><code>{{.Path}}</code> <span class="muted">{{.Percent}}</span></h2>
+{{- if .Missing}}
+<p class="muted">The source could not be read.</p>
+{{- else}}
+<table class="source">
+{{- range .Lines}}
+<tr{{if .Class}} class="{{.Class}}"{{end}}><td class="num">{{.Number}}</td><td class="num">{{.Count}}</td><td>{{.Text}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+
+<p class="muted">Generated by styx.</p>
+</body>
+</html>
+`))
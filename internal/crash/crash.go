@@ -0,0 +1,105 @@
+// Package crash writes the diagnostic bundle of a styx run that panicked,
+// so users can attach it to a bug report instead of a bare Go panic.
+package crash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// IssueURL is where crashes are reported
+const IssueURL = "https://github.com/deviceix/styx/issues"
+
+// configNames are the configuration files looked for in the current
+// directory, in the order styx itself looks for them
+var configNames = []string{"styx.toml", "Styx.toml", "styx.script", "Styx.script"}
+
+// Report is the diagnostic bundle of a crash
+type Report struct {
+	Version    string
+	Args       []string
+	ConfigPath string // the configuration of the project, if one was found
+	Panic      interface{}
+	Stack      []byte
+	Log        []string // the last messages logged before the crash
+	Time       time.Time
+}
+
+// FindConfig returns the configuration file a run uses: path when it is
+// given, or the one in the current directory
+func FindConfig(path string) string {
+	if path != "" {
+		return path
+	}
+	for _, name := range configNames {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// Dir returns the directory crash reports of the project configured by
+// configPath are written to: .styx/crash next to the configuration, or in
+// the current directory when there is none
+func Dir(configPath string) string {
+	root := "."
+	if configPath != "" {
+		root = filepath.Dir(configPath)
+	}
+	return filepath.Join(root, ".styx", "crash")
+}
+
+// Write writes the report to a new file in dir and returns its path
+func Write(dir string, report Report) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create crash directory: %w", err)
+	}
+
+	name := fmt.Sprintf("crash-%s-%d.txt", report.Time.Format("20060102-150405"), os.Getpid())
+	path := filepath.Join(dir, name)
+	if err := platform.WriteFileAtomic(path, []byte(report.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write crash report: %w", err)
+	}
+	return path, nil
+}
+
+// String formats the report as the text of a bug report
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "styx %s crashed at %s\n", r.Version, r.Time.Format(time.RFC3339))
+	fmt.Fprintf(&b, "command: %s\n", strings.Join(r.Args, " "))
+	fmt.Fprintf(&b, "platform: %s/%s, %s\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+	if r.ConfigPath == "" {
+		b.WriteString("config: none\n")
+	} else {
+		fmt.Fprintf(&b, "config: %s (sha256 %s)\n", r.ConfigPath, configHash(r.ConfigPath))
+	}
+
+	fmt.Fprintf(&b, "\npanic: %v\n\n%s\n", r.Panic, r.Stack)
+
+	fmt.Fprintf(&b, "last %d log messages:\n", len(r.Log))
+	for _, line := range r.Log {
+		fmt.Fprintf(&b, "  %s\n", line)
+	}
+	return b.String()
+}
+
+// configHash hashes the configuration file at path, which identifies the
+// configuration a crash happened with without including it in the report
+func configHash(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "unreadable: " + err.Error()
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
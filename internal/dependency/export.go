@@ -0,0 +1,154 @@
+package dependency
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ExportOptions control how a graph is exported
+type ExportOptions struct {
+	// Format is "dot", "json" or "mermaid"
+	Format string
+	// Types restricts the export to nodes of these types; empty means all
+	Types []NodeType
+	// Dirty marks the nodes to highlight as out of date
+	Dirty map[string]bool
+}
+
+// exportedNode is a node as written by Export
+type exportedNode struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Dirty bool   `json:"dirty,omitempty"`
+}
+
+// exportedEdge is an edge from an input to the node built from it
+type exportedEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Export writes the graph in the requested format. Edges point from inputs
+// to what is built from them; when nodes are filtered out, only the edges
+// between the remaining ones are kept.
+func (g *Graph) Export(w io.Writer, opts ExportOptions) error {
+	nodes, edges := g.exportedElements(opts)
+
+	switch opts.Format {
+	case "", "dot":
+		return writeDOT(w, nodes, edges)
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			Nodes []exportedNode `json:"nodes"`
+			Edges []exportedEdge `json:"edges"`
+		}{nodes, edges})
+	case "mermaid":
+		return writeMermaid(w, nodes, edges)
+	default:
+		return fmt.Errorf("unknown graph format: %s (must be dot, json, or mermaid)", opts.Format)
+	}
+}
+
+// exportedElements returns the nodes and edges selected by opts, sorted so
+// that exports are stable
+func (g *Graph) exportedElements(opts ExportOptions) ([]exportedNode, []exportedEdge) {
+	included := func(node *Node) bool {
+		if len(opts.Types) == 0 {
+			return true
+		}
+		for _, t := range opts.Types {
+			if node.Type == t {
+				return true
+			}
+		}
+		return false
+	}
+
+	nodes := []exportedNode{}
+	edges := []exportedEdge{}
+	for id, node := range g.Nodes {
+		if !included(node) {
+			continue
+		}
+		nodes = append(nodes, exportedNode{ID: id, Type: node.Type.String(), Dirty: opts.Dirty[id]})
+
+		for _, dep := range node.Dependencies {
+			if included(dep) {
+				edges = append(edges, exportedEdge{From: dep.ID, To: id})
+			}
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+
+	return nodes, edges
+}
+
+// dotShapes are the Graphviz shapes of each node type
+var dotShapes = map[string]string{
+	"source":     "note",
+	"header":     "note",
+	"object":     "box",
+	"library":    "component",
+	"executable": "doubleoctagon",
+	"generated":  "note",
+}
+
+// writeDOT writes the graph in Graphviz DOT format
+func writeDOT(w io.Writer, nodes []exportedNode, edges []exportedEdge) error {
+	var out strings.Builder
+	out.WriteString("digraph styx {\n")
+	out.WriteString("  rankdir=LR;\n")
+	out.WriteString("  node [fontname=\"Helvetica\", fontsize=10];\n")
+	for _, node := range nodes {
+		attrs := fmt.Sprintf("label=%q, shape=%s", filepath.Base(node.ID), dotShapes[node.Type])
+		if node.Dirty {
+			attrs += ", style=filled, fillcolor=\"#f4a6a6\""
+		}
+		fmt.Fprintf(&out, "  %q [%s];\n", node.ID, attrs)
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(&out, "  %q -> %q;\n", edge.From, edge.To)
+	}
+	out.WriteString("}\n")
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// writeMermaid writes the graph as a Mermaid flowchart; node ids are
+// replaced with short identifiers since paths are not valid Mermaid ids
+func writeMermaid(w io.Writer, nodes []exportedNode, edges []exportedEdge) error {
+	ids := make(map[string]string, len(nodes))
+	var out strings.Builder
+	out.WriteString("flowchart LR\n")
+	out.WriteString("  classDef dirty fill:#f4a6a6,stroke:#c0392b\n")
+	for i, node := range nodes {
+		ids[node.ID] = fmt.Sprintf("n%d", i)
+		label := strings.ReplaceAll(filepath.Base(node.ID), "\"", "#quot;")
+		fmt.Fprintf(&out, "  %s[\"%s\"]\n", ids[node.ID], label)
+		if node.Dirty {
+			fmt.Fprintf(&out, "  class %s dirty\n", ids[node.ID])
+		}
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(&out, "  %s --> %s\n", ids[edge.From], ids[edge.To])
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package dependency
+
+import "os"
+
+// fileID identifies a file however it is reached; files have no identity
+// besides their path on this platform, where only the depth limit stops
+// symlink cycles
+type fileID struct{}
+
+// idOf returns no identity on this platform
+func idOf(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
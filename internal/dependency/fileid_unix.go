@@ -0,0 +1,23 @@
+//go:build unix
+
+package dependency
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file however it is reached, through whichever links
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// idOf returns the identity of the file whose metadata is info
+func idOf(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -5,6 +5,15 @@ import (
 	"fmt"
 )
 
+var (
+	// ErrNodeExists is returned when adding a node whose ID is in the graph
+	ErrNodeExists = errors.New("node already exists")
+	// ErrNodeNotFound is returned when a node is not in the graph
+	ErrNodeNotFound = errors.New("node not found")
+	// ErrCycle is returned when a dependency would make the graph cyclic
+	ErrCycle = errors.New("dependency cycle")
+)
+
 // NodeType represents the type of node in the build graph
 type NodeType int
 
@@ -14,8 +23,38 @@ const (
 	NodeTypeObject
 	NodeTypeLibrary
 	NodeTypeExecutable
+	NodeTypeGenerated
 )
 
+// nodeTypeNames are the names of node types used when exporting the graph
+var nodeTypeNames = map[NodeType]string{
+	NodeTypeSource:     "source",
+	NodeTypeHeader:     "header",
+	NodeTypeObject:     "object",
+	NodeTypeLibrary:    "library",
+	NodeTypeExecutable: "executable",
+	NodeTypeGenerated:  "generated",
+}
+
+// String returns the name of the node type
+func (t NodeType) String() string {
+	if name, ok := nodeTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("NodeType(%d)", int(t))
+}
+
+// ParseNodeType returns the node type with the given name; plural names
+// such as "headers" are accepted as well
+func ParseNodeType(name string) (NodeType, error) {
+	for t, typeName := range nodeTypeNames {
+		if name == typeName || name == typeName+"s" {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown node type: %s (must be source, header, object, library, executable, or generated)", name)
+}
+
 // Node represents a node in the build graph
 type Node struct {
 	ID           string
@@ -43,7 +82,7 @@ func NewGraph() *Graph {
 // AddNode adds a node to the graph
 func (g *Graph) AddNode(node *Node) error {
 	if _, exists := g.Nodes[node.ID]; exists {
-		return fmt.Errorf("node already exists: %s", node.ID)
+		return fmt.Errorf("%w: %s", ErrNodeExists, node.ID)
 	}
 
 	g.Nodes[node.ID] = node
@@ -62,11 +101,11 @@ func (g *Graph) AddDependency(fromID, toID string) error {
 	toNode, toExists := g.Nodes[toID]
 
 	if !fromExists {
-		return fmt.Errorf("source node not found: %s", fromID)
+		return fmt.Errorf("source %w: %s", ErrNodeNotFound, fromID)
 	}
 
 	if !toExists {
-		return fmt.Errorf("target node not found: %s", toID)
+		return fmt.Errorf("target %w: %s", ErrNodeNotFound, toID)
 	}
 
 	// check dupes
@@ -76,13 +115,41 @@ func (g *Graph) AddDependency(fromID, toID string) error {
 		}
 	}
 
-	// add the dependency & check for cyclical dependencies
+	// the new edge closes a cycle only if it leads back to its start; the
+	// rest of the graph is acyclic already
+	if fromID == toID || reaches(toNode, fromID, make(map[string]bool)) {
+		return fmt.Errorf("adding dependency from %s to %s would create a %w", fromID, toID, ErrCycle)
+	}
+
 	fromNode.Dependencies = append(fromNode.Dependencies, toNode)
-	if g.hasCycle() {
-		fromNode.Dependencies = fromNode.Dependencies[:len(fromNode.Dependencies)-1]
-		return fmt.Errorf("adding dependency from %s to %s would create a cycle", fromID, toID)
+	return nil
+}
+
+// reaches reports whether the node with the given ID is among the
+// dependencies of node, directly or not
+func reaches(node *Node, id string, visited map[string]bool) bool {
+	for _, dep := range node.Dependencies {
+		if dep.ID == id {
+			return true
+		}
+		if !visited[dep.ID] {
+			visited[dep.ID] = true
+			if reaches(dep, id, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ClearDependencies removes every dependency of a node, keeping the node
+func (g *Graph) ClearDependencies(nodeID string) error {
+	node, exists := g.Nodes[nodeID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrNodeNotFound, nodeID)
 	}
 
+	node.Dependencies = nil
 	return nil
 }
 
@@ -125,7 +192,7 @@ func (g *Graph) dfsHasCycle(nodeID string, visited, path map[string]bool) bool {
 // TopologicalSort returns the nodes in topological order
 func (g *Graph) TopologicalSort() ([]*Node, error) {
 	if g.hasCycle() {
-		return nil, errors.New("graph has cycles, cannot perform topological sort")
+		return nil, fmt.Errorf("%w in graph, cannot perform topological sort", ErrCycle)
 	}
 
 	visited := make(map[string]bool)
@@ -173,7 +240,7 @@ func (g *Graph) GetBuildOrder() ([]*Node, error) {
 func (g *Graph) MarkEntryPoint(nodeID string) error {
 	node, exists := g.Nodes[nodeID]
 	if !exists {
-		return fmt.Errorf("node not found: %s", nodeID)
+		return fmt.Errorf("%w: %s", ErrNodeNotFound, nodeID)
 	}
 
 	// Check if already an entry point
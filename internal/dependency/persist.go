@@ -0,0 +1,120 @@
+package dependency
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// racySlack is how recently modified files may be for a change within the
+// resolution of filesystem timestamps to leave their stamp unchanged
+const racySlack = 2 * time.Second
+
+// graphVersion is the version of the format of saved scan graphs; graphs
+// saved by other versions are discarded
+const graphVersion = 3
+
+// savedGraph is the form the files read by a scanner are saved in: every
+// file, with the stamp and hash of its contents and the includes it has
+type savedGraph struct {
+	Version int
+	Files   map[string]savedFile
+}
+
+// savedFile is a scanned file of a savedGraph
+type savedFile struct {
+	ModTime  int64
+	Size     int64
+	Hash     uint64
+	Binary   bool
+	Includes []savedInclude
+}
+
+// savedInclude is an include directive of a savedFile
+type savedInclude struct {
+	Name   string
+	System bool
+}
+
+// Load reads the files scanned by a previous invocation from path, where
+// Save wrote them, so only the files that changed since are read again.
+// Includes are resolved anew all the same, which finds the headers added
+// to include directories since. A missing or outdated file is not an
+// error.
+func (s *DependencyScanner) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read scan graph: %w", err)
+	}
+
+	var graph savedGraph
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&graph); err != nil {
+		return fmt.Errorf("failed to parse scan graph: %w", err)
+	}
+	if graph.Version != graphVersion {
+		return nil
+	}
+
+	for name, file := range graph.Files {
+		if _, ok := s.files[name]; ok {
+			continue
+		}
+		directives := make([]directive, len(file.Includes))
+		for i, include := range file.Includes {
+			directives[i] = directive{name: include.Name, system: include.System}
+		}
+		s.files[name] = &scannedFile{modTime: file.ModTime, size: file.Size, hash: file.Hash, binary: file.Binary, directives: directives}
+	}
+	return nil
+}
+
+// Save writes the files scanned so far to path for the next invocation to
+// Load, unless none was read since they were loaded. Files the scanner did
+// not come across are kept as long as they exist. Files modified just
+// before are saved without their modification time, so the next
+// invocation compares their hash.
+func (s *DependencyScanner) Save(path string) error {
+	if !s.changed {
+		return nil
+	}
+
+	racy := time.Now().Add(-racySlack).UnixNano()
+	graph := savedGraph{Version: graphVersion, Files: make(map[string]savedFile, len(s.files))}
+	for name, file := range s.files {
+		if !s.seen[name] {
+			if _, err := s.fs.Stat(name); err != nil {
+				continue
+			}
+		}
+		includes := make([]savedInclude, len(file.directives))
+		for i, d := range file.directives {
+			includes[i] = savedInclude{Name: d.name, System: d.system}
+		}
+		saved := savedFile{ModTime: file.modTime, Size: file.size, Hash: file.hash, Binary: file.binary, Includes: includes}
+		if saved.ModTime >= racy {
+			saved.ModTime = 0
+		}
+		graph.Files[name] = saved
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(graph); err != nil {
+		return fmt.Errorf("failed to serialize scan graph: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	if err := platform.WriteFileAtomic(path, data.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write scan graph: %w", err)
+	}
+	s.changed = false
+	return nil
+}
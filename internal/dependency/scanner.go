@@ -1,20 +1,56 @@
 package dependency
 
 import (
-	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+
+	"github.com/deviceix/styx/internal/fsmeta"
 )
 
 // DependencyScanner scans source files for dependencies
 type DependencyScanner struct {
 	includeDirs     []string
 	visitedFiles    map[string]bool
+	visitedIDs      map[fileID]bool // the files scanned, by identity rather than path
 	systemIncludeRe *regexp.Regexp
-	localIncludeRe  *regexp.Regexp
+	// localIncludeRe also matches the .include of GNU as and the %include
+	// of nasm; both match the #import of Objective-C
+	localIncludeRe *regexp.Regexp
+	fs             *fsmeta.FS              // where includes are looked up, shared with the build
+	files          map[string]*scannedFile // the files read by every Scan so far, or loaded
+	seen           map[string]bool         // the files come across by this invocation
+	changed        bool                    // files were read since they were loaded
+}
+
+// ErrBinaryFile is the error of checking a binary file as a source
+var ErrBinaryFile = errors.New("binary file")
+
+// binarySniffLen is how much of a file is looked at for the NUL bytes of
+// binary files, as git does
+const binarySniffLen = 8000
+
+// directive is an include directive of a file, the name it includes
+// between quotes or, for system includes, angle brackets
+type directive struct {
+	name   string
+	system bool
+}
+
+// scannedFile is the include directives of a file, with the modification
+// time, size and hash of the contents they were read from, and whether
+// those are binary
+type scannedFile struct {
+	modTime    int64
+	size       int64
+	hash       uint64
+	binary     bool
+	directives []directive
 }
 
 // NewDependencyScanner creates a new DependencyScanner with the given include directories
@@ -22,17 +58,35 @@ func NewDependencyScanner(includeDirs []string) *DependencyScanner {
 	return &DependencyScanner{
 		includeDirs:     includeDirs,
 		visitedFiles:    make(map[string]bool),
-		systemIncludeRe: regexp.MustCompile(`#include\s*<([^>]+)>`),
-		localIncludeRe:  regexp.MustCompile(`#include\s*"([^"]+)"`),
+		visitedIDs:      make(map[fileID]bool),
+		systemIncludeRe: regexp.MustCompile(`#(?:include|import)\s*<([^>]+)>`),
+		localIncludeRe:  regexp.MustCompile(`(?:[#.%]include|#import)\s*"([^"]+)"`),
+		files:           make(map[string]*scannedFile),
+		seen:            make(map[string]bool),
 	}
 }
 
-// Scan scans a source file for dependencies
+// AddIncludeDirs adds directories to search for included headers
+func (s *DependencyScanner) AddIncludeDirs(dirs ...string) {
+	s.includeDirs = append(s.includeDirs, dirs...)
+}
+
+// SetFS makes the scanner look includes up through fs, which remembers the
+// headers found and those missing from every include directory
+func (s *DependencyScanner) SetFS(fs *fsmeta.FS) {
+	s.fs = fs
+}
+
+// Scan scans a source file for dependencies. The include directives of
+// every file are read once for all the sources including it, and read
+// again only once the file changed. Includes nested deeper than the depth
+// limit are an error.
 func (s *DependencyScanner) Scan(sourceFile string) ([]string, error) {
 	s.visitedFiles = make(map[string]bool)
+	s.visitedIDs = make(map[fileID]bool)
 
 	dependencies := make(map[string]bool)
-	if err := s.scanRecursive(sourceFile, dependencies); err != nil {
+	if err := s.scanRecursive(sourceFile, nil, dependencies); err != nil {
 		return nil, err
 	}
 
@@ -44,38 +98,45 @@ func (s *DependencyScanner) Scan(sourceFile string) ([]string, error) {
 	return result, nil
 }
 
-// scanRecursive recursively scans for dependencies with visitor pattern
-func (s *DependencyScanner) scanRecursive(sourceFile string, dependencies map[string]bool) error {
+// scanRecursive recursively scans for dependencies with visitor pattern;
+// chain is the files including sourceFile, outermost first
+func (s *DependencyScanner) scanRecursive(sourceFile string, chain []string, dependencies map[string]bool) error {
 	if s.visitedFiles[sourceFile] {
 		return nil
 	}
 
 	s.visitedFiles[sourceFile] = true
-	file, err := os.Open(sourceFile)
+	// a file reached through another path, like a symlinked directory
+	// including itself, was scanned already
+	if info, err := s.fs.Stat(sourceFile); err == nil {
+		if id, ok := idOf(info); ok {
+			if s.visitedIDs[id] {
+				return nil
+			}
+			s.visitedIDs[id] = true
+		}
+	}
+	chain = append(chain, sourceFile)
+	if len(chain) > maxDepth {
+		return s.depthError(chain)
+	}
+
+	file, err := s.file(sourceFile)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		return err
 	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil { /* TODO */
-		}
-	}(file)
 
 	// get dir of current file for resolving relative includes
 	sourceDir := filepath.Dir(sourceFile)
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		// get local includes first
-		if matches := s.localIncludeRe.FindStringSubmatch(line); len(matches) > 1 {
-			includePath := matches[1]
+	for _, d := range file.directives {
+		if !d.system {
 			// relative path check
-			resolvedPath := filepath.Join(sourceDir, includePath)
-			if _, err := os.Stat(resolvedPath); os.IsNotExist(err) {
+			resolvedPath := filepath.Join(sourceDir, d.name)
+			if _, err := s.fs.Stat(resolvedPath); os.IsNotExist(err) {
 				found := false
 				for _, dir := range s.includeDirs {
-					tryPath := filepath.Join(dir, includePath)
-					if _, err := os.Stat(tryPath); err == nil {
+					tryPath := filepath.Join(dir, d.name)
+					if _, err := s.fs.Stat(tryPath); err == nil {
 						resolvedPath = tryPath
 						found = true
 						break
@@ -90,44 +151,112 @@ func (s *DependencyScanner) scanRecursive(sourceFile string, dependencies map[st
 
 			// add as deps
 			dependencies[resolvedPath] = true
-			if err := s.scanRecursive(resolvedPath, dependencies); err != nil {
+			if err := s.scanRecursive(resolvedPath, chain, dependencies); err != nil {
 				return err
 			}
+			continue
 		}
 
-		// system includes (#include <file.h>)
-		if matches := s.systemIncludeRe.FindStringSubmatch(line); len(matches) > 1 {
-			includePath := matches[1]
-			found := false
-			for _, dir := range s.includeDirs {
-				tryPath := filepath.Join(dir, includePath)
-				if _, err := os.Stat(tryPath); err == nil {
-					dependencies[tryPath] = true
-					if err := s.scanRecursive(tryPath, dependencies); err != nil {
-						return err
-					}
-
-					found = true
-					break
+		// system includes (#include <file.h>); if the header isn't found,
+		// that's usually okay, it's probably a standard library header
+		for _, dir := range s.includeDirs {
+			tryPath := filepath.Join(dir, d.name)
+			if _, err := s.fs.Stat(tryPath); err == nil {
+				dependencies[tryPath] = true
+				if err := s.scanRecursive(tryPath, chain, dependencies); err != nil {
+					return err
 				}
+				break
 			}
+		}
+	}
+
+	return nil
+}
 
-			// if the system header isn't found, that's usually okay
-			// it's probably a standard library header or root
-			if !found {
-				continue
+// depthError reports an include chain nested deeper than the depth limit,
+// naming the files of the cycle when the last one is included again
+func (s *DependencyScanner) depthError(chain []string) error {
+	if last, err := s.fs.Stat(chain[len(chain)-1]); err == nil {
+		for i := len(chain) - 2; i >= 0; i-- {
+			if info, err := s.fs.Stat(chain[i]); err == nil && os.SameFile(info, last) {
+				return fmt.Errorf("include cycle: %s", strings.Join(chain[i:], " -> "))
 			}
 		}
 	}
+	return fmt.Errorf("includes nested deeper than %d: %s -> ... -> %s (max_scan_depth raises the limit)",
+		maxDepth, chain[0], chain[len(chain)-1])
+}
+
+// Check reports whether the file at path can be compiled as a source,
+// which files that cannot be read and binary files cannot. Like the files
+// Scan reads, it is read the first time only.
+func (s *DependencyScanner) Check(path string) error {
+	file, err := s.file(path)
+	if err != nil {
+		return err
+	}
+	if file.binary {
+		return ErrBinaryFile
+	}
+	return nil
+}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error scanning file: %w", err)
+// file returns the include directives of a file, read the first time only.
+// A file modified since is hashed, and only read again when its contents
+// changed.
+func (s *DependencyScanner) file(path string) (*scannedFile, error) {
+	info, err := s.fs.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
+	s.seen[path] = true
 
-	return nil
+	known := s.files[path]
+	if known != nil && known.modTime == info.ModTime().UnixNano() && known.size == info.Size() {
+		return known, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	hasher := fnv.New64a()
+	hasher.Write(content)
+	if sum := hasher.Sum64(); known == nil || known.hash != sum {
+		sniff := content[:min(len(content), binarySniffLen)]
+		known = &scannedFile{hash: sum, binary: bytes.IndexByte(sniff, 0) >= 0}
+		if !known.binary {
+			known.directives = s.parseDirectives(content)
+		}
+		s.files[path] = known
+	}
+	known.modTime, known.size = info.ModTime().UnixNano(), info.Size()
+	s.changed = true
+	return known, nil
+}
+
+// parseDirectives returns the include directives of the contents of a
+// file, local ones first on each line
+func (s *DependencyScanner) parseDirectives(content []byte) []directive {
+	var directives []directive
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.Contains(line, "include") {
+			continue
+		}
+		if matches := s.localIncludeRe.FindStringSubmatch(line); len(matches) > 1 {
+			directives = append(directives, directive{name: matches[1]})
+		}
+		if matches := s.systemIncludeRe.FindStringSubmatch(line); len(matches) > 1 {
+			directives = append(directives, directive{name: matches[1], system: true})
+		}
+	}
+	return directives
 }
 
-// FindSourceFiles finds all source files matching the given patterns
+// FindSourceFiles finds all source files matching the given patterns.
+// Recursive patterns follow symlinked directories, and fail on symlink
+// cycles.
 func FindSourceFiles(patterns []string, excludePatterns []string) ([]string, error) {
 	var sourceFiles []string
 
@@ -160,16 +289,10 @@ func FindSourceFiles(patterns []string, excludePatterns []string) ([]string, err
 
 			baseDir := parts[0]
 			suffix := parts[1]
-			err := filepath.Walk(baseDir, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-
-				if !info.IsDir() && strings.HasSuffix(path, suffix) {
+			err := walkSources(baseDir, func(path string) {
+				if strings.HasSuffix(path, suffix) {
 					sourceFiles = append(sourceFiles, path)
 				}
-
-				return nil
 			})
 
 			if err != nil {
@@ -194,12 +317,12 @@ func FindSourceFiles(patterns []string, excludePatterns []string) ([]string, err
 			excluded := false
 
 			for _, excludePattern := range excludePatterns {
-				matched, err := filepath.Match(excludePattern, filepath.Base(file))
+				matched, err := Excludes(excludePattern, file)
 				if err != nil {
-					return nil, fmt.Errorf("invalid exclude pattern %s: %w", excludePattern, err)
+					return nil, err
 				}
 
-				if matched || strings.Contains(file, excludePattern) {
+				if matched {
 					excluded = true
 					break
 				}
@@ -225,3 +348,13 @@ func FindSourceFiles(patterns []string, excludePatterns []string) ([]string, err
 
 	return result, nil
 }
+
+// Excludes reports whether the exclude pattern excludes file: whether it
+// matches its name or is part of its path
+func Excludes(pattern, file string) (bool, error) {
+	matched, err := filepath.Match(pattern, filepath.Base(file))
+	if err != nil {
+		return false, fmt.Errorf("invalid exclude pattern %s: %w", pattern, err)
+	}
+	return matched || strings.Contains(file, pattern), nil
+}
@@ -0,0 +1,93 @@
+package dependency
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DefaultMaxDepth is how deeply includes may nest, and source directories
+// be walked, unless max_scan_depth says otherwise
+const DefaultMaxDepth = 200
+
+// maxDepth is the depth limit of scans and walks
+var maxDepth = DefaultMaxDepth
+
+// SetMaxDepth sets how deeply includes may nest and source directories be
+// walked, DefaultMaxDepth for 0
+func SetMaxDepth(depth int) {
+	if depth <= 0 {
+		depth = DefaultMaxDepth
+	}
+	maxDepth = depth
+}
+
+// walkedDir is a directory walkSources is in
+type walkedDir struct {
+	path string
+	id   fileID
+	ok   bool
+}
+
+// walkSources calls visit for every file under root, in lexical order like
+// filepath.Walk, following symlinked directories. A directory reached
+// again through another link is walked once, while a link leading back to
+// a directory it is in is a cycle, reported as such.
+func walkSources(root string, visit func(path string)) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		visit(root)
+		return nil
+	}
+
+	walked := make(map[fileID]bool)
+	var walk func(dir string, info os.FileInfo, ancestors []walkedDir) error
+	walk = func(dir string, info os.FileInfo, ancestors []walkedDir) error {
+		id, ok := idOf(info)
+		if ok {
+			for _, ancestor := range ancestors {
+				if ancestor.ok && ancestor.id == id {
+					return fmt.Errorf("symlink cycle: %s leads back to %s", dir, ancestor.path)
+				}
+			}
+			if walked[id] {
+				return nil
+			}
+			walked[id] = true
+		}
+		if len(ancestors) >= maxDepth {
+			return fmt.Errorf("directories nested deeper than %d at %s (max_scan_depth raises the limit)", maxDepth, dir)
+		}
+		ancestors = append(ancestors, walkedDir{path: dir, id: id, ok: ok})
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if entry.Type()&os.ModeSymlink != 0 {
+				// a dangling link is left to whatever reads it
+				if target, err := os.Stat(path); err == nil {
+					info = target
+				}
+			}
+			if !info.IsDir() {
+				visit(path)
+				continue
+			}
+			if err := walk(path, info, ancestors); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root, info, nil)
+}
@@ -0,0 +1,215 @@
+package deps
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// extractArchive unpacks a .tar, .tar.gz/.tgz, .tar.xz or .zip archive into dest. When
+// every entry lives under a single top-level directory, that directory is stripped.
+// With copyLinks, links in the archive are extracted as copies.
+func extractArchive(archive, dest string, copyLinks bool) error {
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+
+	staging := dest + ".extract"
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(staging)
+	}()
+
+	var err error
+	switch name := strings.ToLower(archive); {
+	case strings.HasSuffix(name, ".zip"):
+		err = extractZip(archive, staging)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		err = extractTar(archive, staging, "gzip", copyLinks)
+	case strings.HasSuffix(name, ".tar.xz"), strings.HasSuffix(name, ".txz"):
+		err = extractTar(archive, staging, "xz", copyLinks)
+	case strings.HasSuffix(name, ".tar"):
+		err = extractTar(archive, staging, "", copyLinks)
+	default:
+		err = fmt.Errorf("unsupported archive format: %s", filepath.Base(archive))
+	}
+	if err == nil && copyLinks {
+		err = replaceLinks(staging)
+	}
+	if err != nil {
+		return err
+	}
+
+	root := staging
+	entries, err := os.ReadDir(staging)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		root = filepath.Join(staging, entries[0].Name())
+	}
+
+	return os.Rename(root, dest)
+}
+
+// extractTar unpacks a tarball, gzip or xz compressed or not, as compression
+// names. Hard links are copied when copyLinks is set or the filesystem does
+// not support them.
+func extractTar(archive, dest, compression string, copyLinks bool) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	var reader io.Reader = file
+	switch compression {
+	case "gzip":
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to read gzip stream: %w", err)
+		}
+		defer func(gz *gzip.Reader) {
+			_ = gz.Close()
+		}(gz)
+		reader = gz
+	case "xz":
+		xzReader, err := xz.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to read xz stream: %w", err)
+		}
+		reader = xzReader
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar entry: %w", err)
+		}
+
+		target, err := safeJoin(dest, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeEntry(target, tr, os.FileMode(header.Mode).Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source, err := safeJoin(dest, header.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if !copyLinks && os.Link(source, target) == nil {
+				continue
+			}
+			info, err := os.Stat(source)
+			if err != nil {
+				return fmt.Errorf("failed to link %s: %w", header.Name, err)
+			}
+			if err := copyFile(source, target, info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// extractZip unpacks a zip archive
+func extractZip(archive, dest string) error {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("failed to open zip archive: %w", err)
+	}
+	defer func(reader *zip.ReadCloser) {
+		_ = reader.Close()
+	}(reader)
+
+	for _, entry := range reader.File {
+		target, err := safeJoin(dest, entry.Name)
+		if err != nil {
+			return err
+		}
+
+		if entry.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = writeEntry(target, rc, entry.Mode().Perm())
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeEntry writes an archive member to disk
+func writeEntry(target string, r io.Reader, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm|0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// safeJoin joins an archive member name onto dest, refusing names that would
+// escape it
+func safeJoin(dest, name string) (string, error) {
+	target := filepath.Join(dest, name)
+	if target != dest && !strings.HasPrefix(target, dest+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive entry escapes destination: %s", name)
+	}
+	return target, nil
+}
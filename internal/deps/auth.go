@@ -0,0 +1,126 @@
+package deps
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrAuthFailed is returned when a remote rejects or requires credentials
+	ErrAuthFailed = errors.New("authentication failed")
+	// ErrNotFound is returned when a remote has no such repository, ref or file
+	ErrNotFound = errors.New("not found")
+)
+
+// authPatterns are git messages that indicate missing or rejected credentials
+var authPatterns = []string{
+	"authentication failed",
+	"could not read username",
+	"could not read password",
+	"terminal prompts disabled",
+	"permission denied (publickey",
+	"http basic: access denied",
+	"returned error: 401",
+	"returned error: 403",
+	"host key verification failed",
+}
+
+// notFoundPatterns are git messages that indicate a missing repository
+var notFoundPatterns = []string{
+	"repository not found",
+	"does not appear to be a git repository",
+	"returned error: 404",
+}
+
+// repositoryNotFound matches git's "fatal: repository '<url>' not found"; a
+// bare "not found" would also match "git: command not found"
+var repositoryNotFound = regexp.MustCompile(`repository '[^']*' not found`)
+
+// remoteHost returns the host of a remote url, including scp-like git urls
+// such as git@example.com:org/repo.git
+func remoteHost(remote string) string {
+	if parsed, err := url.Parse(remote); err == nil && parsed.Host != "" {
+		return parsed.Hostname()
+	}
+
+	host := remote
+	if i := strings.Index(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if i := strings.Index(host, ":"); i >= 0 {
+		return host[:i]
+	}
+	return ""
+}
+
+// token returns the access token configured for the host of remote, if any
+func (m *Manager) token(remote string) (string, error) {
+	host := remoteHost(remote)
+	envVar, ok := m.Auth[host]
+	if !ok {
+		return "", nil
+	}
+
+	token := os.Getenv(envVar)
+	if token == "" {
+		return "", fmt.Errorf("%w: %s is configured to use the token in $%s, which is not set", ErrAuthFailed, host, envVar)
+	}
+	return token, nil
+}
+
+// gitEnv returns the environment for git commands talking to remote. Git
+// never prompts, so a missing credential fails instead of hanging the build;
+// credential helpers and the ssh agent keep working as usual.
+func (m *Manager) gitEnv(remote string) ([]string, error) {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	if os.Getenv("GIT_SSH_COMMAND") == "" {
+		env = append(env, "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+	}
+
+	token, err := m.token(remote)
+	if err != nil || token == "" {
+		return env, err
+	}
+
+	// passed through the environment so the token never shows up in the
+	// process list, and scoped to the host so redirects cannot leak it
+	parsed, err := url.Parse(remote)
+	if err != nil || parsed.Host == "" {
+		return env, nil
+	}
+	basic := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+	env = append(env,
+		"GIT_CONFIG_COUNT=1",
+		fmt.Sprintf("GIT_CONFIG_KEY_0=http.%s://%s/.extraHeader", parsed.Scheme, parsed.Host),
+		"GIT_CONFIG_VALUE_0=Authorization: Basic "+basic,
+	)
+	return env, nil
+}
+
+// classifyGitError turns a failed git command into an error wrapping
+// ErrAuthFailed or ErrNotFound where the output allows telling them apart
+func classifyGitError(remote, command string, err error, stderr string) error {
+	message := strings.ToLower(stderr)
+	for _, pattern := range authPatterns {
+		if strings.Contains(message, pattern) {
+			return fmt.Errorf("%w for %s: configure a git credential helper, load the key into ssh-agent or add a token under [registry.auth] (git %s: %s)",
+				ErrAuthFailed, remote, command, stderr)
+		}
+	}
+
+	notFound := slices.ContainsFunc(notFoundPatterns, func(pattern string) bool {
+		return strings.Contains(message, pattern)
+	})
+	if notFound || repositoryNotFound.MatchString(message) {
+		return fmt.Errorf("repository %s %w; private repositories report this too when no credentials are available (git %s: %s)",
+			remote, ErrNotFound, command, stderr)
+	}
+
+	return fmt.Errorf("git %s failed: %w: %s", command, err, stderr)
+}
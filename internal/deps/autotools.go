@@ -0,0 +1,94 @@
+package deps
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"github.com/deviceix/styx/internal/config"
+)
+
+// buildAutotools runs the classic configure/make/make install sequence on a
+// private copy of the sources, so that the source tree and its hash stay untouched
+func (m *Manager) buildAutotools(name string, dep config.DependencyConfig, pkg *Package) error {
+	buildDir, err := filepath.Abs(filepath.Join(m.packageDir(name), "build"))
+	if err != nil {
+		return fmt.Errorf("failed to resolve build directory: %w", err)
+	}
+	installDir := filepath.Join(filepath.Dir(buildDir), "install")
+
+	stamp, err := computeStamp(dep.Local, append([]string{KindAutotools}, dep.ConfigureFlags...)...)
+	if err != nil {
+		return err
+	}
+
+	if !m.isUpToDate(name, stamp, []string{installDir}) {
+		m.logger.Info("building autotools dependency %s", name)
+		for _, dir := range []string{buildDir, installDir} {
+			if err := os.RemoveAll(dir); err != nil {
+				return fmt.Errorf("failed to clear %s: %w", dir, err)
+			}
+		}
+
+		if err := copyDir(dep.Local, buildDir, m.CopyLinks); err != nil {
+			return fmt.Errorf("failed to copy sources: %w", err)
+		}
+
+		if err := bootstrapAutotools(buildDir); err != nil {
+			return err
+		}
+
+		configureArgs := append([]string{"--prefix=" + installDir}, dep.ConfigureFlags...)
+		if err := runCommand(buildDir, "sh", append([]string{"./configure"}, configureArgs...)...); err != nil {
+			return fmt.Errorf("configure failed: %w", err)
+		}
+
+		if err := runCommand(buildDir, "make", "-j"+strconv.Itoa(runtime.NumCPU())); err != nil {
+			return fmt.Errorf("make failed: %w", err)
+		}
+
+		if err := runCommand(buildDir, "make", "install"); err != nil {
+			return fmt.Errorf("make install failed: %w", err)
+		}
+
+		if err := m.writeStamp(name, stamp); err != nil {
+			return err
+		}
+	} else {
+		m.logger.Note("dependency %s is up to date", name)
+	}
+
+	pkg.IncludeDirs = append([]string{filepath.Join(installDir, "include")}, pkg.IncludeDirs...)
+	if len(dep.Outputs) == 0 {
+		libs, err := findLibraries(installDir)
+		if err != nil {
+			return fmt.Errorf("failed to find installed libraries: %w", err)
+		}
+		pkg.Outputs = libs
+	}
+
+	return nil
+}
+
+// bootstrapAutotools generates the configure script for checkouts that only
+// ship configure.ac
+func bootstrapAutotools(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, "configure")); err == nil {
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "autogen.sh")); err == nil {
+		if err := runCommand(dir, "sh", "./autogen.sh"); err != nil {
+			return fmt.Errorf("autogen.sh failed: %w", err)
+		}
+		return nil
+	}
+
+	if err := runCommand(dir, "autoreconf", "-fi"); err != nil {
+		return fmt.Errorf("no configure script and autoreconf failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package deps
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/deviceix/styx/internal/config"
+)
+
+// buildCMake configures, builds and installs a CMake project into the
+// dependency directory, skipping all three steps while its sources are unchanged
+func (m *Manager) buildCMake(name string, dep config.DependencyConfig, pkg *Package) error {
+	cmakePath, err := exec.LookPath("cmake")
+	if err != nil {
+		return fmt.Errorf("cmake not found: %w", err)
+	}
+
+	sourceDir, err := filepath.Abs(dep.Local)
+	if err != nil {
+		return fmt.Errorf("failed to resolve source directory: %w", err)
+	}
+
+	buildDir, err := filepath.Abs(filepath.Join(m.packageDir(name), "build"))
+	if err != nil {
+		return fmt.Errorf("failed to resolve build directory: %w", err)
+	}
+	installDir := filepath.Join(filepath.Dir(buildDir), "install")
+
+	stamp, err := computeStamp(dep.Local, append([]string{KindCMake}, dep.CMakeOptions...)...)
+	if err != nil {
+		return err
+	}
+
+	if !m.isUpToDate(name, stamp, []string{installDir}) {
+		m.logger.Info("building cmake dependency %s", name)
+		if err := os.RemoveAll(installDir); err != nil {
+			return fmt.Errorf("failed to clear install directory: %w", err)
+		}
+
+		configureArgs := []string{
+			"-S", sourceDir,
+			"-B", buildDir,
+			"-DCMAKE_BUILD_TYPE=Release",
+			"-DCMAKE_INSTALL_PREFIX=" + installDir,
+		}
+		configureArgs = append(configureArgs, dep.CMakeOptions...)
+
+		steps := [][]string{
+			configureArgs,
+			{"--build", buildDir, "--config", "Release"},
+			{"--install", buildDir, "--config", "Release"},
+		}
+		for _, args := range steps {
+			if err := runCommand("", cmakePath, args...); err != nil {
+				return fmt.Errorf("cmake %s failed: %w", strings.Join(args[:1], " "), err)
+			}
+		}
+
+		if err := m.writeStamp(name, stamp); err != nil {
+			return err
+		}
+	} else {
+		m.logger.Note("dependency %s is up to date", name)
+	}
+
+	pkg.IncludeDirs = append([]string{filepath.Join(installDir, "include")}, pkg.IncludeDirs...)
+	if len(dep.Outputs) == 0 {
+		libs, err := findLibraries(installDir)
+		if err != nil {
+			return fmt.Errorf("failed to find installed libraries: %w", err)
+		}
+		pkg.Outputs = libs
+	}
+
+	return nil
+}
@@ -0,0 +1,470 @@
+package deps
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/logger"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// Dependency kinds
+const (
+	KindCommand   = "command"
+	KindCMake     = "cmake"
+	KindAutotools = "autotools"
+	KindPrebuilt  = "prebuilt"
+	KindStyx      = "styx"
+)
+
+// Package is a dependency that has been resolved and, if needed, built
+type Package struct {
+	Name        string
+	Version     string
+	Dir         string
+	IncludeDirs []string
+	Libraries   []string
+	Outputs     []string
+	PatchDigest string
+}
+
+// Manager resolves and builds the dependencies declared in the configuration.
+// With CopyLinks, sources are copied and extracted without symbolic or hard
+// links, for state directories on filesystems that do not support them.
+type Manager struct {
+	Dependencies map[string]config.DependencyConfig
+	Root         string
+	VendorDir    string
+	LockPath     string
+	Auth         map[string]string
+	CopyLinks    bool
+	Lock         *Lockfile
+	lockChanged  bool
+	logger       *logger.Logger
+}
+
+// NewManager creates a new dependency manager storing its state under root
+func NewManager(dependencies map[string]config.DependencyConfig, root string, log *logger.Logger) *Manager {
+	return &Manager{
+		Dependencies: dependencies,
+		Root:         root,
+		VendorDir:    "vendor",
+		LockPath:     "styx.lock",
+		logger:       log,
+	}
+}
+
+// NewProjectManager creates a dependency manager for the dependencies of cfg,
+// with its state, credentials and link handling
+func NewProjectManager(cfg *config.Config, log *logger.Logger) *Manager {
+	m := NewManager(cfg.Dependencies, filepath.Join(cfg.StateDir(), "deps"), log)
+	m.Auth = cfg.Registry.Auth
+	m.CopyLinks = cfg.Cache.Network
+	return m
+}
+
+// ResolveAll resolves every configured dependency in name order, followed by
+// the dependencies they declare in their own styx.toml
+func (m *Manager) ResolveAll() ([]*Package, error) {
+	if err := m.loadLock(); err != nil {
+		return nil, err
+	}
+
+	names, required, err := m.collectRequirements()
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []*Package
+	for _, name := range names {
+		pkg, err := m.Resolve(name, required[name])
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s: %w", name, err)
+		}
+		packages = append(packages, pkg)
+	}
+
+	if err := m.saveLock(); err != nil {
+		return nil, err
+	}
+
+	return packages, nil
+}
+
+// loadLock reads the lockfile unless it has been loaded already
+func (m *Manager) loadLock() error {
+	if m.Lock != nil {
+		return nil
+	}
+
+	lock, err := LoadLockfile(m.LockPath)
+	if err != nil {
+		return err
+	}
+
+	m.Lock = lock
+	return nil
+}
+
+// saveLock writes the lockfile back if resolution changed it
+func (m *Manager) saveLock() error {
+	if !m.lockChanged {
+		return nil
+	}
+
+	if err := m.Lock.Save(m.LockPath); err != nil {
+		return err
+	}
+
+	m.lockChanged = false
+	return nil
+}
+
+// Resolve resolves a single dependency, running its build step when required
+func (m *Manager) Resolve(name string, dep config.DependencyConfig) (*Package, error) {
+	if err := os.MkdirAll(m.packageDir(name), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create dependency directory: %w", err)
+	}
+
+	if err := m.loadLock(); err != nil {
+		return nil, err
+	}
+
+	// remote sources are fetched first and then built as if they were local
+	if dep.Git != "" {
+		dir, err := m.fetchGit(name, dep)
+		if err != nil {
+			return nil, err
+		}
+		dep.Local = dir
+	}
+
+	// source archives are unpacked and then built like local trees as well
+	if dep.Local == "" && buildsFromSource(dep) {
+		if url, _ := archiveSource(dep); url != "" {
+			dir, err := m.fetchArchive(name, dep)
+			if err != nil {
+				return nil, err
+			}
+			dep.Local = dir
+		}
+	}
+
+	var patchDigest string
+	if len(dep.Patches) > 0 {
+		if dep.Local == "" {
+			dir, err := m.fetchArchive(name, dep)
+			if err != nil {
+				return nil, err
+			}
+			dep.Local = dir
+		}
+
+		dir, digest, err := m.applyPatches(name, dep)
+		if err != nil {
+			return nil, err
+		}
+		dep.Local = dir
+		patchDigest = digest
+	}
+
+	pkg := &Package{
+		Name:        name,
+		Version:     dep.Version,
+		Dir:         dep.Local,
+		IncludeDirs: dep.IncludeDirs,
+		Outputs:     dep.Outputs,
+		PatchDigest: patchDigest,
+	}
+
+	if dep.HeaderOnly {
+		if err := m.resolveHeaderOnly(name, dep, pkg); err != nil {
+			return nil, err
+		}
+		return pkg, nil
+	}
+
+	switch dep.Kind {
+	case "", KindCommand:
+		if dep.BuildCmd != "" {
+			if err := m.runBuildCommand(name, dep); err != nil {
+				return nil, err
+			}
+		} else if dep.Kind == "" && hasManifest(dep.Local) {
+			if err := m.buildStyx(name, dep, pkg); err != nil {
+				return nil, err
+			}
+		}
+	case KindStyx:
+		if err := m.buildStyx(name, dep, pkg); err != nil {
+			return nil, err
+		}
+	case KindCMake:
+		if err := m.buildCMake(name, dep, pkg); err != nil {
+			return nil, err
+		}
+	case KindAutotools:
+		if err := m.buildAutotools(name, dep, pkg); err != nil {
+			return nil, err
+		}
+	case KindPrebuilt:
+		if err := m.fetchPrebuilt(name, dep, pkg); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported dependency kind: %s", dep.Kind)
+	}
+
+	for _, output := range pkg.Outputs {
+		if IsLibrary(output) {
+			pkg.Libraries = append(pkg.Libraries, output)
+		}
+	}
+
+	return pkg, nil
+}
+
+// runBuildCommand runs the external build command of a dependency unless its
+// declared outputs exist and neither the command nor its sources have changed
+func (m *Manager) runBuildCommand(name string, dep config.DependencyConfig) error {
+	stamp, err := computeStamp(dep.Local, dep.BuildCmd)
+	if err != nil {
+		return err
+	}
+
+	if m.isUpToDate(name, stamp, dep.Outputs) {
+		m.logger.Note("dependency %s is up to date", name)
+		return nil
+	}
+
+	m.logger.Info("building dependency %s: %s", name, dep.BuildCmd)
+	if err := RunShell(dep.BuildCmd, ""); err != nil {
+		return fmt.Errorf("build command failed: %w", err)
+	}
+
+	for _, output := range dep.Outputs {
+		if _, err := os.Stat(output); err != nil {
+			return fmt.Errorf("declared output %s was not produced by build_cmd", output)
+		}
+	}
+
+	// in-tree builds leave their products next to the sources, so the stamp
+	// must describe the tree as the build left it
+	stamp, err = computeStamp(dep.Local, dep.BuildCmd)
+	if err != nil {
+		return err
+	}
+
+	return m.writeStamp(name, stamp)
+}
+
+// packageDir returns the directory holding the state of a dependency
+func (m *Manager) packageDir(name string) string {
+	return filepath.Join(m.Root, name)
+}
+
+// isUpToDate reports whether a dependency was last built from the state
+// described by stamp and all of its outputs still exist
+func (m *Manager) isUpToDate(name, stamp string, outputs []string) bool {
+	return m.isStampCurrent(name, "stamp", stamp, outputs)
+}
+
+// writeStamp records the state a dependency was built from
+func (m *Manager) writeStamp(name, stamp string) error {
+	return m.writeStampFile(name, "stamp", stamp)
+}
+
+// isStampCurrent reports whether the stamp file of a dependency records
+// stamp and all of outputs still exist
+func (m *Manager) isStampCurrent(name, file, stamp string, outputs []string) bool {
+	previous, err := os.ReadFile(filepath.Join(m.packageDir(name), file))
+	if err != nil {
+		return false
+	}
+	return string(previous) == stamp && outputsExist(outputs)
+}
+
+// writeStampFile records stamp in a stamp file of a dependency
+func (m *Manager) writeStampFile(name, file, stamp string) error {
+	if err := platform.WriteFileAtomic(filepath.Join(m.packageDir(name), file), []byte(stamp), 0644); err != nil {
+		return fmt.Errorf("failed to write build stamp: %w", err)
+	}
+	return nil
+}
+
+// buildsFromSource reports whether a dependency is built from its sources
+// rather than used as downloaded
+func buildsFromSource(dep config.DependencyConfig) bool {
+	return !dep.HeaderOnly && dep.Kind != KindPrebuilt
+}
+
+// computeStamp hashes the build settings in parts together with the
+// contents of sourceDir, if any
+func computeStamp(sourceDir string, parts ...string) (string, error) {
+	hasher := sha256.New()
+	for _, part := range parts {
+		hasher.Write([]byte(part))
+		hasher.Write([]byte{0})
+	}
+
+	if sourceDir != "" {
+		digest, err := HashDir(sourceDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash sources: %w", err)
+		}
+		hasher.Write([]byte(digest))
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// HashDir computes a digest over the relative paths and contents of every
+// file below dir
+func HashDir(dir string) (string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(files)
+	hasher := sha256.New()
+	for _, path := range files {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return "", err
+		}
+		hasher.Write([]byte(filepath.ToSlash(rel)))
+
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hasher, file)
+		_ = file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// RunShell runs a command line through the platform shell in dir
+func RunShell(command, dir string) error {
+	if runtime.GOOS == "windows" {
+		return runCommand(dir, "cmd", "/C", command)
+	}
+	return runCommand(dir, "sh", "-c", command)
+}
+
+// runCommand runs a program in dir, folding its output into the error
+func runCommand(dir, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
+
+// IsLibrary reports whether path names a static or shared library
+func IsLibrary(path string) bool {
+	switch filepath.Ext(path) {
+	case ".a", ".lib", ".so", ".dylib", ".dll":
+		return true
+	}
+
+	// versioned shared objects such as libz.so.1
+	return strings.Contains(filepath.Base(path), ".so.")
+}
+
+// findLibraries lists the libraries installed below prefix, preferring the
+// static variant when a library is installed both ways
+func findLibraries(prefix string) ([]string, error) {
+	var libs []string
+	static := make(map[string]bool)
+	for _, libDir := range []string{"lib", "lib64"} {
+		entries, err := os.ReadDir(filepath.Join(prefix, libDir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			path := filepath.Join(prefix, libDir, entry.Name())
+			if entry.IsDir() || !IsLibrary(path) {
+				continue
+			}
+			if filepath.Ext(path) == ".a" || filepath.Ext(path) == ".lib" {
+				static[libraryStem(path)] = true
+			}
+			libs = append(libs, path)
+		}
+	}
+
+	var result []string
+	for _, lib := range libs {
+		ext := filepath.Ext(lib)
+		if ext != ".a" && ext != ".lib" && static[libraryStem(lib)] {
+			continue
+		}
+		// the unversioned name of a shared object is the one to link against
+		if strings.Contains(filepath.Base(lib), ".so.") {
+			continue
+		}
+		result = append(result, lib)
+	}
+
+	return result, nil
+}
+
+// libraryStem strips the directory and every extension from a library path
+func libraryStem(path string) string {
+	base := filepath.Base(path)
+	if i := strings.Index(base, "."); i > 0 {
+		return base[:i]
+	}
+	return base
+}
+
+// outputsExist reports whether every path in outputs exists
+func outputsExist(outputs []string) bool {
+	for _, output := range outputs {
+		if _, err := os.Stat(output); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package deps
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// download fetches url into dest, sending token as a bearer token when set,
+// and returns the sha256 of the content
+func download(url, dest, token string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", fmt.Errorf("failed to create download directory: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", fmt.Errorf("%w for %s: %s; add a token for its host under [registry.auth]", ErrAuthFailed, url, resp.Status)
+	case http.StatusNotFound:
+		return "", fmt.Errorf("%s %w (%s)", url, ErrNotFound, resp.Status)
+	default:
+		return "", fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	// download next to the destination and rename, so an interrupted
+	// transfer never leaves a truncated archive behind
+	tmp := dest + ".part"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hasher), resp.Body); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("failed to download %s: %w", url, err)
+	}
+
+	if err := file.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+
+	if err := os.Rename(tmp, dest); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// verifyChecksum compares a computed sha256 against the expected one
+func verifyChecksum(url, expected, actual string) error {
+	if expected == "" {
+		return fmt.Errorf("no sha256 configured for %s (downloaded archive has sha256 %s)", url, actual)
+	}
+
+	if !strings.EqualFold(strings.TrimPrefix(expected, "sha256:"), actual) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", url, expected, actual)
+	}
+
+	return nil
+}
+
+// archiveName derives a local file name for a downloaded archive
+func archiveName(url string) string {
+	name := url
+	if i := strings.IndexAny(name, "?#"); i >= 0 {
+		name = name[:i]
+	}
+	return filepath.Base(name)
+}
@@ -0,0 +1,149 @@
+package deps
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// copyDir recursively copies the tree at src to dst, preserving file modes.
+// With copyLinks, symbolic links are replaced by copies of what they point
+// to, for filesystems that cannot hold links.
+func copyDir(src, dst string, copyLinks bool) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			if info.Name() == ".git" && path != src {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0 && copyLinks:
+			return copyLinkTarget(path, target)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return nil
+		}
+	})
+}
+
+// copyLinkTarget copies the file or directory the symbolic link at path
+// points to into target; dangling links are skipped
+func copyLinkTarget(path, target string) error {
+	resolved, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if parent == resolved || strings.HasPrefix(parent, resolved+string(os.PathSeparator)) {
+			return fmt.Errorf("link points to its own parent: %s", path)
+		}
+		return copyDir(resolved, target, true)
+	}
+	return copyFile(resolved, target, info.Mode().Perm())
+}
+
+// replaceLinks replaces every symbolic link below root with a copy of what
+// it points to, refusing links that lead outside root
+func replaceLinks(root string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	var links []string
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			links = append(links, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		resolved, err := filepath.EvalSymlinks(link)
+		if os.IsNotExist(err) {
+			if err := os.Remove(link); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if resolved != realRoot && !strings.HasPrefix(resolved, realRoot+string(os.PathSeparator)) {
+			return fmt.Errorf("link escapes destination: %s", link)
+		}
+
+		staging := link + ".copy"
+		if err := copyLinkTarget(link, staging); err != nil {
+			return err
+		}
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+		if err := os.Rename(staging, link); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyFile copies a single regular file
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func(in *os.File) {
+		_ = in.Close()
+	}(in)
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,181 @@
+package deps
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/deviceix/styx/internal/config"
+)
+
+// GitRef returns the ref a git dependency tracks
+func GitRef(dep config.DependencyConfig) string {
+	switch {
+	case dep.Rev != "":
+		return dep.Rev
+	case dep.Tag != "":
+		return "refs/tags/" + dep.Tag
+	case dep.Branch != "":
+		return "refs/heads/" + dep.Branch
+	default:
+		return "HEAD"
+	}
+}
+
+// fetchGit returns the checkout of a git dependency, preferring a vendored
+// copy over the network
+func (m *Manager) fetchGit(name string, dep config.DependencyConfig) (string, error) {
+	return m.fetchGitFrom(name, dep, true)
+}
+
+// fetchGitFrom checks out the locked commit of a git dependency, resolving and
+// locking the tracked ref first if the dependency is not locked yet
+func (m *Manager) fetchGitFrom(name string, dep config.DependencyConfig, allowVendor bool) (string, error) {
+	if allowVendor {
+		if dir, ok := m.vendored(name, dep); ok {
+			m.logger.Note("using vendored copy of %s", name)
+			return dir, nil
+		}
+	}
+
+	ref := GitRef(dep)
+	locked, ok := m.Lock.Packages[name]
+	commit := locked.Commit
+	if !ok || locked.Git != dep.Git || locked.Ref != ref || commit == "" {
+		resolved, err := m.resolveGitRef(dep.Git, ref)
+		if err != nil {
+			return "", err
+		}
+		commit = resolved
+		m.Lock.Packages[name] = LockedPackage{Git: dep.Git, Ref: ref, Commit: commit, Patches: locked.Patches}
+		m.lockChanged = true
+	}
+
+	dir := filepath.Join(m.packageDir(name), "src")
+	if err := m.checkoutGit(dep.Git, commit, dir); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// resolveGitRef asks the remote which commit a ref currently points at
+func (m *Manager) resolveGitRef(url, ref string) (string, error) {
+	if isCommitHash(ref) {
+		return ref, nil
+	}
+
+	output, err := m.gitOutput("", url, "ls-remote", url, ref, ref+"^{}")
+	if err != nil {
+		return "", err
+	}
+
+	// annotated tags list the tag object first and the peeled commit second
+	var commit string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if commit == "" || strings.HasSuffix(fields[1], "^{}") {
+			commit = fields[0]
+		}
+	}
+
+	if commit == "" {
+		return "", fmt.Errorf("ref %s %w in %s", ref, ErrNotFound, url)
+	}
+
+	return commit, nil
+}
+
+// listGitTags returns the tag names published by a remote
+func (m *Manager) listGitTags(url string) ([]string, error) {
+	output, err := m.gitOutput("", url, "ls-remote", "--tags", "--refs", url)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			tags = append(tags, strings.TrimPrefix(fields[1], "refs/tags/"))
+		}
+	}
+
+	return tags, nil
+}
+
+// checkoutGit makes dir a checkout of url at commit, cloning on first use
+func (m *Manager) checkoutGit(url, commit, dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		head, err := m.gitOutput(dir, "", "rev-parse", "HEAD")
+		if err == nil && strings.TrimSpace(string(head)) == commit {
+			return nil
+		}
+
+		if _, err := m.gitOutput(dir, url, "fetch", "--quiet", "--tags", "origin"); err != nil {
+			return err
+		}
+	} else {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		if _, err := m.gitOutput("", url, "clone", "--quiet", url, dir); err != nil {
+			return err
+		}
+	}
+
+	if _, err := m.gitOutput(dir, "", "checkout", "--quiet", "--detach", commit); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// gitOutput runs git in dir and returns its standard output. Commands that
+// talk to remote run with its credentials and have their failures classified.
+func (m *Manager) gitOutput(dir, remote string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	if remote != "" {
+		env, err := m.gitEnv(remote)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if remote != "" {
+			return nil, classifyGitError(remote, args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return output, nil
+}
+
+// isCommitHash reports whether ref looks like a full commit id
+func isCommitHash(ref string) bool {
+	if len(ref) != 40 {
+		return false
+	}
+	for _, c := range ref {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
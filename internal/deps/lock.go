@@ -0,0 +1,70 @@
+package deps
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// Lockfile pins the exact revision every remote dependency resolved to
+type Lockfile struct {
+	Packages map[string]LockedPackage `toml:"packages"`
+}
+
+// LockedPackage is the pinned state of a single dependency
+type LockedPackage struct {
+	Git    string `toml:"git,omitempty"`
+	Ref    string `toml:"ref,omitempty"`
+	Commit string `toml:"commit,omitempty"`
+	URL    string `toml:"url,omitempty"`
+	SHA256 string `toml:"sha256,omitempty"`
+
+	// Patches maps each patch applied to the sources to its sha256
+	Patches map[string]string `toml:"patches,omitempty"`
+}
+
+// LoadLockfile reads a lockfile, returning an empty one if it does not exist
+func LoadLockfile(path string) (*Lockfile, error) {
+	lock := &Lockfile{Packages: make(map[string]LockedPackage)}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return lock, nil
+	}
+
+	if _, err := toml.DecodeFile(path, lock); err != nil {
+		return nil, fmt.Errorf("failed to parse lockfile: %w", err)
+	}
+
+	if lock.Packages == nil {
+		lock.Packages = make(map[string]LockedPackage)
+	}
+
+	return lock, nil
+}
+
+// Save writes the lockfile to path
+func (l *Lockfile) Save(path string) error {
+	var buf bytes.Buffer
+	buf.WriteString("# generated by styx; do not edit by hand\n")
+	if err := toml.NewEncoder(&buf).Encode(l); err != nil {
+		return fmt.Errorf("failed to write lockfile: %w", err)
+	}
+
+	if err := platform.WriteFileAtomic(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write lockfile: %w", err)
+	}
+	return nil
+}
+
+// Names returns the locked package names in sorted order
+func (l *Lockfile) Names() []string {
+	names := make([]string, 0, len(l.Packages))
+	for name := range l.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
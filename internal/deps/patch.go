@@ -0,0 +1,133 @@
+package deps
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// applyPatches copies the sources of a dependency and applies its patches to
+// the copy, so the fetched or local tree stays pristine. The copy is only
+// recreated when the sources or the patches change.
+func (m *Manager) applyPatches(name string, dep config.DependencyConfig) (string, string, error) {
+	digests, err := m.lockPatches(name, dep.Patches)
+	if err != nil {
+		return "", "", err
+	}
+
+	patchDigest, err := computeStamp("", digests...)
+	if err != nil {
+		return "", "", err
+	}
+
+	stamp, err := computeStamp(dep.Local, patchDigest)
+	if err != nil {
+		return "", "", err
+	}
+
+	dir := filepath.Join(m.packageDir(name), "patched")
+	stampPath := filepath.Join(m.packageDir(name), "patched.stamp")
+	if previous, err := os.ReadFile(stampPath); err == nil && string(previous) == stamp && outputsExist([]string{dir}) {
+		return dir, patchDigest, nil
+	}
+
+	m.logger.Info("applying %d patches to %s", len(dep.Patches), name)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", "", fmt.Errorf("failed to clear %s: %w", dir, err)
+	}
+	if err := copyDir(dep.Local, dir, m.CopyLinks); err != nil {
+		return "", "", fmt.Errorf("failed to copy sources: %w", err)
+	}
+
+	for _, patch := range dep.Patches {
+		if err := applyPatch(dir, patch); err != nil {
+			return "", "", fmt.Errorf("failed to apply %s: %w", patch, err)
+		}
+	}
+
+	if err := platform.WriteFileAtomic(stampPath, []byte(stamp), 0644); err != nil {
+		return "", "", fmt.Errorf("failed to write patch stamp: %w", err)
+	}
+
+	return dir, patchDigest, nil
+}
+
+// lockPatches hashes the patches of a dependency and records them in the
+// lockfile, returning the digests in a stable order
+func (m *Manager) lockPatches(name string, patches []string) ([]string, error) {
+	hashes := make(map[string]string, len(patches))
+	for _, patch := range patches {
+		data, err := os.ReadFile(patch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read patch: %w", err)
+		}
+		sum := sha256.Sum256(data)
+		hashes[filepath.ToSlash(patch)] = hex.EncodeToString(sum[:])
+	}
+
+	locked := m.Lock.Packages[name]
+	if !equalHashes(locked.Patches, hashes) {
+		if len(locked.Patches) > 0 {
+			m.logger.Note("patches of %s changed", name)
+		}
+		locked.Patches = hashes
+		m.Lock.Packages[name] = locked
+		m.lockChanged = true
+	}
+
+	// patches apply in order, so the order is part of the digest
+	digests := make([]string, 0, len(patches))
+	for _, patch := range patches {
+		digests = append(digests, filepath.ToSlash(patch)+"="+hashes[filepath.ToSlash(patch)])
+	}
+	return digests, nil
+}
+
+// applyPatch applies a unified diff to dir. Git is kept from discovering the
+// repository of the surrounding project, so paths resolve relative to dir.
+func applyPatch(dir, patch string) error {
+	abs, err := filepath.Abs(patch)
+	if err != nil {
+		return err
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("git", "apply", "--whitespace=nowarn", abs)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_CEILING_DIRECTORIES="+filepath.Dir(absDir))
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
+
+// equalHashes reports whether two patch hash maps are identical
+func equalHashes(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, hash := range a {
+		if b[key] != hash {
+			return false
+		}
+	}
+	return true
+}
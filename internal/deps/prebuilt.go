@@ -0,0 +1,121 @@
+package deps
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// fetchPrebuilt downloads and unpacks the archive for the current platform,
+// then exposes its include and library directories
+func (m *Manager) fetchPrebuilt(name string, dep config.DependencyConfig, pkg *Package) error {
+	// patched archives have been unpacked already
+	extractDir := dep.Local
+	if extractDir == "" {
+		dir, err := m.fetchArchive(name, dep)
+		if err != nil {
+			return err
+		}
+		extractDir = dir
+	}
+
+	pkg.Dir = extractDir
+	pkg.IncludeDirs = append([]string{filepath.Join(extractDir, "include")}, pkg.IncludeDirs...)
+	if len(dep.Outputs) == 0 {
+		libs, err := findLibraries(extractDir)
+		if err != nil {
+			return fmt.Errorf("failed to find prebuilt libraries: %w", err)
+		}
+		pkg.Outputs = libs
+	}
+
+	return nil
+}
+
+// resolveHeaderOnly exposes the include directory of a dependency without
+// building anything, fetching its archive first when it is not local
+func (m *Manager) resolveHeaderOnly(name string, dep config.DependencyConfig, pkg *Package) error {
+	dir := dep.Local
+	if dir == "" {
+		extractDir, err := m.fetchArchive(name, dep)
+		if err != nil {
+			return err
+		}
+		dir = extractDir
+	}
+
+	includeDir := filepath.Join(dir, "include")
+	if info, err := os.Stat(includeDir); err != nil || !info.IsDir() {
+		includeDir = dir
+	}
+
+	pkg.Dir = dir
+	pkg.IncludeDirs = append([]string{includeDir}, pkg.IncludeDirs...)
+	pkg.Outputs = nil
+	return nil
+}
+
+// fetchArchive returns the unpacked archive of a dependency, preferring a
+// vendored copy over the network
+func (m *Manager) fetchArchive(name string, dep config.DependencyConfig) (string, error) {
+	return m.fetchArchiveFrom(name, dep, true)
+}
+
+// fetchArchiveFrom downloads, verifies and unpacks the archive configured for
+// the current platform, reusing the previous extraction while url and checksum
+// match. The archive keeps its own stamp, apart from the one of the build step.
+func (m *Manager) fetchArchiveFrom(name string, dep config.DependencyConfig, allowVendor bool) (string, error) {
+	if allowVendor {
+		if dir, ok := m.vendored(name, dep); ok {
+			m.logger.Note("using vendored copy of %s", name)
+			return dir, nil
+		}
+	}
+
+	url, expected := archiveSource(dep)
+	if url == "" {
+		return "", fmt.Errorf("no archive configured for platform %s", platform.GetPlatformInfo().Name)
+	}
+
+	extractDir := filepath.Join(m.packageDir(name), "prebuilt")
+	stamp, err := computeStamp("", KindPrebuilt, dep.Version, url, expected)
+	if err != nil {
+		return "", err
+	}
+
+	if m.isStampCurrent(name, "archive.stamp", stamp, []string{extractDir}) {
+		m.logger.Note("dependency %s is up to date", name)
+		return extractDir, nil
+	}
+
+	m.logger.Info("downloading dependency %s", name)
+	archive := filepath.Join(m.packageDir(name), archiveName(url))
+	token, err := m.token(url)
+	if err != nil {
+		return "", err
+	}
+
+	actual, err := download(url, archive, token)
+	if err != nil {
+		return "", err
+	}
+
+	if err := verifyChecksum(url, expected, actual); err != nil {
+		_ = os.Remove(archive)
+		return "", err
+	}
+
+	if err := extractArchive(archive, extractDir, m.CopyLinks); err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w", filepath.Base(archive), err)
+	}
+	_ = os.Remove(archive)
+
+	if err := m.writeStampFile(name, "archive.stamp", stamp); err != nil {
+		return "", err
+	}
+
+	return extractDir, nil
+}
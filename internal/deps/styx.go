@@ -0,0 +1,191 @@
+package deps
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// hasManifest reports whether dir is the root of a styx project
+func hasManifest(dir string) bool {
+	if dir == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, "styx.toml"))
+	return err == nil
+}
+
+// buildStyx builds a dependency that is a styx project itself by running
+// styx on a private copy of its sources, then exposes the libraries it
+// declares and their include directories
+func (m *Manager) buildStyx(name string, dep config.DependencyConfig, pkg *Package) error {
+	cfg, err := config.ParseFile(filepath.Join(dep.Local, "styx.toml"))
+	if err != nil {
+		return err
+	}
+
+	// release builds are preferred for dependencies when the project has them
+	target := "debug"
+	if _, ok := cfg.Targets["release"]; ok {
+		target = "release"
+	}
+
+	buildDir, err := filepath.Abs(filepath.Join(m.packageDir(name), "build"))
+	if err != nil {
+		return fmt.Errorf("failed to resolve build directory: %w", err)
+	}
+	outputDir := filepath.Join(buildDir, "build", target)
+
+	libs, err := styxLibraries(cfg, outputDir)
+	if err != nil {
+		return err
+	}
+
+	stamp, err := computeStamp(dep.Local, KindStyx, target)
+	if err != nil {
+		return err
+	}
+
+	if !m.isUpToDate(name, stamp, libs) {
+		m.logger.Info("building styx dependency %s", name)
+		styx, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to locate styx: %w", err)
+		}
+
+		if err := os.RemoveAll(buildDir); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", buildDir, err)
+		}
+
+		if err := copyDir(dep.Local, buildDir, m.CopyLinks); err != nil {
+			return fmt.Errorf("failed to copy sources: %w", err)
+		}
+
+		args := []string{"build"}
+		if target != "debug" {
+			args = append(args, "-t", target)
+		}
+		if err := runCommand(buildDir, styx, args...); err != nil {
+			return fmt.Errorf("styx build failed: %w", err)
+		}
+
+		if err := m.writeStamp(name, stamp); err != nil {
+			return err
+		}
+	} else {
+		m.logger.Note("dependency %s is up to date", name)
+	}
+
+	var includeDirs []string
+	for _, dir := range styxIncludeDirs(cfg) {
+		includeDirs = append(includeDirs, filepath.Join(buildDir, dir))
+	}
+
+	// headers generated by feature checks
+	if _, err := os.Stat(filepath.Join(outputDir, "include")); err == nil {
+		includeDirs = append(includeDirs, filepath.Join(outputDir, "include"))
+	}
+
+	pkg.IncludeDirs = append(includeDirs, pkg.IncludeDirs...)
+	if len(dep.Outputs) == 0 {
+		pkg.Outputs = libs
+	}
+
+	return nil
+}
+
+// styxLibraries returns the libraries a styx project builds into outputDir,
+// the directory of a target named after it, each before the libraries it
+// links, so they can be passed to the linker as is
+func styxLibraries(cfg *config.Config, outputDir string) ([]string, error) {
+	info := platform.GetPlatformInfo()
+	libraryPath := func(template, name, outputType string) string {
+		name = config.ExpandOutputName(template, config.OutputNameValues{
+			Name:     name,
+			Version:  cfg.Project.Version,
+			Platform: info.Name,
+			Arch:     platform.Arch(),
+			Target:   filepath.Base(outputDir),
+		})
+		if outputType == "shared_lib" {
+			return filepath.Join(outputDir, "lib"+name+info.SharedLibExtension)
+		}
+		return filepath.Join(outputDir, "lib"+name+info.StaticLibExtension)
+	}
+
+	if len(cfg.Libraries) == 0 {
+		if cfg.Build.OutputType != "static_lib" && cfg.Build.OutputType != "shared_lib" {
+			return nil, fmt.Errorf("project %s does not build a library", cfg.Project.Name)
+		}
+		return []string{libraryPath(cfg.Build.OutputName, cfg.Project.Name, cfg.Build.OutputType)}, nil
+	}
+
+	byName := make(map[string]config.ArtifactConfig)
+	for _, lib := range cfg.Libraries {
+		byName[lib.Name] = lib
+	}
+
+	var postorder []string
+	visited := make(map[string]bool)
+	var visit func(lib config.ArtifactConfig)
+	visit = func(lib config.ArtifactConfig) {
+		if visited[lib.Name] {
+			return
+		}
+		visited[lib.Name] = true
+		for _, link := range lib.Links {
+			visit(byName[link])
+		}
+		postorder = append(postorder, libraryPath(lib.OutputNameTemplate(), lib.Name, lib.Type))
+	}
+	for _, lib := range cfg.Libraries {
+		visit(lib)
+	}
+
+	libs := make([]string, len(postorder))
+	for i, lib := range postorder {
+		libs[len(postorder)-1-i] = lib
+	}
+	return libs, nil
+}
+
+// styxIncludeDirs returns the include directories a styx project declares,
+// relative to its root
+func styxIncludeDirs(cfg *config.Config) []string {
+	dirs := append([]string{}, cfg.Build.IncludeDirs...)
+	for _, lib := range cfg.Libraries {
+		dirs = append(dirs, lib.IncludeDirs...)
+	}
+	return dirs
+}
+
+// ProjectPackage describes the libraries the styx project at dir builds
+// into outputDir as a resolved package, the way projects of a workspace
+// depend on each other
+func ProjectPackage(cfg *config.Config, dir, outputDir string) (*Package, error) {
+	libs, err := styxLibraries(cfg, outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &Package{
+		Name:      cfg.Project.Name,
+		Version:   cfg.Project.Version,
+		Dir:       dir,
+		Libraries: libs,
+		Outputs:   libs,
+	}
+	for _, include := range styxIncludeDirs(cfg) {
+		pkg.IncludeDirs = append(pkg.IncludeDirs, filepath.Join(dir, include))
+	}
+
+	// headers generated by feature checks
+	if _, err := os.Stat(filepath.Join(outputDir, "include")); err == nil {
+		pkg.IncludeDirs = append(pkg.IncludeDirs, filepath.Join(outputDir, "include"))
+	}
+
+	return pkg, nil
+}
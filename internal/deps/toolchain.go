@@ -0,0 +1,98 @@
+package deps
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// ToolchainsDir returns the directory downloaded toolchains are installed
+// in, shared by every project of the user
+func ToolchainsDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, ".styx", "toolchains"), nil
+}
+
+// toolchainSource returns the archive and sha256 of a toolchain for the
+// current platform and architecture
+func toolchainSource(release config.ToolchainRelease) (string, string) {
+	name := platform.GetPlatformInfo().Name
+	arch := platform.Arch()
+
+	for _, key := range []string{name + "-" + arch, name} {
+		if url := release.URLs[key]; url != "" {
+			return url, release.SHA256[key]
+		}
+	}
+	return "", ""
+}
+
+// InstallToolchain downloads, verifies and unpacks the named toolchain into
+// ToolchainsDir, unless the same archive is installed there already. It
+// returns the directory of the toolchain and the sha256 of its archive.
+func (m *Manager) InstallToolchain(name string, release config.ToolchainRelease) (string, string, error) {
+	url, expected := toolchainSource(release)
+	if url == "" {
+		return "", "", fmt.Errorf("toolchain %s has no archive for %s-%s", name, platform.GetPlatformInfo().Name, runtime.GOARCH)
+	}
+	if expected == "" {
+		return "", "", fmt.Errorf("no sha256 configured for toolchain %s", name)
+	}
+
+	toolchainsDir, err := ToolchainsDir()
+	if err != nil {
+		return "", "", err
+	}
+	dir := filepath.Join(toolchainsDir, name)
+	stampPath := dir + ".stamp"
+	stamp := url + "\n" + strings.ToLower(strings.TrimPrefix(expected, "sha256:")) + "\n"
+
+	if data, err := os.ReadFile(stampPath); err == nil && string(data) == stamp {
+		if _, err := os.Stat(filepath.Join(dir, "bin")); err == nil {
+			return dir, expected, nil
+		}
+	}
+
+	m.logger.Info("downloading toolchain %s", name)
+	archive := filepath.Join(toolchainsDir, archiveName(url))
+	token, err := m.token(url)
+	if err != nil {
+		return "", "", err
+	}
+
+	actual, err := download(url, archive, token)
+	if err != nil {
+		return "", "", err
+	}
+	defer func() {
+		_ = os.Remove(archive)
+	}()
+
+	if err := verifyChecksum(url, expected, actual); err != nil {
+		return "", "", err
+	}
+
+	// without the stamp, an interrupted extraction is redone next time
+	_ = os.Remove(stampPath)
+	if err := extractArchive(archive, dir, m.CopyLinks); err != nil {
+		return "", "", fmt.Errorf("failed to extract %s: %w", filepath.Base(archive), err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bin")); err != nil {
+		return "", "", errors.New("toolchain archive has no bin directory")
+	}
+
+	if err := platform.WriteFileAtomic(stampPath, []byte(stamp), 0644); err != nil {
+		return "", "", fmt.Errorf("failed to write toolchain stamp: %w", err)
+	}
+
+	return dir, expected, nil
+}
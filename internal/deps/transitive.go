@@ -0,0 +1,256 @@
+package deps
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/deviceix/styx/internal/config"
+)
+
+// Requirement records a chain of dependencies leading to a requested package
+type Requirement struct {
+	Chain      []string
+	Dependency config.DependencyConfig
+}
+
+// ConflictError reports a package that is required in incompatible versions
+type ConflictError struct {
+	Name         string
+	Requirements []Requirement
+}
+
+// Error describes every requirement chain and how to override them
+func (e *ConflictError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "conflicting requirements for %s:", e.Name)
+	for _, req := range e.Requirements {
+		fmt.Fprintf(&sb, "\n  %s requires %s", strings.Join(req.Chain[:len(req.Chain)-1], " -> "), describeSource(req.Dependency))
+	}
+	fmt.Fprintf(&sb, "\nadd [dependencies.%s] to styx.toml to choose one, e.g.:", e.Name)
+	for _, suggestion := range overrideSuggestions(e.Requirements) {
+		fmt.Fprintf(&sb, "\n  %s", suggestion)
+	}
+	return sb.String()
+}
+
+// manifest is the part of a dependency's own styx.toml that matters for
+// resolution
+type manifest struct {
+	Dependencies map[string]config.DependencyConfig `toml:"dependencies"`
+}
+
+// collectRequirements walks the manifests of the configured dependencies and
+// returns every required package in resolution order, failing if two of them
+// ask for the same package from different sources. Dependencies declared in
+// the project itself always win, which is how conflicts are overridden.
+func (m *Manager) collectRequirements() ([]string, map[string]config.DependencyConfig, error) {
+	names := make([]string, 0, len(m.Dependencies))
+	for name := range m.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var queue []Requirement
+	for _, name := range names {
+		queue = append(queue, Requirement{Chain: []string{name}, Dependency: m.Dependencies[name]})
+	}
+
+	var order []string
+	requirements := make(map[string][]Requirement)
+	for len(queue) > 0 {
+		req := queue[0]
+		queue = queue[1:]
+
+		name := req.Chain[len(req.Chain)-1]
+		requirements[name] = append(requirements[name], req)
+		if len(requirements[name]) > 1 {
+			continue
+		}
+		order = append(order, name)
+
+		dir, err := m.sourceDir(name, req.Dependency)
+		if err != nil {
+			return nil, nil, fmt.Errorf("dependency %s: %w", name, err)
+		}
+
+		children, err := readManifest(dir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("dependency %s: %w", name, err)
+		}
+
+		childNames := make([]string, 0, len(children))
+		for child := range children {
+			childNames = append(childNames, child)
+		}
+		sort.Strings(childNames)
+
+		for _, child := range childNames {
+			chain := append(append([]string{}, req.Chain...), child)
+			queue = append(queue, Requirement{Chain: chain, Dependency: rebase(children[child], dir)})
+		}
+	}
+
+	resolved := make(map[string]config.DependencyConfig, len(order))
+	for _, name := range order {
+		reqs := requirements[name]
+		resolved[name] = reqs[0].Dependency
+		if _, declared := m.Dependencies[name]; declared {
+			continue
+		}
+
+		for _, req := range reqs[1:] {
+			if sourceKey(req.Dependency) != sourceKey(reqs[0].Dependency) {
+				return nil, nil, &ConflictError{Name: name, Requirements: reqs}
+			}
+		}
+	}
+
+	return order, resolved, nil
+}
+
+// sourceDir returns the local source tree of a dependency, fetching git
+// dependencies and source archives; prebuilt archives have no manifest
+func (m *Manager) sourceDir(name string, dep config.DependencyConfig) (string, error) {
+	url, _ := archiveSource(dep)
+	if dep.Git == "" && (dep.Local != "" || url == "" || !buildsFromSource(dep)) {
+		return dep.Local, nil
+	}
+
+	if err := os.MkdirAll(m.packageDir(name), 0755); err != nil {
+		return "", fmt.Errorf("failed to create dependency directory: %w", err)
+	}
+
+	if dep.Git != "" {
+		return m.fetchGit(name, dep)
+	}
+	return m.fetchArchive(name, dep)
+}
+
+// readManifest returns the dependencies declared by the styx.toml in dir
+func readManifest(dir string) (map[string]config.DependencyConfig, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	path := filepath.Join(dir, "styx.toml")
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil
+	}
+
+	var parsed manifest
+	if _, err := toml.DecodeFile(path, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return parsed.Dependencies, nil
+}
+
+// rebase makes the paths of a dependency declared in dir relative to the
+// project root instead
+func rebase(dep config.DependencyConfig, dir string) config.DependencyConfig {
+	join := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+
+	dep.Local = join(dep.Local)
+	outputs := make([]string, len(dep.Outputs))
+	for i, output := range dep.Outputs {
+		outputs[i] = join(output)
+	}
+	dep.Outputs = outputs
+
+	includeDirs := make([]string, len(dep.IncludeDirs))
+	for i, includeDir := range dep.IncludeDirs {
+		includeDirs[i] = join(includeDir)
+	}
+	dep.IncludeDirs = includeDirs
+
+	patches := make([]string, len(dep.Patches))
+	for i, patch := range dep.Patches {
+		patches[i] = join(patch)
+	}
+	dep.Patches = patches
+
+	if dep.BuildCmd != "" {
+		dep.BuildCmd = fmt.Sprintf("cd %q && %s", dir, dep.BuildCmd)
+	}
+
+	return dep
+}
+
+// sourceKey identifies where a dependency is taken from, so two requirements
+// with equal keys can share a single resolution
+func sourceKey(dep config.DependencyConfig) string {
+	url, _ := archiveSource(dep)
+	local := dep.Local
+	if abs, err := filepath.Abs(local); local != "" && err == nil {
+		local = abs
+	}
+	return strings.Join([]string{dep.Version, dep.Git, GitRef(dep), url, local}, "\x00")
+}
+
+// describeSource renders the source of a dependency for error messages
+func describeSource(dep config.DependencyConfig) string {
+	var parts []string
+	if dep.Version != "" {
+		parts = append(parts, "version "+dep.Version)
+	}
+
+	url, _ := archiveSource(dep)
+	switch {
+	case dep.Git != "":
+		parts = append(parts, fmt.Sprintf("%s at %s", dep.Git, strings.TrimPrefix(strings.TrimPrefix(GitRef(dep), "refs/tags/"), "refs/heads/")))
+	case url != "":
+		parts = append(parts, url)
+	case dep.Local != "":
+		parts = append(parts, dep.Local)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// overrideSuggestions returns one override line per distinct requested source
+func overrideSuggestions(reqs []Requirement) []string {
+	var suggestions []string
+	seen := make(map[string]bool)
+	for _, req := range reqs {
+		dep := req.Dependency
+		var fields []string
+		if dep.Version != "" {
+			fields = append(fields, fmt.Sprintf("version = %q", dep.Version))
+		}
+
+		url, _ := archiveSource(dep)
+		switch {
+		case dep.Git != "":
+			fields = append(fields, fmt.Sprintf("git = %q", dep.Git))
+			switch {
+			case dep.Rev != "":
+				fields = append(fields, fmt.Sprintf("rev = %q", dep.Rev))
+			case dep.Tag != "":
+				fields = append(fields, fmt.Sprintf("tag = %q", dep.Tag))
+			case dep.Branch != "":
+				fields = append(fields, fmt.Sprintf("branch = %q", dep.Branch))
+			}
+		case url != "":
+			fields = append(fields, fmt.Sprintf("url = %q", url))
+		case dep.Local != "":
+			fields = append(fields, fmt.Sprintf("local = %q", dep.Local))
+		}
+
+		suggestion := strings.Join(fields, ", ")
+		if suggestion != "" && !seen[suggestion] {
+			seen[suggestion] = true
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+	return suggestions
+}
@@ -0,0 +1,160 @@
+package deps
+
+import (
+	"fmt"
+	"sort"
+)
+
+// OutdatedPackage describes a dependency with a newer upstream revision
+type OutdatedPackage struct {
+	Name    string
+	Current string
+	Latest  string
+}
+
+// Change describes how an update moved a locked dependency
+type Change struct {
+	Name string
+	From string
+	To   string
+}
+
+// Outdated checks every git dependency for newer release tags or, for
+// dependencies tracking a branch, for commits newer than the locked one
+func (m *Manager) Outdated() ([]OutdatedPackage, error) {
+	if err := m.loadLock(); err != nil {
+		return nil, err
+	}
+
+	var outdated []OutdatedPackage
+	for _, name := range m.gitDependencies() {
+		dep := m.Dependencies[name]
+		switch {
+		case dep.Rev != "":
+			// pinned to an exact commit; nothing to compare against
+			continue
+		case dep.Tag != "":
+			latest, err := m.latestReleaseTag(dep.Git)
+			if err != nil {
+				return nil, fmt.Errorf("dependency %s: %w", name, err)
+			}
+			if latest != "" && CompareVersions(latest, dep.Tag) > 0 {
+				outdated = append(outdated, OutdatedPackage{Name: name, Current: dep.Tag, Latest: latest})
+			}
+		default:
+			latest, err := m.resolveGitRef(dep.Git, GitRef(dep))
+			if err != nil {
+				return nil, fmt.Errorf("dependency %s: %w", name, err)
+			}
+			locked := m.Lock.Packages[name].Commit
+			if latest != locked {
+				outdated = append(outdated, OutdatedPackage{Name: name, Current: shortCommit(locked), Latest: shortCommit(latest)})
+			}
+		}
+	}
+
+	return outdated, nil
+}
+
+// Update moves the named git dependencies (all of them if names is empty) to
+// the latest commit of the ref they track, re-resolves them and saves the lock.
+// Dependencies pinned to a tag are not moved: naming one is an error, and
+// when updating all of them a newer release is only reported.
+func (m *Manager) Update(names []string) ([]Change, error) {
+	if err := m.loadLock(); err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		for _, name := range m.gitDependencies() {
+			if dep := m.Dependencies[name]; dep.Tag != "" {
+				// a tag names a single commit; only editing it moves the dependency
+				if newest, err := m.latestReleaseTag(dep.Git); err == nil && CompareVersions(newest, dep.Tag) > 0 {
+					m.logger.Warning("%s is pinned to tag %s but %s is available; update its tag to upgrade", name, dep.Tag, newest)
+				}
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+
+	var changes []Change
+	for _, name := range names {
+		dep, ok := m.Dependencies[name]
+		if !ok {
+			return nil, fmt.Errorf("dependency not found: %s", name)
+		}
+		if dep.Git == "" {
+			return nil, fmt.Errorf("dependency %s is not a git dependency", name)
+		}
+		if dep.Tag != "" {
+			return nil, fmt.Errorf("dependency %s is pinned to tag %s; change its tag in the configuration to update it", name, dep.Tag)
+		}
+
+		ref := GitRef(dep)
+		latest, err := m.resolveGitRef(dep.Git, ref)
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s: %w", name, err)
+		}
+
+		locked := m.Lock.Packages[name]
+		if locked.Commit != latest || locked.Git != dep.Git || locked.Ref != ref {
+			changes = append(changes, Change{Name: name, From: shortCommit(locked.Commit), To: shortCommit(latest)})
+			m.Lock.Packages[name] = LockedPackage{Git: dep.Git, Ref: ref, Commit: latest, Patches: locked.Patches}
+			m.lockChanged = true
+		}
+
+		if _, err := m.Resolve(name, dep); err != nil {
+			return nil, fmt.Errorf("dependency %s: %w", name, err)
+		}
+	}
+
+	if err := m.saveLock(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// gitDependencies returns the names of all git dependencies in sorted order
+func (m *Manager) gitDependencies() []string {
+	var names []string
+	for name, dep := range m.Dependencies {
+		if dep.Git != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// latestReleaseTag returns the highest release-looking tag of a remote
+func (m *Manager) latestReleaseTag(url string) (string, error) {
+	tags, err := m.listGitTags(url)
+	if err != nil {
+		return "", err
+	}
+
+	var latest string
+	for _, tag := range tags {
+		if !isReleaseTag(tag) {
+			continue
+		}
+		if latest == "" || CompareVersions(tag, latest) > 0 {
+			latest = tag
+		}
+	}
+
+	return latest, nil
+}
+
+// shortCommit abbreviates a commit id for display
+func shortCommit(commit string) string {
+	if commit == "" {
+		return "(unlocked)"
+	}
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
@@ -0,0 +1,180 @@
+package deps
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// VendorManifest records where each vendored dependency came from
+type VendorManifest struct {
+	Packages map[string]VendoredPackage `toml:"packages"`
+}
+
+// VendoredPackage describes the origin of a vendored dependency
+type VendoredPackage struct {
+	Version string `toml:"version,omitempty"`
+	URL     string `toml:"url,omitempty"`
+	SHA256  string `toml:"sha256,omitempty"`
+	Git     string `toml:"git,omitempty"`
+	Commit  string `toml:"commit,omitempty"`
+}
+
+// IsRemote reports whether a dependency is fetched over the network
+func IsRemote(dep config.DependencyConfig) bool {
+	return dep.Local == "" && (dep.Git != "" || dep.URL != "" || len(dep.URLs) > 0)
+}
+
+// Vendor resolves every remote dependency and copies its sources into
+// VendorDir, returning the names of the vendored dependencies
+func (m *Manager) Vendor() ([]string, error) {
+	if err := m.loadLock(); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(m.Dependencies))
+	for name, dep := range m.Dependencies {
+		if IsRemote(dep) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	manifest := &VendorManifest{Packages: make(map[string]VendoredPackage)}
+	for _, name := range names {
+		dep := m.Dependencies[name]
+
+		// always resolve from the network so a stale vendor copy is replaced
+		var dir string
+		var err error
+		if dep.Git != "" {
+			dir, err = m.fetchGitFrom(name, dep, false)
+		} else {
+			dir, err = m.fetchArchiveFrom(name, dep, false)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s: %w", name, err)
+		}
+
+		target := filepath.Join(m.VendorDir, name)
+		if err := os.RemoveAll(target); err != nil {
+			return nil, fmt.Errorf("failed to clear %s: %w", target, err)
+		}
+		if err := copyDir(dir, target, m.CopyLinks); err != nil {
+			return nil, fmt.Errorf("failed to vendor %s: %w", name, err)
+		}
+
+		manifest.Packages[name] = m.vendorEntry(name, dep)
+		m.logger.Note("vendored %s into %s", name, target)
+	}
+
+	if err := writeVendorManifest(m.VendorDir, manifest); err != nil {
+		return nil, err
+	}
+
+	if err := m.saveLock(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// vendored returns the vendored copy of a dependency, if one exists and was
+// taken from the same source the configuration currently points at
+func (m *Manager) vendored(name string, dep config.DependencyConfig) (string, bool) {
+	manifest, err := readVendorManifest(m.VendorDir)
+	if err != nil {
+		return "", false
+	}
+
+	entry, ok := manifest.Packages[name]
+	if !ok {
+		return "", false
+	}
+
+	// a fresh checkout without a lockfile adopts the vendored revision
+	if _, locked := m.Lock.Packages[name]; dep.Git != "" && !locked && entry.Git == dep.Git {
+		m.Lock.Packages[name] = LockedPackage{Git: dep.Git, Ref: GitRef(dep), Commit: entry.Commit}
+		m.lockChanged = true
+	}
+
+	if entry != m.vendorEntry(name, dep) {
+		m.logger.Warning("vendored copy of %s is out of date; run 'styx vendor' to refresh it", name)
+		return "", false
+	}
+
+	dir := filepath.Join(m.VendorDir, name)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", false
+	}
+
+	return dir, true
+}
+
+// vendorEntry describes the source a dependency currently resolves from
+func (m *Manager) vendorEntry(name string, dep config.DependencyConfig) VendoredPackage {
+	if dep.Git != "" {
+		return VendoredPackage{
+			Version: dep.Version,
+			Git:     dep.Git,
+			Commit:  m.Lock.Packages[name].Commit,
+		}
+	}
+
+	url, sha := archiveSource(dep)
+	return VendoredPackage{
+		Version: dep.Version,
+		URL:     url,
+		SHA256:  sha,
+	}
+}
+
+// archiveSource returns the url and checksum used for the current platform
+func archiveSource(dep config.DependencyConfig) (string, string) {
+	platformName := platform.GetPlatformInfo().Name
+	url := dep.URLs[platformName]
+	if url == "" {
+		url = dep.URL
+	}
+
+	sha := dep.SHA256[platformName]
+	if sha == "" {
+		sha = dep.SHA256["default"]
+	}
+
+	return url, sha
+}
+
+// readVendorManifest loads the manifest from a vendor directory
+func readVendorManifest(dir string) (*VendorManifest, error) {
+	var manifest VendorManifest
+	if _, err := toml.DecodeFile(filepath.Join(dir, "vendor.toml"), &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// writeVendorManifest stores the manifest in a vendor directory
+func writeVendorManifest(dir string, manifest *VendorManifest) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create vendor directory: %w", err)
+	}
+
+	file, err := os.Create(filepath.Join(dir, "vendor.toml"))
+	if err != nil {
+		return fmt.Errorf("failed to write vendor manifest: %w", err)
+	}
+
+	if err := toml.NewEncoder(file).Encode(manifest); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write vendor manifest: %w", err)
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,88 @@
+package deps
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions orders two version strings such as "v1.10.2" and "1.9",
+// comparing numeric components numerically and the rest lexically
+func CompareVersions(a, b string) int {
+	pa := versionParts(a)
+	pb := versionParts(b)
+
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y string
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		// a missing numeric component is zero, so 1.0 equals 1.0.0
+		if x == "" && isNumeric(y) {
+			x = "0"
+		}
+		if y == "" && isNumeric(x) {
+			y = "0"
+		}
+
+		nx, errX := strconv.Atoi(x)
+		ny, errY := strconv.Atoi(y)
+		switch {
+		case errX == nil && errY == nil:
+			if nx != ny {
+				if nx < ny {
+					return -1
+				}
+				return 1
+			}
+		case x != y:
+			// a release sorts after its pre-releases (1.0 > 1.0-rc1)
+			if x == "" {
+				return 1
+			}
+			if y == "" {
+				return -1
+			}
+			// and so after pre-releases of an earlier component (1.0.0 > 1.0-rc1)
+			if errX == nil {
+				return 1
+			}
+			if errY == nil {
+				return -1
+			}
+			return strings.Compare(x, y)
+		}
+	}
+
+	return 0
+}
+
+// isNumeric reports whether a version component is a number
+func isNumeric(part string) bool {
+	_, err := strconv.Atoi(part)
+	return err == nil
+}
+
+// versionParts splits a version string into its dot/dash separated components
+func versionParts(version string) []string {
+	version = strings.TrimPrefix(strings.TrimPrefix(version, "v"), "V")
+	return strings.FieldsFunc(version, func(r rune) bool {
+		return r == '.' || r == '-' || r == '_' || r == '+'
+	})
+}
+
+// isReleaseTag reports whether a tag looks like a plain release version
+func isReleaseTag(tag string) bool {
+	parts := versionParts(tag)
+	if len(parts) == 0 {
+		return false
+	}
+	for _, part := range parts {
+		if _, err := strconv.Atoi(part); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// debArchitectures are the names Debian gives to the architectures of Go
+var debArchitectures = map[string]string{
+	"amd64": "amd64",
+	"arm64": "arm64",
+}
+
+// debFile is a file installed by a Debian package
+type debFile struct {
+	path string // absolute, as installed
+	data []byte
+	mode int64
+}
+
+// writeDeb writes the Debian package of the Linux binary built for p,
+// installing it as /usr/bin/styx. It can be installed with apt install
+// ./styx_<version>_<arch>.deb or added to an apt repository.
+func writeDeb(dir string, release *Release, p platform, binary, maintainer string) error {
+	arch, ok := debArchitectures[p.GOARCH]
+	if !ok {
+		return nil
+	}
+	name := fmt.Sprintf("styx_%s_%s.deb", release.Version, arch)
+
+	data, err := os.ReadFile(binary)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", binary, err)
+	}
+	license, err := os.ReadFile("LICENSE.txt")
+	if err != nil {
+		return fmt.Errorf("failed to read license: %w", err)
+	}
+	files := []debFile{
+		{"/usr/bin/styx", data, 0755},
+		{"/usr/share/doc/styx/copyright", license, 0644},
+	}
+
+	installedSize := 0
+	for _, file := range files {
+		installedSize += len(file.data)
+	}
+	control := fmt.Sprintf(`Package: styx
+Version: %s
+Architecture: %s
+Maintainer: %s
+Installed-Size: %d
+Section: devel
+Priority: optional
+Homepage: %s
+Description: %s
+ Styx builds C and C++ projects from a TOML configuration, with
+ incremental builds and parallel compilation.
+`, release.Version, arch, maintainer, (installedSize+1023)/1024, release.Homepage, release.Description)
+
+	controlTar, err := debTar([]debFile{{"/control", []byte(control), 0644}})
+	if err != nil {
+		return fmt.Errorf("failed to pack %s: %w", name, err)
+	}
+	dataTar, err := debTar(files)
+	if err != nil {
+		return fmt.Errorf("failed to pack %s: %w", name, err)
+	}
+
+	// a Debian package is an ar archive of these three members, in order
+	var deb bytes.Buffer
+	deb.WriteString("!<arch>\n")
+	now := time.Now().Unix()
+	for _, member := range []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", controlTar},
+		{"data.tar.gz", dataTar},
+	} {
+		fmt.Fprintf(&deb, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", member.name, now, 0, 0, "100644", len(member.data))
+		deb.Write(member.data)
+		if len(member.data)%2 == 1 {
+			deb.WriteByte('\n')
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, name), deb.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// debTar returns a gzip compressed tarball of files, with the directories
+// holding them, rooted at ./ as dpkg expects
+func debTar(files []debFile) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	dirs := map[string]bool{".": true}
+	writeDir := func(dir string) error {
+		return tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "./" + dir + "/", Mode: 0755, ModTime: now})
+	}
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755, ModTime: now}); err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		rel := strings.TrimPrefix(file.path, "/")
+		var parents []string
+		for dir := path.Dir(rel); !dirs[dir]; dir = path.Dir(dir) {
+			parents = append([]string{dir}, parents...)
+			dirs[dir] = true
+		}
+		for _, dir := range parents {
+			if err := writeDir(dir); err != nil {
+				return nil, err
+			}
+		}
+
+		header := &tar.Header{
+			Name:    "./" + rel,
+			Mode:    file.mode,
+			Size:    int64(len(file.data)),
+			ModTime: now,
+			Uname:   "root",
+			Gname:   "root",
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,329 @@
+// Command dist builds the release artifacts of styx for every supported
+// platform, along with the package manager metadata pointing at them: a
+// Homebrew formula, a Scoop manifest, Debian packages and an RPM spec. It
+// runs from the root of the repository, usually through make dist:
+//
+//	go run ./internal/dist -version 0.2.0
+//
+// Everything is written to dist/<version>, with the sha256 of every file in
+// SHA256SUMS. The formula, the manifest and the spec download the archives
+// from the GitHub release of the version unless -url is given.
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	homepage    = "https://github.com/deviceix/styx"
+	description = "Lightweight build system for C and C++ projects"
+)
+
+// platform is an operating system and architecture styx is released for
+type platform struct {
+	GOOS   string
+	GOARCH string
+}
+
+// platforms are the platforms styx is released for
+var platforms = []platform{
+	{"linux", "amd64"},
+	{"linux", "arm64"},
+	{"darwin", "amd64"},
+	{"darwin", "arm64"},
+	{"windows", "amd64"},
+}
+
+// docs are the files shipped next to the binary in every archive
+var docs = []string{"LICENSE.txt", "README.md"}
+
+// versionPattern matches the versions that can be released
+var versionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+(?:-[0-9A-Za-z.]+)?$`)
+
+// Archive is the release archive of styx for one platform
+type Archive struct {
+	Platform platform
+	Name     string
+	Dir      string // the directory the archive unpacks to
+	URL      string
+	SHA256   string
+}
+
+// Release is a version of styx and its archives
+type Release struct {
+	Version     string
+	BaseURL     string
+	Homepage    string
+	Description string
+	Archives    []*Archive
+}
+
+// Archive returns the archive of release for goos and goarch
+func (r *Release) Archive(goos, goarch string) *Archive {
+	for _, archive := range r.Archives {
+		if archive.Platform.GOOS == goos && archive.Platform.GOARCH == goarch {
+			return archive
+		}
+	}
+	return nil
+}
+
+func main() {
+	version := flag.String("version", "", "version to release, without the leading v")
+	out := flag.String("out", "dist", "directory the release is written to")
+	baseURL := flag.String("url", "", "URL the archives are downloaded from (default: the GitHub release of the version)")
+	maintainer := flag.String("maintainer", "IX Authors", "maintainer of the Debian packages")
+	flag.Parse()
+
+	if err := run(*version, *out, *baseURL, *maintainer); err != nil {
+		fmt.Fprintf(os.Stderr, "dist: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run builds the release of version into out
+func run(version, out, baseURL, maintainer string) error {
+	version = strings.TrimPrefix(version, "v")
+	if !versionPattern.MatchString(version) {
+		return fmt.Errorf("invalid version %q (expected x.y.z)", version)
+	}
+	if baseURL == "" {
+		baseURL = homepage + "/releases/download/v" + version
+	}
+
+	dir := filepath.Join(out, version)
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", dir, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	work, err := os.MkdirTemp("", "styx-dist-")
+	if err != nil {
+		return fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(work)
+	}()
+
+	release := &Release{
+		Version:     version,
+		BaseURL:     strings.TrimSuffix(baseURL, "/"),
+		Homepage:    homepage,
+		Description: description,
+	}
+	for _, p := range platforms {
+		fmt.Printf("building %s/%s\n", p.GOOS, p.GOARCH)
+		binary, err := build(p, version, filepath.Join(work, p.GOOS+"-"+p.GOARCH))
+		if err != nil {
+			return err
+		}
+
+		archive, err := writeArchive(dir, release, p, binary)
+		if err != nil {
+			return err
+		}
+		release.Archives = append(release.Archives, archive)
+
+		if p.GOOS == "linux" {
+			if err := writeDeb(dir, release, p, binary, maintainer); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := writeManifests(dir, release); err != nil {
+		return err
+	}
+	if err := writeChecksums(dir); err != nil {
+		return err
+	}
+	fmt.Printf("release %s written to %s\n", version, dir)
+	return nil
+}
+
+// build cross compiles styx for p into dir and returns the path of the
+// binary
+func build(p platform, version, dir string) (string, error) {
+	name := "styx"
+	if p.GOOS == "windows" {
+		name += ".exe"
+	}
+	binary := filepath.Join(dir, name)
+
+	cmd := exec.Command("go", "build", "-trimpath",
+		"-ldflags", "-s -w -X main.version="+version,
+		"-o", binary, "./cmd/styx")
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS="+p.GOOS, "GOARCH="+p.GOARCH)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to build for %s/%s: %w: %s", p.GOOS, p.GOARCH, err, strings.TrimSpace(string(output)))
+	}
+	return binary, nil
+}
+
+// writeArchive packs binary and the docs into the archive of p: a zip on
+// Windows and a gzip compressed tarball elsewhere
+func writeArchive(dir string, release *Release, p platform, binary string) (*Archive, error) {
+	archive := &Archive{
+		Platform: p,
+		Dir:      fmt.Sprintf("styx-%s-%s-%s", release.Version, p.GOOS, p.GOARCH),
+	}
+
+	files := append([]string{binary}, docs...)
+	var data []byte
+	var err error
+	if p.GOOS == "windows" {
+		archive.Name = archive.Dir + ".zip"
+		data, err = zipFiles(archive.Dir, files)
+	} else {
+		archive.Name = archive.Dir + ".tar.gz"
+		data, err = tarFiles(archive.Dir, files)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack %s: %w", archive.Name, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, archive.Name), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", archive.Name, err)
+	}
+	archive.URL = release.BaseURL + "/" + archive.Name
+	archive.SHA256 = sha256Hex(data)
+	return archive, nil
+}
+
+// tarFiles returns a gzip compressed tarball holding files under prefix
+func tarFiles(prefix string, files []string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		data, mode, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		header := &tar.Header{
+			Name:    prefix + "/" + filepath.Base(file),
+			Mode:    int64(mode),
+			Size:    int64(len(data)),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// zipFiles returns a zip archive holding files under prefix
+func zipFiles(prefix string, files []string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range files {
+		data, mode, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		header := &zip.FileHeader{
+			Name:     prefix + "/" + filepath.Base(file),
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		}
+		header.SetMode(mode)
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readFile returns the content of file and the mode it is packed with:
+// executable for the binary, read only for the docs
+func readFile(file string) ([]byte, os.FileMode, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	if info.Mode()&0111 != 0 {
+		return data, 0755, nil
+	}
+	return data, 0644, nil
+}
+
+// writeChecksums writes the sha256 of every file of dir to SHA256SUMS, in
+// the format of sha256sum
+func writeChecksums(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && entry.Name() != "SHA256SUMS" {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var sums strings.Builder
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", hex.EncodeToString(h.Sum(nil)), name)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "SHA256SUMS"), []byte(sums.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write checksums: %w", err)
+	}
+	return nil
+}
+
+// sha256Hex returns the sha256 of data in hexadecimal
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
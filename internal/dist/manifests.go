@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// formula is the Homebrew formula of a release, installing the prebuilt
+// binary of the platform
+var formula = template.Must(template.New("styx.rb").Parse(`class Styx < Formula
+  desc "{{.Description}}"
+  homepage "{{.Homepage}}"
+  version "{{.Version}}"
+  license "MIT"
+{{range $os := .Systems}}
+  on_{{$os.Name}} do
+{{- range $os.Archives}}
+    on_{{.Arch}} do
+      url "{{.Archive.URL}}"
+      sha256 "{{.Archive.SHA256}}"
+    end
+{{- end}}
+  end
+{{end}}
+  def install
+    bin.install "styx"
+  end
+
+  test do
+    assert_match version.to_s, shell_output("#{bin}/styx --version")
+  end
+end
+`))
+
+// spec is the RPM spec of a release, repackaging the Linux archive of the
+// architecture it is built on
+var spec = template.Must(template.New("styx.spec").Parse(`%global debug_package %{nil}
+%ifarch x86_64
+%global goarch amd64
+%endif
+%ifarch aarch64
+%global goarch arm64
+%endif
+
+Name:           styx
+Version:        {{.Version}}
+Release:        1%{?dist}
+Summary:        {{.Description}}
+License:        MIT
+URL:            {{.Homepage}}
+Source0:        {{.BaseURL}}/styx-%{version}-linux-%{goarch}.tar.gz
+ExclusiveArch:  x86_64 aarch64
+
+%description
+Styx is a lightweight build system for C and C++ projects with TOML
+configuration, incremental builds and parallel compilation.
+
+%prep
+%setup -q -n styx-%{version}-linux-%{goarch}
+
+%install
+install -Dm755 styx %{buildroot}%{_bindir}/styx
+
+%files
+%license LICENSE.txt
+%doc README.md
+%{_bindir}/styx
+`))
+
+// formulaSystem is an operating system of the formula with its archives,
+// by the name Homebrew gives to their architecture
+type formulaSystem struct {
+	Name     string
+	Archives []formulaArchive
+}
+
+type formulaArchive struct {
+	Arch    string
+	Archive *Archive
+}
+
+// homebrewNames are the names of the operating systems and architectures
+// in formulas
+var homebrewNames = map[string]string{
+	"darwin": "macos",
+	"linux":  "linux",
+	"arm64":  "arm",
+	"amd64":  "intel",
+}
+
+// scoopManifest is the Scoop manifest of a release; Scoop only runs on
+// Windows
+type scoopManifest struct {
+	Version      string                       `json:"version"`
+	Description  string                       `json:"description"`
+	Homepage     string                       `json:"homepage"`
+	License      string                       `json:"license"`
+	Architecture map[string]scoopArchitecture `json:"architecture"`
+	ExtractDir   string                       `json:"extract_dir"`
+	Bin          string                       `json:"bin"`
+	Checkver     string                       `json:"checkver"`
+	Autoupdate   scoopAutoupdate              `json:"autoupdate"`
+}
+
+type scoopArchitecture struct {
+	URL  string `json:"url"`
+	Hash string `json:"hash,omitempty"`
+}
+
+type scoopAutoupdate struct {
+	Architecture map[string]scoopArchitecture `json:"architecture"`
+	ExtractDir   string                       `json:"extract_dir"`
+}
+
+// writeManifests writes the Homebrew formula, the Scoop manifest and the
+// RPM spec of release to dir
+func writeManifests(dir string, release *Release) error {
+	var systems []formulaSystem
+	for _, goos := range []string{"darwin", "linux"} {
+		system := formulaSystem{Name: homebrewNames[goos]}
+		for _, goarch := range []string{"arm64", "amd64"} {
+			if archive := release.Archive(goos, goarch); archive != nil {
+				system.Archives = append(system.Archives, formulaArchive{homebrewNames[goarch], archive})
+			}
+		}
+		systems = append(systems, system)
+	}
+	if err := writeTemplate(filepath.Join(dir, "styx.rb"), formula, struct {
+		*Release
+		Systems []formulaSystem
+	}{release, systems}); err != nil {
+		return err
+	}
+
+	if err := writeTemplate(filepath.Join(dir, "styx.spec"), spec, release); err != nil {
+		return err
+	}
+
+	windows := release.Archive("windows", "amd64")
+	if windows == nil {
+		return nil
+	}
+	// the autoupdate URL is the archive URL with the version as a variable
+	pattern := strings.ReplaceAll(windows.URL, release.Version, "$version")
+	manifest := scoopManifest{
+		Version:     release.Version,
+		Description: release.Description,
+		Homepage:    release.Homepage,
+		License:     "MIT",
+		Architecture: map[string]scoopArchitecture{
+			"64bit": {URL: windows.URL, Hash: windows.SHA256},
+		},
+		ExtractDir: windows.Dir,
+		Bin:        "styx.exe",
+		Checkver:   "github",
+		Autoupdate: scoopAutoupdate{
+			Architecture: map[string]scoopArchitecture{"64bit": {URL: pattern}},
+			ExtractDir:   strings.ReplaceAll(windows.Dir, release.Version, "$version"),
+		},
+	}
+	data, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode scoop manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "styx.json"), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write scoop manifest: %w", err)
+	}
+	return nil
+}
+
+// writeTemplate executes tmpl with data into the file at path
+func writeTemplate(path string, tmpl *template.Template, data interface{}) error {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return fmt.Errorf("failed to generate %s: %w", filepath.Base(path), err)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+// Command fakecc is a stand-in for gcc and g++ that lets the fixture
+// projects under testdata/fixtures be built without a real toolchain. It is
+// installed as both gcc and g++ in a directory put first in PATH.
+//
+// Every invocation is appended to the file named by FAKECC_LOG as one line
+// holding the name it was run as and its arguments. Compiling writes an
+// object recording the source and the flags, and fails with a GCC style
+// diagnostic on the first #error line of the source; with -E and no -o, what
+// it would write goes to stdout instead, and a source named - is read from
+// stdin. Several sources without -o are compiled one by one. Linking checks
+// that every input exists and writes a shell script that exits with 0, so the
+// outputs can be run by styx run and styx test.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// valueFlags are the flags taking the next argument as their value
+var valueFlags = map[string]bool{
+	"-o": true, "-MF": true, "-MT": true, "-MQ": true, "-x": true,
+	"-include": true, "-isystem": true, "-I": true, "-L": true, "-T": true,
+}
+
+// sourceExtensions are the extensions of the files compiled rather than
+// linked
+var sourceExtensions = map[string]bool{
+	".c": true, ".cc": true, ".cpp": true, ".cxx": true, ".c++": true, ".m": true, ".mm": true, ".cu": true,
+	".s": true, ".S": true, ".h": true, ".hh": true, ".hpp": true, ".hxx": true,
+}
+
+// invocation is a parsed command line
+type invocation struct {
+	compile    bool
+	preprocess bool
+	shared     bool
+	output     string
+	depfile    string
+	linkMap    string
+	inputs     []string
+}
+
+func main() {
+	name := filepath.Base(os.Args[0])
+	args := os.Args[1:]
+	record(name, args)
+
+	for _, arg := range args {
+		switch arg {
+		case "--version":
+			fmt.Printf("%s (fakecc) 13.2.0\nThis is a fake compiler for testing styx.\n", name)
+			return
+		case "-dumpmachine":
+			fmt.Println("x86_64-linux-gnu")
+			return
+		case "-fsyntax-only":
+			// flag and feature probes: everything is supported
+			return
+		}
+	}
+
+	inv := parse(args)
+	var err error
+	if inv.compile {
+		err = compile(inv, args)
+	} else {
+		err = link(inv)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// record appends the invocation to the log named by FAKECC_LOG
+func record(name string, args []string) {
+	path := os.Getenv("FAKECC_LOG")
+	if path == "" {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to open log: %v\n", name, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	fmt.Fprintln(f, strings.Join(append([]string{name}, args...), " "))
+}
+
+// parse finds the mode, the outputs and the inputs of a command line
+func parse(args []string) invocation {
+	var inv invocation
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if valueFlags[arg] && i+1 < len(args) {
+			switch arg {
+			case "-o":
+				inv.output = args[i+1]
+			case "-MF":
+				inv.depfile = args[i+1]
+			}
+			i++
+			continue
+		}
+
+		switch {
+		case arg == "-c", arg == "-S":
+			inv.compile = true
+		case arg == "-E":
+			inv.compile = true
+			inv.preprocess = true
+		case arg == "-":
+			inv.inputs = append(inv.inputs, arg)
+		case arg == "-shared":
+			inv.shared = true
+		case strings.HasPrefix(arg, "-Wl,-Map,"):
+			inv.linkMap = strings.TrimPrefix(arg, "-Wl,-Map,")
+		case strings.HasPrefix(arg, "-Wl,-Map="):
+			inv.linkMap = strings.TrimPrefix(arg, "-Wl,-Map=")
+		case strings.HasPrefix(arg, "-"):
+		default:
+			inv.inputs = append(inv.inputs, arg)
+		}
+	}
+
+	// precompiled headers are built without -c
+	for _, input := range inv.inputs {
+		if sourceExtensions[filepath.Ext(input)] {
+			inv.compile = true
+		}
+	}
+	return inv
+}
+
+// compile checks the sources for #error and writes the object
+func compile(inv invocation, args []string) error {
+	if len(inv.inputs) == 0 {
+		return fmt.Errorf("no input files")
+	}
+	if len(inv.inputs) > 1 && inv.output == "" && !inv.preprocess {
+		// like gcc, every source gets its own object in the current
+		// directory, and a failed one does not stop the others
+		var failed error
+		for _, source := range inv.inputs {
+			if err := compile(invocation{compile: true, inputs: []string{source}}, args); err != nil {
+				failed = err
+			}
+		}
+		return failed
+	}
+
+	var object strings.Builder
+	object.WriteString("fakecc object\n")
+	for _, source := range inv.inputs {
+		if source == "-" {
+			if _, err := io.Copy(io.Discard, os.Stdin); err != nil {
+				return err
+			}
+		} else if err := check(source); err != nil {
+			return err
+		}
+		fmt.Fprintf(&object, "source %s\n", source)
+	}
+	if inv.preprocess && inv.output == "" {
+		_, err := os.Stdout.WriteString(object.String())
+		return err
+	}
+	fmt.Fprintf(&object, "flags %s\n", strings.Join(args, " "))
+
+	output := inv.output
+	if output == "" {
+		output = strings.TrimSuffix(filepath.Base(inv.inputs[0]), filepath.Ext(inv.inputs[0])) + ".o"
+	}
+	if err := os.WriteFile(output, []byte(object.String()), 0644); err != nil {
+		return err
+	}
+	if inv.depfile != "" {
+		rule := fmt.Sprintf("%s: %s\n", output, strings.Join(inv.inputs, " "))
+		return os.WriteFile(inv.depfile, []byte(rule), 0644)
+	}
+	return nil
+}
+
+// check fails with a GCC style diagnostic at the first #error of a source
+func check(source string) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("%s: No such file or directory", source)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "#error") {
+			fmt.Fprintf(os.Stderr, "%s:%d:2: error: %s\n", source, line, text)
+			return fmt.Errorf("compilation terminated")
+		}
+	}
+	return scanner.Err()
+}
+
+// link checks that the inputs exist and writes the output, and an empty
+// link map when one is asked for
+func link(inv invocation) error {
+	if len(inv.inputs) == 0 {
+		return fmt.Errorf("no input files")
+	}
+	for _, input := range inv.inputs {
+		if _, err := os.Stat(input); err != nil {
+			return fmt.Errorf("cannot find %s: No such file or directory", input)
+		}
+	}
+
+	output := inv.output
+	if output == "" {
+		output = "a.out"
+	}
+	if inv.linkMap != "" {
+		if err := os.WriteFile(inv.linkMap, nil, 0644); err != nil {
+			return err
+		}
+	}
+	if inv.shared {
+		return os.WriteFile(output, []byte("fakecc shared library\n"), 0755)
+	}
+	return os.WriteFile(output, []byte("#!/bin/sh\n# fakecc executable\nexit 0\n"), 0755)
+}
@@ -0,0 +1,119 @@
+// Package fsmeta remembers the metadata and content hashes of files for the
+// length of one invocation, so headers included from many sources are
+// statted and hashed once rather than once per includer.
+package fsmeta
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// stat is the outcome of statting a file, missing files included
+type stat struct {
+	info os.FileInfo
+	err  error
+}
+
+// hash is the content hash of a file as it was when hashed
+type hash struct {
+	modTime int64
+	size    int64
+	sum     string
+}
+
+// FS is the memoized metadata of the files seen by an invocation. Files are
+// assumed not to change behind its back: those written during the build
+// have to be forgotten. A nil FS remembers nothing and asks the filesystem
+// every time.
+type FS struct {
+	mu     sync.Mutex
+	stats  map[string]stat
+	hashes map[string]hash
+}
+
+// New returns an empty FS
+func New() *FS {
+	return &FS{
+		stats:  make(map[string]stat),
+		hashes: make(map[string]hash),
+	}
+}
+
+// Stat returns the metadata of the file at path, statting it the first time
+// only; a file found missing stays missing until forgotten
+func (fs *FS) Stat(path string) (os.FileInfo, error) {
+	if fs == nil {
+		return os.Stat(path)
+	}
+
+	key := filepath.Clean(path)
+	fs.mu.Lock()
+	known, ok := fs.stats[key]
+	fs.mu.Unlock()
+	if ok {
+		return known.info, known.err
+	}
+
+	info, err := os.Stat(path)
+	fs.mu.Lock()
+	fs.stats[key] = stat{info: info, err: err}
+	fs.mu.Unlock()
+	return info, err
+}
+
+// Hash returns the hash of the file at path, whose metadata is info, from
+// compute the first time and as long as the file keeps the modification
+// time and size it was hashed with. Concurrent calls for different files
+// hash them in parallel.
+func (fs *FS) Hash(path string, info os.FileInfo, compute func(string) (string, error)) (string, error) {
+	if fs == nil {
+		return compute(path)
+	}
+
+	key := filepath.Clean(path)
+	modTime, size := info.ModTime().UnixNano(), info.Size()
+	fs.mu.Lock()
+	known, ok := fs.hashes[key]
+	fs.mu.Unlock()
+	if ok && known.modTime == modTime && known.size == size {
+		return known.sum, nil
+	}
+
+	sum, err := compute(path)
+	if err != nil {
+		return "", err
+	}
+	fs.mu.Lock()
+	fs.hashes[key] = hash{modTime: modTime, size: size, sum: sum}
+	fs.mu.Unlock()
+	return sum, nil
+}
+
+// Forget drops what is known of the files at paths, which were written or
+// removed since
+func (fs *FS) Forget(paths ...string) {
+	if fs == nil {
+		return
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, path := range paths {
+		delete(fs.stats, filepath.Clean(path))
+		delete(fs.hashes, filepath.Clean(path))
+	}
+}
+
+// Reset drops what is known of every file, for a new build in the same
+// invocation
+func (fs *FS) Reset() {
+	if fs == nil {
+		return
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.stats = make(map[string]stat)
+	fs.hashes = make(map[string]hash)
+}
@@ -0,0 +1,226 @@
+package i18n
+
+// spanish is the Spanish catalog. Translations keep the verbs of the
+// English format in order, or number them like %[2]s when the sentence
+// needs another one.
+var spanish = map[string]string{
+	// commands and flags
+	"Styx build system for C/C++ projects": "Sistema de compilación Styx para proyectos C/C++",
+	`Styx is a modern, lightweight build system for C and C++ projects.
+it provides simple configuration, fast incremental builds, and 
+supports specialized environments like OSDev and embedded systems.`: `Styx es un sistema de compilación moderno y ligero para proyectos C y C++.
+Ofrece una configuración sencilla, compilaciones incrementales rápidas y
+admite entornos especializados como el desarrollo de sistemas operativos y
+los sistemas embebidos.`,
+	"build the project":                                                                  "compila el proyecto",
+	"clean build artifacts":                                                              "elimina los productos de la compilación",
+	"build and run the project":                                                          "compila y ejecuta el proyecto",
+	"rebuild the project on every change":                                                "recompila el proyecto con cada cambio",
+	"build and run the tests":                                                            "compila y ejecuta las pruebas",
+	"initialize a new project":                                                           "inicializa un proyecto nuevo",
+	"show compiler information":                                                          "muestra información de los compiladores",
+	"generate compile_commands.json":                                                     "genera compile_commands.json",
+	"generate build files for another build tool":                                        "genera archivos de compilación para otra herramienta",
+	"check whether a snippet compiles":                                                   "comprueba si un fragmento de código compila",
+	"print the dependency graph":                                                         "imprime el grafo de dependencias",
+	"print the build plan as canonical JSON":                                             "imprime el plan de compilación como JSON canónico",
+	"copy remote dependencies into vendor/":                                              "copia las dependencias remotas en vendor/",
+	"build and install the project":                                                      "compila e instala el proyecto",
+	"remove the files of the last install":                                               "elimina los archivos de la última instalación",
+	"manage project dependencies":                                                        "gestiona las dependencias del proyecto",
+	"list dependencies with newer upstream revisions":                                    "lista las dependencias con revisiones más recientes",
+	"update locked dependencies":                                                         "actualiza las dependencias bloqueadas",
+	"add sources, include directories, libraries or targets to styx.toml":                "añade fuentes, directorios de cabeceras, bibliotecas u objetivos a styx.toml",
+	"add sources or source patterns to the build":                                        "añade fuentes o patrones de fuentes a la compilación",
+	"add include directories to the build":                                               "añade directorios de cabeceras a la compilación",
+	"link system libraries":                                                              "enlaza bibliotecas del sistema",
+	"add a target, or flags to one":                                                      "añade un objetivo, u opciones a uno",
+	"binary or library to add them to instead of [build]":                                "binario o biblioteca al que añadirlos en lugar de [build]",
+	"inspect the configuration":                                                          "inspecciona la configuración",
+	"print the configuration a build uses":                                               "imprime la configuración que usa una compilación",
+	"output format (toml or json)":                                                       "formato de salida (toml o json)",
+	"manage downloaded toolchains":                                                       "gestiona las cadenas de herramientas descargadas",
+	"download and install a toolchain":                                                   "descarga e instala una cadena de herramientas",
+	"check styx itself":                                                                  "comprueba el propio styx",
+	"collect a diagnostic bundle to attach to an issue":                                  "reúne un paquete de diagnóstico para adjuntar a una incidencia",
+	"summarize the last build, or render it as an HTML report or a badge":                "resume la última compilación, o la presenta como un informe HTML o una insignia",
+	"manage the opt-in usage telemetry":                                                  "gestiona la telemetría de uso opcional",
+	"inspect and trim the build cache":                                                   "inspecciona y recorta la caché de compilación",
+	"path to configuration file (default: styx.toml in current directory)":               "ruta del archivo de configuración (por defecto: styx.toml en el directorio actual)",
+	"enable verbose output":                                                              "activa la salida detallada",
+	"environment to build in (default: $STYX_ENV)":                                       "entorno en el que compilar (por defecto: $STYX_ENV)",
+	"log format: text, or json for one event per line on stderr":                         "formato de los mensajes: text, o json para un evento por línea en stderr",
+	"print plain, timestamped lines for CI logs (default: true when $CI is true)":        "imprime líneas simples con marca de tiempo para los registros de CI (por defecto: true cuando $CI es true)",
+	"do not start jobs while the load average is at or above this":                       "no inicia trabajos mientras la carga media sea igual o superior a este valor",
+	"build target (e.g., debug, release)":                                                "objetivo de compilación (p. ej., debug, release)",
+	"build target (e.g., debug, release), or several separated by commas":                "objetivo de compilación (p. ej., debug, release), o varios separados por comas",
+	"build every configured target":                                                      "compilar todos los objetivos configurados",
+	"output directory":                                                                   "directorio de salida",
+	"output layout (target, platform or flat)":                                           "disposición de la salida (target, platform o flat)",
+	"number of parallel jobs, 0 for one per CPU (default: [build].jobs, or one per CPU)": "número de trabajos en paralelo, 0 para uno por CPU (por defecto: [build].jobs, o uno por CPU)",
+	"print the steps of the build and why they run without running them":                 "imprime los pasos de la compilación y por qué se ejecutan, sin ejecutarlos",
+	"write a timing report of the build to .styx/reports":                                "escribe un informe de tiempos de la compilación en .styx/reports",
+	"write a Chrome trace of the commands of the build to this file":                     "escribe una traza de Chrome de los comandos de la compilación en este archivo",
+	"write an HTML report to this file (default build-report.html)":                      "escribe un informe HTML en este archivo (por defecto build-report.html)",
+	"write an SVG badge to this file (default build-badge.svg)":                          "escribe una insignia SVG en este archivo (por defecto build-badge.svg)",
+	"list what would be removed without removing it":                                     "lista lo que se eliminaría sin eliminarlo",
+	"binary to run when the project defines several":                                     "binario que ejecutar cuando el proyecto define varios",
+	"build and run this example of [[examples]] instead":                                 "compilar y ejecutar este ejemplo de [[examples]] en su lugar",
+	"project template":                                                                   "plantilla del proyecto",
+	"project name (default: the directory name)":                                         "nombre del proyecto (por defecto: el nombre del directorio)",
+	"ask for the project settings":                                                       "pregunta la configuración del proyecto",
+
+	// help headings of cobra
+	"Usage:":                  "Uso:",
+	"Aliases:":                "Alias:",
+	"Examples:":               "Ejemplos:",
+	"Available Commands:":     "Comandos disponibles:",
+	"Additional Commands:":    "Comandos adicionales:",
+	"Flags:":                  "Opciones:",
+	"Global Flags:":           "Opciones globales:",
+	"Additional help topics:": "Temas de ayuda adicionales:",
+	`Use "{{.CommandPath}} [command] --help" for more information about a command.`: `Use "{{.CommandPath}} [comando] --help" para más información sobre un comando.`,
+
+	// configuration
+	"loading project configuration...":                            "cargando la configuración del proyecto...",
+	"searching for configuration file...":                         "buscando el archivo de configuración...",
+	"using configuration file: %s":                                "usando el archivo de configuración: %s",
+	"found TOML configuration":                                    "configuración TOML encontrada",
+	"found script configuration":                                  "configuración de script encontrada",
+	"no TOML configuration found, trying script configuration...": "no se encontró configuración TOML, probando con la configuración de script...",
+	"failed to load configuration: %v":                            "no se pudo cargar la configuración: %v",
+	"failed to get current directory: %v":                         "no se pudo obtener el directorio actual: %v",
+	"workspace with %d projects":                                  "espacio de trabajo con %d proyectos",
+
+	// building
+	"creating builder...":                                      "creando el constructor...",
+	"failed to create builder: %v":                             "no se pudo crear el constructor: %v",
+	"setting target: %s":                                       "estableciendo el objetivo: %s",
+	"invalid target: %v":                                       "objetivo no válido: %v",
+	"--dry-run is not supported for workspaces":                "--dry-run no es compatible con los espacios de trabajo",
+	"starting build for target: %s":                            "iniciando la compilación del objetivo: %s",
+	"project: %s (version %s)":                                 "proyecto: %s (versión %s)",
+	"compiler: %s":                                             "compilador: %s",
+	"resolving %d dependencies...":                             "resolviendo %d dependencias...",
+	"dependencies resolved":                                    "dependencias resueltas",
+	"running feature checks...":                                "ejecutando las comprobaciones de características...",
+	"checking for %s... %s":                                    "comprobando %s... %s",
+	"feature checks complete: %d of %d passed":                 "comprobaciones de características completadas: %d de %d superadas",
+	"executing pre-build commands...":                          "ejecutando los comandos previos a la compilación...",
+	"pre-build commands completed":                             "comandos previos a la compilación completados",
+	"finding source files...":                                  "buscando los archivos fuente...",
+	"found %d source files":                                    "%d archivos fuente encontrados",
+	"no source files found. Check your sources configuration.": "no se encontraron archivos fuente. Revise la configuración de sources.",
+	"analyzing dependencies...":                                "analizando las dependencias...",
+	"dependency analysis complete":                             "análisis de dependencias completado",
+	"precompiling standard headers...":                         "precompilando las cabeceras estándar...",
+	"standard headers precompiled in %.2f seconds":             "cabeceras estándar precompiladas en %.2f segundos",
+	"compiling source files...":                                "compilando los archivos fuente...",
+	"building project libraries...":                            "compilando las bibliotecas del proyecto...",
+	"compiling project sources...":                             "compilando las fuentes del proyecto...",
+	"building %s (%d source files)":                            "compilando %s (%d archivos fuente)",
+	"generating %s from %s":                                    "generando %s a partir de %s",
+	"Compilation complete":                                     "Compilación completada",
+	"compilation failed with %d errors":                        "la compilación falló con %d errores",
+	"linking executable: %s":                                   "enlazando el ejecutable: %s",
+	"creating static library: %s":                              "creando la biblioteca estática: %s",
+	"static library created":                                   "biblioteca estática creada",
+	"creating shared library: %s":                              "creando la biblioteca compartida: %s",
+	"creating %s image: %s":                                    "creando la imagen %s: %s",
+	"output: %s":                                               "salida: %s",
+	"output: %s (%s)":                                          "salida: %s (%s)",
+	"Executing post-build commands...":                         "Ejecutando los comandos posteriores a la compilación...",
+	"post-build commands completed":                            "comandos posteriores a la compilación completados",
+	"conflicting flags: %s":                                    "opciones en conflicto: %s",
+	"clock skew detected: %s was modified %s in the future":    "desfase de reloj detectado: %s se modificó %s en el futuro",
+	"failed to save build cache: %v":                           "no se pudo guardar la caché de compilación: %v",
+	"build failed: %v":                                         "la compilación falló: %v",
+	"build completed in %.2f seconds":                          "compilación completada en %.2f segundos",
+	"built %d projects in %.2f seconds":                        "%d proyectos compilados en %.2f segundos",
+
+	"%s does not support %s, building without link-time optimization":                                "%s no admite %s, se compila sin optimización en el enlace",
+	"no archiver for link-time optimization found next to %s, static libraries are archived with ar": "no se encontró un archivador para la optimización en el enlace junto a %s, las bibliotecas estáticas se archivan con ar",
+
+	// progress
+	"running pre-build commands":  "ejecutando los comandos previos a la compilación",
+	"Running post-build commands": "Ejecutando los comandos posteriores a la compilación",
+	"scanning dependencies":       "analizando las dependencias",
+	"scanning %s":                 "analizando %s",
+	"compiling":                   "compilando",
+	"Compiled %s":                 "Compilado %s",
+	"Skipping %s (up to date)":    "Omitiendo %s (al día)",
+	"linking executable":          "enlazando el ejecutable",
+	"Creating shared library":     "Creando la biblioteca compartida",
+	"creating static library":     "creando la biblioteca estática",
+	"creating image":              "creando la imagen",
+	"running rule":                "ejecutando la regla",
+	"linking tests":               "enlazando las pruebas",
+	"linked %s":                   "enlazado %s",
+
+	// timing
+	"slowest translation units:":                      "unidades de traducción más lentas:",
+	"%8s  %s (up to date)":                            "%8s  %s (al día)",
+	"%s wall clock, %s CPU in %s (%.1fx parallelism)": "%s de tiempo real, %s de CPU en %s (paralelismo de %.1fx)",
+	"1 command":                   "1 comando",
+	"%d commands":                 "%d comandos",
+	"timing report written to %s": "informe de tiempos escrito en %s",
+	"trace written to %s":         "traza escrita en %s",
+
+	"critical path: %s of %s wall clock (%.0f%%)":                                                   "ruta crítica: %s de %s de tiempo real (%.0f%%)",
+	"the critical path bounds the build: more workers would not make it faster, splitting %s would": "la ruta crítica limita la compilación: más trabajadores no la acelerarían, dividir %s sí",
+	"the build took %.1fx its critical path: more workers could make it faster":                     "la compilación tardó %.1fx su ruta crítica: más trabajadores podrían acelerarla",
+	"the build spent most of its time between commands, more workers would not make it faster":      "la compilación pasó la mayor parte del tiempo entre comandos, más trabajadores no la acelerarían",
+
+	// running, cleaning and watching
+	"binary not found: %s":                       "binario no encontrado: %s",
+	"executable not found: %s":                   "ejecutable no encontrado: %s",
+	"cannot run non-executable output":           "no se puede ejecutar una salida que no es ejecutable",
+	"execution failed: %v":                       "la ejecución falló: %v",
+	"cleaning target: %s":                        "limpiando el objetivo: %s",
+	"cleaning all targets":                       "limpiando todos los objetivos",
+	"removing %s":                                "eliminando %s",
+	"would remove %s":                            "se eliminaría %s",
+	"clean completed successfully":               "limpieza completada correctamente",
+	"clean failed: %v":                           "la limpieza falló: %v",
+	"watching for changes; press Ctrl+C to stop": "vigilando los cambios; pulse Ctrl+C para detener",
+	"changed: %s":                                "modificado: %s",
+	"stopped watching":                           "vigilancia detenida",
+	"watch failed: %v":                           "la vigilancia falló: %v",
+
+	// tests
+	"building tests for target: %s": "compilando las pruebas del objetivo: %s",
+	"compiling tests...":            "compilando las pruebas...",
+	"running %d tests...":           "ejecutando %d pruebas...",
+	"all %d tests passed":           "las %d pruebas pasaron",
+	"%d of %d tests failed":         "%d de %d pruebas fallaron",
+	"failed to build tests: %v":     "no se pudieron compilar las pruebas: %v",
+
+	"collecting coverage...":                             "recopilando la cobertura...",
+	"line coverage: %.1f%% (%d of %d lines in %d files)": "cobertura de líneas: %.1f%% (%d de %d líneas en %d archivos)",
+	"coverage report written to %s":                      "informe de cobertura escrito en %s",
+	"coverage failed: %v":                                "la cobertura falló: %v",
+
+	// projects, compilers, installs and dependencies
+	"creating %s project %s...":                     "creando el proyecto %[2]s con la plantilla %[1]s...",
+	"created %s":                                    "creado %s",
+	"project %s initialized successfully":           "proyecto %s inicializado correctamente",
+	"project already initialized; styx.toml exists": "el proyecto ya está inicializado; styx.toml existe",
+	"failed to create project: %v":                  "no se pudo crear el proyecto: %v",
+	"detecting available compilers...":              "detectando los compiladores disponibles...",
+	"found %d compiler(s)":                          "%d compilador(es) encontrado(s)",
+	"no compilers found":                            "no se encontraron compiladores",
+	"installed %d files":                            "%d archivos instalados",
+	"install failed: %v":                            "la instalación falló: %v",
+	"removed %d files":                              "%d archivos eliminados",
+	"uninstall failed: %v":                          "la desinstalación falló: %v",
+	"checking dependencies for updates...":          "buscando actualizaciones de las dependencias...",
+	"all dependencies are up to date":               "todas las dependencias están al día",
+	"updating dependencies...":                      "actualizando las dependencias...",
+	"update failed: %v":                             "la actualización falló: %v",
+
+	// crashes and bug reports
+	"styx crashed: %v":                      "styx falló inesperadamente: %v",
+	"crash report written to %s":            "informe de fallo escrito en %s",
+	"please file a bug at %s and attach %s": "por favor, informe del error en %s y adjunte %s",
+	"bug report written to %s":              "informe de error escrito en %s",
+}
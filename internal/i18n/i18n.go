@@ -0,0 +1,76 @@
+// Package i18n translates the messages styx prints. Catalogs map the
+// English text of a message, the format given to the logger, to its
+// translation, so a message missing from a catalog is printed in English.
+package i18n
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// catalogs are the translations by language, English being the messages
+// themselves
+var catalogs = map[string]map[string]string{
+	"es": spanish,
+}
+
+// active is the catalog of the language in use, nil for English
+var active map[string]string
+
+// Languages returns the languages styx speaks
+func Languages() []string {
+	languages := []string{"en"}
+	for language := range catalogs {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Parse returns the language of locale, a language like "es" or a POSIX
+// locale like "es_ES.UTF-8". The C and POSIX locales are English.
+func Parse(locale string) (string, error) {
+	language := strings.ToLower(locale)
+	if i := strings.IndexAny(language, "_-.@"); i >= 0 {
+		language = language[:i]
+	}
+	switch language {
+	case "c", "posix", "en":
+		return "en", nil
+	}
+	if _, ok := catalogs[language]; !ok {
+		return "", fmt.Errorf("unsupported language: %s (must be one of %s)", locale, strings.Join(Languages(), ", "))
+	}
+	return language, nil
+}
+
+// Detect returns the language of the environment: that of LC_ALL,
+// LC_MESSAGES or LANG, the first one set, or English when styx does not
+// speak it
+func Detect() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if locale := os.Getenv(name); locale != "" {
+			if language, err := Parse(locale); err == nil {
+				return language
+			}
+			return "en"
+		}
+	}
+	return "en"
+}
+
+// SetLanguage sets the language of the messages, as returned by Parse
+func SetLanguage(language string) {
+	active = catalogs[language]
+}
+
+// T returns the translation of message in the language in use, or message
+// when it has none
+func T(message string) string {
+	if translation, ok := active[message]; ok {
+		return translation
+	}
+	return message
+}
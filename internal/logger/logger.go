@@ -1,14 +1,17 @@
 package logger
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/fatih/color"
+	"github.com/deviceix/styx/internal/i18n"
 	"github.com/rs/zerolog"
 )
 
@@ -23,14 +26,80 @@ const (
 	TypeError                      // RED
 )
 
+// Format selects how loggers write their messages
+type Format int
+
+const (
+	FormatText Format = iota // colorized lines on stderr
+	FormatJSON               // one JSON event per line on stdout
+)
+
+// activeFormat is the format of the loggers created by New
+var activeFormat = FormatText
+
+// SetFormat sets the format of the loggers created from now on
+func SetFormat(format Format) {
+	activeFormat = format
+}
+
+// ParseFormat returns the format named by name, "text" or "json"
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	default:
+		return FormatText, fmt.Errorf("unknown log format: %s (expected text or json)", name)
+	}
+}
+
+// streams are where text loggers write: progress takes the progress
+// indicator and the success, info and note messages, diagnostics the
+// warnings, errors and compiler diagnostics
+var streams = struct {
+	progress    io.Writer
+	diagnostics io.Writer
+}{os.Stderr, os.Stderr}
+
+// SetStreams sets where text loggers write progress and diagnostics
+func SetStreams(progress, diagnostics io.Writer) {
+	streams.progress = progress
+	streams.diagnostics = diagnostics
+}
+
+// ParseStream returns the stream named by name, "stdout" or "stderr"
+func ParseStream(name string) (io.Writer, error) {
+	switch name {
+	case "", "stderr":
+		return os.Stderr, nil
+	case "stdout":
+		return os.Stdout, nil
+	default:
+		return nil, fmt.Errorf("unknown stream: %s (expected stdout or stderr)", name)
+	}
+}
+
+// ciInterval is how often the progress is printed in CI mode, which is off
+// when it is 0
+var ciInterval time.Duration
+
+// SetCI turns CI mode on for every text logger when interval is not 0: the
+// animated progress indicator is replaced by a plain line printed every
+// interval, every line starts with the time and no carriage return is ever
+// written, so that logs read well in CI systems
+func SetCI(interval time.Duration) {
+	ciInterval = interval
+}
+
 // Logger provides structured, colorized logging for Styx
 type Logger struct {
 	zlog        zerolog.Logger
 	mu          sync.Mutex
 	progressBar *ProgressBar
-	colors      map[MessageType]*color.Color
 	isVerbose   bool
 	output      io.Writer
+	json        bool
 }
 
 // ProgressBar represents a simple progress indicator
@@ -41,6 +110,7 @@ type ProgressBar struct {
 	spinChar int
 	lastLine string
 	isActive bool
+	done     chan struct{} // stops the progress lines of CI mode
 }
 
 // New creates a new logger
@@ -54,61 +124,181 @@ func New(verbose bool) *Logger {
 		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	}
 
+	// JSON events go to stderr too, leaving stdout to the output of commands
+	// such as styx graph --format json
 	return &Logger{
 		zlog:      zlog,
 		output:    os.Stderr,
 		isVerbose: verbose,
-		colors: map[MessageType]*color.Color{
-			TypeSuccess: color.New(color.FgGreen, color.Bold),
-			TypeInfo:    color.New(color.FgBlue),
-			TypeNote:    color.New(color.FgWhite),
-			TypeWarning: color.New(color.FgYellow),
-			TypeError:   color.New(color.FgRed, color.Bold),
-		},
+		json:      activeFormat == FormatJSON,
+	}
+}
+
+// SetVerbose sets whether the logger writes debug messages
+func (l *Logger) SetVerbose(verbose bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.isVerbose = verbose
+	if verbose {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	}
 }
 
-// formatPrefix returns a colored prefix based on message type
+// levelNames are the levels of messages in JSON events
+var levelNames = map[MessageType]string{
+	TypeSuccess: "success",
+	TypeInfo:    "info",
+	TypeNote:    "note",
+	TypeWarning: "warning",
+	TypeError:   "error",
+}
+
+// writeEvent writes a JSON event with the given fields; the caller holds mu
+func (l *Logger) writeEvent(event string, fields map[string]interface{}) {
+	record := map[string]interface{}{
+		"event": event,
+		"time":  time.Now().Format(time.RFC3339Nano),
+	}
+	for key, value := range fields {
+		record[key] = value
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	_, _ = l.output.Write(append(data, '\n'))
+}
+
+// Event writes a machine-readable event, like the start of a task or the
+// summary of a build, in the JSON format. Text loggers leave events out,
+// since the same things are told by their messages.
+func (l *Logger) Event(event string, fields map[string]interface{}) {
+	if !l.json {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writeEvent(event, fields)
+}
+
+// progressOut returns where progress and messages other than warnings and
+// errors are written
+func (l *Logger) progressOut() io.Writer {
+	if l.json {
+		return l.output
+	}
+	return streams.progress
+}
+
+// diagnosticOut returns where warnings, errors and compiler diagnostics are
+// written
+func (l *Logger) diagnosticOut() io.Writer {
+	if l.json {
+		return l.output
+	}
+	return streams.diagnostics
+}
+
+// drawing reports whether the animated progress indicator is on screen
+func (l *Logger) drawing() bool {
+	return ciInterval == 0 && l.progressBar != nil && l.progressBar.isActive
+}
+
+// clearProgress erases the progress indicator before a message is written
+func (l *Logger) clearProgress() {
+	if l.drawing() {
+		_, _ = fmt.Fprint(l.progressOut(), "\r"+strings.Repeat(" ", len(l.progressBar.lastLine))+"\r")
+	}
+}
+
+// stamp returns the time every line starts with in CI mode
+func stamp() string {
+	if ciInterval == 0 {
+		return ""
+	}
+	return time.Now().Format("15:04:05") + " "
+}
+
+// formatPrefix returns the prefix of a message type, colored by the theme
 func (l *Logger) formatPrefix(msgType MessageType) string {
-	var prefix string
+	return themed(levelNames[msgType], activeTheme.Prefixes[levelNames[msgType]])
+}
+
+// themed colors text with the color the theme gives to key
+func themed(key, text string) string {
+	return activeTheme.Colors[key].Sprint(text)
+}
+
+// historySize is the number of messages kept for build logs and crash
+// reports
+const historySize = 1000
 
-	switch msgType {
-	case TypeSuccess:
-		prefix = "[SUCCESS]"
-	case TypeInfo:
-		prefix = "[INFO]"
-	case TypeNote:
-		prefix = "[NOTE]"
-	case TypeWarning:
-		prefix = "[WARNING]"
-	case TypeError:
-		prefix = "[ERROR]"
+// history holds the last messages of every logger, oldest first
+var history struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// remember appends a message to the history, dropping the oldest one when
+// it is full
+func remember(msgType MessageType, message string) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	if len(history.lines) == historySize {
+		history.lines = append(history.lines[:0], history.lines[1:]...)
 	}
+	history.lines = append(history.lines, fmt.Sprintf("[%s] %s", strings.ToUpper(levelNames[msgType]), message))
+}
 
-	return l.colors[msgType].Sprint(prefix)
+// History returns the last messages logged by any logger, oldest first
+func History() []string {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	return append([]string(nil), history.lines...)
 }
 
 // Log logs a message of the specified type
 func (l *Logger) Log(msgType MessageType, format string, args ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	message := fmt.Sprintf(format, args...)
+	remember(msgType, message)
+
+	if l.json {
+		l.writeEvent("message", map[string]interface{}{
+			"level":   levelNames[msgType],
+			"message": message,
+		})
+		return
+	}
+
+	// the history and JSON events stay in English, for bug reports and tools
+	message = fmt.Sprintf(i18n.T(format), args...)
 
 	// clear progress bar if active
-	if l.progressBar != nil && l.progressBar.isActive {
-		_, err := fmt.Fprintln(l.output, "\r"+strings.Repeat(" ", len(l.progressBar.lastLine))+"\r")
+	if l.drawing() {
+		_, err := fmt.Fprintln(l.progressOut(), "\r"+strings.Repeat(" ", len(l.progressBar.lastLine))+"\r")
 		if err != nil {
 			return
 		}
 	}
 
-	message := fmt.Sprintf(format, args...)
-	_, err := fmt.Fprintf(l.output, "%s %s\n", l.formatPrefix(msgType), message)
+	out := l.progressOut()
+	if msgType == TypeWarning || msgType == TypeError {
+		out = l.diagnosticOut()
+	}
+	_, err := fmt.Fprintf(out, "%s%s %s\n", stamp(), l.formatPrefix(msgType), message)
 	if err != nil {
 		return
 	}
 
 	// redraw if otherwise
-	if l.progressBar != nil && l.progressBar.isActive {
+	if l.drawing() {
 		l.drawProgressBar()
 	}
 }
@@ -140,20 +330,66 @@ func (l *Logger) Error(format string, args ...interface{}) {
 
 // StartProgress starts a new progress indicator
 func (l *Logger) StartProgress(total int, message string) {
+	if l.json {
+		return
+	}
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	l.stopProgressLines()
 	l.progressBar = &ProgressBar{
 		total:    total,
 		current:  0,
-		message:  message,
+		message:  i18n.T(message),
 		spinChar: 0,
 		isActive: true,
 	}
 
+	if ciInterval > 0 {
+		l.progressBar.done = make(chan struct{})
+		go l.printProgressLines(l.progressBar, l.progressBar.done)
+		return
+	}
 	l.drawProgressBar()
 }
 
+// printProgressLines prints a line telling the progress of bar every
+// interval of CI mode until it stops
+func (l *Logger) printProgressLines(bar *ProgressBar, done chan struct{}) {
+	ticker := time.NewTicker(ciInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			_, _ = fmt.Fprintf(l.progressOut(), "%s%s %d/%d (%d%%) %s\n", stamp(),
+				themed("progress", activeTheme.Prefixes["progress"]), bar.current, bar.total, bar.percentage(), bar.message)
+			l.mu.Unlock()
+		}
+	}
+}
+
+// stopProgressLines stops the progress lines of CI mode; the caller holds
+// mu
+func (l *Logger) stopProgressLines() {
+	if l.progressBar != nil && l.progressBar.done != nil {
+		close(l.progressBar.done)
+		l.progressBar.done = nil
+	}
+}
+
+// percentage returns how much of the work is done
+func (p *ProgressBar) percentage() int {
+	if p.total <= 0 {
+		return 0
+	}
+	return (p.current * 100) / p.total
+}
+
 // UpdateProgress updates the progress indicator
 func (l *Logger) UpdateProgress(current int, message string) {
 	l.mu.Lock()
@@ -168,7 +404,9 @@ func (l *Logger) UpdateProgress(current int, message string) {
 		l.progressBar.message = message
 	}
 
-	l.drawProgressBar()
+	if ciInterval == 0 {
+		l.drawProgressBar()
+	}
 }
 
 // StopProgress stops the progress indicator
@@ -180,11 +418,8 @@ func (l *Logger) StopProgress() {
 		return
 	}
 
-	// clear
-	_, err := fmt.Fprint(l.output, "\r"+strings.Repeat(" ", len(l.progressBar.lastLine))+"\r")
-	if err != nil {
-		return
-	}
+	l.stopProgressLines()
+	l.clearProgress()
 	l.progressBar.isActive = false
 }
 
@@ -194,23 +429,18 @@ func (l *Logger) drawProgressBar() {
 		return
 	}
 
-	spinner := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
-	spinChar := spinner[l.progressBar.spinChar]
+	spinner := activeTheme.Spinner
+	spinChar := spinner[l.progressBar.spinChar%len(spinner)]
 	l.progressBar.spinChar = (l.progressBar.spinChar + 1) % len(spinner)
 
-	percentage := 0
-	if l.progressBar.total > 0 {
-		percentage = (l.progressBar.current * 100) / l.progressBar.total
-	}
-
 	progressText := fmt.Sprintf("%s %s [%d%%] %s",
-		l.colors[TypeInfo].Sprint("[PROGRESS]"),
+		themed("progress", activeTheme.Prefixes["progress"]),
 		spinChar,
-		percentage,
+		l.progressBar.percentage(),
 		l.progressBar.message)
 
 	l.progressBar.lastLine = progressText
-	_, err := fmt.Fprintln(l.output, "\r"+progressText)
+	_, err := fmt.Fprintln(l.progressOut(), "\r"+progressText)
 	if err != nil {
 		return
 	}
@@ -230,13 +460,33 @@ type BuilderEvent struct {
 func (l *Logger) ReportBuildEvent(event BuilderEvent) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	if l.progressBar != nil && l.progressBar.isActive {
-		_, err := fmt.Fprint(l.output, "\r"+strings.Repeat(" ", len(l.progressBar.lastLine))+"\r")
-		if err != nil {
-			return
+
+	if l.json {
+		fields := map[string]interface{}{
+			"level":   levelNames[event.Type],
+			"message": event.Message,
+		}
+		if event.Source != "" {
+			fields["file"] = event.Source
+		}
+		if event.Line > 0 {
+			fields["line"] = event.Line
+		}
+		if event.Column > 0 {
+			fields["column"] = event.Column
+		}
+		if event.Code != "" {
+			fields["code"] = event.Code
 		}
+		if len(event.Suggestions) > 0 {
+			fields["notes"] = event.Suggestions
+		}
+		l.writeEvent("diagnostic", fields)
+		return
 	}
+	l.clearProgress()
 
+	out := l.diagnosticOut()
 	prefix := l.formatPrefix(event.Type)
 	var location string
 	if event.Source != "" {
@@ -251,32 +501,127 @@ func (l *Logger) ReportBuildEvent(event BuilderEvent) {
 		}
 	}
 
+	_, _ = fmt.Fprint(out, stamp())
 	if location != "" {
-		_, err := color.New(color.FgCyan).Fprintf(l.output, "%s ", location)
+		_, err := fmt.Fprintf(out, "%s ", themed("location", location))
 		if err != nil {
 			return
 		}
 	}
 
-	_, err := fmt.Fprintf(l.output, "%s %s\n", prefix, event.Message)
+	_, err := fmt.Fprintf(out, "%s %s\n", prefix, event.Message)
 	if err != nil {
 		return
 	}
-	if event.Code != "" && l.isVerbose {
-		_, err := fmt.Fprintf(l.output, "    %s\n", event.Code)
-		if err != nil {
-			return
-		}
-	}
+	l.writeSnippet(event)
 
 	for _, suggestion := range event.Suggestions {
-		_, err := fmt.Fprintf(l.output, "    %s\n", suggestion)
+		_, err := fmt.Fprintf(out, "%s    %s\n", stamp(), suggestion)
 		if err != nil {
 			return
 		}
 	}
 
-	if l.progressBar != nil && l.progressBar.isActive {
+	if l.drawing() {
 		l.drawProgressBar()
 	}
 }
+
+// writeSnippet writes the source line an error or warning points at, with
+// a caret under its column, the way compilers echo it. The line is read
+// from the source, or taken from the compiler output when the source cannot
+// be read. The caller holds mu.
+func (l *Logger) writeSnippet(event BuilderEvent) {
+	if event.Line <= 0 || (event.Type != TypeError && event.Type != TypeWarning) {
+		return
+	}
+
+	code, ok := sourceLine(event.Source, event.Line)
+	if !ok {
+		code = event.Code
+	}
+	if strings.TrimSpace(code) == "" {
+		return
+	}
+
+	out := l.diagnosticOut()
+	gutter := strconv.Itoa(event.Line)
+	_, _ = fmt.Fprintf(out, "%s  %s | %s\n", stamp(), gutter, code)
+	offset, ok := columnOffset(code, event.Column)
+	if !ok {
+		return
+	}
+
+	// tabs are kept so the caret lines up with the code above it
+	var underline strings.Builder
+	for _, c := range code[:offset] {
+		if c == '\t' {
+			underline.WriteByte('\t')
+		} else {
+			underline.WriteByte(' ')
+		}
+	}
+	underline.WriteString(themed(levelNames[event.Type], "^"+strings.Repeat("~", tokenLength(code[offset:])-1)))
+	_, _ = fmt.Fprintf(out, "%s  %s | %s\n", stamp(), strings.Repeat(" ", len(gutter)), underline.String())
+}
+
+// sourceLine reads the given line of a file, counting from 1
+func sourceLine(path string, line int) (string, bool) {
+	if path == "" {
+		return "", false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		if n == line {
+			return strings.TrimRight(scanner.Text(), "\r"), true
+		}
+	}
+	return "", false
+}
+
+// columnOffset returns the byte offset of a column of code, counting from 1
+// with tab stops every 8 columns like GCC does
+func columnOffset(code string, column int) (int, bool) {
+	if column <= 0 {
+		return 0, false
+	}
+
+	display := 1
+	for i, c := range code {
+		if display >= column {
+			return i, true
+		}
+		if c == '\t' {
+			display += 8 - (display-1)%8
+		} else {
+			display++
+		}
+	}
+	if display == column {
+		return len(code), true
+	}
+	return 0, false
+}
+
+// tokenLength returns the length of the identifier or number code starts
+// with, or 1 for anything else
+func tokenLength(code string) int {
+	n := 0
+	for n < len(code) {
+		c := code[n]
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		return 1
+	}
+	return n
+}
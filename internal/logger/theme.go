@@ -0,0 +1,196 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Theme is how text loggers look: the color and the prefix of every kind of
+// message, and the characters of the progress spinner. Colors and prefixes
+// are keyed by the names of levelNames, plus "progress" for the progress
+// indicator and "location" for the file and line of compiler diagnostics.
+type Theme struct {
+	Colors   map[string]*color.Color
+	Prefixes map[string]string
+	Spinner  []string
+}
+
+var (
+	unicodeSpinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+	asciiSpinner   = []string{"|", "/", "-", "\\"}
+)
+
+// defaultPrefixes are the prefixes of every theme unless configured
+var defaultPrefixes = map[string]string{
+	"success":  "[SUCCESS]",
+	"info":     "[INFO]",
+	"note":     "[NOTE]",
+	"warning":  "[WARNING]",
+	"error":    "[ERROR]",
+	"progress": "[PROGRESS]",
+}
+
+// themeColors are the colors of the built in themes. colorblind tells
+// success from failure by blue and magenta rather than green and red, light
+// avoids yellow and white, which fade on light backgrounds, and mono has no
+// color at all.
+var themeColors = map[string]map[string]string{
+	"default": {
+		"success":  "green bold",
+		"info":     "blue",
+		"note":     "white",
+		"warning":  "yellow",
+		"error":    "red bold",
+		"progress": "blue",
+		"location": "cyan",
+	},
+	"colorblind": {
+		"success":  "blue bold",
+		"info":     "cyan",
+		"note":     "white",
+		"warning":  "yellow bold",
+		"error":    "magenta bold",
+		"progress": "cyan",
+		"location": "white underline",
+	},
+	"light": {
+		"success":  "green bold",
+		"info":     "blue",
+		"note":     "hiblack",
+		"warning":  "magenta bold",
+		"error":    "red bold",
+		"progress": "blue",
+		"location": "blue underline",
+	},
+	"mono": {
+		"success":  "none",
+		"info":     "none",
+		"note":     "none",
+		"warning":  "bold",
+		"error":    "bold",
+		"progress": "none",
+		"location": "none",
+	},
+}
+
+// Themes returns the names of the built in themes
+func Themes() []string {
+	names := make([]string, 0, len(themeColors))
+	for name := range themeColors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// colorAttributes are the words of color specifications
+var colorAttributes = map[string]color.Attribute{
+	"black":     color.FgBlack,
+	"red":       color.FgRed,
+	"green":     color.FgGreen,
+	"yellow":    color.FgYellow,
+	"blue":      color.FgBlue,
+	"magenta":   color.FgMagenta,
+	"cyan":      color.FgCyan,
+	"white":     color.FgWhite,
+	"hiblack":   color.FgHiBlack,
+	"hired":     color.FgHiRed,
+	"higreen":   color.FgHiGreen,
+	"hiyellow":  color.FgHiYellow,
+	"hiblue":    color.FgHiBlue,
+	"himagenta": color.FgHiMagenta,
+	"hicyan":    color.FgHiCyan,
+	"hiwhite":   color.FgHiWhite,
+	"bold":      color.Bold,
+	"faint":     color.Faint,
+	"italic":    color.Italic,
+	"underline": color.Underline,
+}
+
+// parseColor returns the color specified by spec, space separated words of
+// colorAttributes like "red bold", or "none" for no color
+func parseColor(spec string) (*color.Color, error) {
+	c := color.New()
+	for _, word := range strings.Fields(strings.ToLower(spec)) {
+		if word == "none" {
+			continue
+		}
+		attribute, ok := colorAttributes[word]
+		if !ok {
+			return nil, fmt.Errorf("unknown color: %s", word)
+		}
+		c.Add(attribute)
+	}
+	return c, nil
+}
+
+// NewTheme returns the built in theme called name ("default" when empty)
+// with the given colors and prefixes replacing its own. spinner is
+// "unicode", "ascii", or empty for the spinner of the theme: ASCII for
+// mono, which suits screen readers, and Unicode for the others.
+func NewTheme(name string, colors, prefixes map[string]string, spinner string) (*Theme, error) {
+	if name == "" {
+		name = "default"
+	}
+	specs, ok := themeColors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown theme: %s (must be one of %s)", name, strings.Join(Themes(), ", "))
+	}
+
+	theme := &Theme{
+		Colors:   make(map[string]*color.Color),
+		Prefixes: make(map[string]string),
+		Spinner:  unicodeSpinner,
+	}
+	if name == "mono" {
+		theme.Spinner = asciiSpinner
+	}
+
+	for key, spec := range specs {
+		if override, ok := colors[key]; ok {
+			spec = override
+		}
+		c, err := parseColor(spec)
+		if err != nil {
+			return nil, fmt.Errorf("color of %s: %w", key, err)
+		}
+		theme.Colors[key] = c
+	}
+	for key := range colors {
+		if _, ok := specs[key]; !ok {
+			return nil, fmt.Errorf("unknown message kind in colors: %s", key)
+		}
+	}
+
+	for key, prefix := range defaultPrefixes {
+		theme.Prefixes[key] = prefix
+	}
+	for key, prefix := range prefixes {
+		if _, ok := defaultPrefixes[key]; !ok {
+			return nil, fmt.Errorf("unknown message kind in prefixes: %s", key)
+		}
+		theme.Prefixes[key] = prefix
+	}
+
+	switch spinner {
+	case "":
+	case "unicode":
+		theme.Spinner = unicodeSpinner
+	case "ascii":
+		theme.Spinner = asciiSpinner
+	default:
+		return nil, fmt.Errorf("invalid spinner: %s (must be unicode or ascii)", spinner)
+	}
+	return theme, nil
+}
+
+// activeTheme is the theme of every text logger
+var activeTheme, _ = NewTheme("default", nil, nil, "")
+
+// SetTheme sets the theme of every text logger
+func SetTheme(theme *Theme) {
+	activeTheme = theme
+}
@@ -42,8 +42,12 @@ func DetectPlatform() Platform {
 // GetPlatformInfo returns info about the current platform
 // note: defaults to UNIX-like OS as a fallback
 func GetPlatformInfo() *PlatformInfo {
-	platform := DetectPlatform()
+	return GetPlatformInfoFor(DetectPlatform())
+}
 
+// GetPlatformInfoFor returns info about the given platform, e.g. the one
+// inside a build container
+func GetPlatformInfoFor(platform Platform) *PlatformInfo {
 	switch platform {
 	case PlatformWindows:
 		return &PlatformInfo{
@@ -88,6 +92,18 @@ func GetPlatformInfo() *PlatformInfo {
 	}
 }
 
+// Arch returns the architecture of the host the way toolchains name it,
+// like x86_64 or aarch64
+func Arch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	}
+	return runtime.GOARCH
+}
+
 // IsUnixLike returns true if the platform is Unix-like
 func IsUnixLike(platform Platform) bool {
 	return platform == PlatformLinux || platform == PlatformMacOS
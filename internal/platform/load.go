@@ -0,0 +1,44 @@
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// LoadAverage returns the load average of the system over the last minute.
+// It is read from /proc/loadavg on Linux and from sysctl on macOS and the
+// BSDs; elsewhere it is not available.
+func LoadAverage() (float64, error) {
+	var fields []string
+	switch runtime.GOOS {
+	case "linux":
+		data, err := os.ReadFile("/proc/loadavg")
+		if err != nil {
+			return 0, fmt.Errorf("failed to read load average: %w", err)
+		}
+		fields = strings.Fields(string(data))
+	case "darwin", "freebsd", "netbsd", "openbsd":
+		// prints "{ 1.52 1.61 1.70 }"
+		out, err := exec.Command("sysctl", "-n", "vm.loadavg").Output()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read load average: %w", err)
+		}
+		fields = strings.Fields(strings.Trim(strings.TrimSpace(string(out)), "{}"))
+	default:
+		return 0, fmt.Errorf("load average is not available on %s", runtime.GOOS)
+	}
+
+	if len(fields) == 0 {
+		return 0, errors.New("failed to read load average: no value")
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read load average: %w", err)
+	}
+	return load, nil
+}
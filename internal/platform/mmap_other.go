@@ -0,0 +1,15 @@
+//go:build !unix
+
+package platform
+
+import "os"
+
+// MapFile reads the file at path, which cannot be mapped into memory on
+// this platform, and returns its contents with a function doing nothing
+func MapFile(path string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
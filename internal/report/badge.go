@@ -0,0 +1,60 @@
+package report
+
+import (
+	"fmt"
+	"io"
+)
+
+// badge colors, those of shields.io
+const (
+	badgeGreen  = "#4c1"
+	badgeYellow = "#dfb317"
+	badgeRed    = "#e05d44"
+	badgeGrey   = "#555"
+)
+
+// badgeStatus returns the message and color of the badge of a build:
+// passing, passing with warnings, or failing
+func badgeStatus(build *Build) (string, string) {
+	if !build.Success {
+		return "failing", badgeRed
+	}
+	switch warnings := build.Count("warning"); warnings {
+	case 0:
+		return "passing", badgeGreen
+	case 1:
+		return "passing, 1 warning", badgeYellow
+	default:
+		return fmt.Sprintf("passing, %d warnings", warnings), badgeYellow
+	}
+}
+
+// textWidth estimates the width in pixels of text in the 11px sans-serif
+// font of badges
+func textWidth(text string) int {
+	return len(text)*7 + 10
+}
+
+// badgeSVG returns the badge of a build, in the style of shields.io
+func badgeSVG(build *Build) string {
+	label := "styx build"
+	message, color := badgeStatus(build)
+	left, right := textWidth(label), textWidth(message)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+  <title>%[4]s: %[5]s</title>
+  <rect width="%[2]d" height="20" fill="%[7]s"/>
+  <rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+    <text x="%[8]d" y="14">%[4]s</text>
+    <text x="%[9]d" y="14">%[5]s</text>
+  </g>
+</svg>
+`, left+right, left, right, label, message, color, badgeGrey, left/2, left+right/2)
+}
+
+// WriteBadge writes an SVG badge telling whether the build passed, to show
+// in a README or on a CI dashboard
+func WriteBadge(w io.Writer, build *Build) error {
+	_, err := io.WriteString(w, badgeSVG(build))
+	return err
+}
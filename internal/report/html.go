@@ -0,0 +1,314 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"sort"
+	"unicode/utf8"
+)
+
+const (
+	// barsShown is the number of steps in the timings chart
+	barsShown = 30
+	// graphNodesShown is the number of nodes above which headers are left
+	// out of the graph, which would not be readable anymore
+	graphNodesShown = 300
+)
+
+// page is what the HTML report is generated from
+type page struct {
+	*Build
+	Badge       template.HTML
+	StartTime   string
+	WallTime    string
+	CPU         string
+	Parallelism string
+	Bars        []bar
+	HiddenBars  int
+	HitRate     string
+	Saved       string
+	Graph       graphView
+}
+
+// bar is a step in the timings chart
+type bar struct {
+	Step
+	Wall    string
+	CPU     string
+	Percent float64
+}
+
+// graphView is the dependency graph laid out in columns by node type
+type graphView struct {
+	Width, Height int
+	Nodes         []nodeView
+	Edges         []string // SVG paths
+	Omitted       int      // headers left out
+}
+
+// nodeView is a node of the graph and its box
+type nodeView struct {
+	X, Y  int
+	Label string
+	Title string
+	Type  string
+}
+
+// WriteHTML writes a static HTML page reporting on the build: its summary,
+// a chart of its slowest steps, its warnings and errors, what the cache
+// saved and its dependency graph. The page needs no script or network.
+func WriteHTML(w io.Writer, build *Build) error {
+	p := page{
+		Build:     build,
+		Badge:     template.HTML(badgeSVG(build)),
+		StartTime: build.Started.Local().Format("2006-01-02 15:04:05 MST"),
+		WallTime:  seconds(float64(build.DurationMS)),
+		Graph:     layoutGraph(build.Graph),
+	}
+
+	var cpu float64
+	for _, step := range build.Steps {
+		if !step.UpToDate {
+			cpu += step.CPUMS
+		}
+	}
+	p.CPU = seconds(cpu)
+	if build.DurationMS > 0 {
+		p.Parallelism = fmt.Sprintf("%.1fx", cpu/float64(build.DurationMS))
+	}
+
+	steps := append([]Step(nil), build.Steps...)
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].WallMS > steps[j].WallMS
+	})
+	if len(steps) > barsShown {
+		p.HiddenBars = len(steps) - barsShown
+		steps = steps[:barsShown]
+	}
+	for _, step := range steps {
+		b := bar{Step: step, Wall: seconds(step.WallMS), CPU: seconds(step.CPUMS)}
+		if steps[0].WallMS > 0 {
+			b.Percent = 100 * step.WallMS / steps[0].WallMS
+		}
+		if step.UpToDate {
+			b.CPU = "-"
+		}
+		p.Bars = append(p.Bars, b)
+	}
+
+	if build.Cache.Units > 0 {
+		p.HitRate = fmt.Sprintf("%.0f%%", 100*float64(build.Cache.Hits)/float64(build.Cache.Units))
+	}
+	p.Saved = seconds(build.Cache.SavedMS)
+
+	return pageTemplate.Execute(w, p)
+}
+
+// seconds formats milliseconds in seconds, like the times styx prints
+func seconds(ms float64) string {
+	return fmt.Sprintf("%.2fs", ms/1000)
+}
+
+// graphColumns are the columns of the graph by node type, inputs on the left
+var graphColumns = map[string]int{
+	"header":     0,
+	"source":     1,
+	"generated":  1,
+	"object":     2,
+	"library":    3,
+	"executable": 4,
+}
+
+// the size of the boxes of the graph and the space around them
+const (
+	nodeWidth  = 200
+	nodeHeight = 18
+	rowSpacing = 24
+	colSpacing = 80
+	margin     = 10
+)
+
+// layoutGraph places the nodes of g in a column per node type and draws
+// its edges as curves between them
+func layoutGraph(g Graph) graphView {
+	var view graphView
+	nodes := g.Nodes
+	if len(nodes) > graphNodesShown {
+		var kept []Node
+		for _, node := range nodes {
+			if node.Type == "header" {
+				view.Omitted++
+				continue
+			}
+			kept = append(kept, node)
+		}
+		nodes = kept
+	}
+
+	// only the columns holding nodes are drawn
+	used := make(map[int]bool)
+	for _, node := range nodes {
+		used[graphColumns[node.Type]] = true
+	}
+	var columns []int
+	for column := range used {
+		columns = append(columns, column)
+	}
+	sort.Ints(columns)
+	x := make(map[int]int)
+	for i, column := range columns {
+		x[column] = margin + i*(nodeWidth+colSpacing)
+	}
+
+	rows := make(map[int]int)
+	positions := make(map[string]nodeView)
+	for _, node := range nodes {
+		column := graphColumns[node.Type]
+		n := nodeView{
+			X:     x[column],
+			Y:     margin + rows[column]*rowSpacing,
+			Label: shorten(filepath.Base(node.ID), 28),
+			Title: node.ID,
+			Type:  node.Type,
+		}
+		rows[column]++
+		positions[node.ID] = n
+		view.Nodes = append(view.Nodes, n)
+	}
+
+	for _, edge := range g.Edges {
+		from, ok := positions[edge.From]
+		to, ok2 := positions[edge.To]
+		if !ok || !ok2 {
+			continue
+		}
+		x1, y1 := from.X+nodeWidth, from.Y+nodeHeight/2
+		x2, y2 := to.X, to.Y+nodeHeight/2
+		view.Edges = append(view.Edges, fmt.Sprintf("M%d %d C%d %d, %d %d, %d %d", x1, y1, x1+colSpacing/2, y1, x2-colSpacing/2, y2, x2, y2))
+	}
+
+	maxRows := 0
+	for _, n := range rows {
+		maxRows = max(maxRows, n)
+	}
+	view.Width = 2*margin + len(columns)*(nodeWidth+colSpacing) - colSpacing
+	view.Height = 2*margin + maxRows*rowSpacing
+	return view
+}
+
+// shorten cuts text to n characters, marking the cut with an ellipsis
+func shorten(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	return string([]rune(text)[:n-1]) + "…"
+}
+
+var pageTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Project}} ({{.Target}}): build report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 1100px; color: #24292f; padding: 0 1em; }
+h1 { display: flex; align-items: center; gap: .6em; }
+h2 { border-bottom: 1px solid #d0d7de; padding-bottom: .3em; margin-top: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .3em .6em; border-bottom: 1px solid #eaeef2; vertical-align: top; }
+th { background: #f6f8fa; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; white-space: nowrap; }
+.summary th { width: 12em; }
+.chart td.bar { width: 45%; }
+.chart .fill { background: #54aeff; height: 1em; border-radius: 2px; }
+.chart .cached .fill { background: #d0d7de; }
+.muted { color: #6e7781; }
+.warning { color: #9a6700; font-weight: 600; }
+.error { color: #cf222e; font-weight: 600; }
+.note { color: #6e7781; font-weight: 600; }
+code { font-family: ui-monospace, Menlo, Consolas, monospace; font-size: .9em; }
+.graph { overflow: auto; border: 1px solid #d0d7de; border-radius: 6px; max-height: 700px; }
+.graph rect { stroke: #57606a; stroke-width: 1; rx: 3; }
+.graph .source rect, .graph .generated rect { fill: #ddf4ff; }
+.graph .header rect { fill: #f6f8fa; }
+.graph .object rect { fill: #fff8c5; }
+.graph .library rect { fill: #dafbe1; }
+.graph .executable rect { fill: #ffebe9; }
+.graph text { font: 11px ui-monospace, Menlo, Consolas, monospace; }
+.graph path { fill: none; stroke: #8c959f; stroke-width: 1; opacity: .6; }
+</style>
+</head>
+<body>
+<h1>{{.Project}} {{.Badge}}</h1>
+
+<table class="summary">
+<tr><th>Project</th><td>{{.Project}}{{if .Version}} {{.Version}}{{end}}</td></tr>
+<tr><th>Target</th><td>{{.Target}}</td></tr>
+<tr><th>Compiler</th><td>{{.Compiler}}</td></tr>
+<tr><th>Started</th><td>{{.StartTime}}</td></tr>
+<tr><th>Duration</th><td>{{.WallTime}} wall clock, {{.CPU}} CPU{{if .Parallelism}} ({{.Parallelism}} parallelism){{end}}</td></tr>
+<tr><th>Parallel jobs</th><td>{{.Jobs}}</td></tr>
+<tr><th>Result</th><td>{{if .Success}}succeeded{{else}}<span class="error">failed</span>{{if .Error}}: <code>{{.Error}}</code>{{end}}{{end}}</td></tr>
+{{- if .Outputs}}
+<tr><th>Outputs</th><td>{{range .Outputs}}<code>{{.}}</code><br>{{end}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Timings</h2>
+{{- if .Bars}}
+<table class="chart">
+<tr><th>Step</th><th>Name</th><th class="num">Wall</th><th class="num">CPU</th><th></th></tr>
+{{- range .Bars}}
+<tr{{if .UpToDate}} class="cached"{{end}}><td>{{.Kind}}</td><td><code>{{.Name}}</code>{{if .UpToDate}} <span class="muted">(up to date, last compile)</span>{{end}}</td><td class="num">{{.Wall}}</td><td class="num">{{.CPU}}</td><td class="bar"><div class="fill" style="width: {{printf "%.1f" .Percent}}%"></div></td></tr>
+{{- end}}
+</table>
+{{- if .HiddenBars}}
+<p class="muted">{{.HiddenBars}} faster steps are not shown.</p>
+{{- end}}
+{{- else}}
+<p class="muted">The build ran no commands.</p>
+{{- end}}
+
+<h2>Warnings and errors</h2>
+{{- if .Diagnostics}}
+<table>
+<tr><th>Severity</th><th>Location</th><th>Message</th></tr>
+{{- range .Diagnostics}}
+<tr><td class="{{.Severity}}">{{.Severity}}</td><td><code>{{.File}}{{if .Line}}:{{.Line}}{{if .Column}}:{{.Column}}{{end}}{{end}}</code></td><td>{{.Message}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p class="muted">The compiler reported no warnings or errors.</p>
+{{- end}}
+
+<h2>Build cache</h2>
+<table class="summary">
+<tr><th>Translation units</th><td>{{.Cache.Units}}</td></tr>
+<tr><th>Up to date</th><td>{{.Cache.Hits}}{{if .HitRate}} ({{.HitRate}}){{end}}</td></tr>
+<tr><th>Compile time saved</th><td>{{.Saved}}</td></tr>
+</table>
+
+<h2>Dependency graph</h2>
+{{- if .Graph.Nodes}}
+{{- if .Graph.Omitted}}
+<p class="muted">{{.Graph.Omitted}} headers are left out.</p>
+{{- end}}
+<div class="graph">
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Graph.Width}}" height="{{.Graph.Height}}">
+{{- range .Graph.Edges}}
+<path d="{{.}}"/>
+{{- end}}
+{{- range .Graph.Nodes}}
+<g class="{{.Type}}"><title>{{.Title}}</title><rect x="{{.X}}" y="{{.Y}}" width="200" height="18"/><text x="{{.X}}" y="{{.Y}}" dx="6" dy="13">{{.Label}}</text></g>
+{{- end}}
+</svg>
+</div>
+{{- else}}
+<p class="muted">The build recorded no dependency graph.</p>
+{{- end}}
+
+<p class="muted">Generated by styx.</p>
+</body>
+</html>
+`))
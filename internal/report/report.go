@@ -0,0 +1,126 @@
+// Package report keeps the record of the last build of a project, written
+// by every build, and renders it as a static HTML page or a status badge to
+// publish from CI.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// Build is the record of a build
+type Build struct {
+	Project     string       `json:"project"`
+	Version     string       `json:"version,omitempty"`
+	Target      string       `json:"target"`
+	Compiler    string       `json:"compiler"`
+	Started     time.Time    `json:"started"`
+	DurationMS  int64        `json:"duration_ms"`
+	Jobs        int          `json:"jobs"`
+	Success     bool         `json:"success"`
+	Error       string       `json:"error,omitempty"`
+	Outputs     []string     `json:"outputs"`
+	Steps       []Step       `json:"steps"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	Cache       CacheStats   `json:"cache"`
+	Graph       Graph        `json:"graph"`
+}
+
+// Step is a command of a build, or a translation unit that was up to date,
+// timed when it was last compiled
+type Step struct {
+	Kind     string  `json:"kind"`
+	Name     string  `json:"name"` // the source of compile steps, the output of the others
+	WallMS   float64 `json:"wall_ms"`
+	CPUMS    float64 `json:"cpu_ms"`
+	UpToDate bool    `json:"up_to_date,omitempty"`
+}
+
+// Diagnostic is a warning or an error of the compiler
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Message  string `json:"message"`
+}
+
+// CacheStats tell how much of a build the build cache saved: Hits of the
+// Units were up to date, and would have taken SavedMS to compile
+type CacheStats struct {
+	Units   int     `json:"units"`
+	Hits    int     `json:"hits"`
+	SavedMS float64 `json:"saved_ms"`
+}
+
+// Graph is the dependency graph of a build, edges pointing from inputs to
+// what is built from them
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// Node is a file of the dependency graph; Type is source, header, object,
+// library, executable or generated
+type Node struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// Edge is an edge of the dependency graph
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Duration returns how long the build took
+func (b *Build) Duration() time.Duration {
+	return time.Duration(b.DurationMS) * time.Millisecond
+}
+
+// Count returns the number of diagnostics of the given severity
+func (b *Build) Count(severity string) int {
+	n := 0
+	for _, d := range b.Diagnostics {
+		if d.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// Save writes the record of a build to path
+func Save(path string, build *Build) error {
+	data, err := json.MarshalIndent(build, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode build record: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := platform.WriteFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write build record: %w", err)
+	}
+	return nil
+}
+
+// Load reads the record of a build saved to path
+func Load(path string) (*Build, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no build recorded yet; run styx build first")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read build record: %w", err)
+	}
+	var build Build
+	if err := json.Unmarshal(data, &build); err != nil {
+		return nil, fmt.Errorf("failed to parse build record: %w", err)
+	}
+	return &build, nil
+}
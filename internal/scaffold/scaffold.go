@@ -0,0 +1,289 @@
+// Package scaffold creates new styx projects from templates: the
+// configuration, the directory layout and starter sources of an executable,
+// a library, a kernel, firmware or a project tested with GoogleTest.
+package scaffold
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Options describe the project to create; empty fields take the defaults of
+// the template
+type Options struct {
+	Template string
+	Name     string
+	Language string
+	Standard string
+	Compiler string
+}
+
+// Template is a kind of project
+type Template struct {
+	Name        string
+	Description string
+	Languages   []string // the first one is the default
+	Compiler    string   // the default compiler
+	Dirs        []string // created even when no file goes in them
+	Files       map[string]string
+	Notes       []string // printed once the project is created
+}
+
+// DefaultTemplate is the template used when none is given
+const DefaultTemplate = "executable"
+
+// defaultStandards are the standards of new projects by language
+var defaultStandards = map[string]string{
+	"c":   "c17",
+	"c++": "c++23",
+}
+
+// Templates returns the templates sorted by name
+func Templates() []*Template {
+	list := make([]*Template, 0, len(templates))
+	for _, t := range templates {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Lookup returns the template called name
+func Lookup(name string) (*Template, error) {
+	t, ok := templates[name]
+	if !ok {
+		names := make([]string, 0, len(templates))
+		for _, t := range Templates() {
+			names = append(names, t.Name)
+		}
+		return nil, fmt.Errorf("unknown template: %s (must be one of %s)", name, strings.Join(names, ", "))
+	}
+	return t, nil
+}
+
+// complete fills the empty options with the defaults of t and checks the
+// others
+func (t *Template) complete(opts *Options) error {
+	if opts.Name == "" {
+		return errors.New("project name is required")
+	}
+	if opts.Language == "" {
+		opts.Language = t.Languages[0]
+	}
+	if !contains(t.Languages, opts.Language) {
+		return fmt.Errorf("the %s template supports %s, not %s", t.Name, strings.Join(t.Languages, " and "), opts.Language)
+	}
+	if opts.Standard == "" {
+		opts.Standard = defaultStandards[opts.Language]
+	}
+	if opts.Compiler == "" {
+		opts.Compiler = t.Compiler
+	}
+	return nil
+}
+
+// data is what the files of a template are generated from
+type data struct {
+	Options
+	Ident     string // the name as a C identifier
+	Guard     string // the include guard of C headers
+	Ext       string // of sources
+	HeaderExt string
+	FlagsKey  string // c_flags or cxx_flags
+}
+
+// identifier matches the characters that cannot appear in a C identifier
+var identifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// newData returns the data of the project described by opts
+func newData(opts Options) data {
+	d := data{
+		Options:   opts,
+		Ident:     identifier.ReplaceAllString(opts.Name, "_"),
+		Ext:       "c",
+		HeaderExt: "h",
+		FlagsKey:  "c_flags",
+	}
+	if d.Ident[0] >= '0' && d.Ident[0] <= '9' {
+		d.Ident = "_" + d.Ident
+	}
+	d.Guard = strings.ToUpper(d.Ident) + "_H"
+	if opts.Language == "c++" {
+		d.Ext = "cpp"
+		d.HeaderExt = "hpp"
+		d.FlagsKey = "cxx_flags"
+	}
+	return d
+}
+
+// Generate creates the project described by opts in dir and returns the
+// files it wrote. Existing files are never overwritten; the project is not
+// created at all when one of its files exists.
+func Generate(dir string, opts Options) ([]string, error) {
+	if opts.Template == "" {
+		opts.Template = DefaultTemplate
+	}
+	t, err := Lookup(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.complete(&opts); err != nil {
+		return nil, err
+	}
+	d := newData(opts)
+
+	files := make(map[string]string, len(t.Files))
+	for pathTemplate, contentTemplate := range t.Files {
+		path, err := expand(pathTemplate, d)
+		if err != nil {
+			return nil, err
+		}
+		content, err := expand(contentTemplate, d)
+		if err != nil {
+			return nil, err
+		}
+		files[filepath.FromSlash(path)] = content
+		if _, err := os.Stat(filepath.Join(dir, path)); err == nil {
+			return nil, fmt.Errorf("%s already exists", path)
+		}
+	}
+
+	for _, sub := range t.Dirs {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory %s: %w", sub, err)
+		}
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		full := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(full, []byte(files[path]), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+
+	// the ignore file is a courtesy, kept when the directory has one
+	ignore := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(ignore); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(ignore, []byte("/build/\n/.styx/\n"), 0644); err == nil {
+			paths = append(paths, ".gitignore")
+		}
+	}
+	return paths, nil
+}
+
+// expand executes text as a template of d
+func expand(text string, d data) (string, error) {
+	tmpl, err := template.New("").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, d); err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// Prompt asks on out for the template, name, language, standard and
+// compiler of the project, reading the answers from in. The values already
+// in opts are offered as defaults, and an empty answer keeps them.
+func Prompt(in io.Reader, out io.Writer, opts *Options) error {
+	reader := bufio.NewReader(in)
+	ask := func(question, value string) (string, error) {
+		fmt.Fprintf(out, "%s [%s]: ", question, value)
+		answer, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || answer == "") {
+			return "", errors.New("no answer")
+		}
+		if answer = strings.TrimSpace(answer); answer != "" {
+			return answer, nil
+		}
+		return value, nil
+	}
+
+	if opts.Template == "" {
+		opts.Template = DefaultTemplate
+	}
+	fmt.Fprintln(out, "templates:")
+	for _, t := range Templates() {
+		fmt.Fprintf(out, "  %-14s %s\n", t.Name, t.Description)
+	}
+
+	var err error
+	var t *Template
+	for t == nil {
+		answer, err := ask("template", opts.Template)
+		if err != nil {
+			return err
+		}
+		if t, err = Lookup(answer); err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+		opts.Template = answer
+	}
+
+	if opts.Name, err = ask("project name", opts.Name); err != nil {
+		return err
+	}
+
+	if opts.Language == "" {
+		opts.Language = t.Languages[0]
+	}
+	if len(t.Languages) > 1 {
+		for {
+			answer, err := ask("language ("+strings.Join(t.Languages, " or ")+")", opts.Language)
+			if err != nil {
+				return err
+			}
+			if contains(t.Languages, answer) {
+				opts.Language = answer
+				break
+			}
+			fmt.Fprintf(out, "the %s template supports %s\n", t.Name, strings.Join(t.Languages, " and "))
+		}
+	}
+
+	if opts.Standard == "" {
+		opts.Standard = defaultStandards[opts.Language]
+	}
+	if opts.Standard, err = ask("standard", opts.Standard); err != nil {
+		return err
+	}
+
+	if opts.Compiler == "" {
+		opts.Compiler = t.Compiler
+	}
+	if opts.Compiler, err = ask("compiler", opts.Compiler); err != nil {
+		return err
+	}
+	return nil
+}
+
+// contains reports whether list holds value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,473 @@
+package scaffold
+
+// targets are the debug and release targets every hosted template has
+const targets = `
+[targets.debug]
+{{.FlagsKey}} = [ "-g", "-O0" ]
+
+[targets.release]
+{{.FlagsKey}} = [ "-O2", "-DNDEBUG" ]
+`
+
+// project is the [project] table every template starts with
+const project = `[project]
+name = "{{.Name}}"
+version = "0.1.0"
+language = "{{.Language}}"
+standard = "{{.Standard}}"
+`
+
+var templates = map[string]*Template{
+	"executable": {
+		Name:        "executable",
+		Description: "a program (the default)",
+		Languages:   []string{"c++", "c"},
+		Compiler:    "auto",
+		Dirs:        []string{"include"},
+		Files: map[string]string{
+			"styx.toml": project + `
+[build]
+output_type = "executable"
+output_name = "{{.Name}}"
+sources = [ "src/*.{{.Ext}}", "src/**/*.{{.Ext}}" ]
+include_dirs = [ "include" ]
+
+[toolchain]
+compiler = "{{.Compiler}}"
+{{.FlagsKey}} = [ "-Wall", "-Wextra" ]
+linker_flags = []
+` + targets,
+			"src/main.{{.Ext}}": `{{if eq .Language "c++"}}#include <iostream>
+
+int main(int argc, char* argv[])
+{
+    std::cout << "Hello from " << argv[0] << "!" << std::endl;
+    return 0;
+}
+{{else}}#include <stdio.h>
+
+int main(int argc, char* argv[])
+{
+    (void)argc;
+    printf("Hello from %s!\n", argv[0]);
+    return 0;
+}
+{{end}}`,
+		},
+		Notes: []string{
+			"run 'styx build' to build the project",
+			"run 'styx run' to build and run the project",
+		},
+	},
+
+	"static-lib": {
+		Name:        "static-lib",
+		Description: "a static library",
+		Languages:   []string{"c++", "c"},
+		Compiler:    "auto",
+		Files: map[string]string{
+			"styx.toml": project + `
+[build]
+output_type = "static_lib"
+output_name = "{{.Name}}"
+sources = [ "src/*.{{.Ext}}", "src/**/*.{{.Ext}}" ]
+include_dirs = [ "include" ]
+
+[toolchain]
+compiler = "{{.Compiler}}"
+{{.FlagsKey}} = [ "-Wall", "-Wextra" ]
+` + targets,
+			"include/{{.Name}}/{{.Name}}.{{.HeaderExt}}": libraryHeader,
+			"src/{{.Name}}.{{.Ext}}":                     librarySource,
+		},
+		Notes: []string{
+			"run 'styx build' to build the library",
+			"the public headers go in include/",
+		},
+	},
+
+	"shared-lib": {
+		Name:        "shared-lib",
+		Description: "a shared library",
+		Languages:   []string{"c++", "c"},
+		Compiler:    "auto",
+		Files: map[string]string{
+			"styx.toml": project + `
+[build]
+output_type = "shared_lib"
+output_name = "{{.Name}}"
+sources = [ "src/*.{{.Ext}}", "src/**/*.{{.Ext}}" ]
+include_dirs = [ "include" ]
+
+[toolchain]
+compiler = "{{.Compiler}}"
+{{.FlagsKey}} = [ "-Wall", "-Wextra", "-fPIC" ]
+` + targets,
+			"include/{{.Name}}/{{.Name}}.{{.HeaderExt}}": libraryHeader,
+			"src/{{.Name}}.{{.Ext}}":                     librarySource,
+		},
+		Notes: []string{
+			"run 'styx build' to build the library",
+			"the public headers go in include/",
+		},
+	},
+
+	"kernel": {
+		Name:        "kernel",
+		Description: "a multiboot kernel for x86",
+		Languages:   []string{"c"},
+		Compiler:    "i686-elf-gcc",
+		Dirs:        []string{"include"},
+		Files: map[string]string{
+			"styx.toml": project + `
+[build]
+output_type = "executable"
+output_name = "{{.Name}}"
+sources = [ "src/*.c" ]
+include_dirs = [ "include" ]
+linker_script = "linker.ld"
+
+[toolchain]
+compiler = "{{.Compiler}}"
+c_flags = [ "-ffreestanding", "-fno-stack-protector", "-fno-pic", "-Wall", "-Wextra" ]
+linker_flags = [ "-ffreestanding", "-nostdlib", "-lgcc" ]
+
+[targets.debug]
+c_flags = [ "-g", "-O0" ]
+
+[targets.release]
+c_flags = [ "-O2" ]
+`,
+			"linker.ld": `ENTRY(_start)
+
+SECTIONS
+{
+    /* multiboot loaders put the kernel at 1 MiB */
+    . = 1M;
+
+    .multiboot :
+    {
+        KEEP(*(.multiboot))
+    }
+
+    .text BLOCK(4K) : ALIGN(4K)
+    {
+        *(.text*)
+    }
+
+    .rodata BLOCK(4K) : ALIGN(4K)
+    {
+        *(.rodata*)
+    }
+
+    .data BLOCK(4K) : ALIGN(4K)
+    {
+        *(.data*)
+    }
+
+    .bss BLOCK(4K) : ALIGN(4K)
+    {
+        *(COMMON)
+        *(.bss*)
+    }
+
+    /DISCARD/ :
+    {
+        *(.note*)
+        *(.comment)
+    }
+}
+`,
+			"src/boot.c": `#include <stdint.h>
+
+#define MULTIBOOT_MAGIC 0x1BADB002
+#define MULTIBOOT_FLAGS 0x3 /* align modules, provide the memory map */
+
+struct multiboot_header
+{
+    uint32_t magic;
+    uint32_t flags;
+    uint32_t checksum;
+};
+
+/* the loader looks for this header in the first 8 KiB of the kernel */
+__attribute__((section(".multiboot"), used, aligned(4)))
+static const struct multiboot_header header = {
+    MULTIBOOT_MAGIC,
+    MULTIBOOT_FLAGS,
+    (uint32_t)-(MULTIBOOT_MAGIC + MULTIBOOT_FLAGS),
+};
+
+__attribute__((aligned(16)))
+uint8_t kernel_stack[16384];
+
+void kmain(void);
+
+/* the loader jumps here in protected mode, without a stack */
+__asm__(
+    ".text\n"
+    ".global _start\n"
+    "_start:\n"
+    "    mov $(kernel_stack + 16384), %esp\n"
+    "    call kmain\n"
+    "1:  cli\n"
+    "    hlt\n"
+    "    jmp 1b\n");
+`,
+			"src/kernel.c": `#include <stddef.h>
+#include <stdint.h>
+
+#define VGA_WIDTH 80
+#define VGA_HEIGHT 25
+#define VGA_COLOR 0x0F /* white on black */
+
+static volatile uint16_t* const vga = (volatile uint16_t*)0xB8000;
+
+void kmain(void)
+{
+    for (size_t i = 0; i < VGA_WIDTH * VGA_HEIGHT; i++)
+        vga[i] = (uint16_t)' ' | (uint16_t)VGA_COLOR << 8;
+
+    const char* message = "Hello from {{.Name}}!";
+    for (size_t i = 0; message[i] != '\0'; i++)
+        vga[i] = (uint16_t)message[i] | (uint16_t)VGA_COLOR << 8;
+}
+`,
+		},
+		Notes: []string{
+			"the kernel needs an i686-elf cross compiler, or set compiler in styx.toml",
+			"run 'styx build', then boot the kernel with 'qemu-system-i386 -kernel <output>'",
+		},
+	},
+
+	"embedded-arm": {
+		Name:        "embedded-arm",
+		Description: "bare metal firmware for an ARM Cortex-M4",
+		Languages:   []string{"c"},
+		Compiler:    "arm-none-eabi-gcc",
+		Dirs:        []string{"include"},
+		Files: map[string]string{
+			"styx.toml": project + `
+[build]
+output_type = "executable"
+output_name = "{{.Name}}"
+sources = [ "src/*.c" ]
+include_dirs = [ "include" ]
+linker_script = "linker.ld"
+output_format = "bin"
+strip_dead_code = true
+
+[toolchain]
+compiler = "{{.Compiler}}"
+objcopy = "arm-none-eabi-objcopy"
+c_flags = [ "-mcpu=cortex-m4", "-mthumb", "-Wall", "-Wextra" ]
+linker_flags = [ "-mcpu=cortex-m4", "-mthumb", "-nostartfiles", "--specs=nano.specs", "--specs=nosys.specs" ]
+
+[targets.debug]
+c_flags = [ "-g", "-Og" ]
+
+[targets.release]
+c_flags = [ "-Os", "-DNDEBUG" ]
+`,
+			"linker.ld": `/* adjust to the memory of the microcontroller */
+MEMORY
+{
+    FLASH (rx)  : ORIGIN = 0x08000000, LENGTH = 512K
+    RAM   (rwx) : ORIGIN = 0x20000000, LENGTH = 128K
+}
+
+ENTRY(Reset_Handler)
+
+_estack = ORIGIN(RAM) + LENGTH(RAM);
+
+SECTIONS
+{
+    .isr_vector :
+    {
+        KEEP(*(.isr_vector))
+    } > FLASH
+
+    .text :
+    {
+        *(.text*)
+        *(.rodata*)
+        . = ALIGN(4);
+    } > FLASH
+
+    _sidata = LOADADDR(.data);
+
+    .data :
+    {
+        _sdata = .;
+        *(.data*)
+        . = ALIGN(4);
+        _edata = .;
+    } > RAM AT > FLASH
+
+    .bss :
+    {
+        _sbss = .;
+        *(.bss*)
+        *(COMMON)
+        . = ALIGN(4);
+        _ebss = .;
+        end = .;
+    } > RAM
+}
+`,
+			"src/startup.c": `#include <stdint.h>
+
+/* defined by linker.ld */
+extern uint32_t _sidata, _sdata, _edata, _sbss, _ebss, _estack;
+
+int main(void);
+
+void Reset_Handler(void)
+{
+    uint32_t* src = &_sidata;
+    for (uint32_t* dst = &_sdata; dst < &_edata;)
+        *dst++ = *src++;
+    for (uint32_t* dst = &_sbss; dst < &_ebss;)
+        *dst++ = 0;
+
+    main();
+    for (;;)
+        ;
+}
+
+void Default_Handler(void)
+{
+    for (;;)
+        ;
+}
+
+/* the core loads the stack pointer and the reset handler from here */
+__attribute__((section(".isr_vector"), used))
+static void (*const vectors[])(void) = {
+    (void (*)(void))&_estack,
+    Reset_Handler,
+    Default_Handler, /* NMI */
+    Default_Handler, /* hard fault */
+    Default_Handler, /* memory management fault */
+    Default_Handler, /* bus fault */
+    Default_Handler, /* usage fault */
+};
+`,
+			"src/main.c": `#include <stdint.h>
+
+int main(void)
+{
+    volatile uint32_t ticks = 0;
+    for (;;)
+        ticks++;
+}
+`,
+		},
+		Notes: []string{
+			"the firmware needs the arm-none-eabi toolchain",
+			"adjust -mcpu and the memory in linker.ld to your microcontroller",
+			"run 'styx build' to build the ELF file and its flat binary image",
+		},
+	},
+
+	"gtest-project": {
+		Name:        "gtest-project",
+		Description: "a library and a program, tested with GoogleTest",
+		Languages:   []string{"c++"},
+		Compiler:    "auto",
+		Files: map[string]string{
+			"styx.toml": project + `
+[[libraries]]
+name = "core"
+sources = [ "src/core/*.cpp" ]
+include_dirs = [ "include" ]
+
+[[binaries]]
+name = "{{.Name}}"
+sources = [ "src/main.cpp" ]
+links = [ "core" ]
+
+[toolchain]
+compiler = "{{.Compiler}}"
+cxx_flags = [ "-Wall", "-Wextra" ]
+
+[test]
+sources = [ "tests/*_test.cpp" ]
+framework = "gtest"
+` + targets,
+			"include/{{.Name}}/core.hpp": `#pragma once
+
+namespace {{.Ident}}
+{
+    int add(int a, int b);
+}
+`,
+			"src/core/core.cpp": `#include "{{.Name}}/core.hpp"
+
+namespace {{.Ident}}
+{
+    int add(int a, int b)
+    {
+        return a + b;
+    }
+}
+`,
+			"src/main.cpp": `#include <iostream>
+
+#include "{{.Name}}/core.hpp"
+
+int main()
+{
+    std::cout << "2 + 3 = " << {{.Ident}}::add(2, 3) << std::endl;
+    return 0;
+}
+`,
+			"tests/core_test.cpp": `#include <gtest/gtest.h>
+
+#include "{{.Name}}/core.hpp"
+
+TEST(Core, Add)
+{
+    EXPECT_EQ({{.Ident}}::add(2, 3), 5);
+    EXPECT_EQ({{.Ident}}::add(-1, 1), 0);
+}
+`,
+		},
+		Notes: []string{
+			"run 'styx run' to build and run the program",
+			"run 'styx test' to run the tests; GoogleTest must be installed",
+		},
+	},
+}
+
+// libraryHeader and librarySource are the starter files of the library
+// templates
+const libraryHeader = `{{if eq .Language "c++"}}#pragma once
+
+namespace {{.Ident}}
+{
+    int add(int a, int b);
+}
+{{else}}#ifndef {{.Guard}}
+#define {{.Guard}}
+
+int {{.Ident}}_add(int a, int b);
+
+#endif
+{{end}}`
+
+const librarySource = `#include "{{.Name}}/{{.Name}}.{{.HeaderExt}}"
+{{if eq .Language "c++"}}
+namespace {{.Ident}}
+{
+    int add(int a, int b)
+    {
+        return a + b;
+    }
+}
+{{else}}
+int {{.Ident}}_add(int a, int b)
+{
+    return a + b;
+}
+{{end}}`
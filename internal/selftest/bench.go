@@ -0,0 +1,262 @@
+package selftest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/deviceix/styx/internal/builder"
+	"github.com/deviceix/styx/internal/dependency"
+	"github.com/deviceix/styx/internal/fsmeta"
+)
+
+// Benchmark measures one part of a build on a generated tree
+type Benchmark struct {
+	Name string
+	Run  func(b *testing.B)
+}
+
+// Benchmarks returns the benchmarks of the scanner, the build cache and the
+// build graph on tree. The include dependencies of its sources are scanned
+// once to set them up.
+func Benchmarks(tree *Tree) ([]Benchmark, error) {
+	scanner := dependency.NewDependencyScanner([]string{tree.IncludeDir})
+	deps := make(map[string][]string, len(tree.Sources))
+	for _, source := range tree.Sources {
+		found, err := scanner.Scan(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", source, err)
+		}
+		deps[source] = found
+	}
+
+	cachePath, err := populateCache(tree, deps)
+	if err != nil {
+		return nil, err
+	}
+
+	files := float64(len(tree.Sources))
+	return []Benchmark{
+		{
+			// every source and the headers it includes are read, each header
+			// once, as on the first build of a project
+			Name: "Scanner",
+			Run: func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					scanner := dependency.NewDependencyScanner([]string{tree.IncludeDir})
+					for _, source := range tree.Sources {
+						if _, err := scanner.Scan(source); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+				b.ReportMetric(files*float64(b.N)/b.Elapsed().Seconds(), "files/s")
+			},
+		},
+		{
+			// the same with the includes looked up through the FS a build
+			// shares, statting every header once
+			Name: "ScannerFS",
+			Run: func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					scanner := dependency.NewDependencyScanner([]string{tree.IncludeDir})
+					scanner.SetFS(fsmeta.New())
+					for _, source := range tree.Sources {
+						if _, err := scanner.Scan(source); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+				b.ReportMetric(files*float64(b.N)/b.Elapsed().Seconds(), "files/s")
+			},
+		},
+		{
+			// the cache is loaded and every object found up to date, which
+			// is the work of a build with nothing to do
+			Name: "CacheNoOp",
+			Run: func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					cache := builder.NewCache(cachePath)
+					if err := cache.Load(); err != nil {
+						b.Fatal(err)
+					}
+					checkUpToDate(b, cache, tree, deps)
+				}
+				b.ReportMetric(files*float64(b.N)/b.Elapsed().Seconds(), "files/s")
+			},
+		},
+		{
+			// the same with the hash strategy, hashing every file one after
+			// the other
+			Name: "CacheNoOpHash",
+			Run: func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					cache := builder.NewCache(cachePath)
+					cache.Strategy = "hash"
+					if err := cache.Load(); err != nil {
+						b.Fatal(err)
+					}
+					checkUpToDate(b, cache, tree, deps)
+				}
+				b.ReportMetric(files*float64(b.N)/b.Elapsed().Seconds(), "files/s")
+			},
+		},
+		{
+			// the same with the files hashed by the pool of workers first,
+			// as builds do
+			Name: "CacheNoOpPrehash",
+			Run: func(b *testing.B) {
+				b.ReportAllocs()
+				checks := make(map[string][]string, len(tree.Sources))
+				for _, source := range tree.Sources {
+					checks[objectPath(source)] = append([]string{source}, deps[source]...)
+				}
+				for i := 0; i < b.N; i++ {
+					cache := builder.NewCache(cachePath)
+					cache.Strategy = "hash"
+					if err := cache.Load(); err != nil {
+						b.Fatal(err)
+					}
+					cache.Prehash(checks)
+					checkUpToDate(b, cache, tree, deps)
+				}
+				b.ReportMetric(files*float64(b.N)/b.Elapsed().Seconds(), "files/s")
+			},
+		},
+		{
+			// the graph of sources, headers and objects is built and sorted
+			// into build order
+			Name: "Graph",
+			Run: func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if err := buildGraph(tree, deps); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(files*float64(b.N)/b.Elapsed().Seconds(), "files/s")
+			},
+		},
+	}, nil
+}
+
+// checkUpToDate fails b unless cache finds the object of every source of
+// tree up to date
+func checkUpToDate(b *testing.B, cache *builder.Cache, tree *Tree, deps map[string][]string) {
+	for _, source := range tree.Sources {
+		if rebuild, reason := cache.NeedsRebuild(objectPath(source), append([]string{source}, deps[source]...), "bench"); rebuild {
+			b.Fatalf("%s needs a rebuild: %s", source, reason)
+		}
+	}
+}
+
+// objectPath returns the dummy object of a source of a generated tree
+func objectPath(source string) string {
+	return source + ".o"
+}
+
+// populateCache writes a dummy object for every source and records it in a
+// build cache, as a build of the tree would, and returns the cache path
+func populateCache(tree *Tree, deps map[string][]string) (string, error) {
+	cache := builder.NewCache(filepath.Join(tree.Dir, ".styx", "cache", "build.json"))
+	if err := cache.Load(); err != nil {
+		return "", err
+	}
+
+	past := time.Now().Add(-time.Hour)
+	for _, source := range tree.Sources {
+		object := objectPath(source)
+		if err := os.WriteFile(object, []byte("object"), 0644); err != nil {
+			return "", fmt.Errorf("failed to write object: %w", err)
+		}
+		if err := os.Chtimes(object, past, past); err != nil {
+			return "", fmt.Errorf("failed to date object: %w", err)
+		}
+		if err := cache.UpdateEntry(object, append([]string{source}, deps[source]...), "bench", object, 0); err != nil {
+			return "", err
+		}
+	}
+
+	if err := cache.Save(); err != nil {
+		return "", err
+	}
+	return cache.Path, nil
+}
+
+// buildGraph builds the graph of the tree the way a build does and sorts it
+func buildGraph(tree *Tree, deps map[string][]string) error {
+	graph := dependency.NewGraph()
+	for _, source := range tree.Sources {
+		if err := graph.AddNode(&dependency.Node{ID: source, Type: dependency.NodeTypeSource, Path: source}); err != nil {
+			return err
+		}
+		for _, header := range deps[source] {
+			if _, exists := graph.GetNode(header); !exists {
+				if err := graph.AddNode(&dependency.Node{ID: header, Type: dependency.NodeTypeHeader, Path: header}); err != nil {
+					return err
+				}
+			}
+			if err := graph.AddDependency(source, header); err != nil {
+				return err
+			}
+		}
+
+		object := objectPath(source)
+		if err := graph.AddNode(&dependency.Node{ID: object, Type: dependency.NodeTypeObject, Path: object}); err != nil {
+			return err
+		}
+		if err := graph.AddDependency(object, source); err != nil {
+			return err
+		}
+	}
+
+	_, err := graph.TopologicalSort()
+	return err
+}
+
+// Run generates a tree of sources sources and a header chain of depth
+// headers in a temporary directory, runs the benchmarks whose name matches
+// filter on it and writes their results to w in the format of go test
+func Run(w io.Writer, sources, depth int, filter string) error {
+	match, err := regexp.Compile(filter)
+	if err != nil {
+		return fmt.Errorf("invalid benchmark filter: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "styx-bench-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tree, err := GenerateTree(dir, sources, depth)
+	if err != nil {
+		return err
+	}
+
+	benchmarks, err := Benchmarks(tree)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "tree: %d sources, include depth %d\n", sources, depth)
+	for _, benchmark := range benchmarks {
+		if !match.MatchString(benchmark.Name) {
+			continue
+		}
+		result := testing.Benchmark(benchmark.Run)
+		if result.N == 0 {
+			return fmt.Errorf("benchmark %s failed", benchmark.Name)
+		}
+		fmt.Fprintf(w, "Benchmark%s\t%s\t%s\n", benchmark.Name, result.String(), result.MemString())
+	}
+	return nil
+}
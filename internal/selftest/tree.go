@@ -0,0 +1,73 @@
+package selftest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sourcesPerDir is how many sources a directory of a generated tree holds
+const sourcesPerDir = 100
+
+// Tree is a generated project: Sources include one header of a chain of
+// Depth headers in IncludeDir, each including the next one, so scanning a
+// source reads up to Depth headers
+type Tree struct {
+	Dir        string
+	IncludeDir string
+	Sources    []string
+	Depth      int
+}
+
+// GenerateTree writes a tree of sources sources and a header chain of
+// depth headers into dir. Every file is dated an hour back, like a checkout
+// that has not been touched since, so its timestamps are never racy.
+func GenerateTree(dir string, sources, depth int) (*Tree, error) {
+	if sources <= 0 || depth <= 0 {
+		return nil, fmt.Errorf("a tree needs at least one source and one header")
+	}
+
+	tree := &Tree{Dir: dir, IncludeDir: filepath.Join(dir, "include"), Depth: depth}
+	if err := os.MkdirAll(tree.IncludeDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create include directory: %w", err)
+	}
+
+	var files []string
+	for i := 0; i < depth; i++ {
+		content := fmt.Sprintf("#pragma once\n\nint header%d(int x);\n", i)
+		if i+1 < depth {
+			content = fmt.Sprintf("#pragma once\n#include \"h%d.h\"\n\nint header%d(int x);\n", i+1, i)
+		}
+		path := filepath.Join(tree.IncludeDir, fmt.Sprintf("h%d.h", i))
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write header: %w", err)
+		}
+		files = append(files, path)
+	}
+
+	for i := 0; i < sources; i++ {
+		srcDir := filepath.Join(dir, "src", fmt.Sprintf("d%03d", i/sourcesPerDir))
+		if i%sourcesPerDir == 0 {
+			if err := os.MkdirAll(srcDir, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create source directory: %w", err)
+			}
+		}
+
+		content := fmt.Sprintf("#include <stdio.h>\n#include \"h%d.h\"\n\nint function%d(int x)\n{\n\treturn header%d(x) + %d;\n}\n", i%depth, i, i%depth, i)
+		path := filepath.Join(srcDir, fmt.Sprintf("file%d.c", i))
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write source: %w", err)
+		}
+		tree.Sources = append(tree.Sources, path)
+		files = append(files, path)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	for _, file := range files {
+		if err := os.Chtimes(file, past, past); err != nil {
+			return nil, fmt.Errorf("failed to date %s: %w", file, err)
+		}
+	}
+	return tree, nil
+}
@@ -0,0 +1,134 @@
+package telemetry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report summarizes the recorded events
+type Report struct {
+	Events   int            `json:"events"`
+	Since    time.Time      `json:"since"`
+	Commands map[string]int `json:"commands"`
+	Flags    map[string]int `json:"flags"` // by command and flag, like "build --jobs"
+	Features map[string]int `json:"features"`
+
+	Builds    int            `json:"builds"`
+	Succeeded int            `json:"succeeded"`
+	MedianMS  int64          `json:"median_ms"`
+	P90MS     int64          `json:"p90_ms"`
+	Steps     int            `json:"steps"`
+	StepsRan  int            `json:"steps_ran"`
+	Compilers map[string]int `json:"compilers"`
+}
+
+// Summarize counts the commands, flags and features used by events and
+// computes the durations of the builds
+func Summarize(events []Event) *Report {
+	r := &Report{
+		Events:    len(events),
+		Commands:  make(map[string]int),
+		Flags:     make(map[string]int),
+		Features:  make(map[string]int),
+		Compilers: make(map[string]int),
+	}
+
+	var durations []int64
+	for _, event := range events {
+		if r.Since.IsZero() || event.Time.Before(r.Since) {
+			r.Since = event.Time
+		}
+
+		switch event.Kind {
+		case "command":
+			r.Commands[event.Command]++
+			for _, flag := range event.Flags {
+				r.Flags[event.Command+" --"+flag]++
+			}
+		case "build":
+			r.Builds++
+			if event.Success {
+				r.Succeeded++
+				durations = append(durations, event.DurationMS)
+			}
+			for _, feature := range event.Features {
+				r.Features[feature]++
+			}
+			if event.Compiler != "" {
+				r.Compilers[event.Compiler]++
+			}
+			r.Steps += event.Steps
+			r.StepsRan += event.Ran
+		}
+	}
+
+	if len(durations) > 0 {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		r.MedianMS = durations[len(durations)/2]
+		r.P90MS = durations[len(durations)*9/10]
+	}
+	return r
+}
+
+// String formats the report for the terminal
+func (r *Report) String() string {
+	var b strings.Builder
+	if r.Events == 0 {
+		b.WriteString("no events recorded\n")
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "%d events since %s\n", r.Events, r.Since.Local().Format("2006-01-02"))
+	writeCounts(&b, "commands", r.Commands, 0)
+	writeCounts(&b, "flags", r.Flags, 0)
+
+	if r.Builds == 0 {
+		return b.String()
+	}
+	writeCounts(&b, "features (builds using them)", r.Features, r.Builds)
+	writeCounts(&b, "compilers", r.Compilers, r.Builds)
+
+	fmt.Fprintf(&b, "\nbuilds: %d, %d succeeded\n", r.Builds, r.Succeeded)
+	if r.Succeeded > 0 {
+		fmt.Fprintf(&b, "  duration: median %s, p90 %s\n", formatMS(r.MedianMS), formatMS(r.P90MS))
+	}
+	if r.Steps > 0 {
+		fmt.Fprintf(&b, "  steps: %d, %d run (%.0f%%), the rest up to date\n", r.Steps, r.StepsRan, 100*float64(r.StepsRan)/float64(r.Steps))
+	}
+	return b.String()
+}
+
+// writeCounts writes the counts under a title, most used first, with their
+// share of total when it is not 0
+func writeCounts(b *strings.Builder, title string, counts map[string]int, total int) {
+	if len(counts) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	fmt.Fprintf(b, "\n%s:\n", title)
+	for _, name := range names {
+		if total > 0 {
+			fmt.Fprintf(b, "  %-28s %5d  %3.0f%%\n", name, counts[name], 100*float64(counts[name])/float64(total))
+		} else {
+			fmt.Fprintf(b, "  %-28s %5d\n", name, counts[name])
+		}
+	}
+}
+
+// formatMS formats a duration in milliseconds
+func formatMS(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).Round(10 * time.Millisecond).String()
+}
@@ -0,0 +1,306 @@
+// Package telemetry records which commands and features of styx are used and
+// how builds perform, for users who opted in with styx telemetry enable.
+// Events stay in ~/.styx/telemetry on this machine and hold no paths, names
+// or flag values; they are only sent anywhere by styx telemetry upload, to
+// the endpoint the user configured. STYX_TELEMETRY=0 or DO_NOT_TRACK=1 turn
+// recording off whatever the settings say.
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/deviceix/styx/internal/config"
+	"github.com/deviceix/styx/internal/platform"
+)
+
+// Version is the version of styx recorded in events, set by main
+var Version = "unknown"
+
+// Settings are the choices of the user about telemetry. ID is random and
+// only identifies the uploads of the same machine; Uploaded counts the
+// events already uploaded.
+type Settings struct {
+	Enabled  bool   `json:"enabled"`
+	Endpoint string `json:"endpoint,omitempty"`
+	ID       string `json:"id"`
+	Uploaded int    `json:"uploaded"`
+}
+
+// Event is one recorded use of styx: a command with the names of the flags
+// given to it, or a build with the features of its configuration and how
+// long it took
+type Event struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"` // command or build
+	Version  string    `json:"version"`
+	Platform string    `json:"platform"`
+
+	Command string   `json:"command,omitempty"`
+	Flags   []string `json:"flags,omitempty"`
+
+	Features   []string `json:"features,omitempty"`
+	Compiler   string   `json:"compiler,omitempty"` // the vendor, like gcc or clang
+	Success    bool     `json:"success,omitempty"`
+	DurationMS int64    `json:"duration_ms,omitempty"`
+	Steps      int      `json:"steps,omitempty"`
+	Ran        int      `json:"ran,omitempty"` // steps that were not up to date
+}
+
+// Dir returns the directory holding the settings and the events
+func Dir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, ".styx", "telemetry"), nil
+}
+
+// EventsPath returns the file events are appended to
+func EventsPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "events.jsonl"), nil
+}
+
+// LoadSettings reads the settings, which are all off when the user never
+// enabled telemetry
+func LoadSettings() (*Settings, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &Settings{}
+	data, err := os.ReadFile(filepath.Join(dir, "settings.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read telemetry settings: %w", err)
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		return nil, fmt.Errorf("failed to parse telemetry settings: %w", err)
+	}
+	return settings, nil
+}
+
+// Save writes the settings, choosing the ID of the machine on first use
+func (s *Settings) Save() error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create telemetry directory: %w", err)
+	}
+
+	if s.ID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return fmt.Errorf("failed to generate telemetry id: %w", err)
+		}
+		s.ID = hex.EncodeToString(id)
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode telemetry settings: %w", err)
+	}
+	if err := platform.WriteFileAtomic(filepath.Join(dir, "settings.json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to write telemetry settings: %w", err)
+	}
+	return nil
+}
+
+// Disabled returns the environment variable turning telemetry off, if one
+// is set
+func Disabled() string {
+	switch os.Getenv("STYX_TELEMETRY") {
+	case "0", "off", "false":
+		return "STYX_TELEMETRY"
+	}
+	if value := os.Getenv("DO_NOT_TRACK"); value != "" && value != "0" {
+		return "DO_NOT_TRACK"
+	}
+	return ""
+}
+
+// Enabled reports whether events are recorded
+func Enabled() bool {
+	if Disabled() != "" {
+		return false
+	}
+	settings, err := LoadSettings()
+	return err == nil && settings.Enabled
+}
+
+// Record appends event to the events when telemetry is enabled. Telemetry
+// never gets in the way of a command, so failures are ignored.
+func Record(event Event) {
+	if !Enabled() {
+		return
+	}
+	path, err := EventsPath()
+	if err != nil {
+		return
+	}
+
+	event.Time = time.Now().UTC()
+	event.Version = Version
+	event.Platform = runtime.GOOS + "/" + runtime.GOARCH
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, _ = f.Write(append(data, '\n'))
+}
+
+// ReadEvents returns the recorded events, skipping lines that do not parse
+func ReadEvents() ([]Event, error) {
+	path, err := EventsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read telemetry events: %w", err)
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err == nil {
+			events = append(events, event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read telemetry events: %w", err)
+	}
+	return events, nil
+}
+
+// Clear deletes the recorded events
+func Clear() error {
+	path, err := EventsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete telemetry events: %w", err)
+	}
+
+	settings, err := LoadSettings()
+	if err != nil {
+		return err
+	}
+	if settings.Uploaded == 0 {
+		return nil
+	}
+	settings.Uploaded = 0
+	return settings.Save()
+}
+
+// Upload sends the events not uploaded yet to the endpoint of the settings
+// as one JSON document, and returns how many were sent
+func Upload(settings *Settings) (int, error) {
+	if settings.Endpoint == "" {
+		return 0, errors.New("no upload endpoint configured; set one with styx telemetry enable --endpoint")
+	}
+
+	events, err := ReadEvents()
+	if err != nil {
+		return 0, err
+	}
+	if settings.Uploaded > len(events) {
+		settings.Uploaded = 0
+	}
+	pending := events[settings.Uploaded:]
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":     settings.ID,
+		"events": pending,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode telemetry events: %w", err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(settings.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload telemetry: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("failed to upload telemetry: %s", resp.Status)
+	}
+
+	settings.Uploaded = len(events)
+	if err := settings.Save(); err != nil {
+		return 0, err
+	}
+	return len(pending), nil
+}
+
+// ConfigFeatures returns the features of styx a configuration uses
+func ConfigFeatures(cfg *config.Config) []string {
+	var features []string
+	add := func(used bool, feature string) {
+		if used {
+			features = append(features, feature)
+		}
+	}
+
+	sanitizers, lto := false, false
+	for _, target := range cfg.Targets {
+		sanitizers = sanitizers || len(target.Sanitizers) > 0
+		lto = lto || target.LTO != ""
+	}
+	checks := cfg.Checks
+	env := cfg.ActiveEnvironment()
+
+	add(len(cfg.Binaries)+len(cfg.Libraries) > 0, "artifacts")
+	add(len(cfg.Dependencies) > 0, "dependencies")
+	add(len(cfg.Rules) > 0, "rules")
+	add(len(cfg.Test.Sources) > 0, "tests")
+	add(len(checks.Headers)+len(checks.Functions)+len(checks.Symbols)+len(checks.Sizes)+len(checks.TryCompile) > 0, "checks")
+	add(sanitizers, "sanitizers")
+	add(lto, "lto")
+	add(cfg.Build.StdlibPCH, "stdlib_pch")
+	add(cfg.Build.LinkerScript != "", "linker_script")
+	add(cfg.Build.StripDeadCode, "strip_dead_code")
+	add(len(cfg.Build.Libs)+len(cfg.Build.Frameworks) > 0, "libs")
+	add(cfg.Toolchain.Use != "", "toolchains")
+	add(env != nil, "environments")
+	add(env != nil && env.Container != "", "container")
+	add(env != nil && env.Nix != "", "nix")
+	add(env != nil && env.Devcontainer, "devcontainer")
+	return features
+}
@@ -0,0 +1,66 @@
+#!/bin/sh
+# Compares the wall clock time of clean builds with and without
+# preprocess_ahead on a generated project of SOURCES sources, each including
+# HEADERS headers. The headers go to HEADER_DIR, a scratch directory by
+# default; pointing it at a network filesystem shows what the pipeline hides.
+# STYX names the styx binary and RUNS the number of builds of each kind;
+# make bench sets STYX.
+set -eu
+
+: "${STYX:?STYX must name the styx binary}"
+SOURCES=${SOURCES:-64}
+HEADERS=${HEADERS:-32}
+RUNS=${RUNS:-3}
+
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+headers=${HEADER_DIR:-$work/include}
+mkdir -p "$work/src" "$headers"
+
+i=0
+while [ $i -lt "$HEADERS" ]; do
+	printf 'static inline int h%d(int x) { return x * %d + 1; }\n' $i $i >"$headers/h$i.h"
+	i=$((i + 1))
+done
+i=0
+while [ $i -lt "$SOURCES" ]; do
+	j=0
+	while [ $j -lt "$HEADERS" ]; do
+		echo "#include \"h$j.h\""
+		j=$((j + 1))
+	done >"$work/src/s$i.c"
+	printf 'int s%d(void) { return h0(%d); }\n' $i $i >>"$work/src/s$i.c"
+	i=$((i + 1))
+done
+
+# bench builds the project RUNS times from scratch and prints the average
+# wall clock time, with preprocess_ahead set to $1
+bench() {
+	cat >"$work/styx.toml" <<TOML
+[project]
+name = "bench"
+version = "0.1.0"
+language = "c"
+
+[build]
+output_type = "static_lib"
+sources = [ "src/*.c" ]
+include_dirs = [ "$headers" ]
+preprocess_ahead = $1
+TOML
+	total=0
+	run=0
+	while [ $run -lt "$RUNS" ]; do
+		rm -rf "$work/build" "$work/.styx"
+		start=$(date +%s%N)
+		(cd "$work" && "$STYX" build >/dev/null 2>&1)
+		end=$(date +%s%N)
+		total=$((total + (end - start) / 1000000))
+		run=$((run + 1))
+	done
+	echo "preprocess_ahead = $1: $((total / RUNS)) ms"
+}
+
+echo "$SOURCES sources, $HEADERS headers each, in $headers"
+bench false
+bench true
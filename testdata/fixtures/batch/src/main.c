@@ -0,0 +1,4 @@
+int one(void);
+int two(void);
+
+int main(void) { return one() + two() - 3; }
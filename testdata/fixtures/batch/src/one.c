@@ -0,0 +1 @@
+int one(void) { return 1; }
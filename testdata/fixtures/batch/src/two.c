@@ -0,0 +1 @@
+int two(void) { return 2; }
@@ -0,0 +1,21 @@
+# small sources are compiled two at a time by one compiler invocation, run
+# in their object directory
+styx_ok build
+expect_calls 1 "^gcc -c /.*/src/main.c /.*/src/one.c "
+expect_calls 1 "^gcc -c src/two.c -o build/debug/src/two.o "
+expect_file build/debug/src/main.o
+expect_file build/debug/src/one.o
+expect_file build/debug/batch
+
+# a failed source of a batch fails alone and is reported by its path
+echo "#error one is broken" >>src/one.c
+echo "// changed" >>src/main.c
+reset_log
+styx_fails build
+expect_output "src/one.c:2:2: error"
+expect_output "Compilation of src/one.c failed"
+if grep -q "Compilation of src/main.c failed" styx.out; then
+	fail "main.c was reported as failed"
+fi
+expect_file build/debug/src/main.o
+[ ! -e build/debug/src/one.o ] || fail "one.o was kept"
@@ -0,0 +1,5 @@
+int broken(void)
+{
+#error not implemented
+	return 0;
+}
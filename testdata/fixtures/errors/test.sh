@@ -0,0 +1,12 @@
+# a failed compilation fails the build and reports where
+styx_fails build
+expect_output "broken.c"
+expect_output "not implemented"
+[ ! -e build/debug/errors ] || fail "errors was linked"
+
+# once fixed, the build succeeds
+sed '/#error/d' src/broken.c >src/broken.c.new && mv src/broken.c.new src/broken.c
+reset_log
+styx_ok build
+expect_calls 1 "^gcc -c src/broken.c "
+expect_file build/debug/errors
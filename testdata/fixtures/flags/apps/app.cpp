@@ -0,0 +1,3 @@
+#include "core.h"
+
+int main() { return core_value() == 42 ? 0 : 1; }
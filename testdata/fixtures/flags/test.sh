@@ -0,0 +1,27 @@
+# flags of the toolchain, the target and the libraries reach the commands
+styx_ok build -t release
+expect_calls 2 "^g++ -c .* -DNDEBUG -Wall .*-O2"
+expect_calls 1 "^g++ -c apps/app.cpp .*-Icore/include"
+expect_calls 0 " -g"
+expect_calls 1 "^g++ build/release/obj/app/apps/app.o build/release/libcore.a -o build/release/app .*-pthread"
+expect_file build/release/libcore.a
+
+# a changed flag recompiles everything built with it
+sed 's/"-O2"/"-O3"/' styx.toml >styx.toml.new && mv styx.toml.new styx.toml
+reset_log
+styx_ok build -t release
+expect_calls 2 "^g++ -c .*-O3"
+
+# other targets build apart
+reset_log
+styx_ok build -t debug
+expect_calls 2 "^g++ -c .* -g"
+expect_file build/debug/app
+
+# overridden flags are dropped, duplicates merged and includes come first
+reset_log
+styx_ok build -t tuned
+expect_calls 2 "^g++ -c [^ ]* -o [^ ]* -I.* -DLEVEL=2 -std=c++17 -Wall -O1$"
+expect_calls 0 "-O0"
+expect_output "-O0 is overridden by -O1"
+expect_output "-DLEVEL=1 is overridden by -DLEVEL=2"
@@ -0,0 +1 @@
+int a_util(void) { return 1; }
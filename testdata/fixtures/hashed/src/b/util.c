@@ -0,0 +1 @@
+int b_util(void) { return 2; }
@@ -0,0 +1,4 @@
+int a_util(void);
+int b_util(void);
+
+int main(void) { return a_util() + b_util() - 3; }
@@ -0,0 +1,16 @@
+# the objects of the hashed layout are not named after their sources, so
+# they are compiled one by one even with batches, and sources of the same
+# name get objects of their own
+styx_ok build
+expect_calls 0 "^gcc -c /"
+expect_calls 1 "^gcc -c src/a/util.c -o build/debug/util-[0-9a-f]*.o "
+expect_calls 1 "^gcc -c src/b/util.c -o build/debug/util-[0-9a-f]*.o "
+[ "$(ls build/debug/util-*.o | wc -l)" -eq 2 ] || fail "expected two util objects"
+[ ! -e build/debug/util.o ] || fail "util.o was written"
+expect_file build/debug/hashed
+
+# the mirror layout keeps the objects in directories mirroring the sources
+reset_log
+STYX_BUILD_OBJECT_LAYOUT=mirror styx_ok build
+expect_file build/debug/src/a/util.o
+expect_file build/debug/src/b/util.o
@@ -0,0 +1,6 @@
+#include <stdio.h>
+#include "greet.h"
+
+int answer(void);
+
+int main(void) { return puts(greeting()) < 0 || answer() != 42; }
@@ -0,0 +1,27 @@
+# compiles only what changed since the previous build
+styx_ok build
+expect_calls 3 "^gcc -c "
+expect_file build/debug/incremental
+
+reset_log
+styx_ok build
+expect_calls 0 "^gcc -c "
+
+# touching a file without changing it recompiles nothing
+touch include/greet.h src/answer.c
+reset_log
+styx_ok build
+expect_calls 0 "^gcc -c "
+
+# a changed header recompiles the sources including it
+echo "// changed" >>include/greet.h
+reset_log
+styx_ok build
+expect_calls 2 "^gcc -c "
+expect_calls 1 "^gcc -c src/main.c "
+expect_calls 1 "^gcc -c src/greet.c "
+
+# the tests build with the support sources and pass
+reset_log
+styx_ok test
+expect_calls 1 "^gcc -c tests/test_greet.c "
@@ -0,0 +1,44 @@
+# Helpers for the test.sh of the fixtures, which run with set -e in a
+# scratch copy of their project. The output of the last styx command is in
+# styx.out and the commands the fake compiler ran since the last
+# reset_log are in $FAKECC_LOG, one per line.
+set -e
+
+# fail reports a failed expectation and ends the fixture
+fail() {
+	echo "  $*" >&2
+	exit 1
+}
+
+# styx_ok runs styx and fails unless it succeeds
+styx_ok() {
+	"$STYX" "$@" >styx.out 2>&1 || { cat styx.out >&2; fail "styx $* failed"; }
+}
+
+# styx_fails runs styx and fails unless it fails
+styx_fails() {
+	if "$STYX" "$@" >styx.out 2>&1; then
+		fail "styx $* succeeded"
+	fi
+}
+
+# reset_log forgets the commands run so far
+reset_log() {
+	: >"$FAKECC_LOG"
+}
+
+# expect_calls fails unless exactly $1 commands match the pattern $2
+expect_calls() {
+	count=$(grep -c -e "$2" "$FAKECC_LOG" || true)
+	[ "$count" -eq "$1" ] || { cat "$FAKECC_LOG" >&2; fail "expected $1 commands matching '$2', got $count"; }
+}
+
+# expect_output fails unless the output of the last styx command matches $1
+expect_output() {
+	grep -q -e "$1" styx.out || { cat styx.out >&2; fail "expected output matching '$1'"; }
+}
+
+# expect_file fails unless the file $1 exists
+expect_file() {
+	[ -e "$1" ] || fail "expected $1 to exist"
+}
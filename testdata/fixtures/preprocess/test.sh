@@ -0,0 +1,15 @@
+# every source is preprocessed into memory and compiled from there
+styx_ok build
+expect_calls 2 "^gcc -E src/"
+expect_calls 1 "^gcc -x cpp-output -c - -o build/debug/src/main.o "
+expect_calls 1 "^gcc -x cpp-output -c - -o build/debug/src/answer.o "
+expect_file build/debug/preprocess
+
+# a failed preprocessing fails the compilation without running it
+echo "#error not ready" >>src/answer.c
+reset_log
+styx_fails build
+expect_output "answer.c"
+expect_output "not ready"
+expect_calls 1 "^gcc -E src/answer.c "
+expect_calls 0 "^gcc -x cpp-output -c - -o build/debug/src/answer.o "
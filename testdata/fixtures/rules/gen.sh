@@ -0,0 +1,6 @@
+# writes a function returning the contents of the .def file $1 to the
+# source $2 and its declaration to the header $3
+echo "gen $*" >>"$FAKECC_LOG"
+name=$(basename "$1" .def)
+printf 'int %s(void) { return %s; }\n' "$name" "$(cat "$1")" >"$2"
+printf 'int %s(void);\n' "$name" >"$3"
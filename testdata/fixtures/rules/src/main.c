@@ -0,0 +1,7 @@
+#include "one.h"
+#include "seven.h"
+
+int main(void)
+{
+	return seven() - one() == 6 ? 0 : 1;
+}
@@ -0,0 +1,27 @@
+# runs the rules before compiling, and compiles the sources they generate
+styx_ok build
+expect_calls 2 "^gen "
+expect_calls 1 "^gcc -c gen/one.c "
+expect_calls 1 "^gcc -c gen/seven.c "
+expect_calls 1 "^gcc -c src/main.c "
+expect_file gen/seven.h
+
+# nothing runs again while the inputs are unchanged
+reset_log
+styx_ok build
+expect_calls 0 "^gen "
+expect_calls 0 "^gcc -c "
+
+# a changed input runs its rule and recompiles what it generated
+echo 8 >defs/seven.def
+reset_log
+styx_ok build
+expect_calls 1 "^gen defs/seven.def "
+expect_calls 1 "^gcc -c gen/seven.c "
+expect_calls 0 "^gcc -c src/main.c "
+
+# a deleted output is generated again
+rm gen/one.h
+reset_log
+styx_ok build
+expect_calls 1 "^gen defs/one.def "
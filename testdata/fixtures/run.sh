@@ -0,0 +1,32 @@
+#!/bin/sh
+# Runs the test.sh of every fixture project, or of the ones named as
+# arguments, in a scratch copy of the project with the fake compiler first
+# in PATH. STYX names the styx binary and FAKECC the directory holding the
+# fake gcc and g++; make fixtures sets both.
+set -u
+
+here=$(cd "$(dirname "$0")" && pwd)
+: "${STYX:?STYX must name the styx binary}"
+: "${FAKECC:?FAKECC must name the directory of the fake compiler}"
+PATH="$FAKECC:$PATH"
+# the tests match the English messages
+LC_ALL=C
+export STYX PATH LC_ALL
+
+if [ $# -eq 0 ]; then
+	set -- $(cd "$here" && ls -d */ | tr -d /)
+fi
+
+failed=0
+for fixture in "$@"; do
+	work=$(mktemp -d)
+	cp -R "$here/$fixture/." "$work"
+	if (cd "$work" && FAKECC_LOG="$work/fakecc.log" && export FAKECC_LOG && . "$here/lib.sh" && . ./test.sh); then
+		echo "ok   $fixture"
+		rm -rf "$work"
+	else
+		echo "FAIL $fixture (kept in $work)"
+		failed=1
+	fi
+done
+exit $failed
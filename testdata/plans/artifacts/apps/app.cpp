@@ -0,0 +1,5 @@
+#include "core.h"
+
+int plugin_value();
+
+int main() { return plugin_value() == core_value() + 1 ? 0 : 1; }
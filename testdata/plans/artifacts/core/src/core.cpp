@@ -0,0 +1,3 @@
+#include "core.h"
+
+int core_value() { return 42; }
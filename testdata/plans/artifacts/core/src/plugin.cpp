@@ -0,0 +1,3 @@
+#include "core.h"
+
+int plugin_value() { return core_value() + 1; }